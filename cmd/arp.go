@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"packeteer/internal/arp"
+	"packeteer/internal/output"
+	"packeteer/internal/packet"
+	"packeteer/internal/storage"
+)
+
+var (
+	// arpMonitor watches the ARP and NDP traffic of a sniff run, see
+	// recordNeighbor
+	arpMonitor *arp.Monitor
+	// arpAlerts is where alerts are printed as they are raised, they are only
+	// stored when nil
+	arpAlerts io.Writer
+)
+
+// arpCmd represents the arp command
+var arpCmd = &cobra.Command{
+	Use:   "arp",
+	Short: "list the ARP table learned by sniff",
+	Long: `List the IP to MAC bindings learned from the ARP traffic seen by sniff,
+with the times each was first and last seen. An IP claimed by more than one MAC
+on the same interface and VLAN is marked as a conflict. With --alerts the
+gratuitous ARP, duplicate IP, flapping and storm alerts about IPv4 addresses
+are listed instead. See ndp for the IPv6 neighbors.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ListARP(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(arpCmd)
+
+	arpCmd.Flags().BoolP("alerts", "a", false, "list the alerts instead of the table")
+	arpCmd.Flags().StringP("format", "f", "table", "output format: table or json")
+	setConfigKey(arpCmd.Flags(), "format", "arp.format")
+}
+
+// ListARP prints the learned ARP table or the alerts in the chosen format
+func ListARP(cmd *cobra.Command) {
+	format := viper.GetString("arp.format")
+	if format != "table" && format != "json" {
+		log.Fatalf("unknown format %q", format)
+	}
+
+	var err error
+	if viper.GetBool("alerts") {
+		alerts := neighborAlerts(false)
+		if format == "json" {
+			err = output.PrintARPAlertsJSON(os.Stdout, alerts)
+		} else {
+			err = output.PrintARPAlerts(os.Stdout, alerts)
+		}
+	} else {
+		var entries []storage.ARPEntry
+		entries, err = storage.GetARPEntries(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if format == "json" {
+			err = output.PrintARPTableJSON(os.Stdout, entries)
+		} else {
+			err = output.PrintARPTable(os.Stdout, entries)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// neighborAlerts returns the stored alerts about IPv6 or about IPv4 addresses
+func neighborAlerts(ipv6 bool) []storage.ARPAlert {
+	alerts, err := storage.GetARPAlerts(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var kept []storage.ARPAlert
+	for _, a := range alerts {
+		if addr, err := netip.ParseAddr(a.IP); err == nil && addr.Is6() == ipv6 {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// arpConfig reads the alert thresholds of the ARP monitor
+func arpConfig() arp.Config {
+	return arp.Config{
+		DuplicateWindow: viper.GetDuration("arp.duplicate_window"),
+		FlapWindow:      viper.GetDuration("arp.flap_window"),
+		FlapCount:       viper.GetInt("arp.flap_count"),
+		StormRate:       viper.GetInt("arp.storm_rate"),
+		Routers:         viper.GetStringSlice("ndp.routers"),
+	}
+}
+
+// recordNeighbor stores the binding an ARP or NDP packet claims, and runs it
+// through the monitor, storing and printing the alerts it raises
+func recordNeighbor(pi *packet.PacketInfo) {
+	if ip, mac, ok := pi.Neighbor(); ok {
+		var vlan int
+		if len(pi.VLANs) > 0 {
+			vlan = int(pi.VLANs[len(pi.VLANs)-1])
+		}
+
+		var err error
+		if pi.NDP != nil {
+			err = storage.UpsertNDPNeighbor(db, ip, mac, pi.Interface, vlan, pi.NDP.Router, pi.Timestamp)
+		} else {
+			err = storage.UpsertARPEntry(db, ip, mac, pi.Interface, vlan, pi.Timestamp)
+		}
+		if err != nil {
+			log.Fatalf("inserting into neighbor table: %v", err)
+		}
+	}
+
+	for _, alert := range arpMonitor.Observe(pi) {
+		err := storage.InsertARPAlert(db, storage.ARPAlert{
+			Timestamp: alert.Time,
+			Kind:      string(alert.Kind),
+			IP:        alert.IP,
+			MAC:       alert.MAC,
+			OldMAC:    alert.OldMAC,
+			Interface: alert.Interface,
+			Message:   alert.Message,
+		})
+		if err != nil {
+			log.Fatalf("inserting into arp alerts: %v", err)
+		}
+		if arpAlerts != nil {
+			fmt.Fprintf(arpAlerts, "%s alert: %s\n", alertProtocol(pi), alert)
+		}
+	}
+}
+
+// alertProtocol names the protocol an alert was raised for
+func alertProtocol(pi *packet.PacketInfo) string {
+	if pi.NDP != nil {
+		return "NDP"
+	}
+	return "ARP"
+}
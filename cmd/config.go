@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// configKeyAnnotation overrides the config key a flag is bound to, for flags
+// that live in a section of the config file such as capture:
+const configKeyAnnotation = "packeteer_config_key"
+
+// envPrefix is prepended to config keys to get their environment variable,
+// e.g. capture.snaplen is read from PACKETEER_CAPTURE_SNAPLEN
+const envPrefix = "packeteer"
+
+// setConfigKey binds flag to key instead of the key derived from its name
+func setConfigKey(flags *pflag.FlagSet, flag, key string) {
+	if err := flags.SetAnnotation(flag, configKeyAnnotation, []string{key}); err != nil {
+		panic(err)
+	}
+}
+
+// configKey is the config key a flag is read from. Unless set with
+// setConfigKey it is the flag name with dashes turned into underscores, so
+// --stats-interval is stats_interval
+func configKey(f *pflag.Flag) string {
+	if key, ok := f.Annotations[configKeyAnnotation]; ok && len(key) == 1 {
+		return key[0]
+	}
+	return strings.ReplaceAll(f.Name, "-", "_")
+}
+
+// bindFlags binds every flag of the running command, including the inherited
+// persistent ones, to its config key. Only the running command is bound, as
+// commands may share flag names with different meanings
+func bindFlags(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil {
+			return
+		}
+		err = viper.BindPFlag(configKey(f), f)
+	})
+
+	return err
+}
+
+// initConfig reads the config file and applies the selected profile.
+// Settings resolve in the order flag, PACKETEER_* environment variable,
+// profile, config file and finally the flag default
+func initConfig() {
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.AutomaticEnv()
+
+	if err := readConfig(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := applyProfile(viper.GetString("profile")); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readConfig reads the --config file. The default file may be missing, in
+// which case everything falls back to the flag defaults, but a file that was
+// asked for explicitly must exist
+func readConfig() error {
+	if cfgFile == "" {
+		return nil
+	}
+
+	viper.SetConfigFile(cfgFile)
+	err := viper.ReadInConfig()
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, fs.ErrNotExist) && !rootCmd.PersistentFlags().Changed("config") {
+		return nil
+	}
+	return fmt.Errorf("reading config %s: %w", cfgFile, err)
+}
+
+// applyProfile merges the settings under profiles.<name> over the top level
+// of the config file. An empty name applies no profile
+func applyProfile(name string) error {
+	if name == "" {
+		return nil
+	}
+
+	profile := viper.Sub("profiles." + name)
+	if profile == nil {
+		return fmt.Errorf("unknown profile %q, define it under profiles: in %s", name, cfgFile)
+	}
+
+	return viper.MergeConfigMap(profile.AllSettings())
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/gopacket/gopacket"
+	"github.com/spf13/viper"
+
+	"packeteer/internal/defrag"
+	"packeteer/internal/pcapwriter"
+)
+
+// defragmenter puts fragmented IP datagrams back together before they are
+// decoded, see defragment
+var defragmenter *defrag.Defragmenter
+
+// defragConfig reads the defragmentation limits from the flags or the defrag:
+// section of the config file
+func defragConfig() (defrag.Config, error) {
+	maxBuffered, err := pcapwriter.ParseSize(viper.GetString("defrag.max_buffer"))
+	if err != nil {
+		return defrag.Config{}, fmt.Errorf("defragmentation buffer: %w", err)
+	}
+
+	return defrag.Config{
+		Timeout:     viper.GetDuration("defrag.timeout"),
+		MaxBuffered: int(maxBuffered),
+	}, nil
+}
+
+// defragment returns the packet to decode for p: p itself, the datagram it
+// completes, or nil while its datagram is incomplete
+func defragment(p gopacket.Packet) gopacket.Packet {
+	if defragmenter == nil {
+		return p
+	}
+	return defragmenter.Defrag(p)
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+
+	"packeteer/internal/filter"
+)
+
+// compileFilter compiles the display filter given with --filter for records of
+// scope, exiting with the position of the mistake when it does not parse. No
+// filter gives nil, which matches everything
+func compileFilter(expr string, scope filter.Scope) *filter.Filter {
+	if expr == "" {
+		return nil
+	}
+
+	f, err := filter.Compile(expr, scope)
+	if err != nil {
+		var se *filter.SyntaxError
+		if errors.As(err, &se) {
+			log.Fatalf("%v\n%s", err, se.Context())
+		}
+		log.Fatal(err)
+	}
+	return f
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"packeteer/internal/output"
+	"packeteer/internal/packet"
+	"packeteer/internal/quic"
+	"packeteer/internal/storage"
+	"packeteer/internal/tls"
+)
+
+var (
+	// quicTracker reads the handshakes of the QUIC connections, see
+	// recordQUIC
+	quicTracker *quic.Tracker
+	// knownBad are the fingerprints that raise an alert when seen
+	knownBad tls.KnownBad
+	// tlsAlerts is where alerts are printed as they are raised, they are only
+	// stored when nil
+	tlsAlerts io.Writer
+)
+
+// fingerprintsCmd represents the fingerprints command
+var fingerprintsCmd = &cobra.Command{
+	Use:   "fingerprints",
+	Short: "list the TLS fingerprints seen by sniff",
+	Long: `List the TLS client fingerprints of the handshakes seen by sniff, each
+with the hosts that presented it and the server names they asked for. JA4 is
+used unless --type asks for JA3, or for JA3S which fingerprints servers instead
+of clients. With --alerts the handshakes that matched the --tls-known-bad list
+of sniff are listed instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ListFingerprints(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fingerprintsCmd)
+
+	fingerprintsCmd.Flags().StringP("type", "t", "ja4", "fingerprint: ja4, ja3 or ja3s")
+	fingerprintsCmd.Flags().BoolP("alerts", "a", false, "list the known bad fingerprint alerts instead")
+	fingerprintsCmd.Flags().StringP("format", "f", "table", "output format: table or json")
+	setConfigKey(fingerprintsCmd.Flags(), "format", "fingerprints.format")
+}
+
+// ListFingerprints prints the fingerprint report or the alerts in the chosen
+// format
+func ListFingerprints(cmd *cobra.Command) {
+	format := viper.GetString("fingerprints.format")
+	if format != "table" && format != "json" {
+		log.Fatalf("unknown format %q", format)
+	}
+
+	var err error
+	if viper.GetBool("alerts") {
+		var alerts []storage.TLSAlert
+		alerts, err = storage.GetTLSAlerts(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if format == "json" {
+			err = output.PrintTLSAlertsJSON(os.Stdout, alerts)
+		} else {
+			err = output.PrintTLSAlerts(os.Stdout, alerts)
+		}
+	} else {
+		var fps []storage.TLSFingerprint
+		fps, err = storage.GetTLSFingerprints(db, viper.GetString("type"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if format == "json" {
+			err = output.PrintFingerprintsJSON(os.Stdout, fps)
+		} else {
+			err = output.PrintFingerprints(os.Stdout, fps)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadKnownBad reads the known bad fingerprint list, when one is configured
+func loadKnownBad() tls.KnownBad {
+	path := viper.GetString("tls.known_bad")
+	if path == "" {
+		return nil
+	}
+
+	known, err := tls.LoadKnownBad(path)
+	if err != nil {
+		log.Fatalf("loading known bad fingerprints: %v", err)
+	}
+	return known
+}
+
+// recordTLS tags a TCP packet with what its connection's handshake taught,
+// which is stored once the handshake is over. pi is nil when the session
+// ended without a packet of its own
+func recordTLS(pi *packet.PacketInfo, s *tls.Session) {
+	info, complete := s.Take()
+	if info == nil {
+		return
+	}
+
+	if pi != nil {
+		pi.TLS = info
+	}
+	if complete {
+		storeTLS(info)
+	}
+}
+
+// recordQUIC feeds a UDP packet to the QUIC tracker. A packet of a QUIC
+// connection is tagged as such, and with what it taught about the handshake
+// like a TCP one
+func recordQUIC(pi *packet.PacketInfo) {
+	info, hs, complete := quicTracker.Feed(pi.Datagram())
+	if info == nil {
+		return
+	}
+
+	pi.Protocol = packet.QUIC
+	pi.QUIC = info
+	if hs == nil {
+		return
+	}
+	pi.TLS = hs
+	if complete {
+		storeTLS(hs)
+	}
+}
+
+// storeTLS stores a finished handshake and checks it against the known bad
+// fingerprints
+func storeTLS(info *tls.Info) {
+	if err := tls.InsertTLSInfo(info, db); err != nil {
+		log.Fatalf("inserting into tls table: %v", err)
+	}
+
+	for _, alert := range knownBad.Check(info) {
+		err := storage.InsertTLSAlert(db, storage.TLSAlert{
+			Timestamp:   alert.Time,
+			Kind:        alert.Kind,
+			Fingerprint: alert.Fingerprint,
+			Description: alert.Description,
+			ClientIP:    alert.ClientIP,
+			ServerIP:    alert.ServerIP,
+			ServerPort:  alert.ServerPort,
+			SNI:         alert.SNI,
+			Message:     alert.Message,
+		})
+		if err != nil {
+			log.Fatalf("inserting into tls alerts: %v", err)
+		}
+		if tlsAlerts != nil {
+			fmt.Fprintf(tlsAlerts, "TLS alert: %s\n", alert)
+		}
+	}
+}
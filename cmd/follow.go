@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gopacket/gopacket/pcap"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"packeteer/internal/capture"
+	"packeteer/internal/defrag"
+	"packeteer/internal/follow"
+	"packeteer/internal/output"
+	"packeteer/internal/packet"
+)
+
+// followCmd represents the follow command
+var followCmd = &cobra.Command{
+	Use:   "follow",
+	Short: "dump the payload of one TCP or UDP conversation",
+	Long: `follow prints what the two sides of a TCP or UDP conversation sent,
+interleaved and colored per side, with TCP put back in order first. Pick the
+conversation with --conn, as shown in the connections table, or with --index.
+Without either the conversations of the capture are listed with their index`,
+	Run: func(cmd *cobra.Command, args []string) {
+		Follow(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(followCmd)
+
+	followCmd.Flags().
+		String("conn", "", "conversation to follow (ex. '10.0.0.5:51234-->10.0.0.9:443/TCP')")
+	followCmd.Flags().
+		Int("index", -1, "follow the Nth TCP or UDP conversation of the capture, counting from 0")
+	followCmd.Flags().
+		StringP("read", "r", "", "read packets from a pcap or pcapng file instead of an interface")
+	followCmd.Flags().
+		StringSliceP("device", "d", nil, "set devices to listen to, repeatable (ex. -d eth0 -d eth1)")
+	followCmd.Flags().StringP("bpf", "b", "", "set bpf filters")
+	followCmd.Flags().
+		StringP("format", "f", output.FollowASCII, "output: ascii, hex, yaml, or raw to write each side to a file")
+	followCmd.Flags().
+		StringP("output", "o", "", "with --format raw, write PREFIX.client and PREFIX.server")
+	setConfigKey(followCmd.Flags(), "format", "follow.format")
+
+	followCmd.Flags().
+		String("defrag-max-buffer", "16MB", "IP fragments held across incomplete datagrams before giving up on the oldest")
+	followCmd.Flags().
+		Duration("defrag-timeout", defrag.DefaultConfig().Timeout, "give up on a fragmented IP datagram this long after its first fragment")
+	setConfigKey(followCmd.Flags(), "defrag-max-buffer", "defrag.max_buffer")
+	setConfigKey(followCmd.Flags(), "defrag-timeout", "defrag.timeout")
+
+	addCaptureFlags(followCmd.Flags())
+}
+
+// Follow reads the capture until it ends, or until ctrl+c when live, printing
+// the payload of the chosen conversation as it goes
+func Follow(cmd *cobra.Command) {
+	devices = viper.GetStringSlice("device")
+	bpf = viper.GetString("bpf")
+	readFile = viper.GetString("read")
+
+	conn, index := viper.GetString("conn"), viper.GetInt("index")
+	if conn != "" && index >= 0 {
+		log.Fatal("--conn and --index cannot be combined")
+	}
+
+	var (
+		onChunk func(follow.Key, follow.Chunk)
+		done    func(k follow.Key, client, server int64) error
+	)
+	switch format := viper.GetString("follow.format"); format {
+	case "raw":
+		raw, err := newRawFollower(viper.GetString("output"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		onChunk, done = raw.chunk, raw.close
+	default:
+		printer, err := output.NewFollowPrinter(os.Stdout, format)
+		if err != nil {
+			log.Fatal(err)
+		}
+		onChunk = func(k follow.Key, c follow.Chunk) {
+			if err := printer.Chunk(k, c); err != nil {
+				log.Fatalf("writing conversation: %v", err)
+			}
+		}
+		done = printer.Close
+	}
+
+	var f *follow.Follower
+	switch {
+	case conn != "":
+		k, err := follow.ParseKey(conn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f = follow.ByKey(k, onChunk)
+	default:
+		// Without an index nothing is followed, the conversations are listed
+		f = follow.ByIndex(index, onChunk)
+	}
+
+	fragLimits, err := defragConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := openFollowSource()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer src.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	defragmenter = defrag.NewDefragmenter(fragLimits)
+	for p := range untilDone(ctx, src.Packets()) {
+		if p = defragment(p); p == nil {
+			continue
+		}
+		pi, _ := packet.ExtractPacketInfo(p)
+		f.Feed(pi)
+	}
+	f.Close()
+
+	if conn == "" && index < 0 {
+		if err := output.PrintConversations(os.Stdout, f.Keys()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	k, ok := f.Found()
+	if !ok {
+		log.Fatal("the conversation was not seen, or carried no payload")
+	}
+	client, server := f.Sent()
+	if err := done(k, client, server); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// openFollowSource opens the --read file, or otherwise the --device
+// interfaces with the capture settings and backend sniff uses, asking for
+// them when none were set
+func openFollowSource() (capture.Source, error) {
+	if readFile != "" {
+		return capture.OpenFile(readFile, bpf)
+	}
+
+	if len(devices) == 0 {
+		var err error
+		devices, err = packet.SelectInterfaces(pcap.FindAllDevs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	backend := viper.GetString("backend")
+	live, err := liveConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]capture.Source, 0, len(devices))
+	for _, device := range devices {
+		src, err := openDevice(backend, device, live)
+		if err != nil {
+			for _, s := range sources {
+				s.Close()
+			}
+			return nil, fmt.Errorf("opening %s: %w", device, err)
+		}
+		sources = append(sources, src)
+	}
+
+	if len(sources) == 1 {
+		return sources[0], nil
+	}
+	return capture.Merge(sources...), nil
+}
+
+// rawFollower writes what each side of a conversation sent to a file of its
+// own, byte for byte
+type rawFollower struct {
+	client, server *os.File
+}
+
+func newRawFollower(prefix string) (*rawFollower, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("--format raw needs an --output prefix")
+	}
+
+	client, err := os.Create(prefix + ".client")
+	if err != nil {
+		return nil, err
+	}
+	server, err := os.Create(prefix + ".server")
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &rawFollower{client: client, server: server}, nil
+}
+
+func (r *rawFollower) chunk(k follow.Key, c follow.Chunk) {
+	w := r.server
+	if c.FromClient {
+		w = r.client
+	}
+	if _, err := w.Write(c.Data); err != nil {
+		log.Fatalf("writing conversation: %v", err)
+	}
+}
+
+func (r *rawFollower) close(k follow.Key, client, server int64) error {
+	for _, f := range []*os.File{r.client, r.server} {
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%s: wrote %d bytes to %s and %d bytes to %s\n",
+		k, client, r.client.Name(), server, r.server.Name())
+	return nil
+}
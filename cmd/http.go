@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"packeteer/internal/http"
+	"packeteer/internal/output"
+	"packeteer/internal/packet"
+	"packeteer/internal/storage"
+)
+
+// httpStatsCmd represents the http-stats command
+var httpStatsCmd = &cobra.Command{
+	Use:   "http-stats",
+	Short: "get some http stats",
+	Long: `Sum up the plaintext HTTP/1.x transactions seen by sniff, grouped by host,
+status, path or method with their request count, errors, bytes and latency.
+With --slowest the transactions that waited longest for their response are
+listed instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		GetHTTPStats(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(httpStatsCmd)
+
+	httpStatsCmd.Flags().String("by", "host", "group by host, status, path or method")
+	httpStatsCmd.Flags().Int("slowest", 0, "list the N slowest transactions instead")
+	httpStatsCmd.Flags().StringP("format", "f", "table", "output format: table or json")
+	setConfigKey(httpStatsCmd.Flags(), "format", "http_stats.format")
+}
+
+// GetHTTPStats prints the grouped transactions or the slowest ones in the
+// chosen format
+func GetHTTPStats(cmd *cobra.Command) {
+	format := viper.GetString("http_stats.format")
+	if format != "table" && format != "json" {
+		log.Fatalf("unknown format %q", format)
+	}
+
+	var err error
+	if n := viper.GetInt("slowest"); n > 0 {
+		var txs []storage.HTTPTransaction
+		txs, err = storage.GetSlowestHTTPTransactions(db, n)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if format == "json" {
+			err = output.PrintHTTPTransactionsJSON(os.Stdout, txs)
+		} else {
+			err = output.PrintHTTPTransactions(os.Stdout, txs)
+		}
+	} else {
+		by := viper.GetString("by")
+		var stats []storage.HTTPStat
+		stats, err = storage.GetHTTPStats(db, by)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if format == "json" {
+			err = output.PrintHTTPStatsJSON(os.Stdout, stats)
+		} else {
+			err = output.PrintHTTPStats(os.Stdout, by, stats)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// recordHTTP tags a TCP packet of an HTTP conversation as such, and with the
+// transactions it completed, which are stored. pi is nil when the
+// conversation ended without a packet of its own
+func recordHTTP(pi *packet.PacketInfo, c *http.Conversation) {
+	done, ok := c.Take()
+	if !ok {
+		return
+	}
+
+	if pi != nil {
+		if len(pi.Payload) > 0 {
+			pi.Protocol = packet.HTTP
+		}
+		pi.HTTP = done
+	}
+	for i := range done {
+		if err := http.InsertTransaction(&done[i], db); err != nil {
+			log.Fatalf("inserting into http table: %v", err)
+		}
+	}
+}
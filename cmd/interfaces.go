@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gopacket/gopacket/pcap"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"packeteer/internal/output"
+	"packeteer/internal/packet"
+)
+
+// interfacesCmd represents the interfaces command
+var interfacesCmd = &cobra.Command{
+	Use:   "interfaces",
+	Short: "list the interfaces available for capture",
+	Long: `List every capture device with its addresses, flags and supported link
+types. Link types are read by opening the device, which usually needs the same
+privileges as capturing, they show as ? otherwise.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ListInterfaces(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(interfacesCmd)
+
+	interfacesCmd.Flags().
+		BoolP("all", "a", false, "include devices without addresses, such as bridge ports or monitor-mode devices")
+	interfacesCmd.Flags().StringP("format", "f", "table", "output format: table or json")
+	setConfigKey(interfacesCmd.Flags(), "format", "interfaces.format")
+}
+
+// ListInterfaces prints the capture devices in the chosen format
+func ListInterfaces(cmd *cobra.Command) {
+	ifaces, err := packet.ListInterfaces(pcap.FindAllDevs, packet.DataLinks, viper.GetBool("all"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch format := viper.GetString("interfaces.format"); format {
+	case "table":
+		err = output.PrintInterfaces(os.Stdout, ifaces)
+	case "json":
+		err = output.PrintInterfacesJSON(os.Stdout, ifaces)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
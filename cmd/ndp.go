@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"packeteer/internal/output"
+	"packeteer/internal/storage"
+)
+
+// ndpCmd represents the ndp command
+var ndpCmd = &cobra.Command{
+	Use:   "ndp",
+	Short: "list the IPv6 neighbors learned by sniff",
+	Long: `List the IPv6 to MAC bindings learned from the neighbor discovery traffic
+seen by sniff, the IPv6 counterpart of the arp command. Neighbors that sent
+router advertisements or set the router flag are marked as routers. With
+--alerts the duplicate IP, flapping, storm and rogue router advertisement
+alerts about IPv6 addresses are listed instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ListNDP(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ndpCmd)
+
+	ndpCmd.Flags().BoolP("alerts", "a", false, "list the alerts instead of the table")
+	ndpCmd.Flags().StringP("format", "f", "table", "output format: table or json")
+	setConfigKey(ndpCmd.Flags(), "format", "ndp.format")
+}
+
+// ListNDP prints the learned IPv6 neighbor table or the alerts in the chosen
+// format
+func ListNDP(cmd *cobra.Command) {
+	format := viper.GetString("ndp.format")
+	if format != "table" && format != "json" {
+		log.Fatalf("unknown format %q", format)
+	}
+
+	var err error
+	if viper.GetBool("alerts") {
+		alerts := neighborAlerts(true)
+		if format == "json" {
+			err = output.PrintARPAlertsJSON(os.Stdout, alerts)
+		} else {
+			err = output.PrintARPAlerts(os.Stdout, alerts)
+		}
+	} else {
+		var neighbors []storage.NDPNeighbor
+		neighbors, err = storage.GetNDPNeighbors(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if format == "json" {
+			err = output.PrintNDPTableJSON(os.Stdout, neighbors)
+		} else {
+			err = output.PrintNDPTable(os.Stdout, neighbors)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
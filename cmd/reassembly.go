@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+
+	"packeteer/internal/http"
+	"packeteer/internal/packet"
+	"packeteer/internal/pcapwriter"
+	"packeteer/internal/reassembly"
+	"packeteer/internal/tls"
+)
+
+// assembler puts the TCP connections of the capture back in order for the
+// TLS and HTTP trackers, see recordStreams
+var assembler *reassembly.Assembler
+
+// reassemblyConfig reads the reassembly limits from the flags or the
+// reassembly: section of the config file
+func reassemblyConfig() (reassembly.Config, error) {
+	maxBuffered, err := pcapwriter.ParseSize(viper.GetString("reassembly.max_buffer"))
+	if err != nil {
+		return reassembly.Config{}, fmt.Errorf("reassembly buffer: %w", err)
+	}
+	maxPerConn, err := pcapwriter.ParseSize(viper.GetString("reassembly.max_conn_buffer"))
+	if err != nil {
+		return reassembly.Config{}, fmt.Errorf("reassembly connection buffer: %w", err)
+	}
+
+	return reassembly.Config{
+		MaxBuffered:        int(maxBuffered),
+		MaxBufferedPerConn: int(maxPerConn),
+		Timeout:            viper.GetDuration("reassembly.timeout"),
+	}, nil
+}
+
+// newAssembler returns an Assembler handing connections to the TLS and HTTP
+// trackers
+func newAssembler(cfg reassembly.Config) *reassembly.Assembler {
+	a := reassembly.NewAssembler(cfg)
+	a.Register(tls.NewTracker())
+	a.Register(http.NewTracker())
+	return a
+}
+
+// recordStreams feeds a TCP packet to the assembler, after letting go of the
+// connections that went quiet, and records what the streams reading its
+// connection learned
+func recordStreams(pi *packet.PacketInfo) {
+	collectStreams(assembler.Expire(pi.Timestamp), nil)
+	collectStreams(assembler.Feed(pi.Segment()), pi)
+}
+
+// collectStreams records what streams learned, tagging pi with it unless nil,
+// as when the streams' connections were removed or the capture ended
+func collectStreams(streams []reassembly.Stream, pi *packet.PacketInfo) {
+	for _, s := range streams {
+		switch s := s.(type) {
+		case *tls.Session:
+			recordTLS(pi, s)
+		case *http.Conversation:
+			recordHTTP(pi, s)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"database/sql"
-	"fmt"
 	"log"
 	"os"
 	"path"
@@ -10,6 +9,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"packeteer/internal/oui"
 	"packeteer/internal/storage"
 )
 
@@ -20,6 +20,16 @@ var rootCmd = &cobra.Command{
 	Use:   "packeteer",
 	Short: "packet sniffer",
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := bindFlags(cmd); err != nil {
+			log.Fatal(err)
+		}
+
+		if f := viper.GetString("oui_file"); f != "" {
+			if err := oui.Load(f); err != nil {
+				log.Fatalf("loading oui file: %v", err)
+			}
+		}
+
 		// Set up DB
 		var err error
 		db, err = storage.OpenDb(viper.GetString("db_path"))
@@ -46,26 +56,17 @@ func init() {
 
 	rootCmd.PersistentFlags().
 		StringVar(&cfgFile, "config", path.Join(homeDir, ".packeteer.yaml"), "config file")
+	rootCmd.PersistentFlags().
+		String("profile", "", "apply the settings under profiles.<name> in the config file")
+	rootCmd.PersistentFlags().
+		String("db", path.Join(homeDir, ".packeteer.db"), "sqlite3 database to store results in")
+	setConfigKey(rootCmd.PersistentFlags(), "db", "db_path")
+	rootCmd.PersistentFlags().
+		String("oui-file", "", "Wireshark manuf file adding to the built-in MAC vendor names")
 
-	rootCmd.Flags().BoolP("find-interfaces", "i", false, "")
-	rootCmd.Flags().
-		StringVarP(&device, "device", "d", "", "set device to listen to (ex. wlan0, eth0)")
-	rootCmd.Flags().StringVarP(&bpf, "bpf", "b", "", "set bpf filters")
-}
-
-func initConfig() {
-	if cfgFile != "" {
-		viper.SetConfigFile(cfgFile)
-	} else {
-		fmt.Println("no config file set")
-		panic(
-			"no config file set. Please create a $HOME/.packeteer.yaml file or pass in a new path",
-		)
-	}
-
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			log.Fatal(err)
-		}
+	// The profile picks which settings apply, so unlike the other flags it
+	// is needed before any command runs
+	if err := viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile")); err != nil {
+		log.Fatal(err)
 	}
 }
@@ -1,25 +1,47 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	tea "charm.land/bubbletea/v2"
 	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/pcap"
+	"github.com/gopacket/gopacket/pcapgo"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 
+	"packeteer/internal/arp"
+	"packeteer/internal/capture"
 	"packeteer/internal/conntrack"
+	"packeteer/internal/defrag"
 	"packeteer/internal/dns"
+	"packeteer/internal/filter"
 	"packeteer/internal/output"
 	"packeteer/internal/packet"
+	"packeteer/internal/pcapwriter"
+	"packeteer/internal/quic"
+	"packeteer/internal/reassembly"
 )
 
+// packetQueueSize is how many packets may wait for the connections TUI before
+// live capture starts dropping them instead of stalling
+const packetQueueSize = 4096
+
 var (
-	device  string
-	bpf     string
-	cfgFile string
+	devices  []string
+	bpf      string
+	cfgFile  string
+	readFile string
+	writeTo  string
 
 	homeDir, _ = os.UserHomeDir()
 )
@@ -37,93 +59,644 @@ func init() {
 	rootCmd.AddCommand(sniffCmd)
 
 	sniffCmd.Flags().BoolP("find-interfaces", "i", false, "show available interfaces")
-	sniffCmd.Flags().StringVarP(&device, "device", "d", "", "set device to listen to (ex. wlan0)")
-	sniffCmd.Flags().StringVarP(&bpf, "bpf", "b", "", "set bpf filters")
+	sniffCmd.Flags().
+		StringSliceP("device", "d", nil, "set devices to listen to, repeatable (ex. -d eth0 -d eth1)")
+	sniffCmd.Flags().StringP("bpf", "b", "", "set bpf filters")
+	sniffCmd.Flags().
+		StringP("filter", "Y", "", "display filter applied after decoding (ex. 'tcp.port == 443 && !ip.dst in {10.0.0.0/8}')")
+	sniffCmd.Flags().
+		StringP("read", "r", "", "read packets from a pcap or pcapng file instead of an interface")
+	sniffCmd.Flags().Bool("realtime", false, "replay a --read file at the pace it was captured")
+
+	sniffCmd.Flags().
+		StringP("write", "w", "", "also save captured packets to this pcapng file")
+	sniffCmd.Flags().
+		String("rotate-size", "", "start a new --write file after this size (ex. 100MB)")
+	sniffCmd.Flags().
+		Duration("rotate-every", 0, "start a new --write file after this much time (ex. 15m)")
+	sniffCmd.Flags().Int("max-files", 0, "number of rotated --write files to keep, 0 keeps all")
+	sniffCmd.Flags().Bool("gzip", false, "gzip --write files once they are rotated out")
 
 	sniffCmd.Flags().BoolP("connections", "c", false, "a life-refreshing TUI connections table")
+	sniffCmd.Flags().
+		Bool("key-by-vlan", false, "with --connections, track the same flow on different VLANs separately")
+	sniffCmd.Flags().
+		StringP("format", "f", "text", "packet output: text, or json for one JSON object per line")
+	sniffCmd.Flags().
+		StringP("template", "t", "", "print packets with a Go text/template, or the name of one under templates: in the config file")
+	sniffCmd.Flags().
+		CountP("verbose", "v", "dissect every layer of each packet, -vv for all header fields")
+	sniffCmd.Flags().BoolP("hex", "X", false, "add a hex and ASCII dump of each packet")
+	sniffCmd.Flags().
+		Duration("stats-interval", 0, "print capture statistics as a JSON line to stderr this often")
+
+	// Other commands have a --format and --filter of their own, each is read
+	// from its command's section of the config file
+	setConfigKey(sniffCmd.Flags(), "format", "sniff.format")
+	setConfigKey(sniffCmd.Flags(), "filter", "sniff.filter")
+
+	addCaptureFlags(sniffCmd.Flags())
+
+	def := arp.DefaultConfig()
+	sniffCmd.Flags().
+		Duration("arp-duplicate-window", def.DuplicateWindow, "two MACs claiming an IP within this long is a duplicate IP alert")
+	sniffCmd.Flags().
+		Int("arp-flap-count", def.FlapCount, "MAC changes of an IP within --arp-flap-window that raise a flapping alert")
+	sniffCmd.Flags().
+		Duration("arp-flap-window", def.FlapWindow, "window for --arp-flap-count")
+	sniffCmd.Flags().
+		Int("arp-storm-rate", def.StormRate, "ARP packets a second from one MAC that raise a storm alert")
+	sniffCmd.Flags().
+		StringSlice("ndp-routers", nil, "IPv6 or MAC addresses allowed to send router advertisements, by default the first router seen is trusted")
+
+	// The ARP thresholds live under arp: and the NDP ones under ndp: in the
+	// config file
+	setConfigKey(sniffCmd.Flags(), "arp-duplicate-window", "arp.duplicate_window")
+	setConfigKey(sniffCmd.Flags(), "arp-flap-count", "arp.flap_count")
+	setConfigKey(sniffCmd.Flags(), "arp-flap-window", "arp.flap_window")
+	setConfigKey(sniffCmd.Flags(), "arp-storm-rate", "arp.storm_rate")
+	setConfigKey(sniffCmd.Flags(), "ndp-routers", "ndp.routers")
+
+	sniffCmd.Flags().
+		String("tls-known-bad", "", "file of known bad JA3, JA3S or JA4 fingerprints to alert on, one per line")
+	setConfigKey(sniffCmd.Flags(), "tls-known-bad", "tls.known_bad")
+
+	sniffCmd.Flags().
+		String("reassembly-max-buffer", "64MB", "out of order TCP bytes held across connections before giving up on missing segments")
+	sniffCmd.Flags().
+		String("reassembly-max-conn-buffer", "1MB", "out of order TCP bytes held for one connection")
+	sniffCmd.Flags().
+		Duration("reassembly-timeout", reassembly.DefaultConfig().Timeout, "forget a TCP connection's streams after this long without a packet, the TUI also drops them with its connections")
+	setConfigKey(sniffCmd.Flags(), "reassembly-max-buffer", "reassembly.max_buffer")
+	setConfigKey(sniffCmd.Flags(), "reassembly-max-conn-buffer", "reassembly.max_conn_buffer")
+	setConfigKey(sniffCmd.Flags(), "reassembly-timeout", "reassembly.timeout")
+
+	sniffCmd.Flags().
+		String("defrag-max-buffer", "16MB", "IP fragments held across incomplete datagrams before giving up on the oldest")
+	sniffCmd.Flags().
+		Duration("defrag-timeout", defrag.DefaultConfig().Timeout, "give up on a fragmented IP datagram this long after its first fragment")
+	setConfigKey(sniffCmd.Flags(), "defrag-max-buffer", "defrag.max_buffer")
+	setConfigKey(sniffCmd.Flags(), "defrag-timeout", "defrag.timeout")
 }
 
 // Sniff looks at the packet and, currently, prints out the packet info. It will
-// also store any DNS packets within the sqlite3 database
+// also store any DNS packets within the sqlite3 database. Settings come from
+// the flags, the environment or the config file, see initConfig
 func Sniff(cmd *cobra.Command) {
-	showConnections, err := cmd.Flags().GetBool("connections")
-	if err != nil {
-		log.Fatal(err)
+	devices = viper.GetStringSlice("device")
+	bpf = viper.GetString("bpf")
+	readFile = viper.GetString("read")
+	writeTo = viper.GetString("write")
+
+	showConnections := viper.GetBool("connections")
+	realtime := viper.GetBool("realtime")
+	statsInterval := viper.GetDuration("stats_interval")
+
+	format := viper.GetString("sniff.format")
+	if format != "text" && format != "json" {
+		log.Fatalf("unknown format %q", format)
+	}
+	if format == "json" && showConnections {
+		log.Fatal("--format json cannot be combined with --connections")
 	}
 
-	// Get interface to sniff
-	search, err := cmd.Flags().GetBool("find-interfaces")
-	if err != nil {
-		log.Fatal(err)
+	verbosity := viper.GetInt("verbose")
+	hexDump := viper.GetBool("hex")
+	if format == "json" && (verbosity > 0 || hexDump) {
+		log.Fatal("-v and -X cannot be combined with --format json")
 	}
 
-	if search || device == "" {
-		device, err = packet.SelectInterface(pcap.FindAllDevs)
+	var tmpl *output.TemplatePrinter
+	if t := viper.GetString("template"); t != "" {
+		if format != "text" {
+			log.Fatal("--template cannot be combined with --format json")
+		}
+
+		var err error
+		tmpl, err = output.NewTemplatePrinter(os.Stdout, lookupTemplate(t))
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("parsing template: %v", err)
 		}
 	}
 
-	// Open connection to network interface
-	handle, err := pcap.OpenLive(device, 1600, true, pcap.BlockForever)
+	scope := filter.ScopePacket
+	if showConnections {
+		scope = filter.ScopeConnection
+	}
+	displayFilter := compileFilter(viper.GetString("sniff.filter"), scope)
+
+	// With JSON on stdout everything else goes to stderr, so that the output
+	// can be piped straight into jq
+	summary := io.Writer(os.Stdout)
+	if format == "json" {
+		summary = os.Stderr
+	}
+
+	// ARP, NDP and TLS alerts are printed along with the summary, the TUI
+	// only stores them
+	arpMonitor = arp.NewMonitor(arpConfig())
+	if !showConnections {
+		arpAlerts = summary
+	}
+	quicTracker = quic.NewTracker()
+	knownBad = loadKnownBad()
+	if !showConnections {
+		tlsAlerts = summary
+	}
+
+	// The TUI lets go of a connection's streams when it cleans the connection
+	// up. Connections it never tracked, like those first seen mid-stream or
+	// whose SYN was dropped, still need the timeout
+	limits, err := reassemblyConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if showConnections && limits.Timeout <= 0 {
+		limits.Timeout = reassembly.DefaultConfig().Timeout
+	}
+	assembler = newAssembler(limits)
+
+	fragLimits, err := defragConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defragmenter = defrag.NewDefragmenter(fragLimits)
+
+	src, ifaces, err := openSource()
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer src.Close()
+
+	// Stop reading on ctrl+c so that deferred cleanup, like flushing the
+	// --write file, still happens
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	monitor := capture.NewMonitor(src)
 
-	if bpf != "" {
-		if err := handle.SetBPFFilter(bpf); err != nil {
+	// Packet processing
+	packets := untilDone(ctx, src.Packets())
+	if writeTo != "" {
+		w, err := newPacketWriter(src, ifaces)
+		if err != nil {
 			log.Fatal(err)
 		}
+		var written <-chan struct{}
+		packets, written = writePackets(ctx, packets, w)
+
+		// Deferred calls run last first, so the capture is stopped here
+		// rather than by the defers above: a packet written after Close would
+		// start the file over
+		defer func() {
+			stop()
+			src.Close()
+			<-written
+			if err := w.Close(); err != nil {
+				log.Printf("closing %s: %v", writeTo, err)
+			}
+		}()
+	}
+	if readFile != "" && realtime {
+		packets = paceReplay(packets)
 	}
 
-	// Packet processing
-	packetSrc := gopacket.NewPacketSource(handle, handle.LinkType())
 	if showConnections {
-		packetChan := make(chan *packet.PacketInfo)
+		packetChan := make(chan *packet.PacketInfo, packetQueueSize)
 		go func() {
-			for p := range packetSrc.Packets() {
-				pi, err := packet.ExtractPacketInfo(p)
-				if err != nil {
-					log.Fatalf("error extracting packet info: %v", err)
+			defer close(packetChan)
+			for p := range packets {
+				if p = defragment(p); p == nil {
+					continue
 				}
+				pi, _ := handlePacket(p, ifaces)
 				if pi == nil {
 					continue
 				}
 
-				if pi.Protocol == packet.TCP || pi.Protocol == packet.UDP {
-					packetChan <- pi
+				tracked := pi.Transport == packet.TCP || pi.Transport == packet.UDP ||
+					pi.ICMP != nil || pi.Protocol == packet.ESP
+				if !tracked {
+					continue
 				}
 
+				// A slow TUI must not stall live capture, packets are dropped
+				// and counted instead. A file can simply wait
+				if readFile != "" {
+					packetChan <- pi
+				} else {
+					capture.Offer(monitor, packetChan, pi)
+				}
 			}
 		}()
 
 		// Running the bubbletea application
 		m := conntrack.NewModel(packetChan)
+		if readFile != "" {
+			// Packets from a file carry their own time, the wall clock would
+			// age every connection out immediately
+			m.UsePacketClock()
+		}
+		if viper.GetBool("key_by_vlan") {
+			m.KeyByVLAN()
+		}
+		m.OnRemove(func(c *conntrack.Connection) {
+			if c.Protocol == packet.TCP {
+				collectStreams(assembler.Remove(c.SrcIP, c.SrcPort, c.DstIP, c.DstPort), nil)
+			}
+		})
+		m.SetFilter(displayFilter)
+		m.ShowStats(func() capture.Stats {
+			stats, _ := monitor.Stats()
+			return stats
+		})
 		p := tea.NewProgram(m)
 		if _, err := p.Run(); err != nil {
 			fmt.Printf("Alas, there's been an error: %v", err)
 			return
 		}
 
+		collectStreams(assembler.Flush(), nil)
 		m.PrintStats()
+		printCaptureStats(os.Stdout, monitor, statsInterval)
 		return
 	}
 
+	if statsInterval > 0 {
+		go monitor.Report(ctx, statsInterval, func(stats capture.Stats, err error) {
+			if err != nil {
+				log.Printf("reading capture statistics: %v", err)
+			}
+			if err := output.PrintStatsJSON(os.Stderr, time.Now(), stats); err != nil {
+				log.Printf("printing capture statistics: %v", err)
+			}
+		})
+	}
+
 	// Normal packet capture
 	n := 0
-	for p := range packetSrc.Packets() {
-		pi, dnsInfo := packet.ExtractPacketInfo(p)
+	for p := range packets {
+		// A fragment is shown once its datagram is whole, it keeps its number
+		// all the same
+		if p = defragment(p); p == nil {
+			n++
+			continue
+		}
+		pi, dnsInfo := handlePacket(p, ifaces)
 		if pi == nil {
 			log.Fatal("PacketInfo is nil")
 		}
 
-		if dnsInfo != nil {
-			if err := dns.InsertDNSInfo(dnsInfo, db); err != nil {
-				log.Fatalf("inserting into dns table: %v", err)
-			}
+		// Packets are numbered before filtering, so the numbers stay the
+		// same as in an unfiltered run and in the --write file
+		if !displayFilter.Match(filter.Packet(pi, dnsInfo)) {
+			n++
+			continue
 		}
 
-		output.PrintPacketInfo(pi, n)
+		// A packet the template skipped is not dissected either
+		printed := true
+		switch {
+		case format == "json":
+			if err := output.PrintPacketJSON(os.Stdout, pi, dnsInfo, n); err != nil {
+				log.Fatalf("writing packet: %v", err)
+			}
+		case tmpl != nil:
+			var err error
+			if printed, err = tmpl.Print(pi, dnsInfo, n); err != nil {
+				log.Fatalf("executing template: %v", err)
+			}
+		default:
+			output.PrintPacketInfo(pi, n)
+		}
+		if printed && (verbosity > 0 || hexDump) {
+			if err := output.PrintDissection(os.Stdout, p, verbosity, hexDump); err != nil {
+				log.Fatalf("writing packet: %v", err)
+			}
+		}
 		n++
 	}
+
+	// What the connections still open at the end taught is stored too
+	collectStreams(assembler.Flush(), nil)
+
+	fmt.Fprintln(summary)
+	printCaptureStats(summary, monitor, statsInterval)
+}
+
+// lookupTemplate returns the named template from the config file or the
+// built-in ones, or otherwise t itself as the template text
+func lookupTemplate(t string) string {
+	if named, ok := viper.GetStringMapString("templates")[strings.ToLower(t)]; ok {
+		return named
+	}
+	if named, ok := output.BuiltinTemplates[t]; ok {
+		return named
+	}
+	return t
+}
+
+// printCaptureStats prints the end-of-run capture summary to w, followed by a
+// final JSON stats line when those were asked for
+func printCaptureStats(w io.Writer, monitor *capture.Monitor, statsInterval time.Duration) {
+	stats, err := monitor.Stats()
+	if err != nil {
+		log.Printf("reading capture statistics: %v", err)
+	}
+
+	output.PrintCaptureStats(w, stats)
+	if defragmenter != nil {
+		output.PrintFragmentStats(w, defragmenter.Stats())
+	}
+	if statsInterval > 0 {
+		if err := output.PrintStatsJSON(os.Stderr, time.Now(), stats); err != nil {
+			log.Printf("printing capture statistics: %v", err)
+		}
+	}
+}
+
+// openSource opens the capture file given with --read, or otherwise the live
+// interfaces given with --device, asking for them when none were set. Several
+// interfaces are merged into one stream in timestamp order. The names of the
+// live interfaces are returned by InterfaceIndex
+func openSource() (capture.Source, []string, error) {
+	if readFile != "" {
+		// Offline analysis, libpcap handles both pcap and pcapng files
+		src, err := capture.OpenFile(readFile, bpf)
+		return src, nil, err
+	}
+
+	// Get interfaces to sniff
+	if viper.GetBool("find_interfaces") || len(devices) == 0 {
+		var err error
+		devices, err = packet.SelectInterfaces(pcap.FindAllDevs)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	backend := viper.GetString("backend")
+	live, err := liveConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sources := make([]capture.Source, 0, len(devices))
+	for _, device := range devices {
+		src, err := openDevice(backend, device, live)
+		if err != nil {
+			for _, s := range sources {
+				s.Close()
+			}
+			return nil, nil, fmt.Errorf("opening %s: %w", device, err)
+		}
+		sources = append(sources, src)
+	}
+
+	if len(sources) == 1 {
+		return sources[0], devices, nil
+	}
+	return capture.Merge(sources...), devices, nil
+}
+
+// liveConfig reads the libpcap capture settings from the flags or the
+// capture: section of the config file
+func liveConfig() (capture.LiveConfig, error) {
+	bufferSize, err := pcapwriter.ParseSize(viper.GetString("capture.buffer_size"))
+	if err != nil {
+		return capture.LiveConfig{}, fmt.Errorf("buffer size: %w", err)
+	}
+
+	return capture.LiveConfig{
+		SnapLen:         viper.GetInt("capture.snaplen"),
+		Promiscuous:     viper.GetBool("capture.promiscuous"),
+		Immediate:       viper.GetBool("capture.immediate"),
+		BufferSize:      int(bufferSize),
+		Timeout:         viper.GetDuration("capture.timeout"),
+		TimestampSource: viper.GetString("capture.tstamp_source"),
+	}, nil
+}
+
+// addCaptureFlags adds the flags of the live capture settings and backends,
+// read by liveConfig and openDevice
+func addCaptureFlags(flags *pflag.FlagSet) {
+	flags.
+		Int("snaplen", capture.DefaultSnapLen, "bytes captured of every packet, raise for jumbo frames")
+	flags.Bool("promiscuous", true, "capture traffic not addressed to the interface")
+	flags.
+		Bool("immediate", false, "deliver packets as soon as they arrive instead of in batches")
+	flags.
+		String("buffer-size", "", "kernel capture buffer size (ex. 16MB), libpcap default when empty")
+	flags.
+		Duration("timeout", 0, "how long the kernel may batch packets before delivering, 0 waits for a full buffer")
+	flags.
+		String("tstamp-source", "", "timestamp source: host, host_lowprec, host_hiprec, adapter or adapter_unsynced")
+
+	// The capture settings live under capture: in the config file
+	setConfigKey(flags, "snaplen", "capture.snaplen")
+	setConfigKey(flags, "promiscuous", "capture.promiscuous")
+	setConfigKey(flags, "immediate", "capture.immediate")
+	setConfigKey(flags, "buffer-size", "capture.buffer_size")
+	setConfigKey(flags, "timeout", "capture.timeout")
+	setConfigKey(flags, "tstamp-source", "capture.tstamp_source")
+
+	flags.String("backend", "pcap", "capture backend: pcap, or afpacket (Linux only)")
+	flags.
+		Int("afpacket-block-size", capture.DefaultAFPacketBlockSize, "afpacket ring block size in bytes")
+	flags.
+		Int("afpacket-blocks", capture.DefaultAFPacketNumBlocks, "number of blocks in the afpacket ring")
+	flags.
+		Int("afpacket-workers", capture.DefaultAFPacketWorkers, "afpacket sockets sharing the interface through fanout")
+	flags.
+		Uint16("afpacket-fanout-group", 0, "afpacket fanout group id, derived from the pid when 0")
+
+	// And the afpacket ones under afpacket:
+	setConfigKey(flags, "afpacket-block-size", "afpacket.block_size")
+	setConfigKey(flags, "afpacket-blocks", "afpacket.blocks")
+	setConfigKey(flags, "afpacket-workers", "afpacket.workers")
+	setConfigKey(flags, "afpacket-fanout-group", "afpacket.fanout_group")
+}
+
+// openDevice opens a single live interface with the chosen backend
+func openDevice(backend, device string, live capture.LiveConfig) (capture.Source, error) {
+	switch backend {
+	case "pcap":
+		return capture.OpenLive(device, bpf, live)
+	case "afpacket":
+		return openAFPacket(device, live.SnapLen)
+	default:
+		return nil, fmt.Errorf("unknown capture backend %q", backend)
+	}
+}
+
+// openAFPacket opens the Linux AF_PACKET backend from the afpacket settings
+func openAFPacket(device string, snapLen int) (capture.Source, error) {
+	return capture.OpenAFPacket(capture.AFPacketConfig{
+		Device:      device,
+		Filter:      bpf,
+		SnapLen:     snapLen,
+		BlockSize:   viper.GetInt("afpacket.block_size"),
+		NumBlocks:   viper.GetInt("afpacket.blocks"),
+		Workers:     viper.GetInt("afpacket.workers"),
+		FanoutGroup: viper.GetUint16("afpacket.fanout_group"),
+	})
+}
+
+// handlePacket extracts the PacketInfo of a captured packet, tags it with the
+// interface it arrived on and stores any DNS information, finished TLS
+// handshake or HTTP transaction it carries. The packet and DNS information are
+// returned for printing
+func handlePacket(p gopacket.Packet, ifaces []string) (*packet.PacketInfo, *dns.DNSInfo) {
+	pi, dnsInfo := packet.ExtractPacketInfo(p)
+
+	var iface string
+	if idx := p.Metadata().InterfaceIndex; idx >= 0 && idx < len(ifaces) {
+		iface = ifaces[idx]
+	}
+	if pi != nil {
+		pi.Interface = iface
+	}
+
+	if pi != nil && (pi.ARP != nil || pi.NDP != nil) && arpMonitor != nil {
+		recordNeighbor(pi)
+	}
+	if pi != nil && pi.Transport == packet.TCP && assembler != nil {
+		recordStreams(pi)
+	}
+	if pi != nil && pi.Transport == packet.UDP && quicTracker != nil {
+		recordQUIC(pi)
+	}
+
+	if dnsInfo != nil {
+		dnsInfo.Interface = iface
+		if err := dns.InsertDNSInfo(dnsInfo, db); err != nil {
+			log.Fatalf("inserting into dns table: %v", err)
+		}
+	}
+
+	return pi, dnsInfo
+}
+
+// paceReplay re-emits packets read from a file with the same spacing they
+// were captured with, so an offline capture can be watched as if it were live
+func paceReplay(in <-chan gopacket.Packet) <-chan gopacket.Packet {
+	out := make(chan gopacket.Packet)
+	go func() {
+		defer close(out)
+
+		var first time.Time
+		var start time.Time
+		for p := range in {
+			ts := p.Metadata().Timestamp
+			if first.IsZero() {
+				first = ts
+				start = time.Now()
+			}
+
+			if wait := time.Until(start.Add(ts.Sub(first))); wait > 0 {
+				time.Sleep(wait)
+			}
+			out <- p
+		}
+	}()
+
+	return out
+}
+
+// untilDone forwards packets until the context is cancelled or the input runs
+// dry, closing the returned channel either way
+func untilDone(ctx context.Context, in <-chan gopacket.Packet) <-chan gopacket.Packet {
+	out := make(chan gopacket.Packet)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case p, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- p:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// newPacketWriter builds the --write pcapng writer from the rotation settings,
+// describing every interface the source captures on
+func newPacketWriter(src capture.Source, ifaces []string) (*pcapwriter.Writer, error) {
+	rotateSize, err := pcapwriter.ParseSize(viper.GetString("rotate_size"))
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []capture.Source{src}
+	if ms, ok := src.(*capture.MultiSource); ok {
+		sources = ms.Sources()
+	}
+
+	interfaces := make([]pcapgo.NgInterface, len(sources))
+	for i, s := range sources {
+		name := readFile
+		if i < len(ifaces) {
+			name = ifaces[i]
+		}
+
+		var snapLen uint32
+		if sl, ok := s.(interface{ SnapLen() int }); ok {
+			snapLen = uint32(sl.SnapLen())
+		}
+
+		interfaces[i] = pcapgo.NgInterface{
+			Name:       name,
+			Filter:     bpf,
+			LinkType:   s.LinkType(),
+			SnapLength: snapLen,
+		}
+	}
+
+	return pcapwriter.NewWriter(pcapwriter.Config{
+		Path:        writeTo,
+		Interfaces:  interfaces,
+		RotateSize:  rotateSize,
+		RotateEvery: viper.GetDuration("rotate_every"),
+		MaxFiles:    viper.GetInt("max_files"),
+		Gzip:        viper.GetBool("gzip"),
+	})
+}
+
+// writePackets saves every packet passing through to w before handing it on
+// to the printer or TUI. The returned done channel is closed once it stops,
+// when the input runs dry or the context is cancelled
+func writePackets(
+	ctx context.Context,
+	in <-chan gopacket.Packet,
+	w *pcapwriter.Writer,
+) (<-chan gopacket.Packet, <-chan struct{}) {
+	out := make(chan gopacket.Packet)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(out)
+		for p := range in {
+			if err := w.WritePacket(p.Metadata().CaptureInfo, p.Data()); err != nil {
+				log.Printf("writing packet: %v", err)
+			}
+			// Whoever reads may be gone, like a TUI that quit
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, done
 }
@@ -5,7 +5,9 @@ import (
 	"log"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
+	"packeteer/internal/filter"
 	"packeteer/internal/output"
 	"packeteer/internal/storage"
 )
@@ -27,13 +29,36 @@ func init() {
 	dnsStatsCmd.Flags().BoolP("over-time", "t", false, "queries over time") // queries over time
 	dnsStatsCmd.Flags().
 		BoolP("unique", "u", false, "unique domians per source IP") // unique domains per src IP
+	dnsStatsCmd.Flags().
+		StringP("filter", "Y", "", "only count entries matching a display filter (ex. 'dns.qtype == AAAA')")
+	setConfigKey(dnsStatsCmd.Flags(), "filter", "dns_stats.filter")
 }
 
 // GetStats will pretty-print stats depending on the flag used
 func GetStats(cmd *cobra.Command, args []string) {
-	mqf, _ := cmd.Flags().GetBool("most-queried")
-	if mqf {
-		domains, err := storage.GetMostQueriedDomains(db)
+	dnsEntries, err := storage.GetDNSEntries(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The filter runs over the entries here, the aggregate queries are then
+	// limited to the ids which passed
+	var opts []storage.QueryOption
+	if f := compileFilter(viper.GetString("dns_stats.filter"), filter.ScopeDNS); f != nil {
+		var matched []storage.DNSEntry
+		ids := []int{}
+		for _, e := range dnsEntries {
+			if f.Match(filter.DNSEntry(e)) {
+				matched = append(matched, e)
+				ids = append(ids, e.Id)
+			}
+		}
+		dnsEntries = matched
+		opts = append(opts, storage.OnlyIDs(ids))
+	}
+
+	if viper.GetBool("most_queried") {
+		domains, err := storage.GetMostQueriedDomains(db, opts...)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -42,9 +67,8 @@ func GetStats(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	otf, _ := cmd.Flags().GetBool("over-time")
-	if otf {
-		ots, err := storage.GetQueriesOverTime(db)
+	if viper.GetBool("over_time") {
+		ots, err := storage.GetQueriesOverTime(db, opts...)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -53,8 +77,8 @@ func GetStats(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	if uf, _ := cmd.Flags().GetBool("unique"); uf {
-		dqs, err := storage.GetUniqueDomains(db)
+	if viper.GetBool("unique") {
+		dqs, err := storage.GetUniqueDomains(db, opts...)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -63,11 +87,6 @@ func GetStats(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	dnsEntries, err := storage.GetDNSEntries(db)
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	for _, e := range dnsEntries {
 		fmt.Println("cname paths: ", e.CNamePath)
 		fmt.Println("reponse IPs: ", e.ResponseIPs)
@@ -0,0 +1,304 @@
+// Package arp learns IP to MAC bindings from ARP traffic, and from IPv6
+// neighbor discovery which takes its place, and raises alerts for the
+// patterns that usually mean spoofing or a misconfigured network
+package arp
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"packeteer/internal/oui"
+	"packeteer/internal/packet"
+)
+
+// Config holds the thresholds of the alerts
+type Config struct {
+	// Two MACs claiming an IP within this long of each other are reported as
+	// a duplicate IP, further apart it is taken as the address moving
+	DuplicateWindow time.Duration
+	// FlapCount MAC changes of one IP within FlapWindow are reported as
+	// flapping, which is what ARP spoofing usually looks like
+	FlapWindow time.Duration
+	FlapCount  int
+	// More than StormRate ARP packets a second from one MAC is a storm
+	StormRate int
+	// Routers are the IPv6 or MAC addresses allowed to send router
+	// advertisements. When empty the first router seen on each segment is
+	// trusted instead
+	Routers []string
+}
+
+// DefaultConfig returns the thresholds used unless configured otherwise
+func DefaultConfig() Config {
+	return Config{
+		DuplicateWindow: 5 * time.Second,
+		FlapWindow:      time.Minute,
+		FlapCount:       3,
+		StormRate:       50,
+	}
+}
+
+// AlertKind names the kind of an Alert
+type AlertKind string
+
+const (
+	Gratuitous  AlertKind = "gratuitous"   // a host announced its own address
+	DuplicateIP AlertKind = "duplicate-ip" // two MACs are using the same IP
+	Flapping    AlertKind = "flapping"     // the MAC of an IP keeps changing
+	Storm       AlertKind = "storm"        // a MAC is sending too much ARP
+	RogueRA     AlertKind = "rogue-ra"     // an unexpected IPv6 router advertisement
+)
+
+// Alert is raised by Monitor.Observe
+type Alert struct {
+	Time      time.Time `json:"time"`
+	Kind      AlertKind `json:"kind"`
+	IP        string    `json:"ip,omitempty"`
+	MAC       string    `json:"mac"`
+	OldMAC    string    `json:"old_mac,omitempty"` // the MAC the IP had before
+	Interface string    `json:"interface,omitempty"`
+	Message   string    `json:"message"`
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("%s %s: %s", a.Time.Format(time.RFC3339), a.Kind, a.Message)
+}
+
+// segment is one broadcast domain, bindings on different interfaces or VLANs
+// do not conflict
+type segment struct {
+	iface string
+	vlan  uint16
+}
+
+type binding struct {
+	mac      string
+	lastSeen time.Time
+	changes  []time.Time // MAC changes within the flap window
+}
+
+type rate struct {
+	start time.Time // start of the current one second window
+	count int
+}
+
+// expired reports whether ts falls outside of the window
+func (r *rate) expired(ts time.Time) bool {
+	return ts.Sub(r.start) >= time.Second || ts.Before(r.start)
+}
+
+// Monitor keeps the current binding of every IP it has seen a claim for
+type Monitor struct {
+	mu       sync.Mutex
+	cfg      Config
+	bindings map[segment]map[string]*binding
+	rates    map[string]*rate            // by sender MAC
+	swept    time.Time                   // when rates last dropped its past windows
+	routers  map[segment]map[string]bool // advertising routers, by IP
+}
+
+// NewMonitor returns an empty Monitor alerting with the thresholds of cfg
+func NewMonitor(cfg Config) *Monitor {
+	return &Monitor{
+		cfg:      cfg,
+		bindings: map[segment]map[string]*binding{},
+		rates:    map[string]*rate{},
+		routers:  map[segment]map[string]bool{},
+	}
+}
+
+// Observe learns from an ARP or NDP packet and returns the alerts it raised.
+// The packet's timestamp is used as the time, so that reading a capture file
+// works the same as a live capture. Other packets are ignored
+func (m *Monitor) Observe(pi *packet.PacketInfo) []Alert {
+	// The sender of a packet that claims nothing still counts for storms
+	ip, mac, claims := pi.Neighbor()
+	switch {
+	case claims:
+	case pi.ARP != nil:
+		ip, mac = pi.ARP.SenderIP, pi.ARP.SenderMAC
+	case pi.NDP != nil:
+		ip, mac = pi.SrcIP, pi.SrcMAC
+	}
+	if ip == "" || mac == "" {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ts := pi.Timestamp
+	alert := func(kind AlertKind, oldMAC, format string, args ...any) Alert {
+		msg := fmt.Sprintf(format, args...)
+		if pi.Interface != "" {
+			msg += " on " + pi.Interface
+		}
+		return Alert{
+			Time:      ts,
+			Kind:      kind,
+			IP:        ip,
+			MAC:       mac,
+			OldMAC:    oldMAC,
+			Interface: pi.Interface,
+			Message:   msg,
+		}
+	}
+
+	seg := segment{iface: pi.Interface}
+	if len(pi.VLANs) > 0 {
+		seg.vlan = pi.VLANs[len(pi.VLANs)-1]
+	}
+
+	var alerts []Alert
+	if m.storm(mac, ts) {
+		alerts = append(alerts, alert(
+			Storm, "",
+			"%s sent more than %d %s packets in a second",
+			oui.Name(mac), m.cfg.StormRate, protocolName(pi),
+		))
+	}
+	if gratuitous(pi) {
+		alerts = append(alerts, alert(
+			Gratuitous, "",
+			"%s announced %s", oui.Name(mac), ip,
+		))
+	}
+	if pi.NDP != nil && pi.NDP.Op == packet.NDPRouterAdvertisement {
+		if msg := m.router(seg, pi.SrcIP, mac); msg != "" {
+			alerts = append(alerts, alert(RogueRA, "", "%s", msg))
+		}
+	}
+
+	// A probe claims nothing, so there is nothing to learn from it
+	if !claims {
+		return alerts
+	}
+
+	ips, ok := m.bindings[seg]
+	if !ok {
+		ips = map[string]*binding{}
+		m.bindings[seg] = ips
+	}
+
+	b, ok := ips[ip]
+	if !ok {
+		ips[ip] = &binding{mac: mac, lastSeen: ts}
+		return alerts
+	}
+
+	if b.mac != mac {
+		oldMAC := b.mac
+		if ts.Sub(b.lastSeen) <= m.cfg.DuplicateWindow {
+			alerts = append(alerts, alert(
+				DuplicateIP, oldMAC,
+				"%s is used by both %s and %s",
+				ip, oui.Name(oldMAC), oui.Name(mac),
+			))
+		}
+
+		b.changes = append(b.changes, ts)
+		for len(b.changes) > 0 && ts.Sub(b.changes[0]) > m.cfg.FlapWindow {
+			b.changes = b.changes[1:]
+		}
+		if m.cfg.FlapCount > 0 && len(b.changes) >= m.cfg.FlapCount {
+			alerts = append(alerts, alert(
+				Flapping, oldMAC,
+				"%s changed MAC %d times within %s, now %s",
+				ip, len(b.changes), m.cfg.FlapWindow, oui.Name(mac),
+			))
+			b.changes = nil
+		}
+		b.mac = mac
+	}
+	b.lastSeen = ts
+
+	return alerts
+}
+
+// router checks a router advertisement from ip, returning why it is rogue or
+// an empty string. Each router is only reported once per segment
+func (m *Monitor) router(seg segment, ip, mac string) string {
+	known, ok := m.routers[seg]
+	if !ok {
+		known = map[string]bool{}
+		m.routers[seg] = known
+	}
+	if known[ip] {
+		return ""
+	}
+	first := len(known) == 0
+	known[ip] = true
+
+	name := fmt.Sprintf("%s (%s)", ip, oui.Name(mac))
+	if addr, err := netip.ParseAddr(ip); err != nil || !addr.IsLinkLocalUnicast() {
+		// RFC 4861 has routers advertise from their link-local address
+		return "router advertisement from " + name + ", which is not link-local"
+	}
+	if len(m.cfg.Routers) > 0 {
+		if slices.Contains(m.cfg.Routers, ip) || slices.Contains(m.cfg.Routers, mac) {
+			return ""
+		}
+		return "router advertisement from " + name + ", which is not an allowed router"
+	}
+	if first {
+		return ""
+	}
+
+	others := make([]string, 0, len(known)-1)
+	for r := range known {
+		if r != ip {
+			others = append(others, r)
+		}
+	}
+	slices.Sort(others)
+	return "new router " + name + " besides " + strings.Join(others, ", ")
+}
+
+// gratuitous reports whether a packet announces the sender's own address
+// without being asked, a gratuitous ARP or an unsolicited neighbor
+// advertisement
+func gratuitous(pi *packet.PacketInfo) bool {
+	if pi.ARP != nil {
+		return pi.ARP.Gratuitous()
+	}
+	return pi.NDP != nil && pi.NDP.Op == packet.NDPNeighborAdvertisement && !pi.NDP.Solicited
+}
+
+func protocolName(pi *packet.PacketInfo) string {
+	if pi.NDP != nil {
+		return "NDP"
+	}
+	return "ARP"
+}
+
+// storm counts a packet from mac, reporting true once per second in which
+// the mac goes over the storm rate
+func (m *Monitor) storm(mac string, ts time.Time) bool {
+	if m.cfg.StormRate <= 0 {
+		return false
+	}
+
+	// A flood of spoofed MACs would otherwise leave one entry behind for
+	// every one of them
+	if ts.Sub(m.swept) >= time.Second || ts.Before(m.swept) {
+		for mac, r := range m.rates {
+			if r.expired(ts) {
+				delete(m.rates, mac)
+			}
+		}
+		m.swept = ts
+	}
+
+	r, ok := m.rates[mac]
+	if !ok || r.expired(ts) {
+		r = &rate{start: ts}
+		m.rates[mac] = r
+	}
+	r.count++
+
+	return r.count == m.cfg.StormRate+1
+}
@@ -0,0 +1,246 @@
+package arp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/packet"
+)
+
+const (
+	macA = "00:03:93:00:00:0a"
+	macB = "00:50:56:00:00:0b"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func reply(ts time.Time, mac, ip string) *packet.PacketInfo {
+	return &packet.PacketInfo{
+		Timestamp: ts,
+		Protocol:  packet.ARP,
+		Interface: "eth0",
+		ARP: &packet.ARPInfo{
+			Opcode:    2,
+			Operation: "reply",
+			SenderMAC: mac,
+			SenderIP:  ip,
+			TargetMAC: "00:00:0c:00:00:01",
+			TargetIP:  "10.0.0.1",
+		},
+	}
+}
+
+func kinds(alerts []Alert) []AlertKind {
+	var ks []AlertKind
+	for _, a := range alerts {
+		ks = append(ks, a.Kind)
+	}
+	return ks
+}
+
+func TestObserve_Learns(t *testing.T) {
+	m := NewMonitor(DefaultConfig())
+
+	assert.Empty(t, m.Observe(reply(t0, macA, "10.0.0.5")))
+	assert.Empty(t, m.Observe(reply(t0.Add(time.Second), macA, "10.0.0.5")))
+	assert.Empty(t, m.Observe(&packet.PacketInfo{Protocol: packet.TCP}))
+}
+
+func TestObserve_Gratuitous(t *testing.T) {
+	m := NewMonitor(DefaultConfig())
+
+	p := reply(t0, macA, "10.0.0.5")
+	p.ARP.TargetIP = "10.0.0.5"
+	p.ARP.TargetMAC = "ff:ff:ff:ff:ff:ff"
+	alerts := m.Observe(p)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, Gratuitous, alerts[0].Kind)
+	assert.Equal(t, "Apple_00:00:0a announced 10.0.0.5 on eth0", alerts[0].Message)
+
+	// A probe is not gratuitous and is not learned
+	probe := reply(t0, macB, "0.0.0.0")
+	probe.ARP.TargetIP = "0.0.0.0"
+	assert.Empty(t, m.Observe(probe))
+}
+
+func TestObserve_DuplicateIP(t *testing.T) {
+	m := NewMonitor(DefaultConfig())
+
+	m.Observe(reply(t0, macA, "10.0.0.5"))
+	alerts := m.Observe(reply(t0.Add(2*time.Second), macB, "10.0.0.5"))
+	require.Len(t, alerts, 1)
+	assert.Equal(t, DuplicateIP, alerts[0].Kind)
+	assert.Equal(t, macA, alerts[0].OldMAC)
+	assert.Equal(t, macB, alerts[0].MAC)
+	assert.Equal(
+		t,
+		"10.0.0.5 is used by both Apple_00:00:0a and VMware_00:00:0b on eth0",
+		alerts[0].Message,
+	)
+
+	// Moving to another MAC long after is not a conflict
+	assert.Empty(t, m.Observe(reply(t0.Add(time.Hour), macA, "10.0.0.9")))
+	assert.Empty(t, m.Observe(reply(t0.Add(2*time.Hour), macB, "10.0.0.9")))
+}
+
+func TestObserve_Segments(t *testing.T) {
+	m := NewMonitor(DefaultConfig())
+
+	m.Observe(reply(t0, macA, "10.0.0.5"))
+	other := reply(t0, macB, "10.0.0.5")
+	other.Interface = "eth1"
+	assert.Empty(t, m.Observe(other))
+
+	tagged := reply(t0, macB, "10.0.0.5")
+	tagged.VLANs = []uint16{20}
+	assert.Empty(t, m.Observe(tagged))
+}
+
+func TestObserve_Flapping(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DuplicateWindow = 0
+	m := NewMonitor(cfg)
+
+	m.Observe(reply(t0, macA, "10.0.0.5"))
+	assert.Empty(t, m.Observe(reply(t0.Add(10*time.Second), macB, "10.0.0.5")))
+	assert.Empty(t, m.Observe(reply(t0.Add(20*time.Second), macA, "10.0.0.5")))
+
+	alerts := m.Observe(reply(t0.Add(30*time.Second), macB, "10.0.0.5"))
+	assert.Equal(t, []AlertKind{Flapping}, kinds(alerts))
+	assert.Contains(t, alerts[0].Message, "changed MAC 3 times within 1m0s")
+
+	// The count starts over after an alert, and old changes age out
+	assert.Empty(t, m.Observe(reply(t0.Add(2*time.Minute), macA, "10.0.0.5")))
+	assert.Empty(t, m.Observe(reply(t0.Add(4*time.Minute), macB, "10.0.0.5")))
+	assert.Empty(t, m.Observe(reply(t0.Add(6*time.Minute), macA, "10.0.0.5")))
+}
+
+func TestObserve_Storm(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StormRate = 5
+	m := NewMonitor(cfg)
+
+	var got []AlertKind
+	for i := range 20 {
+		ts := t0.Add(time.Duration(i) * 10 * time.Millisecond)
+		got = append(got, kinds(m.Observe(reply(ts, macA, "10.0.0.5")))...)
+	}
+	assert.Equal(t, []AlertKind{Storm}, got)
+
+	// A new second gets a new count
+	for i := range 6 {
+		ts := t0.Add(time.Second + time.Duration(i)*time.Millisecond)
+		got = append(got, kinds(m.Observe(reply(ts, macA, "10.0.0.5")))...)
+	}
+	assert.Equal(t, []AlertKind{Storm, Storm}, got)
+}
+
+func TestObserve_StormForgets(t *testing.T) {
+	m := NewMonitor(DefaultConfig())
+
+	// Every packet from a MAC of its own, as when spoofing
+	for i := range 1000 {
+		mac := fmt.Sprintf("02:00:00:00:%02x:%02x", i>>8, i&0xff)
+		m.Observe(reply(t0.Add(time.Duration(i)*time.Microsecond), mac, "10.0.0.5"))
+	}
+	assert.Len(t, m.rates, 1000)
+
+	// Their windows have passed by the next second
+	m.Observe(reply(t0.Add(1500*time.Millisecond), macA, "10.0.0.6"))
+	assert.Len(t, m.rates, 1)
+}
+
+// ******************************
+// NDP
+// ******************************
+
+func advert(ts time.Time, mac, ip string, solicited bool) *packet.PacketInfo {
+	return &packet.PacketInfo{
+		Timestamp: ts,
+		Protocol:  packet.ICMPv6,
+		Interface: "eth0",
+		SrcIP:     ip,
+		SrcMAC:    mac,
+		NDP: &packet.NDPInfo{
+			Op:        packet.NDPNeighborAdvertisement,
+			TargetIP:  ip,
+			LinkAddr:  mac,
+			Solicited: solicited,
+		},
+	}
+}
+
+func routerAdvert(mac, ip string) *packet.PacketInfo {
+	return &packet.PacketInfo{
+		Timestamp: t0,
+		Protocol:  packet.ICMPv6,
+		Interface: "eth0",
+		SrcIP:     ip,
+		SrcMAC:    mac,
+		NDP:       &packet.NDPInfo{Op: packet.NDPRouterAdvertisement, Router: true},
+	}
+}
+
+func TestObserve_NDP(t *testing.T) {
+	m := NewMonitor(DefaultConfig())
+
+	assert.Empty(t, m.Observe(advert(t0, macA, "2001:db8::5", true)))
+
+	alerts := m.Observe(advert(t0.Add(time.Second), macB, "2001:db8::5", true))
+	assert.Equal(t, []AlertKind{DuplicateIP}, kinds(alerts))
+	assert.Equal(
+		t,
+		"2001:db8::5 is used by both Apple_00:00:0a and VMware_00:00:0b on eth0",
+		alerts[0].Message,
+	)
+
+	alerts = m.Observe(advert(t0.Add(time.Hour), macB, "2001:db8::9", false))
+	assert.Equal(t, []AlertKind{Gratuitous}, kinds(alerts))
+
+	// Duplicate address detection claims nothing
+	dad := &packet.PacketInfo{
+		Timestamp: t0,
+		SrcIP:     "::",
+		SrcMAC:    macA,
+		NDP:       &packet.NDPInfo{Op: packet.NDPNeighborSolicitation, TargetIP: "2001:db8::9"},
+	}
+	assert.Empty(t, m.Observe(dad))
+}
+
+func TestObserve_RogueRA(t *testing.T) {
+	m := NewMonitor(DefaultConfig())
+
+	assert.Empty(t, m.Observe(routerAdvert(macA, "fe80::1")))
+	assert.Empty(t, m.Observe(routerAdvert(macA, "fe80::1")))
+
+	alerts := m.Observe(routerAdvert(macB, "fe80::2"))
+	require.Equal(t, []AlertKind{RogueRA}, kinds(alerts))
+	assert.Equal(t, "new router fe80::2 (VMware_00:00:0b) besides fe80::1 on eth0", alerts[0].Message)
+	assert.Empty(t, m.Observe(routerAdvert(macB, "fe80::2")))
+
+	// Another segment has routers of its own
+	other := routerAdvert(macB, "fe80::2")
+	other.Interface = "eth1"
+	assert.Empty(t, m.Observe(other))
+
+	alerts = m.Observe(routerAdvert(macB, "2001:db8::1"))
+	require.Equal(t, []AlertKind{RogueRA}, kinds(alerts))
+	assert.Contains(t, alerts[0].Message, "which is not link-local")
+}
+
+func TestObserve_AllowedRouters(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Routers = []string{"fe80::1", macB}
+	m := NewMonitor(cfg)
+
+	assert.Empty(t, m.Observe(routerAdvert(macA, "fe80::1")))
+	assert.Empty(t, m.Observe(routerAdvert(macB, "fe80::2")))
+
+	alerts := m.Observe(routerAdvert(macA, "fe80::3"))
+	require.Equal(t, []AlertKind{RogueRA}, kinds(alerts))
+	assert.Contains(t, alerts[0].Message, "which is not an allowed router")
+}
@@ -0,0 +1,67 @@
+package capture
+
+import (
+	"time"
+
+	"github.com/gopacket/gopacket"
+)
+
+// Defaults for the afpacket backend ring. A block holds many packets and is
+// handed to userspace in one go, so the ring is BlockSize * NumBlocks bytes
+const (
+	DefaultAFPacketFrameSize = 4096
+	DefaultAFPacketBlockSize = 1 << 20
+	DefaultAFPacketNumBlocks = 64
+	DefaultAFPacketWorkers   = 1
+
+	// afpacketPollTimeout bounds how long a worker blocks waiting for a
+	// block, so that it notices when the source is closed
+	afpacketPollTimeout = 100 * time.Millisecond
+)
+
+// AFPacketConfig configures the Linux AF_PACKET (TPACKET_V3) backend
+type AFPacketConfig struct {
+	Device    string
+	Filter    string // BPF expression, compiled and attached to every socket
+	SnapLen   int    // bytes kept of every packet, also used to compile the filter
+	FrameSize int
+	BlockSize int // must be a multiple of both the page size and FrameSize
+	NumBlocks int
+	// Workers is the number of sockets, each with its own goroutine, that
+	// share the interface through a fanout group. Packets of one flow
+	// always land on the same worker
+	Workers int
+	// FanoutGroup is the kernel fanout group id. When zero and Workers is
+	// above one, a group id is derived from the process id
+	FanoutGroup uint16
+}
+
+// withDefaults fills any unset field with its default
+func (c AFPacketConfig) withDefaults() AFPacketConfig {
+	if c.SnapLen == 0 {
+		c.SnapLen = DefaultSnapLen
+	}
+	if c.FrameSize == 0 {
+		c.FrameSize = DefaultAFPacketFrameSize
+	}
+	if c.BlockSize == 0 {
+		c.BlockSize = DefaultAFPacketBlockSize
+	}
+	if c.NumBlocks == 0 {
+		c.NumBlocks = DefaultAFPacketNumBlocks
+	}
+	if c.Workers == 0 {
+		c.Workers = DefaultAFPacketWorkers
+	}
+	return c
+}
+
+// snap cuts the packet to snapLen bytes, as libpcap does. The ring itself
+// only limits packets to what a block holds
+func snap(data []byte, ci *gopacket.CaptureInfo, snapLen int) []byte {
+	if len(data) <= snapLen {
+		return data
+	}
+	ci.CaptureLength = snapLen
+	return data[:snapLen]
+}
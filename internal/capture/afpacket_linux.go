@@ -0,0 +1,240 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/afpacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcap"
+	"golang.org/x/net/bpf"
+)
+
+// AFPacketSource captures through memory-mapped AF_PACKET ring buffers,
+// bypassing libpcap. With several workers the sockets join one fanout group
+// and the kernel spreads packets across them by flow hash
+type AFPacketSource struct {
+	cfg     AFPacketConfig
+	sockets []*afpacket.TPacket
+
+	mu       sync.Mutex // guards sockets once workers close them
+	once     sync.Once
+	started  bool
+	packets  chan gopacket.Packet
+	done     chan struct{}
+	closed   sync.Once
+	wg       sync.WaitGroup
+	received atomic.Int64
+	last     Stats // counters as of the sockets being closed
+}
+
+// OpenAFPacket opens cfg.Workers AF_PACKET sockets on the device
+func OpenAFPacket(cfg AFPacketConfig) (Source, error) {
+	cfg = cfg.withDefaults()
+	if cfg.Device == "" {
+		return nil, errors.New("the afpacket backend needs a device")
+	}
+	if cfg.Workers < 1 {
+		return nil, fmt.Errorf("invalid number of afpacket workers %d", cfg.Workers)
+	}
+
+	var filter []bpf.RawInstruction
+	if cfg.Filter != "" {
+		var err error
+		filter, err = compileBPF(cfg.Filter, cfg.SnapLen)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	group := cfg.FanoutGroup
+	if group == 0 {
+		group = uint16(os.Getpid())
+	}
+	fanout := cfg.Workers > 1 || cfg.FanoutGroup != 0
+
+	s := &AFPacketSource{
+		cfg:  cfg,
+		done: make(chan struct{}),
+	}
+	for range cfg.Workers {
+		tp, err := afpacket.NewTPacket(
+			afpacket.OptInterface(cfg.Device),
+			afpacket.OptFrameSize(cfg.FrameSize),
+			afpacket.OptBlockSize(cfg.BlockSize),
+			afpacket.OptNumBlocks(cfg.NumBlocks),
+			afpacket.OptPollTimeout(afpacketPollTimeout),
+			afpacket.TPacketVersion3,
+		)
+		if err != nil {
+			s.closeSockets()
+			return nil, err
+		}
+		s.sockets = append(s.sockets, tp)
+
+		if filter != nil {
+			if err := tp.SetBPF(filter); err != nil {
+				s.closeSockets()
+				return nil, err
+			}
+		}
+		if fanout {
+			if err := tp.SetFanout(afpacket.FanoutHashWithDefrag, group); err != nil {
+				s.closeSockets()
+				return nil, err
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// compileBPF compiles the expression with libpcap into raw instructions that
+// can be attached to a socket
+func compileBPF(filter string, snapLen int) ([]bpf.RawInstruction, error) {
+	insns, err := pcap.CompileBPFFilter(layers.LinkTypeEthernet, snapLen, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]bpf.RawInstruction, len(insns))
+	for i, in := range insns {
+		raw[i] = bpf.RawInstruction{Op: in.Code, Jt: in.Jt, Jf: in.Jf, K: in.K}
+	}
+	return raw, nil
+}
+
+// Packets satisfies Source. Every worker decodes the packets of its own
+// socket, so packets of different flows may arrive out of order
+func (s *AFPacketSource) Packets() <-chan gopacket.Packet {
+	s.once.Do(func() {
+		s.mu.Lock()
+		s.started = true
+		s.mu.Unlock()
+
+		s.packets = make(chan gopacket.Packet)
+		for _, tp := range s.sockets {
+			s.wg.Add(1)
+			go s.work(tp)
+		}
+		go func() {
+			s.wg.Wait()
+			close(s.packets)
+		}()
+	})
+
+	return s.packets
+}
+
+// work reads one socket until the source is closed. The socket is closed by
+// its worker, as closing it under a blocked read would unmap the ring
+func (s *AFPacketSource) work(tp *afpacket.TPacket) {
+	defer s.wg.Done()
+	defer s.closeSocket(tp)
+
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		data, ci, err := tp.ReadPacketData()
+		if errors.Is(err, afpacket.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			log.Printf("afpacket read: %v", err)
+			return
+		}
+
+		// The kernel reports its own ifindex, packeteer numbers interfaces
+		// by source
+		ci.InterfaceIndex = 0
+		data = snap(data, &ci, s.cfg.SnapLen)
+		p := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+		*p.Metadata() = gopacket.PacketMetadata{CaptureInfo: ci}
+
+		select {
+		case s.packets <- p:
+			s.received.Add(1)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// LinkType satisfies Source
+func (s *AFPacketSource) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+// SnapLen returns the snapshot length packets are cut to
+func (s *AFPacketSource) SnapLen() int {
+	return s.cfg.SnapLen
+}
+
+// Stats satisfies Source, summing the kernel counters of every socket
+func (s *AFPacketSource) Stats() (Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.last
+	stats.Received = int(s.received.Load())
+	for _, tp := range s.sockets {
+		_, v3, err := tp.SocketStats()
+		if err != nil {
+			return stats, err
+		}
+		stats.KernelDropped += int(v3.Drops())
+	}
+
+	return stats, nil
+}
+
+// Close satisfies Source
+func (s *AFPacketSource) Close() error {
+	s.closed.Do(func() {
+		close(s.done)
+
+		s.mu.Lock()
+		started := s.started
+		s.mu.Unlock()
+
+		if started {
+			s.wg.Wait()
+		} else {
+			s.closeSockets()
+		}
+	})
+	return nil
+}
+
+// closeSocket records the final counters of the socket and closes it
+func (s *AFPacketSource) closeSocket(tp *afpacket.TPacket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, v3, err := tp.SocketStats(); err == nil {
+		s.last.KernelDropped += int(v3.Drops())
+	}
+	tp.Close()
+
+	for i, open := range s.sockets {
+		if open == tp {
+			s.sockets = append(s.sockets[:i], s.sockets[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *AFPacketSource) closeSockets() {
+	for len(s.sockets) > 0 {
+		s.closeSocket(s.sockets[0])
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package capture
+
+import "errors"
+
+// OpenAFPacket is only available on Linux
+func OpenAFPacket(cfg AFPacketConfig) (Source, error) {
+	return nil, errors.New("the afpacket backend is only available on Linux")
+}
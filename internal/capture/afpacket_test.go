@@ -0,0 +1,51 @@
+package capture
+
+import (
+	"testing"
+
+	"github.com/gopacket/gopacket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAFPacketConfig_WithDefaults(t *testing.T) {
+	cfg := AFPacketConfig{Device: "eth0"}.withDefaults()
+
+	assert.Equal(t, "eth0", cfg.Device)
+	assert.Equal(t, DefaultSnapLen, cfg.SnapLen)
+	assert.Equal(t, DefaultAFPacketFrameSize, cfg.FrameSize)
+	assert.Equal(t, DefaultAFPacketBlockSize, cfg.BlockSize)
+	assert.Equal(t, DefaultAFPacketNumBlocks, cfg.NumBlocks)
+	assert.Equal(t, DefaultAFPacketWorkers, cfg.Workers)
+	assert.Equal(t, uint16(0), cfg.FanoutGroup)
+}
+
+func TestAFPacketConfig_WithDefaults_KeepsSet(t *testing.T) {
+	cfg := AFPacketConfig{
+		BlockSize:   4 << 20,
+		NumBlocks:   8,
+		Workers:     4,
+		FanoutGroup: 7,
+	}.withDefaults()
+
+	assert.Equal(t, 4<<20, cfg.BlockSize)
+	assert.Equal(t, 8, cfg.NumBlocks)
+	assert.Equal(t, 4, cfg.Workers)
+	assert.Equal(t, uint16(7), cfg.FanoutGroup)
+}
+
+func TestOpenAFPacket_NoDevice(t *testing.T) {
+	_, err := OpenAFPacket(AFPacketConfig{})
+	assert.Error(t, err)
+}
+
+func TestSnap(t *testing.T) {
+	data := make([]byte, 100)
+	ci := gopacket.CaptureInfo{CaptureLength: 100, Length: 100}
+
+	assert.Len(t, snap(data, &ci, 1600), 100)
+	assert.Equal(t, 100, ci.CaptureLength)
+
+	assert.Len(t, snap(data, &ci, 64), 64)
+	assert.Equal(t, 64, ci.CaptureLength)
+	assert.Equal(t, 100, ci.Length)
+}
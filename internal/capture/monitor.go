@@ -0,0 +1,61 @@
+package capture
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Monitor combines the counters of a Source with the packets the pipeline
+// behind it had to drop, so that loss anywhere between the wire and the
+// output is visible
+type Monitor struct {
+	src          Source
+	queueDropped atomic.Int64
+}
+
+// NewMonitor returns a Monitor over the source
+func NewMonitor(src Source) *Monitor {
+	return &Monitor{src: src}
+}
+
+// Drop records one packet dropped because a queue was full
+func (m *Monitor) Drop() {
+	m.queueDropped.Add(1)
+}
+
+// Offer sends v on ch without blocking. When ch is full the packet is
+// counted as dropped and false is returned
+func Offer[T any](m *Monitor, ch chan<- T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	default:
+		m.Drop()
+		return false
+	}
+}
+
+// Stats returns the source counters together with the queue drops. The
+// queue drops are filled in even if the source fails to report
+func (m *Monitor) Stats() (Stats, error) {
+	stats, err := m.src.Stats()
+	stats.QueueDropped = int(m.queueDropped.Load())
+	return stats, err
+}
+
+// Report calls fn with the current Stats every interval until the context
+// is cancelled
+func (m *Monitor) Report(ctx context.Context, interval time.Duration, fn func(Stats, error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			fn(m.Stats())
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingSource is a Source whose counters cannot be read
+type failingSource struct {
+	*MemorySource
+}
+
+func (failingSource) Stats() (Stats, error) {
+	return Stats{}, errors.New("no stats")
+}
+
+func TestMonitor_Stats(t *testing.T) {
+	src := NewMemorySource(layers.LinkTypeEthernet)
+	src.Add(gopacket.CaptureInfo{}, []byte{0x01})
+	<-src.Packets()
+
+	m := NewMonitor(src)
+	m.Drop()
+	m.Drop()
+
+	stats, err := m.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Received: 1, QueueDropped: 2}, stats)
+}
+
+func TestMonitor_Stats_SourceError(t *testing.T) {
+	m := NewMonitor(failingSource{NewMemorySource(layers.LinkTypeEthernet)})
+	m.Drop()
+
+	stats, err := m.Stats()
+	assert.Error(t, err)
+	assert.Equal(t, 1, stats.QueueDropped)
+}
+
+func TestOffer(t *testing.T) {
+	m := NewMonitor(NewMemorySource(layers.LinkTypeEthernet))
+	ch := make(chan int, 1)
+
+	assert.True(t, Offer(m, ch, 1))
+	assert.False(t, Offer(m, ch, 2))
+	assert.Equal(t, 1, <-ch)
+
+	stats, _ := m.Stats()
+	assert.Equal(t, 1, stats.QueueDropped)
+}
+
+func TestMonitor_Report(t *testing.T) {
+	m := NewMonitor(NewMemorySource(layers.LinkTypeEthernet))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	reports := make(chan Stats, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Report(ctx, time.Millisecond, func(s Stats, err error) {
+			select {
+			case reports <- s:
+			default:
+			}
+		})
+	}()
+
+	m.Drop()
+	select {
+	case <-reports:
+	case <-time.After(time.Second):
+		t.Fatal("no report")
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,208 @@
+package capture
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// DefaultMergeWindow is how long a packet may be held back waiting for the
+// other interfaces before it is released out of timestamp order
+const DefaultMergeWindow = 100 * time.Millisecond
+
+// MultiSource merges several sources into one stream ordered by packet
+// timestamp. The CaptureInfo.InterfaceIndex of every packet is set to the
+// index of the source it came from
+//
+// A packet is released once every open source has a later packet queued, so
+// ordering is exact when all sources keep delivering. An idle interface would
+// stall the others forever, so a packet is also released once it has been
+// held for the merge window
+type MultiSource struct {
+	sources []Source
+	window  time.Duration
+
+	once    sync.Once
+	packets chan gopacket.Packet
+	done    chan struct{}
+	closed  sync.Once
+}
+
+// Merge returns a MultiSource over the sources using DefaultMergeWindow
+func Merge(sources ...Source) *MultiSource {
+	return MergeWithin(DefaultMergeWindow, sources...)
+}
+
+// MergeWithin returns a MultiSource over the sources which holds packets back
+// for at most window
+func MergeWithin(window time.Duration, sources ...Source) *MultiSource {
+	return &MultiSource{
+		sources: sources,
+		window:  window,
+		done:    make(chan struct{}),
+	}
+}
+
+// queued is a packet waiting in the merge heap
+type queued struct {
+	p       gopacket.Packet
+	src     int
+	arrived time.Time
+}
+
+type mergeHeap []queued
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	return h[i].p.Metadata().Timestamp.Before(h[j].p.Metadata().Timestamp)
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)   { *h = append(*h, x.(queued)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	q := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return q
+}
+
+// Packets satisfies Source
+func (m *MultiSource) Packets() <-chan gopacket.Packet {
+	m.once.Do(func() {
+		m.packets = make(chan gopacket.Packet)
+
+		// A nil packet marks the end of a source
+		in := make(chan queued)
+		for i, src := range m.sources {
+			go func() {
+				for p := range src.Packets() {
+					p.Metadata().InterfaceIndex = i
+					select {
+					case in <- queued{p: p, src: i, arrived: time.Now()}:
+					case <-m.done:
+						return
+					}
+				}
+				select {
+				case in <- queued{src: i}:
+				case <-m.done:
+				}
+			}()
+		}
+
+		go m.merge(in)
+	})
+
+	return m.packets
+}
+
+// merge releases packets from the heap in timestamp order, see MultiSource
+func (m *MultiSource) merge(in <-chan queued) {
+	defer close(m.packets)
+
+	var h mergeHeap
+	pending := make([]int, len(m.sources))
+	open := len(m.sources)
+	isOpen := make([]bool, len(m.sources))
+	for i := range isOpen {
+		isOpen[i] = true
+	}
+
+	// ready reports whether the oldest packet can be released
+	ready := func(now time.Time) bool {
+		if len(h) == 0 {
+			return false
+		}
+		if now.Sub(h[0].arrived) >= m.window {
+			return true
+		}
+		for i, n := range pending {
+			if isOpen[i] && n == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	tick := time.NewTicker(max(m.window/2, time.Millisecond))
+	defer tick.Stop()
+
+	for open > 0 || len(h) > 0 {
+		for ready(time.Now()) || (open == 0 && len(h) > 0) {
+			q := heap.Pop(&h).(queued)
+			pending[q.src]--
+			select {
+			case m.packets <- q.p:
+			case <-m.done:
+				return
+			}
+		}
+		if open == 0 {
+			continue
+		}
+
+		select {
+		case q := <-in:
+			if q.p == nil {
+				isOpen[q.src] = false
+				open--
+				continue
+			}
+			heap.Push(&h, q)
+			pending[q.src]++
+		case <-tick.C:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// LinkType satisfies Source. The sources may differ, every packet is already
+// decoded with the link type of its own source
+func (m *MultiSource) LinkType() layers.LinkType {
+	if len(m.sources) == 0 {
+		return layers.LinkTypeNull
+	}
+	return m.sources[0].LinkType()
+}
+
+// Sources returns the merged sources, in InterfaceIndex order
+func (m *MultiSource) Sources() []Source {
+	return m.sources
+}
+
+// Stats satisfies Source, summing the counters of every source
+func (m *MultiSource) Stats() (Stats, error) {
+	var total Stats
+	var errs []error
+	for _, src := range m.sources {
+		s, err := src.Stats()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		total.Received += s.Received
+		total.KernelDropped += s.KernelDropped
+		total.IfaceDropped += s.IfaceDropped
+		total.QueueDropped += s.QueueDropped
+	}
+
+	return total, errors.Join(errs...)
+}
+
+// Close satisfies Source, closing every source
+func (m *MultiSource) Close() error {
+	var errs []error
+	m.closed.Do(func() {
+		close(m.done)
+		for _, src := range m.sources {
+			if err := src.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+
+	return errors.Join(errs...)
+}
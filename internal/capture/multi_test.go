@@ -0,0 +1,86 @@
+package capture
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// idleSource is a Source that never delivers and never finishes, like a
+// quiet interface
+type idleSource struct {
+	*MemorySource
+	packets chan gopacket.Packet
+}
+
+func (s idleSource) Packets() <-chan gopacket.Packet {
+	return s.packets
+}
+
+func TestMerge_TimestampOrder(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	lan := NewMemorySource(layers.LinkTypeEthernet)
+	wan := NewMemorySource(layers.LinkTypeEthernet)
+	require.NoError(t, lan.AddLayers(t0, udpLayers(1000)...))
+	require.NoError(t, lan.AddLayers(t0.Add(2*time.Second), udpLayers(1002)...))
+	require.NoError(t, wan.AddLayers(t0.Add(time.Second), udpLayers(1001)...))
+	require.NoError(t, wan.AddLayers(t0.Add(3*time.Second), udpLayers(1003)...))
+
+	m := Merge(lan, wan)
+	defer m.Close()
+
+	var ports []layers.UDPPort
+	var ifaces []int
+	for p := range m.Packets() {
+		ports = append(ports, p.Layer(layers.LayerTypeUDP).(*layers.UDP).SrcPort)
+		ifaces = append(ifaces, p.Metadata().InterfaceIndex)
+	}
+
+	assert.Equal(t, []layers.UDPPort{1000, 1001, 1002, 1003}, ports)
+	assert.Equal(t, []int{0, 1, 0, 1}, ifaces)
+
+	stats, err := m.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.Received)
+}
+
+func TestMerge_IdleSource(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	busy := NewMemorySource(layers.LinkTypeEthernet)
+	require.NoError(t, busy.AddLayers(t0, udpLayers(1000)...))
+	idle := idleSource{
+		MemorySource: NewMemorySource(layers.LinkTypeEthernet),
+		packets:      make(chan gopacket.Packet),
+	}
+
+	m := MergeWithin(10*time.Millisecond, idle, busy)
+	defer m.Close()
+
+	select {
+	case p := <-m.Packets():
+		assert.Equal(t, 1, p.Metadata().InterfaceIndex)
+	case <-time.After(time.Second):
+		t.Fatal("packet held back by an idle source")
+	}
+}
+
+func TestMerge_Close(t *testing.T) {
+	idle := idleSource{
+		MemorySource: NewMemorySource(layers.LinkTypeEthernet),
+		packets:      make(chan gopacket.Packet),
+	}
+	m := Merge(idle)
+	packets := m.Packets()
+	require.NoError(t, m.Close())
+
+	select {
+	case _, ok := <-packets:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("packets not closed")
+	}
+}
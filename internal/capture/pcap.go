@@ -0,0 +1,206 @@
+package capture
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcap"
+)
+
+// DefaultSnapLen is the number of bytes captured of every packet on a live
+// interface
+const DefaultSnapLen = 1600
+
+// PcapSource reads packets through a libpcap handle, either from a live
+// interface or from a pcap/pcapng file
+type PcapSource struct {
+	handle *pcap.Handle
+	live   bool
+
+	once     sync.Once
+	packets  chan gopacket.Packet
+	done     chan struct{}
+	closed   sync.Once
+	received atomic.Int64
+}
+
+// LiveConfig holds the libpcap settings of a live capture
+type LiveConfig struct {
+	SnapLen     int  // bytes captured of every packet, DefaultSnapLen when 0
+	Promiscuous bool // also capture traffic not addressed to the interface
+	// Immediate delivers every packet as soon as it arrives instead of
+	// waiting for the kernel buffer to fill or the timeout to expire
+	Immediate bool
+	// BufferSize is the kernel capture buffer in bytes, 0 keeps the libpcap
+	// default
+	BufferSize int
+	// Timeout is how long the kernel may hold packets back to batch them,
+	// 0 blocks until the buffer is full
+	Timeout time.Duration
+	// TimestampSource picks where timestamps come from, e.g. "host" or
+	// "adapter". Empty keeps the libpcap default
+	TimestampSource string
+}
+
+// withDefaults fills any unset field with its default
+func (c LiveConfig) withDefaults() LiveConfig {
+	if c.SnapLen == 0 {
+		c.SnapLen = DefaultSnapLen
+	}
+	if c.Timeout == 0 {
+		c.Timeout = pcap.BlockForever
+	}
+	return c
+}
+
+// OpenLive opens the device for capture with the given settings. The filter
+// is a BPF expression and may be empty
+func OpenLive(device, filter string, cfg LiveConfig) (*PcapSource, error) {
+	cfg = cfg.withDefaults()
+	if cfg.SnapLen < 0 || cfg.BufferSize < 0 {
+		return nil, fmt.Errorf("snaplen and buffer size cannot be negative")
+	}
+
+	inactive, err := pcap.NewInactiveHandle(device)
+	if err != nil {
+		return nil, err
+	}
+	defer inactive.CleanUp()
+
+	if err := inactive.SetSnapLen(cfg.SnapLen); err != nil {
+		return nil, fmt.Errorf("setting snaplen: %w", err)
+	}
+	if err := inactive.SetPromisc(cfg.Promiscuous); err != nil {
+		return nil, fmt.Errorf("setting promiscuous mode: %w", err)
+	}
+	if err := inactive.SetImmediateMode(cfg.Immediate); err != nil {
+		return nil, fmt.Errorf("setting immediate mode: %w", err)
+	}
+	if err := inactive.SetTimeout(cfg.Timeout); err != nil {
+		return nil, fmt.Errorf("setting timeout: %w", err)
+	}
+	if cfg.BufferSize > 0 {
+		if err := inactive.SetBufferSize(cfg.BufferSize); err != nil {
+			return nil, fmt.Errorf("setting buffer size: %w", err)
+		}
+	}
+	if cfg.TimestampSource != "" {
+		ts, err := pcap.TimestampSourceFromString(cfg.TimestampSource)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timestamp source %q", cfg.TimestampSource)
+		}
+		if err := inactive.SetTimestampSource(ts); err != nil {
+			return nil, fmt.Errorf("setting timestamp source %q: %w", cfg.TimestampSource, err)
+		}
+	}
+
+	handle, err := inactive.Activate()
+	if err != nil {
+		return nil, err
+	}
+
+	return newPcapSource(handle, filter, true)
+}
+
+// OpenFile reads packets from a pcap or pcapng file. The filter is a BPF
+// expression and may be empty
+func OpenFile(path, filter string) (*PcapSource, error) {
+	handle, err := pcap.OpenOffline(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPcapSource(handle, filter, false)
+}
+
+func newPcapSource(handle *pcap.Handle, filter string, live bool) (*PcapSource, error) {
+	if filter != "" {
+		if err := handle.SetBPFFilter(filter); err != nil {
+			handle.Close()
+			return nil, err
+		}
+	}
+
+	return &PcapSource{
+		handle: handle,
+		live:   live,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Packets satisfies Source
+func (s *PcapSource) Packets() <-chan gopacket.Packet {
+	s.once.Do(func() {
+		s.packets = make(chan gopacket.Packet)
+		in := gopacket.NewPacketSource(s.handle, s.handle.LinkType()).Packets()
+		go func() {
+			defer close(s.packets)
+			for p := range in {
+				// Prefer stopping over delivering once closed
+				select {
+				case <-s.done:
+					return
+				default:
+				}
+
+				s.untag(p)
+				select {
+				case s.packets <- p:
+					s.received.Add(1)
+				case <-s.done:
+					return
+				}
+			}
+		}()
+	})
+
+	return s.packets
+}
+
+// untag clears the OS ifindex libpcap sets on live packets, packeteer numbers
+// interfaces by source and a lone device is interface 0
+func (s *PcapSource) untag(p gopacket.Packet) {
+	if s.live {
+		p.Metadata().InterfaceIndex = 0
+	}
+}
+
+// LinkType satisfies Source
+func (s *PcapSource) LinkType() layers.LinkType {
+	return s.handle.LinkType()
+}
+
+// SnapLen returns the snapshot length of the handle
+func (s *PcapSource) SnapLen() int {
+	return s.handle.SnapLen()
+}
+
+// Stats satisfies Source. Drop counters are only known for live captures
+func (s *PcapSource) Stats() (Stats, error) {
+	stats := Stats{Received: int(s.received.Load())}
+	if !s.live {
+		return stats, nil
+	}
+
+	ps, err := s.handle.Stats()
+	if err != nil {
+		return stats, err
+	}
+	stats.KernelDropped = ps.PacketsDropped
+	stats.IfaceDropped = ps.PacketsIfDropped
+
+	return stats, nil
+}
+
+// Close satisfies Source
+func (s *PcapSource) Close() error {
+	s.closed.Do(func() {
+		close(s.done)
+		s.handle.Close()
+	})
+	return nil
+}
@@ -0,0 +1,77 @@
+package capture
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcap"
+	"github.com/gopacket/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/pcapwriter"
+)
+
+func TestLiveConfig_WithDefaults(t *testing.T) {
+	cfg := LiveConfig{Promiscuous: true}.withDefaults()
+
+	assert.Equal(t, DefaultSnapLen, cfg.SnapLen)
+	assert.Equal(t, pcap.BlockForever, cfg.Timeout)
+	assert.True(t, cfg.Promiscuous)
+	assert.False(t, cfg.Immediate)
+	assert.Equal(t, 0, cfg.BufferSize)
+}
+
+func TestLiveConfig_WithDefaults_KeepsSet(t *testing.T) {
+	cfg := LiveConfig{
+		SnapLen:         9216,
+		Timeout:         50 * time.Millisecond,
+		BufferSize:      16 << 20,
+		TimestampSource: "adapter",
+	}.withDefaults()
+
+	assert.Equal(t, 9216, cfg.SnapLen)
+	assert.Equal(t, 50*time.Millisecond, cfg.Timeout)
+	assert.Equal(t, 16<<20, cfg.BufferSize)
+	assert.Equal(t, "adapter", cfg.TimestampSource)
+}
+
+func TestOpenLive_Negative(t *testing.T) {
+	_, err := OpenLive("eth0", "", LiveConfig{SnapLen: -1})
+	assert.Error(t, err)
+
+	_, err = OpenLive("eth0", "", LiveConfig{BufferSize: -1})
+	assert.Error(t, err)
+}
+
+func TestPcapSource_Untag(t *testing.T) {
+	data := make([]byte, 60)
+	p := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+	// libpcap tags live packets with the OS ifindex, eth0 is usually 2
+	*p.Metadata() = gopacket.PacketMetadata{CaptureInfo: gopacket.CaptureInfo{
+		Timestamp:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		CaptureLength:  len(data),
+		Length:         len(data),
+		InterfaceIndex: 2,
+	}}
+
+	(&PcapSource{live: true}).untag(p)
+	assert.Equal(t, 0, p.Metadata().InterfaceIndex)
+
+	// A single device capture is written as interface 0
+	w, err := pcapwriter.NewWriter(pcapwriter.Config{
+		Path:       filepath.Join(t.TempDir(), "capture.pcapng"),
+		Interfaces: []pcapgo.NgInterface{{Name: "eth0", LinkType: layers.LinkTypeEthernet}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, w.WritePacket(p.Metadata().CaptureInfo, p.Data()))
+	require.NoError(t, w.Close())
+
+	// Files number their interfaces themselves
+	p.Metadata().InterfaceIndex = 1
+	(&PcapSource{}).untag(p)
+	assert.Equal(t, 1, p.Metadata().InterfaceIndex)
+}
@@ -0,0 +1,30 @@
+package capture
+
+import (
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// Source is anything packets can be read from: a live interface, a capture
+// file, or packets built in memory. It decouples the rest of packeteer from
+// libpcap, so everything past capture can run without root or an interface
+type Source interface {
+	// Packets returns the channel packets are delivered on. It is closed
+	// once the source runs out of packets or is closed. Calling it more
+	// than once returns the same channel
+	Packets() <-chan gopacket.Packet
+	// LinkType is the link layer the packets start with
+	LinkType() layers.LinkType
+	// Stats returns the capture counters so far
+	Stats() (Stats, error)
+	// Close releases the source and stops packet delivery
+	Close() error
+}
+
+// Stats are the capture counters of a Source
+type Stats struct {
+	Received      int `json:"received"`       // packets delivered by the source
+	KernelDropped int `json:"kernel_dropped"` // packets dropped by the kernel for lack of buffer space
+	IfaceDropped  int `json:"iface_dropped"`  // packets dropped by the network interface or driver
+	QueueDropped  int `json:"queue_dropped"`  // packets dropped by packeteer because a queue was full
+}
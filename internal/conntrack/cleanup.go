@@ -25,8 +25,12 @@ func (m *model) Cleanup(ctx context.Context, timeChan <-chan time.Time, conns *T
 		case <-ctx.Done():
 			return
 		case tick := <-timeChan:
+			var removed []*Connection
 			conns.mu.Lock()
 			tickTime := tick.UTC()
+			if m.packetClock {
+				tickTime = conns.lastPacket.UTC()
+			}
 			for k, v := range conns.connections {
 				m.isLongestLiving(string(k), v)
 				m.isMostData(string(k), v)
@@ -34,30 +38,50 @@ func (m *model) Cleanup(ctx context.Context, timeChan <-chan time.Time, conns *T
 				tls := v.TimeLastSeen.UTC()
 				if tls.Before(tickTime.Add(-StaleTime)) {
 					delete(conns.connections, k)
+					removed = append(removed, v)
 				}
 			}
+			onRemove := conns.onRemove
 			conns.mu.Unlock()
+
+			// Whoever holds on to the connections, like the stream
+			// reassembly, lets go of them too
+			if onRemove != nil {
+				for _, c := range removed {
+					onRemove(c)
+				}
+			}
 		}
 	}
 }
 
 func (m *model) isLongestLiving(currConnKey string, currConn *Connection) {
+	lived := int(m.since(currConn.TimeStart).Seconds())
 	if m.longestLivedConn == nil {
 		m.longestLivedConn = &connInfo{
 			ConnectionName:  currConnKey,
-			ConnectionValue: int(time.Since(currConn.TimeStart).Seconds()),
+			ConnectionValue: lived,
 		}
 		return
 	}
 
-	if int(time.Since(currConn.TimeStart).Seconds()) > m.longestLivedConn.ConnectionValue {
+	if lived > m.longestLivedConn.ConnectionValue {
 		m.longestLivedConn = &connInfo{
 			ConnectionName:  currConnKey,
-			ConnectionValue: int(time.Since(currConn.TimeStart).Seconds()),
+			ConnectionValue: lived,
 		}
 	}
 }
 
+// since returns the time elapsed from t. With the packet clock this is
+// measured against the newest packet instead of the wall clock
+func (m *model) since(t time.Time) time.Duration {
+	if m.packetClock {
+		return m.tracker.lastPacket.Sub(t)
+	}
+	return time.Since(t)
+}
+
 func (m *model) isMostData(currConnKey string, currConn *Connection) {
 	if m.highestDataConn == nil {
 		m.highestDataConn = &connInfo{
@@ -40,6 +40,38 @@ func TestCleanup_RemoveStaleConnection(t *testing.T) {
 	assert.Contains(t, tracker.connections, freshKey)
 }
 
+func TestCleanup_OnRemove(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := &model{}
+	tracker := NewTracker()
+	now := time.Now()
+	stale := &Connection{Key: "stale", TimeLastSeen: now.Add(-60 * time.Second)}
+	tracker.connections[stale.Key] = stale
+	tracker.connections["fresh"] = &Connection{TimeLastSeen: now}
+
+	removed := make(chan *Connection, 2)
+	tracker.OnRemove(func(c *Connection) {
+		// Called unlocked, the tracker can be used again
+		tracker.mu.RLock()
+		defer tracker.mu.RUnlock()
+		removed <- c
+	})
+
+	timeChan := make(chan time.Time, 1)
+	go m.Cleanup(ctx, timeChan, &tracker)
+	timeChan <- now
+
+	select {
+	case c := <-removed:
+		assert.Same(t, stale, c)
+	case <-time.After(time.Second):
+		t.Fatal("OnRemove was not called")
+	}
+	assert.Empty(t, removed)
+}
+
 func TestIsLongestLiving_SetsWhenNil(t *testing.T) {
 	m := &model{}
 	conn := &Connection{
@@ -170,3 +202,50 @@ func TestCleanup_PopulatesStatsOnTick(t *testing.T) {
 	assert.Equal(t, "long-lived", m.highestDataConn.ConnectionName)
 	assert.Equal(t, 1000, m.highestDataConn.ConnectionValue)
 }
+
+func TestCleanup_PacketClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := &model{packetClock: true}
+	tracker := NewTracker()
+	captured := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker.lastPacket = captured
+
+	staleKey := ConnKey("stale")
+	freshKey := ConnKey("fresh")
+	tracker.connections[staleKey] = &Connection{
+		TimeLastSeen: captured.Add(-60 * time.Second),
+	}
+	tracker.connections[freshKey] = &Connection{
+		TimeLastSeen: captured.Add(-5 * time.Second),
+	}
+
+	timeChan := make(chan time.Time, 1)
+	go m.Cleanup(ctx, timeChan, &tracker)
+
+	// The wall clock is far past both connections, only the packet clock
+	// should be used to age them out
+	timeChan <- time.Now()
+
+	time.Sleep(10 * time.Millisecond)
+
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+
+	assert.NotContains(t, tracker.connections, staleKey)
+	assert.Contains(t, tracker.connections, freshKey)
+}
+
+func TestIsLongestLiving_PacketClock(t *testing.T) {
+	captured := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := &model{packetClock: true}
+	m.tracker.lastPacket = captured
+	conn := &Connection{
+		TimeStart: captured.Add(-10 * time.Second),
+	}
+
+	m.isLongestLiving("conn1", conn)
+
+	assert.Equal(t, 10, m.longestLivedConn.ConnectionValue)
+}
@@ -2,10 +2,16 @@ package conntrack
 
 import (
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"packeteer/internal/filter"
 	"packeteer/internal/packet"
+	"packeteer/internal/quic"
+	"packeteer/internal/tls"
 )
 
 const ConnKeyStringFormat = "%s:%s-->%s:%s/%s"
@@ -27,20 +33,90 @@ const (
 )
 
 // Connection is a struct that contains information related to a TCP or UDP
-// connection
+// connection, or a ping flow of ICMP echo requests and replies
 type Connection struct {
 	Key           ConnKey
 	SrcIP         string
 	SrcPort       string
 	DstIP         string
 	DstPort       string
-	Protocol      packet.PacketProtocol // TCP or UDP
+	Protocol      packet.PacketProtocol // TCP, UDP, ICMPv4 and ICMPv6 for pings, or ESP
 	State         TCPState              // only matters for TCP
 	BytesReceived int64                 // from src -> dst
 	BytesSent     int64                 // from dst -> src
 	TotalBytes    int64
-	TimeStart     time.Time // when the connection was first seen
-	TimeLastSeen  time.Time // when the most recent packet for this arrived
+	TimeStart     time.Time       // when the connection was first seen
+	TimeLastSeen  time.Time       // when the most recent packet for this arrived
+	Interfaces    []string        // interfaces the connection was seen on, in order
+	VLANs         []uint16        // VLAN tags of the first packet, outermost first
+	Tunnels       []packet.Tunnel // tunnels of the first packet, outermost first
+	ICMPError     string          // the last ICMP error about the flow, e.g. port unreachable
+	RTT           time.Duration   // of the last answered echo request of a ping flow
+	TLS           *tls.Info       // what the TLS handshake told, nil until one is seen
+	QUIC          *quic.Info      // set when a UDP connection is QUIC
+
+	pending map[uint16]time.Time // echo requests waiting for a reply, by seq
+}
+
+// seenOn records that a packet of the connection arrived on iface
+func (c *Connection) seenOn(iface string) {
+	if iface == "" || slices.Contains(c.Interfaces, iface) {
+		return
+	}
+	c.Interfaces = append(c.Interfaces, iface)
+}
+
+// Field makes a Connection a filter.Record, see filter.ScopeConnection
+func (c *Connection) Field(name string) []any {
+	switch name {
+	case "frame.interface":
+		v := make([]any, len(c.Interfaces))
+		for i, iface := range c.Interfaces {
+			v[i] = iface
+		}
+		return v
+	case "vlan.id":
+		v := make([]any, len(c.VLANs))
+		for i, id := range c.VLANs {
+			v[i] = int64(id)
+		}
+		return v
+	case "conn.state":
+		if c.Protocol != packet.TCP {
+			return nil
+		}
+		return []any{c.State.String()}
+	case "conn.bytes":
+		return []any{c.TotalBytes}
+	case "conn.bytes_sent":
+		return []any{c.BytesSent}
+	case "conn.bytes_received":
+		return []any{c.BytesReceived}
+	case "conn.duration":
+		return []any{c.TimeLastSeen.Sub(c.TimeStart)}
+	case "conn.icmp_error":
+		if c.ICMPError == "" {
+			return nil
+		}
+		return []any{c.ICMPError}
+	case "conn.rtt":
+		if c.RTT == 0 {
+			return nil
+		}
+		return []any{c.RTT}
+	case "tls":
+		return []any{c.TLS != nil}
+	case "tls.sni", "tls.alpn", "tls.version", "tls.ja3", "tls.ja3s", "tls.ja4":
+		return filter.TLSField(name, c.TLS)
+	case "tunnel", "tunnel.type", "tunnel.id", "tunnel.addr":
+		return filter.TunnelField(name, c.Tunnels)
+	case "quic":
+		return []any{c.QUIC != nil}
+	case "quic.version", "quic.migrated":
+		return filter.QUICField(name, c.QUIC)
+	}
+
+	return filter.Flow(name, c.SrcIP, c.SrcPort, c.DstIP, c.DstPort, c.Protocol)
 }
 
 // String satisfies the fmt.Stringer interface and now returns the string
@@ -69,6 +145,9 @@ func (s TCPState) String() string {
 type Tracker struct {
 	mu          sync.RWMutex
 	connections map[ConnKey]*Connection
+	lastPacket  time.Time // timestamp of the newest packet seen
+	keyByVLAN   bool      // keep the same flow on different VLANs apart
+	onRemove    func(*Connection)
 }
 
 // NewTracker returns a new Tracker object
@@ -79,22 +158,108 @@ func NewTracker() Tracker {
 	}
 }
 
-// UpdateTracker takes in a TCP or UDP packet and builds/updates a connection
-// in the connection map
+// KeyByVLAN makes the tracker treat the same addresses and ports on
+// different VLANs as different connections, by prefixing the key with the VLAN
+// tags, e.g. vlan 100.20 10.0.0.1:... On trunks carrying overlapping address
+// space the flows would otherwise be merged
+func (t *Tracker) KeyByVLAN() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keyByVLAN = true
+}
+
+// OnRemove sets a function called with every connection cleaned up as stale,
+// after the tracker is unlocked again
+func (t *Tracker) OnRemove(f func(*Connection)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onRemove = f
+}
+
+// vlanPrefix is prepended to the keys of a packet with the given VLAN tags
+func (t *Tracker) vlanPrefix(vlans []uint16) string {
+	if !t.keyByVLAN || len(vlans) == 0 {
+		return ""
+	}
+
+	ids := make([]string, len(vlans))
+	for i, v := range vlans {
+		ids[i] = strconv.Itoa(int(v))
+	}
+	return "vlan " + strings.Join(ids, ".") + " "
+}
+
+// UpdateTracker takes in a TCP, UDP, ICMP or ESP packet and builds/updates a
+// connection in the connection map. Tunneled packets are tracked by their
+// inner addresses and ports, the tunnel is kept with the connection
 func (t *Tracker) UpdateTracker(p *packet.PacketInfo) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	con := t.connections
+	if p.Timestamp.After(t.lastPacket) {
+		t.lastPacket = p.Timestamp
+	}
+
+	if p.ICMP != nil {
+		t.updateICMP(p)
+		return
+	}
+	if spi, ok := espSPI(p); ok {
+		t.updateESP(p, spi)
+		return
+	}
+
+	// A packet decoded past its transport, e.g. TLS or DNS, still belongs to
+	// the TCP or UDP connection
+	proto := p.Transport
+	if proto == "" {
+		proto = p.Protocol
+	}
 
+	vlan := t.vlanPrefix(p.VLANs)
 	key := ConnKey(
-		fmt.Sprintf(ConnKeyStringFormat, p.SrcIP, p.SrcPort, p.DestIP, p.DestPort, p.Protocol),
+		vlan + fmt.Sprintf(ConnKeyStringFormat, p.SrcIP, p.SrcPort, p.DestIP, p.DestPort, proto),
 	)
 	oppositeKey := ConnKey(
-		fmt.Sprintf(ConnKeyStringFormat, p.DestIP, p.DestPort, p.SrcIP, p.SrcPort, p.Protocol),
+		vlan + fmt.Sprintf(ConnKeyStringFormat, p.DestIP, p.DestPort, p.SrcIP, p.SrcPort, proto),
 	)
 
-	if p.Protocol == packet.UDP {
+	// A QUIC connection is one flow whichever path or direction its packets
+	// take, the one of the client's first Initial
+	if p.QUIC != nil {
+		q := p.QUIC
+		key = ConnKey(vlan + fmt.Sprintf(
+			ConnKeyStringFormat, q.ClientIP, q.ClientPort, q.ServerIP, q.ServerPort, proto,
+		))
+		oppositeKey = ConnKey(vlan + fmt.Sprintf(
+			ConnKeyStringFormat, q.ServerIP, q.ServerPort, q.ClientIP, q.ClientPort, proto,
+		))
+	}
+
+	// Whichever direction the packet updated, remember the interface once the
+	// state changes below are done
+	defer func() {
+		for _, k := range []ConnKey{key, oppositeKey} {
+			if v, ok := con[k]; ok {
+				v.seenOn(p.Interface)
+				if v.VLANs == nil {
+					v.VLANs = p.VLANs
+				}
+				if v.Tunnels == nil {
+					v.Tunnels = p.Tunnels
+				}
+				if p.TLS != nil {
+					v.TLS = p.TLS
+				}
+				if p.QUIC != nil {
+					v.QUIC = p.QUIC
+				}
+			}
+		}
+	}()
+
+	if proto == packet.UDP {
 		if v, ok := con[key]; ok {
 			v.TotalBytes += int64(p.CaptureLength)
 			v.TimeLastSeen = p.Timestamp
@@ -110,7 +275,7 @@ func (t *Tracker) UpdateTracker(p *packet.PacketInfo) {
 				TimeLastSeen:  p.Timestamp,
 				BytesReceived: int64(p.CaptureLength),
 				TotalBytes:    int64(p.CaptureLength),
-				Protocol:      p.Protocol,
+				Protocol:      proto,
 			}
 		}
 		return
@@ -128,7 +293,7 @@ func (t *Tracker) UpdateTracker(p *packet.PacketInfo) {
 			TimeStart:     p.Timestamp,
 			TimeLastSeen:  p.Timestamp,
 			BytesReceived: int64(p.CaptureLength),
-			Protocol:      p.Protocol,
+			Protocol:      proto,
 		}
 		return
 	}
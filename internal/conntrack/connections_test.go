@@ -6,8 +6,11 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"packeteer/internal/packet"
+	"packeteer/internal/quic"
+	"packeteer/internal/tls"
 )
 
 func TestNewTracker(t *testing.T) {
@@ -558,3 +561,230 @@ func TestTrackerUpdate_EmptyPacketInfo(t *testing.T) {
 	assert.NotNil(t, tracker.connections)
 	assert.Len(t, tracker.connections, 0)
 }
+
+func TestUpdateTracker_LastPacket(t *testing.T) {
+	tracker := NewTracker()
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tracker.UpdateTracker(&packet.PacketInfo{
+		SrcIP:     "192.168.0.1",
+		SrcPort:   "8080",
+		DestIP:    "10.10.10.10",
+		DestPort:  "443",
+		Protocol:  packet.UDP,
+		Timestamp: t0.Add(time.Second),
+	})
+	tracker.UpdateTracker(&packet.PacketInfo{
+		SrcIP:     "192.168.0.1",
+		SrcPort:   "8080",
+		DestIP:    "10.10.10.10",
+		DestPort:  "443",
+		Protocol:  packet.UDP,
+		Timestamp: t0,
+	})
+
+	assert.Equal(t, t0.Add(time.Second), tracker.lastPacket)
+}
+
+func TestUpdateTracker_Interfaces(t *testing.T) {
+	tracker := NewTracker()
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	syn := &packet.PacketInfo{
+		SrcIP:     "192.168.0.1",
+		SrcPort:   "50000",
+		DestIP:    "10.10.10.10",
+		DestPort:  "443",
+		Protocol:  packet.TCP,
+		TCPFlags:  packet.TCPFlags{SYN: true},
+		Timestamp: t0,
+		Interface: "eth1",
+	}
+	tracker.UpdateTracker(syn)
+
+	// The reply comes back through the other leg of the gateway
+	tracker.UpdateTracker(&packet.PacketInfo{
+		SrcIP:     "10.10.10.10",
+		SrcPort:   "443",
+		DestIP:    "192.168.0.1",
+		DestPort:  "50000",
+		Protocol:  packet.TCP,
+		TCPFlags:  packet.TCPFlags{SYN: true, ACK: true},
+		Timestamp: t0.Add(time.Millisecond),
+		Interface: "eth0",
+	})
+
+	key := ConnKey(fmt.Sprintf(
+		ConnKeyStringFormat,
+		syn.SrcIP, syn.SrcPort,
+		syn.DestIP, syn.DestPort,
+		syn.Protocol,
+	))
+	assert.Equal(t, []string{"eth1", "eth0"}, tracker.connections[key].Interfaces)
+}
+
+func TestConnection_Field(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &Connection{
+		SrcIP:         "192.168.0.1",
+		SrcPort:       "50000",
+		DstIP:         "10.10.10.10",
+		DstPort:       "443(https)",
+		Protocol:      packet.TCP,
+		State:         StateEstablished,
+		BytesReceived: 100,
+		BytesSent:     900,
+		TotalBytes:    1000,
+		TimeStart:     t0,
+		TimeLastSeen:  t0.Add(90 * time.Second),
+		Interfaces:    []string{"eth0"},
+	}
+
+	assert.Equal(t, []any{"ESTABLISHED"}, c.Field("conn.state"))
+	assert.Equal(t, []any{int64(1000)}, c.Field("conn.bytes"))
+	assert.Equal(t, []any{90 * time.Second}, c.Field("conn.duration"))
+	assert.Equal(t, []any{"eth0"}, c.Field("frame.interface"))
+	assert.Equal(t, []any{int64(50000), int64(443)}, c.Field("tcp.port"))
+	assert.Nil(t, c.Field("udp.port"))
+	assert.Equal(t, []any{true}, c.Field("tcp"))
+
+	assert.Equal(t, []any{false}, c.Field("tls"))
+	assert.Nil(t, c.Field("tls.sni"))
+
+	c.TLS = &tls.Info{SNI: "api.github.com", OfferedALPN: []string{"h2"}, Version: "TLS 1.3"}
+	assert.Equal(t, []any{true}, c.Field("tls"))
+	assert.Equal(t, []any{"api.github.com"}, c.Field("tls.sni"))
+	assert.Equal(t, []any{"h2"}, c.Field("tls.alpn"))
+	assert.Equal(t, []any{"TLS 1.3"}, c.Field("tls.version"))
+
+	c.Protocol = packet.UDP
+	assert.Nil(t, c.Field("conn.state"))
+
+	assert.Equal(t, []any{false}, c.Field("quic"))
+	c.QUIC = &quic.Info{Version: "QUIC v1"}
+	assert.Equal(t, []any{true}, c.Field("quic"))
+	assert.Equal(t, []any{"QUIC v1"}, c.Field("quic.version"))
+	assert.Equal(t, []any{false}, c.Field("quic.migrated"))
+}
+
+func TestUpdateTracker_QUICMigration(t *testing.T) {
+	tracker := NewTracker()
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	info := &quic.Info{
+		Version:    "QUIC v1",
+		ClientIP:   "10.0.0.5",
+		ClientPort: "51000",
+		ServerIP:   "142.250.74.100",
+		ServerPort: "443(https)",
+	}
+	udp := func(src, srcPort, dst, dstPort string) *packet.PacketInfo {
+		return &packet.PacketInfo{
+			SrcIP:         src,
+			SrcPort:       srcPort,
+			DestIP:        dst,
+			DestPort:      dstPort,
+			Protocol:      packet.QUIC,
+			Transport:     packet.UDP,
+			CaptureLength: 1000,
+			Timestamp:     t0,
+			QUIC:          info,
+		}
+	}
+
+	tracker.UpdateTracker(udp("10.0.0.5", "51000", "142.250.74.100", "443(https)"))
+	tracker.UpdateTracker(udp("142.250.74.100", "443(https)", "10.0.0.5", "51000"))
+
+	// After a NAT rebinding the packets still belong to the first flow
+	migrated := *info
+	migrated.Migrated = true
+	info = &migrated
+	tracker.UpdateTracker(udp("10.0.0.5", "61000", "142.250.74.100", "443(https)"))
+	tracker.UpdateTracker(udp("142.250.74.100", "443(https)", "10.0.0.5", "61000"))
+
+	assert.Len(t, tracker.connections, 1)
+	c := tracker.connections["10.0.0.5:51000-->142.250.74.100:443(https)/UDP"]
+	if assert.NotNil(t, c) {
+		assert.Equal(t, int64(4000), c.TotalBytes)
+		assert.Equal(t, packet.UDP, c.Protocol)
+		assert.True(t, c.QUIC.Migrated)
+	}
+
+	// Other UDP flows are still told apart by address
+	tracker.UpdateTracker(&packet.PacketInfo{
+		SrcIP:     "10.0.0.5",
+		SrcPort:   "61000",
+		DestIP:    "142.250.74.100",
+		DestPort:  "443(https)",
+		Protocol:  packet.UDP,
+		Transport: packet.UDP,
+	})
+	assert.Len(t, tracker.connections, 2)
+}
+
+func TestUpdateTracker_KeyByVLAN(t *testing.T) {
+	syn := func(vlan uint16) *packet.PacketInfo {
+		return &packet.PacketInfo{
+			SrcIP:    "10.0.0.1",
+			SrcPort:  "50000",
+			DestIP:   "10.0.0.2",
+			DestPort: "443",
+			Protocol: packet.TCP,
+			TCPFlags: packet.TCPFlags{SYN: true},
+			VLANs:    []uint16{vlan},
+		}
+	}
+
+	// By default the same flow on two VLANs is one connection
+	tracker := NewTracker()
+	tracker.UpdateTracker(syn(10))
+	tracker.UpdateTracker(syn(20))
+	assert.Len(t, tracker.connections, 1)
+
+	tracker = NewTracker()
+	tracker.KeyByVLAN()
+	tracker.UpdateTracker(syn(10))
+	tracker.UpdateTracker(syn(20))
+	assert.Len(t, tracker.connections, 2)
+
+	conn := tracker.connections["vlan 10 10.0.0.1:50000-->10.0.0.2:443/TCP"]
+	if assert.NotNil(t, conn) {
+		assert.Equal(t, []uint16{10}, conn.VLANs)
+		assert.Equal(t, []any{int64(10)}, conn.Field("vlan.id"))
+	}
+
+	// The SYN-ACK finds its connection on the same VLAN
+	tracker.UpdateTracker(&packet.PacketInfo{
+		SrcIP:    "10.0.0.2",
+		SrcPort:  "443",
+		DestIP:   "10.0.0.1",
+		DestPort: "50000",
+		Protocol: packet.TCP,
+		TCPFlags: packet.TCPFlags{SYN: true, ACK: true},
+		VLANs:    []uint16{20},
+	})
+	assert.Equal(t, StateSynReceived, tracker.connections["vlan 20 10.0.0.1:50000-->10.0.0.2:443/TCP"].State)
+	assert.Equal(t, StateSynSent, conn.State)
+}
+
+func TestUpdateTracker_Tunnel(t *testing.T) {
+	vxlan := packet.Tunnel{Type: packet.VXLAN, SrcIP: "192.168.0.1", DstIP: "192.168.0.2", ID: 42}
+	tracker := NewTracker()
+	tracker.UpdateTracker(&packet.PacketInfo{
+		SrcIP:     "10.244.1.5",
+		SrcPort:   "51000",
+		DestIP:    "10.244.2.9",
+		DestPort:  "443",
+		Protocol:  packet.TCP,
+		Transport: packet.TCP,
+		TCPFlags:  packet.TCPFlags{SYN: true},
+		Tunnels:   []packet.Tunnel{vxlan},
+	})
+
+	// Keyed on the inner flow, the tunnel is an attribute
+	conn := tracker.connections["10.244.1.5:51000-->10.244.2.9:443/TCP"]
+	require.NotNil(t, conn)
+	assert.Equal(t, []packet.Tunnel{vxlan}, conn.Tunnels)
+	assert.Equal(t, []any{true}, conn.Field("tunnel"))
+	assert.Equal(t, []any{"VXLAN"}, conn.Field("tunnel.type"))
+	assert.Equal(t, []any{int64(42)}, conn.Field("tunnel.id"))
+}
@@ -8,13 +8,26 @@ import (
 	"slices"
 	"strings"
 	"text/tabwriter"
+	"time"
+	"unicode"
 
 	tea "charm.land/bubbletea/v2"
 	"charm.land/lipgloss/v2"
 
+	"packeteer/internal/capture"
+	"packeteer/internal/filter"
+	"packeteer/internal/http"
 	"packeteer/internal/packet"
 )
 
+const (
+	// statsRefresh is how often the capture statistics footer is redrawn
+	statsRefresh = time.Second
+	// maxTransactions is how many of the latest HTTP transactions the HTTP
+	// tab keeps
+	maxTransactions = 100
+)
+
 // model is the model structure for the bubbletea TUI
 type model struct {
 	tracker          Tracker
@@ -22,6 +35,12 @@ type model struct {
 	longestLivedConn *connInfo
 	highestDataConn  *connInfo
 	cancel           context.CancelFunc
+	packetClock      bool // measure time by packet timestamps, for offline reads
+	done             bool // the packet channel was closed
+	stats            func() capture.Stats
+	filter           *filter.Filter // connections not matching are hidden
+	showHTTP         bool           // the HTTP tab is shown instead of the connections
+	transactions     []http.Transaction
 }
 
 type connInfo struct {
@@ -34,6 +53,13 @@ type packetCapture struct {
 	packetInfo *packet.PacketInfo
 }
 
+// captureDone is the UI event sent once the packet channel has been closed,
+// e.g. when the end of a capture file is reached
+type captureDone struct{}
+
+// statsTick is the UI event that redraws the capture statistics footer
+type statsTick struct{}
+
 // NewModel returns a new model used for the bubbletea TUI
 func NewModel(pc <-chan *packet.PacketInfo) *model {
 	return &model{
@@ -45,6 +71,37 @@ func NewModel(pc <-chan *packet.PacketInfo) *model {
 	}
 }
 
+// UsePacketClock makes the model measure connection age and staleness by the
+// timestamps of the packets it receives instead of the wall clock. This is
+// used when replaying capture files
+func (m *model) UsePacketClock() {
+	m.packetClock = true
+}
+
+// ShowStats adds a footer with the capture statistics returned by stats,
+// refreshed every second
+func (m *model) ShowStats(stats func() capture.Stats) {
+	m.stats = stats
+}
+
+// SetFilter hides the connections not matching f from the view. They are
+// still tracked, so they show up again once they match
+func (m *model) SetFilter(f *filter.Filter) {
+	m.filter = f
+}
+
+// KeyByVLAN tracks the same flow on different VLANs as separate
+// connections, see Tracker.KeyByVLAN
+func (m *model) KeyByVLAN() {
+	m.tracker.KeyByVLAN()
+}
+
+// OnRemove sets a function called with every connection cleaned up as
+// stale, see Tracker.OnRemove
+func (m *model) OnRemove(f func(*Connection)) {
+	m.tracker.OnRemove(f)
+}
+
 // Init is 1/3 of fulfilling the bubbletea interface. It initialized reading
 // from the channel
 func (m *model) Init() tea.Cmd {
@@ -52,6 +109,9 @@ func (m *model) Init() tea.Cmd {
 
 	m.cancel = cancel
 	m.CleanupRoutine(ctx, &m.tracker)
+	if m.stats != nil {
+		return tea.Batch(waitForPacket(m.packetChan), tickStats())
+	}
 	return waitForPacket(m.packetChan)
 }
 
@@ -64,11 +124,23 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "ctrl+c", "q":
 			m.cancel()
 			return m, tea.Quit
+		case "tab":
+			m.showHTTP = !m.showHTTP
 		}
 	case packetCapture:
 		pi := msg.packetInfo
 		m.tracker.UpdateTracker(pi)
+		if len(pi.HTTP) > 0 {
+			m.transactions = append(m.transactions, pi.HTTP...)
+			if n := len(m.transactions) - maxTransactions; n > 0 {
+				m.transactions = slices.Delete(m.transactions, 0, n)
+			}
+		}
 		return m, waitForPacket(m.packetChan)
+	case captureDone:
+		m.done = true
+	case statsTick:
+		return m, tickStats()
 	}
 	return m, nil
 }
@@ -76,6 +148,29 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 // View is 3/3 of the bubbletea interface where the view is generated to the
 // terminal
 func (m *model) View() tea.View {
+	var header strings.Builder
+	if m.showHTTP {
+		header.WriteString(m.httpView())
+	} else {
+		header.WriteString(m.connectionsView())
+	}
+
+	if m.filter != nil && !m.showHTTP {
+		header.WriteString("\nfilter: " + m.filter.String() + "\n")
+	}
+	if m.stats != nil {
+		header.WriteString("\n" + FormatCaptureStats(m.stats()) + "\n")
+	}
+	if m.done {
+		header.WriteString("\nCapture finished\n")
+	}
+	header.WriteString("\nPress 'tab' to switch views, 'q' to quit\n")
+	return tea.NewView(header.String())
+}
+
+// connectionsView renders the connections tab, one connection a line colored
+// by its state
+func (m *model) connectionsView() string {
 	var header strings.Builder
 	header.WriteString("Active Connections\n")
 
@@ -88,11 +183,55 @@ func (m *model) View() tea.View {
 	states := make([]TCPState, 0, len(sortedKeys))
 	for _, k := range sortedKeys {
 		v := m.tracker.connections[k]
-		if v.Protocol == packet.UDP {
-			fmt.Fprintf(w, "%s\t | bytes: %d\n", k, v.TotalBytes)
+		if !m.filter.Match(v) {
+			continue
+		}
+		var ifaces string
+		if len(v.Interfaces) > 0 {
+			ifaces = "[" + strings.Join(v.Interfaces, ",") + "] "
+		}
+		// Name the server by the name the client asked for, when there is one
+		label := string(k)
+		if v.TLS != nil && v.TLS.SNI != "" {
+			label = strings.Replace(label, "-->"+v.DstIP+":", "-->"+printable(v.TLS.SNI)+":", 1)
+		}
+		if v.QUIC != nil {
+			label = strings.TrimSuffix(label, "/"+string(packet.UDP)) + "/" + string(packet.QUIC)
+		}
+		var notes string
+		if v.ICMPError != "" {
+			notes = " | " + v.ICMPError
+		}
+		// An encrypted tunnel is what the connection is, others what it
+		// travels in
+		for _, t := range v.Tunnels {
+			if t.Opaque() {
+				label = strings.TrimSuffix(label, "/"+string(v.Protocol)) + "/" + string(t.Type)
+			} else {
+				notes += " | via " + t.String()
+			}
+		}
+		switch v.Protocol {
+		case packet.UDP, packet.ESP:
+			fmt.Fprintf(w, "%s%s\t | bytes: %d%s\n", ifaces, label, v.TotalBytes, notes)
+			states = append(states, StateUnknown)
+		case packet.ICMPv4, packet.ICMPv6:
+			rtt := "-"
+			if v.RTT > 0 {
+				rtt = v.RTT.String()
+			}
+			fmt.Fprintf(
+				w,
+				"%s%s\t | bytes: %d | rtt: %s%s\n",
+				ifaces, k, v.TotalBytes, rtt, notes,
+			)
 			states = append(states, StateUnknown)
-		} else {
-			fmt.Fprintf(w, "%s\t:: %s\t | bytes: %d\n", k, v.State, v.TotalBytes)
+		default:
+			fmt.Fprintf(
+				w,
+				"%s%s\t:: %s\t | bytes: %d%s\n",
+				ifaces, label, v.State, v.TotalBytes, notes,
+			)
 			states = append(states, v.State)
 		}
 	}
@@ -111,21 +250,86 @@ func (m *model) View() tea.View {
 		header.WriteString(setStyledString(line, state))
 		header.WriteString("\n")
 	}
+	return header.String()
+}
 
-	header.WriteString("\nPress 'q' to quit\n")
-	return tea.NewView(header.String())
+// httpView renders the HTTP tab, the latest transactions oldest first colored
+// by their status
+func (m *model) httpView() string {
+	var header strings.Builder
+	header.WriteString("HTTP Transactions\n")
+
+	var tw strings.Builder
+	w := tabwriter.NewWriter(&tw, 3, 4, 1, ' ', 0)
+	for _, tx := range m.transactions {
+		contentType := tx.ContentType
+		if contentType == "" {
+			contentType = "-"
+		}
+		fmt.Fprintf(
+			w,
+			"%s-->%s:%s\t %s %s%s\t:: %d %s\t | bytes: %d | latency: %s\n",
+			tx.ClientIP, tx.ServerIP, tx.ServerPort,
+			printable(tx.Method), printable(tx.Host), printable(tx.Path),
+			tx.Status, printable(contentType), tx.ResponseSize, tx.Latency.Round(time.Microsecond),
+		)
+	}
+	w.Flush()
+
+	lines := strings.Split(tw.String(), "\n")
+	for i, line := range lines {
+		if line == "" || i >= len(m.transactions) {
+			continue
+		}
+		header.WriteString(setStatusString(line, m.transactions[i].Status))
+		header.WriteString("\n")
+	}
+	return header.String()
+}
+
+// printable replaces the control characters of a field read off the wire,
+// which would add lines or columns to the table, with '.'
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return '.'
+		}
+		return r
+	}, s)
 }
 
 // waitForPacket wraps reading the channel into a packetCapture struct, which
 // decouples this from the domain structures from the UI structures
 func waitForPacket(pc <-chan *packet.PacketInfo) tea.Cmd {
 	return func() tea.Msg {
+		pi, ok := <-pc
+		if !ok {
+			return captureDone{}
+		}
 		return packetCapture{
-			packetInfo: <-pc,
+			packetInfo: pi,
 		}
 	}
 }
 
+// tickStats schedules the next redraw of the statistics footer
+func tickStats() tea.Cmd {
+	return tea.Tick(statsRefresh, func(time.Time) tea.Msg {
+		return statsTick{}
+	})
+}
+
+// FormatCaptureStats renders the capture counters on a single line
+func FormatCaptureStats(s capture.Stats) string {
+	return fmt.Sprintf(
+		"received: %d | kernel drops: %d | interface drops: %d | queue drops: %d",
+		s.Received,
+		s.KernelDropped,
+		s.IfaceDropped,
+		s.QueueDropped,
+	)
+}
+
 // setStyledString styles the connection string based on the state
 func setStyledString(s string, state TCPState) string {
 	var c color.Color
@@ -150,6 +354,26 @@ func setStyledString(s string, state TCPState) string {
 	return style.Render(s)
 }
 
+// setStatusString styles a transaction's line based on its status class
+func setStatusString(s string, status int) string {
+	var c color.Color
+	switch {
+	case status >= 500:
+		c = lipgloss.Red
+	case status >= 400:
+		c = lipgloss.Yellow
+	case status >= 300:
+		c = lipgloss.Cyan
+	case status >= 200:
+		c = lipgloss.Green
+	default:
+		c = lipgloss.White
+	}
+
+	style := lipgloss.NewStyle().Foreground(c)
+	return style.Render(s)
+}
+
 // PrintStats prints longest-lived connections top talkers by bytes transferred.
 func (m *model) PrintStats() {
 	fmt.Println()
@@ -4,11 +4,18 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	tea "charm.land/bubbletea/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"packeteer/internal/capture"
+	"packeteer/internal/filter"
+	"packeteer/internal/http"
 	"packeteer/internal/packet"
+	"packeteer/internal/quic"
+	"packeteer/internal/tls"
 )
 
 func TestModelUpdate_PacketCapture(t *testing.T) {
@@ -94,7 +101,7 @@ func TestModelView_ShowsTCPConnections(t *testing.T) {
 	assert.Equal(t, "Active Connections", splitContent[0])
 	assert.True(t, strings.Contains(splitContent[1], key))
 	assert.Equal(t, "", splitContent[2])
-	assert.Equal(t, "Press 'q' to quit", splitContent[3])
+	assert.Equal(t, "Press 'tab' to switch views, 'q' to quit", splitContent[3])
 	assert.Equal(t, "", splitContent[4])
 }
 
@@ -133,6 +140,264 @@ func TestModelView_ShowsUDPConnections(t *testing.T) {
 	assert.Equal(t, "Active Connections", splitContent[0])
 	assert.True(t, strings.Contains(splitContent[1], key))
 	assert.Equal(t, "", splitContent[2])
-	assert.Equal(t, "Press 'q' to quit", splitContent[3])
+	assert.Equal(t, "Press 'tab' to switch views, 'q' to quit", splitContent[3])
 	assert.Equal(t, "", splitContent[4])
 }
+
+func TestWaitForPacket_ClosedChannel(t *testing.T) {
+	ch := make(chan *packet.PacketInfo)
+	close(ch)
+
+	msg := waitForPacket(ch)()
+	assert.Equal(t, captureDone{}, msg)
+}
+
+func TestModelUpdate_CaptureDone(t *testing.T) {
+	ch := make(chan *packet.PacketInfo)
+	m := NewModel(ch)
+
+	updated, cmd := m.Update(captureDone{})
+	assert.Nil(t, cmd)
+
+	um := updated.(*model)
+	assert.True(t, um.done)
+	assert.Contains(t, um.View().Content, "Capture finished")
+}
+
+func TestModelView_ShowsCaptureStats(t *testing.T) {
+	ch := make(chan *packet.PacketInfo)
+	m := NewModel(ch)
+	m.ShowStats(func() capture.Stats {
+		return capture.Stats{Received: 10, KernelDropped: 1, IfaceDropped: 2, QueueDropped: 3}
+	})
+
+	content := m.View().Content
+	assert.Contains(
+		t,
+		content,
+		"received: 10 | kernel drops: 1 | interface drops: 2 | queue drops: 3",
+	)
+}
+
+func TestModelUpdate_StatsTick(t *testing.T) {
+	ch := make(chan *packet.PacketInfo)
+	m := NewModel(ch)
+
+	_, cmd := m.Update(statsTick{})
+	assert.NotNil(t, cmd)
+}
+
+func TestModelView_Filter(t *testing.T) {
+	ch := make(chan *packet.PacketInfo, 1)
+	m := NewModel(ch)
+
+	f, err := filter.Compile("tcp.port == 443 && conn.state == SYN_SENT", filter.ScopeConnection)
+	require.NoError(t, err)
+	m.SetFilter(f)
+
+	m.Update(packetCapture{packetInfo: &packet.PacketInfo{
+		SrcIP:    "192.168.0.1",
+		SrcPort:  "8080",
+		DestIP:   "10.10.10.10",
+		DestPort: "443(https)",
+		Protocol: packet.TCP,
+		TCPFlags: packet.TCPFlags{SYN: true},
+	}})
+	m.Update(packetCapture{packetInfo: &packet.PacketInfo{
+		SrcIP:    "192.168.0.1",
+		SrcPort:  "5353",
+		DestIP:   "224.0.0.251",
+		DestPort: "5353",
+		Protocol: packet.UDP,
+	}})
+	assert.Len(t, m.tracker.connections, 2)
+
+	content := m.View().Content
+	assert.Contains(t, content, "10.10.10.10:443(https)")
+	assert.NotContains(t, content, "224.0.0.251")
+	assert.Contains(t, content, "filter: tcp.port == 443 && conn.state == SYN_SENT")
+}
+
+func TestModelView_TLSServerName(t *testing.T) {
+	ch := make(chan *packet.PacketInfo, 1)
+	m := NewModel(ch)
+
+	f, err := filter.Compile("tls.sni contains github", filter.ScopeConnection)
+	require.NoError(t, err)
+	m.SetFilter(f)
+
+	m.Update(packetCapture{packetInfo: &packet.PacketInfo{
+		SrcIP:     "192.168.0.1",
+		SrcPort:   "50000",
+		DestIP:    "140.82.112.6",
+		DestPort:  "443(https)",
+		Protocol:  packet.TCP,
+		Transport: packet.TCP,
+		TCPFlags:  packet.TCPFlags{SYN: true},
+	}})
+	assert.NotContains(t, m.View().Content, "140.82.112.6")
+
+	// The ClientHello decodes as TLS but belongs to the TCP connection
+	m.Update(packetCapture{packetInfo: &packet.PacketInfo{
+		SrcIP:     "192.168.0.1",
+		SrcPort:   "50000",
+		DestIP:    "140.82.112.6",
+		DestPort:  "443(https)",
+		Protocol:  packet.TLS,
+		Transport: packet.TCP,
+		TCPFlags:  packet.TCPFlags{ACK: true, PSH: true},
+		TLS:       &tls.Info{SNI: "api.github.com"},
+	}})
+	require.Len(t, m.tracker.connections, 1)
+
+	content := m.View().Content
+	assert.Contains(t, content, "192.168.0.1:50000-->api.github.com:443(https)/TCP")
+	assert.NotContains(t, content, "140.82.112.6")
+}
+
+func TestModelView_QUIC(t *testing.T) {
+	ch := make(chan *packet.PacketInfo, 1)
+	m := NewModel(ch)
+
+	m.Update(packetCapture{packetInfo: &packet.PacketInfo{
+		SrcIP:     "192.168.0.1",
+		SrcPort:   "50000",
+		DestIP:    "142.250.74.100",
+		DestPort:  "443(https)",
+		Protocol:  packet.QUIC,
+		Transport: packet.UDP,
+		TLS:       &tls.Info{SNI: "www.google.com"},
+		QUIC: &quic.Info{
+			Version:    "QUIC v1",
+			ClientIP:   "192.168.0.1",
+			ClientPort: "50000",
+			ServerIP:   "142.250.74.100",
+			ServerPort: "443(https)",
+		},
+	}})
+
+	content := m.View().Content
+	assert.Contains(t, content, "192.168.0.1:50000-->www.google.com:443(https)/QUIC")
+	assert.NotContains(t, content, "/UDP")
+}
+
+func TestModelView_Tunnels(t *testing.T) {
+	ch := make(chan *packet.PacketInfo, 1)
+	m := NewModel(ch)
+
+	m.Update(packetCapture{packetInfo: &packet.PacketInfo{
+		SrcIP:     "10.244.1.5",
+		SrcPort:   "51000",
+		DestIP:    "10.244.2.9",
+		DestPort:  "53(domain)",
+		Protocol:  packet.UDP,
+		Transport: packet.UDP,
+		Tunnels: []packet.Tunnel{
+			{Type: packet.GENEVE, SrcIP: "192.168.0.1", DstIP: "192.168.0.2", ID: 7},
+		},
+	}})
+	m.Update(packetCapture{packetInfo: &packet.PacketInfo{
+		SrcIP:     "203.0.113.5",
+		SrcPort:   "40000",
+		DestIP:    "198.51.100.9",
+		DestPort:  "51820",
+		Protocol:  packet.WireGuard,
+		Transport: packet.UDP,
+		Tunnels: []packet.Tunnel{{
+			Type:    packet.WireGuard,
+			SrcIP:   "203.0.113.5",
+			DstIP:   "198.51.100.9",
+			SrcPort: "40000",
+			DstPort: "51820",
+			ID:      77,
+		}},
+	}})
+
+	content := m.View().Content
+	assert.Contains(t, content, "10.244.1.5:51000-->10.244.2.9:53(domain)/UDP")
+	assert.Contains(t, content, "| via GENEVE 7 192.168.0.1 > 192.168.0.2")
+	assert.Contains(t, content, "203.0.113.5:40000-->198.51.100.9:51820/WireGuard")
+}
+
+func TestModelView_HTTP(t *testing.T) {
+	ch := make(chan *packet.PacketInfo, 1)
+	m := NewModel(ch)
+
+	pi := &packet.PacketInfo{
+		SrcIP:     "10.0.0.80",
+		SrcPort:   "80(http)",
+		DestIP:    "192.168.0.1",
+		DestPort:  "50000",
+		Protocol:  packet.HTTP,
+		Transport: packet.TCP,
+		TCPFlags:  packet.TCPFlags{ACK: true, PSH: true},
+	}
+	for i := range maxTransactions + 1 {
+		pi.HTTP = []http.Transaction{{
+			ClientIP:     "192.168.0.1",
+			ServerIP:     "10.0.0.80",
+			ServerPort:   "80(http)",
+			Method:       "GET",
+			Host:         "api.internal",
+			Path:         fmt.Sprintf("/items/%d", i),
+			Status:       404,
+			ResponseSize: 9,
+			Latency:      1500 * time.Microsecond,
+		}}
+		m.Update(packetCapture{packetInfo: pi})
+	}
+	require.Len(t, m.transactions, maxTransactions)
+	assert.Equal(t, "/items/1", m.transactions[0].Path)
+	assert.Contains(t, m.View().Content, "Active Connections")
+
+	m.Update(tea.KeyPressMsg{Code: tea.KeyTab})
+	content := m.View().Content
+	assert.Contains(t, content, "HTTP Transactions")
+	assert.NotContains(t, content, "Active Connections")
+	assert.NotContains(t, content, "/items/0 ")
+	assert.Regexp(
+		t,
+		`192\.168\.0\.1-->10\.0\.0\.80:80\(http\) +GET api\.internal/items/100 +:: 404 - +`+
+			`\| bytes: 9 \| latency: 1\.5ms`,
+		content,
+	)
+
+	m.Update(tea.KeyPressMsg{Code: tea.KeyTab})
+	assert.Contains(t, m.View().Content, "Active Connections")
+}
+
+func TestModelView_HTTPControlCharacters(t *testing.T) {
+	ch := make(chan *packet.PacketInfo, 1)
+	m := NewModel(ch)
+
+	// A form feed is a line break to the tabwriter
+	m.Update(packetCapture{packetInfo: &packet.PacketInfo{
+		SrcIP:     "10.0.0.80",
+		SrcPort:   "80(http)",
+		DestIP:    "192.168.0.1",
+		DestPort:  "50000",
+		Protocol:  packet.HTTP,
+		Transport: packet.TCP,
+		HTTP: []http.Transaction{{
+			ClientIP:    "192.168.0.1",
+			ServerIP:    "10.0.0.80",
+			ServerPort:  "80(http)",
+			Method:      "GET",
+			Host:        "api.internal",
+			Path:        "/a\f\f\fb\tc",
+			ContentType: "text/html\r\n",
+			Status:      200,
+		}},
+	}})
+
+	m.Update(tea.KeyPressMsg{Code: tea.KeyTab})
+	content := m.View().Content
+	assert.Contains(t, content, "GET api.internal/a...b.c")
+	assert.Contains(t, content, "200 text/html..")
+}
+
+func TestPrintable(t *testing.T) {
+	assert.Equal(t, "/index.html", printable("/index.html"))
+	assert.Equal(t, "a.b.c.", printable("a\fb\x00c\x7f"))
+	assert.Equal(t, "café", printable("café"))
+}
@@ -0,0 +1,47 @@
+package conntrack
+
+import (
+	"fmt"
+
+	"packeteer/internal/packet"
+)
+
+// ESPKeyStringFormat is the key of an IPsec security association carried in
+// plain ESP. There are no ports, the SPI tells apart the associations between
+// the same hosts, and each association only goes one way
+const ESPKeyStringFormat = "%s-->%s spi 0x%08x/%s"
+
+// espSPI returns the SPI of a packet that is ESP straight over IP. ESP behind
+// NAT is carried over UDP and tracked as its UDP flow
+func espSPI(p *packet.PacketInfo) (uint32, bool) {
+	if p.Protocol != packet.ESP || p.Transport != "" || len(p.Tunnels) == 0 {
+		return 0, false
+	}
+	t := p.Tunnels[len(p.Tunnels)-1]
+	return t.ID, t.Type == packet.ESP
+}
+
+// updateESP counts the packet towards its security association. The caller
+// holds the lock
+func (t *Tracker) updateESP(p *packet.PacketInfo, spi uint32) {
+	key := ConnKey(t.vlanPrefix(p.VLANs) +
+		fmt.Sprintf(ESPKeyStringFormat, p.SrcIP, p.DestIP, spi, packet.ESP))
+
+	c, ok := t.connections[key]
+	if !ok {
+		c = &Connection{
+			Key:       key,
+			SrcIP:     p.SrcIP,
+			DstIP:     p.DestIP,
+			Protocol:  packet.ESP,
+			TimeStart: p.Timestamp,
+			VLANs:     p.VLANs,
+			Tunnels:   p.Tunnels,
+		}
+		t.connections[key] = c
+	}
+	c.TimeLastSeen = p.Timestamp
+	c.BytesReceived += int64(p.CaptureLength)
+	c.TotalBytes += int64(p.CaptureLength)
+	c.seenOn(p.Interface)
+}
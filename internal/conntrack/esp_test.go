@@ -0,0 +1,82 @@
+package conntrack
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/packet"
+)
+
+// espPacket decodes an ESP packet straight over IPv4 with the given SPI
+func espPacket(t *testing.T, ts time.Time, src, dst net.IP, spi uint32) *packet.PacketInfo {
+	t.Helper()
+	esp := make([]byte, 64)
+	binary.BigEndian.PutUint32(esp, spi)
+
+	buf := gopacket.NewSerializeBuffer()
+	require.NoError(t, gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true},
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+			DstMAC:       net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x02},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		&layers.IPv4{
+			Version:  4,
+			IHL:      5,
+			TTL:      64,
+			Protocol: layers.IPProtocolESP,
+			SrcIP:    src,
+			DstIP:    dst,
+		},
+		gopacket.Payload(esp),
+	))
+
+	p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	p.Metadata().Timestamp = ts
+	p.Metadata().CaptureLength = len(buf.Bytes())
+	pi, _ := packet.ExtractPacketInfo(p)
+	require.NotNil(t, pi)
+	return pi
+}
+
+// ******************************
+// ESP
+// ******************************
+
+func TestUpdateTracker_ESP(t *testing.T) {
+	a, b := net.IP{192, 168, 0, 1}, net.IP{192, 168, 0, 2}
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := NewTracker()
+
+	tracker.UpdateTracker(espPacket(t, t0, a, b, 0x1001))
+	tracker.UpdateTracker(espPacket(t, t0.Add(time.Second), a, b, 0x1001))
+	// The way back is an association of its own
+	tracker.UpdateTracker(espPacket(t, t0.Add(time.Second), b, a, 0x2002))
+
+	require.Len(t, tracker.connections, 2)
+	conn := tracker.connections["192.168.0.1-->192.168.0.2 spi 0x00001001/ESP"]
+	require.NotNil(t, conn)
+	assert.Equal(t, packet.ESP, conn.Protocol)
+	assert.Equal(t, int64(2*(14+20+64)), conn.TotalBytes)
+	assert.Equal(t, t0.Add(time.Second), conn.TimeLastSeen)
+	assert.Equal(t, []any{int64(0x1001)}, conn.Field("tunnel.id"))
+	assert.NotNil(t, tracker.connections["192.168.0.2-->192.168.0.1 spi 0x00002002/ESP"])
+}
+
+func TestModelView_ESP(t *testing.T) {
+	ch := make(chan *packet.PacketInfo, 1)
+	m := NewModel(ch)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pi := espPacket(t, t0, net.IP{192, 168, 0, 1}, net.IP{192, 168, 0, 2}, 7)
+	m.Update(packetCapture{packetInfo: pi})
+
+	assert.Contains(t, m.View().Content, "192.168.0.1-->192.168.0.2 spi 0x00000007/ESP")
+}
@@ -0,0 +1,126 @@
+package conntrack
+
+import (
+	"fmt"
+	"time"
+
+	"packeteer/internal/packet"
+)
+
+// PingKeyStringFormat is the key of a ping flow, there are no ports but the
+// echo id tells apart pings between the same hosts
+const PingKeyStringFormat = "%s-->%s id %d/%s"
+
+// maxPendingPings bounds the echo requests of a flow waiting for a reply
+const maxPendingPings = 64
+
+// pingKey returns the key of the ping flow from src to dst
+func (t *Tracker) pingKey(
+	vlans []uint16,
+	src, dst string,
+	id uint16,
+	proto packet.PacketProtocol,
+) ConnKey {
+	return ConnKey(t.vlanPrefix(vlans) + fmt.Sprintf(PingKeyStringFormat, src, dst, id, proto))
+}
+
+// updateICMP tracks echo requests and replies as ping flows, and flags the
+// flow an ICMP error was sent about. The caller holds the lock
+func (t *Tracker) updateICMP(p *packet.PacketInfo) {
+	icmp := p.ICMP
+	con := t.connections
+
+	switch icmp.Kind {
+	case packet.ICMPEchoRequest:
+		key := t.pingKey(p.VLANs, p.SrcIP, p.DestIP, icmp.ID, p.Protocol)
+		c, ok := con[key]
+		if !ok {
+			c = &Connection{
+				Key:       key,
+				SrcIP:     p.SrcIP,
+				DstIP:     p.DestIP,
+				Protocol:  p.Protocol,
+				TimeStart: p.Timestamp,
+				VLANs:     p.VLANs,
+				Tunnels:   p.Tunnels,
+				pending:   map[uint16]time.Time{},
+			}
+			con[key] = c
+		}
+		c.TimeLastSeen = p.Timestamp
+		c.BytesReceived += int64(p.CaptureLength)
+		c.TotalBytes += int64(p.CaptureLength)
+		c.seenOn(p.Interface)
+		c.sentPing(icmp.Seq, p.Timestamp)
+
+	case packet.ICMPEchoReply:
+		c, ok := con[t.pingKey(p.VLANs, p.DestIP, p.SrcIP, icmp.ID, p.Protocol)]
+		if !ok {
+			return
+		}
+		c.TimeLastSeen = p.Timestamp
+		c.BytesSent += int64(p.CaptureLength)
+		c.TotalBytes += int64(p.CaptureLength)
+		c.seenOn(p.Interface)
+		if sent, ok := c.pending[icmp.Seq]; ok {
+			c.RTT = p.Timestamp.Sub(sent)
+			delete(c.pending, icmp.Seq)
+		}
+
+	case packet.ICMPError:
+		o := icmp.Original
+		if o == nil {
+			return
+		}
+
+		var keys []ConnKey
+		switch o.Protocol {
+		case packet.TCP, packet.UDP:
+			vlan := t.vlanPrefix(p.VLANs)
+			keys = []ConnKey{
+				ConnKey(vlan + fmt.Sprintf(
+					ConnKeyStringFormat, o.SrcIP, o.SrcPort, o.DestIP, o.DestPort, o.Protocol,
+				)),
+				ConnKey(vlan + fmt.Sprintf(
+					ConnKeyStringFormat, o.DestIP, o.DestPort, o.SrcIP, o.SrcPort, o.Protocol,
+				)),
+			}
+		case packet.ICMPv4, packet.ICMPv6:
+			keys = []ConnKey{t.pingKey(p.VLANs, o.SrcIP, o.DestIP, o.ID, o.Protocol)}
+		}
+
+		for _, k := range keys {
+			if c, ok := con[k]; ok {
+				c.ICMPError = icmpErrorFlag(icmp)
+			}
+		}
+	}
+}
+
+// sentPing remembers when an echo request was sent, to time its reply. Once
+// too many are waiting the oldest is given up on
+func (c *Connection) sentPing(seq uint16, ts time.Time) {
+	if c.pending == nil {
+		c.pending = map[uint16]time.Time{}
+	}
+	if len(c.pending) >= maxPendingPings {
+		var oldest uint16
+		var oldestSent time.Time
+		for s, sent := range c.pending {
+			if oldestSent.IsZero() || sent.Before(oldestSent) {
+				oldest, oldestSent = s, sent
+			}
+		}
+		delete(c.pending, oldest)
+	}
+	c.pending[seq] = ts
+}
+
+// icmpErrorFlag is how an ICMP error shows on the flow it is about, e.g.
+// port unreachable, or PMTU 1400 when it carries a path MTU
+func icmpErrorFlag(icmp *packet.ICMPInfo) string {
+	if icmp.MTU > 0 {
+		return fmt.Sprintf("PMTU %d", icmp.MTU)
+	}
+	return icmp.Message
+}
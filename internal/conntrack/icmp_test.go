@@ -0,0 +1,194 @@
+package conntrack
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/filter"
+	"packeteer/internal/packet"
+)
+
+func echo(ts time.Time, kind packet.ICMPKind, src, dst string, seq uint16) *packet.PacketInfo {
+	return &packet.PacketInfo{
+		Timestamp:     ts,
+		SrcIP:         src,
+		DestIP:        dst,
+		Protocol:      packet.ICMPv4,
+		CaptureLength: 98,
+		ICMP:          &packet.ICMPInfo{Kind: kind, ID: 7, Seq: seq},
+	}
+}
+
+func icmpError(msg string, mtu int, orig *packet.ICMPOriginal) *packet.PacketInfo {
+	return &packet.PacketInfo{
+		SrcIP:    "10.0.0.1",
+		DestIP:   orig.SrcIP,
+		Protocol: packet.ICMPv4,
+		ICMP: &packet.ICMPInfo{
+			Kind:     packet.ICMPError,
+			Message:  msg,
+			MTU:      mtu,
+			Original: orig,
+		},
+	}
+}
+
+// ******************************
+// Ping flows
+// ******************************
+
+func TestUpdateTracker_Ping(t *testing.T) {
+	tracker := NewTracker()
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tracker.UpdateTracker(echo(t0, packet.ICMPEchoRequest, "10.0.0.5", "1.1.1.1", 1))
+	tracker.UpdateTracker(echo(t0.Add(time.Second), packet.ICMPEchoRequest, "10.0.0.5", "1.1.1.1", 2))
+	tracker.UpdateTracker(
+		echo(t0.Add(time.Second+12*time.Millisecond), packet.ICMPEchoReply, "1.1.1.1", "10.0.0.5", 2),
+	)
+
+	key := ConnKey(fmt.Sprintf(PingKeyStringFormat, "10.0.0.5", "1.1.1.1", 7, packet.ICMPv4))
+	require.Len(t, tracker.connections, 1)
+	c := tracker.connections[key]
+	require.NotNil(t, c)
+	assert.Equal(t, 12*time.Millisecond, c.RTT)
+	assert.Equal(t, int64(196), c.BytesReceived)
+	assert.Equal(t, int64(98), c.BytesSent)
+	assert.Equal(t, int64(294), c.TotalBytes)
+	assert.Equal(t, t0, c.TimeStart)
+	assert.Len(t, c.pending, 1)
+
+	// A reply nothing asked for is dropped, and so is its repeat
+	tracker.UpdateTracker(echo(t0.Add(2*time.Second), packet.ICMPEchoReply, "1.1.1.1", "10.0.0.5", 2))
+	assert.Equal(t, 12*time.Millisecond, c.RTT)
+	tracker.UpdateTracker(echo(t0, packet.ICMPEchoReply, "8.8.8.8", "10.0.0.5", 1))
+	assert.Len(t, tracker.connections, 1)
+}
+
+func TestUpdateTracker_PingPendingIsBounded(t *testing.T) {
+	tracker := NewTracker()
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := range maxPendingPings + 10 {
+		ts := t0.Add(time.Duration(i) * time.Second)
+		tracker.UpdateTracker(echo(ts, packet.ICMPEchoRequest, "10.0.0.5", "1.1.1.1", uint16(i)))
+	}
+
+	key := ConnKey(fmt.Sprintf(PingKeyStringFormat, "10.0.0.5", "1.1.1.1", 7, packet.ICMPv4))
+	c := tracker.connections[key]
+	assert.Len(t, c.pending, maxPendingPings)
+	assert.NotContains(t, c.pending, uint16(0))
+	assert.Contains(t, c.pending, uint16(maxPendingPings+9))
+}
+
+// ******************************
+// Errors
+// ******************************
+
+func TestUpdateTracker_ICMPErrorFlagsFlow(t *testing.T) {
+	tracker := NewTracker()
+	tracker.UpdateTracker(&packet.PacketInfo{
+		SrcIP:    "10.0.0.5",
+		SrcPort:  "40001",
+		DestIP:   "10.0.0.1",
+		DestPort: "53(domain)",
+		Protocol: packet.UDP,
+	})
+	tracker.UpdateTracker(&packet.PacketInfo{
+		SrcIP:    "10.0.0.5",
+		SrcPort:  "51000",
+		DestIP:   "93.184.216.34",
+		DestPort: "443(https)",
+		Protocol: packet.TCP,
+		TCPFlags: packet.TCPFlags{SYN: true},
+	})
+
+	tracker.UpdateTracker(icmpError("port unreachable", 0, &packet.ICMPOriginal{
+		SrcIP:    "10.0.0.5",
+		SrcPort:  "40001",
+		DestIP:   "10.0.0.1",
+		DestPort: "53(domain)",
+		Protocol: packet.UDP,
+	}))
+	// The server's packets are too big on the way back
+	tracker.UpdateTracker(icmpError("fragmentation needed", 1400, &packet.ICMPOriginal{
+		SrcIP:    "93.184.216.34",
+		SrcPort:  "443(https)",
+		DestIP:   "10.0.0.5",
+		DestPort: "51000",
+		Protocol: packet.TCP,
+	}))
+	// Nothing to flag
+	tracker.UpdateTracker(icmpError("host unreachable", 0, &packet.ICMPOriginal{
+		SrcIP:    "10.0.0.5",
+		DestIP:   "10.9.9.9",
+		Protocol: packet.TCP,
+	}))
+
+	udp := tracker.connections["10.0.0.5:40001-->10.0.0.1:53(domain)/UDP"]
+	tcp := tracker.connections["10.0.0.5:51000-->93.184.216.34:443(https)/TCP"]
+	require.NotNil(t, udp)
+	require.NotNil(t, tcp)
+	assert.Equal(t, "port unreachable", udp.ICMPError)
+	assert.Equal(t, "PMTU 1400", tcp.ICMPError)
+	assert.Equal(t, StateSynSent, tcp.State)
+	assert.Len(t, tracker.connections, 2)
+}
+
+func TestUpdateTracker_ICMPErrorFlagsPing(t *testing.T) {
+	tracker := NewTracker()
+	tracker.UpdateTracker(echo(time.Time{}, packet.ICMPEchoRequest, "10.0.0.5", "8.8.8.8", 1))
+	tracker.UpdateTracker(icmpError("ttl exceeded", 0, &packet.ICMPOriginal{
+		SrcIP:    "10.0.0.5",
+		DestIP:   "8.8.8.8",
+		Protocol: packet.ICMPv4,
+		ID:       7,
+	}))
+
+	key := ConnKey(fmt.Sprintf(PingKeyStringFormat, "10.0.0.5", "8.8.8.8", 7, packet.ICMPv4))
+	c := tracker.connections[key]
+	assert.Equal(t, "ttl exceeded", c.ICMPError)
+	assert.Equal(t, []any{"ttl exceeded"}, c.Field("conn.icmp_error"))
+	assert.Nil(t, c.Field("conn.rtt"))
+	assert.Equal(t, []any{true}, c.Field("icmp"))
+}
+
+func TestModelView_ICMP(t *testing.T) {
+	m := NewModel(nil)
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	m.Update(packetCapture{packetInfo: echo(t0, packet.ICMPEchoRequest, "10.0.0.5", "1.1.1.1", 1)})
+	m.Update(packetCapture{packetInfo: echo(
+		t0.Add(20*time.Millisecond), packet.ICMPEchoReply, "1.1.1.1", "10.0.0.5", 1,
+	)})
+	m.Update(packetCapture{packetInfo: &packet.PacketInfo{
+		SrcIP:    "10.0.0.5",
+		SrcPort:  "40001",
+		DestIP:   "10.0.0.1",
+		DestPort: "53(domain)",
+		Protocol: packet.UDP,
+	}})
+	m.Update(packetCapture{packetInfo: icmpError("port unreachable", 0, &packet.ICMPOriginal{
+		SrcIP:    "10.0.0.5",
+		SrcPort:  "40001",
+		DestIP:   "10.0.0.1",
+		DestPort: "53(domain)",
+		Protocol: packet.UDP,
+	})})
+
+	content := m.View().Content
+	assert.Contains(t, content, "10.0.0.5-->1.1.1.1 id 7/ICMPv4")
+	assert.Contains(t, content, "rtt: 20ms")
+	assert.Contains(t, content, "| port unreachable")
+
+	f, err := filter.Compile("conn.icmp_error == \"port unreachable\"", filter.ScopeConnection)
+	require.NoError(t, err)
+	m.SetFilter(f)
+	content = m.View().Content
+	assert.NotContains(t, content, "1.1.1.1")
+	assert.Contains(t, content, "10.0.0.1:53(domain)")
+}
@@ -0,0 +1,102 @@
+package conntrack
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/capture"
+	"packeteer/internal/packet"
+)
+
+// tcpSegment builds the layers of a TCP segment between the two fixed hosts
+func tcpSegment(fromClient bool, tcp *layers.TCP) []gopacket.SerializableLayer {
+	client, server := net.IP{192, 168, 0, 1}, net.IP{10, 10, 10, 10}
+	tcp.SrcPort, tcp.DstPort = 54321, 40443
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		SrcIP:    client,
+		DstIP:    server,
+		Protocol: layers.IPProtocolTCP,
+	}
+	if !fromClient {
+		ip.SrcIP, ip.DstIP = server, client
+		tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	return []gopacket.SerializableLayer{
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+			DstMAC:       net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x02},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		ip,
+		tcp,
+	}
+}
+
+// runPipeline feeds every packet of the source through ExtractPacketInfo
+// into the model, the same way `sniff --connections` does
+func runPipeline(t *testing.T, src capture.Source, m *model) {
+	t.Helper()
+
+	packetChan := make(chan *packet.PacketInfo)
+	go func() {
+		defer close(packetChan)
+		for p := range src.Packets() {
+			pi, _ := packet.ExtractPacketInfo(p)
+			if pi != nil && (pi.Transport == packet.TCP || pi.Transport == packet.UDP ||
+				pi.ICMP != nil || pi.Protocol == packet.ESP) {
+				packetChan <- pi
+			}
+		}
+	}()
+
+	m.packetChan = packetChan
+	for {
+		msg := waitForPacket(m.packetChan)()
+		if _, ok := msg.(captureDone); ok {
+			m.Update(msg)
+			return
+		}
+		m.Update(msg)
+	}
+}
+
+func TestPipeline_TCPHandshake(t *testing.T) {
+	src := capture.NewMemorySource(layers.LinkTypeEthernet)
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, src.AddLayers(t0, tcpSegment(true, &layers.TCP{SYN: true})...))
+	require.NoError(t, src.AddLayers(
+		t0.Add(time.Millisecond),
+		tcpSegment(false, &layers.TCP{SYN: true, ACK: true})...,
+	))
+	require.NoError(t, src.AddLayers(
+		t0.Add(2*time.Millisecond),
+		tcpSegment(true, &layers.TCP{ACK: true})...,
+	))
+
+	m := NewModel(nil)
+	m.UsePacketClock()
+	runPipeline(t, src, m)
+
+	key := ConnKey(fmt.Sprintf(ConnKeyStringFormat, "192.168.0.1", "54321", "10.10.10.10", "40443", packet.TCP))
+	require.Contains(t, m.tracker.connections, key)
+	conn := m.tracker.connections[key]
+	assert.Equal(t, StateEstablished, conn.State)
+	assert.Equal(t, t0, conn.TimeStart)
+	assert.Equal(t, t0.Add(2*time.Millisecond), conn.TimeLastSeen)
+	assert.True(t, m.done)
+
+	content := m.View().Content
+	assert.Contains(t, content, string(key))
+	assert.Contains(t, content, "ESTABLISHED")
+}
@@ -0,0 +1,526 @@
+// Package defrag puts fragmented IPv4 and IPv6 datagrams back together before
+// they are decoded any further, so that the fragments of a large UDP
+// datagram read as one packet with its ports. Exact duplicates of a fragment
+// are ignored, but any other overlap, a known way to slip past inspection,
+// drops its datagram even when the overlapping bytes agree
+package defrag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// Config limits the fragments a Defragmenter holds on to
+type Config struct {
+	// Timeout is how long after its first fragment a datagram may take to
+	// complete, measured in packet time
+	Timeout time.Duration
+	// MaxBuffered caps the bytes held across incomplete datagrams, past it
+	// the oldest ones are given up on
+	MaxBuffered int
+}
+
+// DefaultConfig returns the limits used unless configured otherwise, the
+// timeout is Linux's
+func DefaultConfig() Config {
+	return Config{
+		Timeout:     30 * time.Second,
+		MaxBuffered: 16 << 20,
+	}
+}
+
+const (
+	// maxFragments is the most fragments one datagram may have
+	maxFragments = 256
+	// maxPayload is the most payload a datagram can carry, as the IP length
+	// fields are 16 bits
+	maxPayload = 0xffff
+	// expireEvery is how often, in packet time, timed out datagrams are
+	// looked for
+	expireEvery = time.Second
+)
+
+// Stats counts the fragments a Defragmenter saw. Fragmentation in a capture
+// usually means a path MTU smaller than the senders think
+type Stats struct {
+	Fragments       int // fragments seen
+	Reassembled     int // datagrams put back together
+	IPv4            int // of those, IPv4 ones
+	IPv6            int // and IPv6 ones
+	LargestFragment int // bytes of IP of the largest fragment, a hint of the path MTU
+	LargestDatagram int // bytes of IP of the largest datagram put back together
+	TimedOut        int // datagrams still missing fragments after the timeout
+	Overlapping     int // datagrams dropped as their fragments overlapped with different bytes
+	Invalid         int // fragments dropped as malformed, too large or cut short by the snap length
+	Evicted         int // incomplete datagrams given up on to stay under the buffer limit
+	Pending         int // incomplete datagrams held at the time
+}
+
+// key identifies the fragments of one datagram
+type key struct {
+	iface    int
+	src, dst netip.Addr
+	proto    uint8 // IPv4 only, IPv6 leaves it to the identification
+	id       uint32
+}
+
+// fragment is the payload of a fragment, at its offset in the datagram's
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+func (f fragment) end() int {
+	return f.offset + len(f.data)
+}
+
+// outer is a header enclosing the fragmented IP packet, such as the outer IP
+// and UDP headers of a tunnel, whose length field must grow along with it
+type outer struct {
+	offset int
+	typ    gopacket.LayerType
+}
+
+// datagram is a datagram being put back together
+type datagram struct {
+	first   time.Time
+	frags   []fragment // sorted by offset, not overlapping
+	total   int        // payload length, -1 until the last fragment is seen
+	held    int        // bytes held, fragments and headers
+	dropped bool       // further fragments are dropped until the timeout
+
+	// Taken from the fragment at offset 0, the headers that start the
+	// datagram once put back together
+	header  []byte // everything up to the fragment's payload
+	ipOff   int    // where the IP header starts in header
+	v6      bool
+	nextPos int   // IPv6: where in header the next header field to fix is
+	next    uint8 // IPv6: the protocol after the fragment header
+	outers  []outer
+	decoder gopacket.Decoder
+}
+
+// Defragmenter holds IP fragments until their datagram is whole
+type Defragmenter struct {
+	mu         sync.Mutex
+	cfg        Config
+	datagrams  map[key]*datagram
+	held       int // bytes held across datagrams
+	stats      Stats
+	lastExpire time.Time
+}
+
+// NewDefragmenter returns a Defragmenter holding no fragments
+func NewDefragmenter(cfg Config) *Defragmenter {
+	return &Defragmenter{cfg: cfg, datagrams: map[key]*datagram{}}
+}
+
+// Stats returns the counters so far
+func (d *Defragmenter) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := d.stats
+	for _, g := range d.datagrams {
+		if !g.dropped {
+			stats.Pending++
+		}
+	}
+	return stats
+}
+
+// Defrag returns p itself when it is not a fragment. A fragment is held and
+// nil returned until it completes its datagram, which is then returned as a
+// packet of its own with the capture info of that last fragment. Dropped
+// fragments return nil too
+func (d *Defragmenter) Defrag(p gopacket.Packet) gopacket.Packet {
+	if p.Layer(gopacket.LayerTypeFragment) == nil {
+		return p
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ci := p.Metadata().CaptureInfo
+	d.expire(ci.Timestamp)
+	d.stats.Fragments++
+
+	f, ok := parse(p)
+	if !ok {
+		d.stats.Invalid++
+		return nil
+	}
+	d.stats.LargestFragment = max(d.stats.LargestFragment, f.ipLen)
+	f.key.iface = ci.InterfaceIndex
+
+	g := d.datagrams[f.key]
+	if g == nil {
+		g = &datagram{first: ci.Timestamp, total: -1}
+		d.datagrams[f.key] = g
+	}
+	if g.dropped {
+		return nil
+	}
+
+	if f.offset+len(f.payload) > maxPayload || len(g.frags) == maxFragments {
+		d.stats.Invalid++
+		d.drop(g)
+		return nil
+	}
+	stored, ok := g.add(f)
+	if !ok {
+		d.stats.Overlapping++
+		d.drop(g)
+		return nil
+	}
+	if !stored {
+		return nil
+	}
+
+	added := len(f.payload)
+	if f.offset == 0 {
+		g.setHeader(p, f)
+		added += len(g.header)
+	}
+	g.held += added
+	d.held += added
+	if !d.makeRoom(f.key) {
+		d.stats.Evicted++
+		d.drop(g)
+		return nil
+	}
+
+	if !g.complete() {
+		return nil
+	}
+
+	data, ok := g.build()
+	d.forget(f.key, g)
+	if !ok {
+		d.stats.Invalid++
+		return nil
+	}
+	d.stats.Reassembled++
+	if g.v6 {
+		d.stats.IPv6++
+	} else {
+		d.stats.IPv4++
+	}
+	d.stats.LargestDatagram = max(d.stats.LargestDatagram, len(data)-g.ipOff)
+
+	whole := gopacket.NewPacket(data, g.decoder, gopacket.Default)
+	md := whole.Metadata()
+	md.CaptureInfo = ci
+	md.CaptureLength, md.Length = len(data), len(data)
+	return whole
+}
+
+// expire forgets the datagrams that took longer than the timeout before now
+func (d *Defragmenter) expire(now time.Time) {
+	if now.Sub(d.lastExpire) < expireEvery {
+		return
+	}
+	d.lastExpire = now
+
+	for k, g := range d.datagrams {
+		if now.Sub(g.first) > d.cfg.Timeout {
+			if !g.dropped {
+				d.stats.TimedOut++
+			}
+			d.forget(k, g)
+		}
+	}
+}
+
+// makeRoom gives up on the oldest datagrams other than k's until the bytes
+// held fit the buffer, and reports whether they do
+func (d *Defragmenter) makeRoom(k key) bool {
+	for d.held > d.cfg.MaxBuffered {
+		var oldest *datagram
+		for gk, g := range d.datagrams {
+			if gk != k && g.held > 0 && (oldest == nil || g.first.Before(oldest.first)) {
+				oldest = g
+			}
+		}
+		if oldest == nil {
+			return false
+		}
+		d.stats.Evicted++
+		d.drop(oldest)
+	}
+	return true
+}
+
+// drop frees a datagram's fragments but remembers it until the timeout, so
+// that its remaining fragments are dropped too
+func (d *Defragmenter) drop(g *datagram) {
+	d.held -= g.held
+	*g = datagram{first: g.first, dropped: true}
+}
+
+// forget frees a datagram for good
+func (d *Defragmenter) forget(k key, g *datagram) {
+	d.held -= g.held
+	delete(d.datagrams, k)
+}
+
+// add inserts a fragment, and reports whether it was stored and false when
+// it conflicts with the ones already held. A fragment seen twice is only
+// stored once
+func (g *datagram) add(f parsed) (stored, ok bool) {
+	frag := fragment{offset: f.offset, data: f.payload}
+	end := frag.end()
+	if !f.more {
+		if g.total >= 0 && g.total != end {
+			return false, false
+		}
+		g.total = end
+	}
+	if g.total >= 0 && end > g.total {
+		return false, false
+	}
+
+	for _, held := range g.frags {
+		if frag.offset < held.end() && held.offset < end {
+			if held.offset == frag.offset && bytes.Equal(held.data, frag.data) {
+				// A retransmission, harmless
+				return false, true
+			}
+			return false, false
+		}
+	}
+
+	// Copied, as the packet's buffer may be reused
+	frag.data = slices.Clone(frag.data)
+	i, _ := slices.BinarySearchFunc(g.frags, frag.offset, func(f fragment, off int) int {
+		return f.offset - off
+	})
+	g.frags = slices.Insert(g.frags, i, frag)
+	return true, true
+}
+
+// complete reports whether the fragments cover the whole datagram
+func (g *datagram) complete() bool {
+	if g.total < 0 || g.header == nil {
+		return false
+	}
+	next := 0
+	for _, f := range g.frags {
+		if f.offset != next {
+			return false
+		}
+		next = f.end()
+	}
+	return next == g.total
+}
+
+// setHeader keeps the headers of the fragment at offset 0 to start the
+// datagram with
+func (g *datagram) setHeader(p gopacket.Packet, f parsed) {
+	g.header = slices.Clone(p.Data()[:f.ipOff+f.headerLen])
+	g.ipOff = f.ipOff
+	g.v6, g.nextPos, g.next = f.v6, f.ipOff+f.nextPos, f.next
+	g.decoder = p.Layers()[0].LayerType()
+
+	for _, l := range p.Layers() {
+		off, ok := offset(p.Data(), l.LayerContents())
+		if !ok || off >= f.ipOff {
+			break
+		}
+		switch l.LayerType() {
+		case layers.LayerTypeIPv4, layers.LayerTypeIPv6, layers.LayerTypeUDP:
+			g.outers = append(g.outers, outer{offset: off, typ: l.LayerType()})
+		}
+	}
+}
+
+// build returns the bytes of the whole datagram, its headers fixed up to
+// match. It fails when an IPv4 datagram ends up too long
+func (g *datagram) build() ([]byte, bool) {
+	data := make([]byte, 0, len(g.header)+g.total)
+	data = append(data, g.header...)
+	for _, f := range g.frags {
+		data = append(data, f.data...)
+	}
+
+	ip := data[g.ipOff:]
+	if g.v6 && len(ip)-40 > maxPayload || !g.v6 && len(ip) > maxPayload {
+		return nil, false
+	}
+	if g.v6 {
+		// The fragment header is left out, the header before it points
+		// past it
+		data[g.nextPos] = g.next
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(ip)-40))
+	} else {
+		binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)))
+		ip[6] &= 0x40 // only don't fragment is left
+		ip[7] = 0
+		checksumIPv4(ip)
+	}
+
+	// Headers around the IP packet now end along with it
+	for _, o := range g.outers {
+		h := data[o.offset:]
+		switch o.typ {
+		case layers.LayerTypeIPv4:
+			binary.BigEndian.PutUint16(h[2:], uint16(len(h)))
+			checksumIPv4(h)
+		case layers.LayerTypeIPv6:
+			binary.BigEndian.PutUint16(h[4:], uint16(len(h)-40))
+		case layers.LayerTypeUDP:
+			binary.BigEndian.PutUint16(h[4:], uint16(len(h)))
+			h[6], h[7] = 0, 0 // no longer right, and optional
+		}
+	}
+	return data, true
+}
+
+// checksumIPv4 computes the header checksum of an IPv4 header
+func checksumIPv4(h []byte) {
+	hlen := int(h[0]&0x0f) * 4
+	h[10], h[11] = 0, 0
+
+	var sum uint32
+	for i := 0; i+1 < hlen; i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(h[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	binary.BigEndian.PutUint16(h[10:], ^uint16(sum))
+}
+
+// parsed is a fragment read from a packet
+type parsed struct {
+	key       key
+	offset    int // of the payload in the datagram's
+	more      bool
+	payload   []byte
+	ipOff     int // where the IP header starts in the packet
+	ipLen     int // length of the IP packet
+	headerLen int // of the IP header, up to the payload
+	v6        bool
+	nextPos   int   // IPv6: where in the header the next header field pointing to the fragment header is
+	next      uint8 // IPv6: the protocol after the fragment header
+}
+
+// parse reads the fragment the IP layer of p carries. The layer is read from
+// the packet's bytes, as gopacket leaves some of what is needed out
+func parse(p gopacket.Packet) (parsed, bool) {
+	var ip gopacket.Layer
+	for _, l := range p.Layers() {
+		switch l.LayerType() {
+		case layers.LayerTypeIPv4, layers.LayerTypeIPv6:
+			ip = l
+		}
+	}
+	if ip == nil {
+		return parsed{}, false
+	}
+	ipOff, ok := offset(p.Data(), ip.LayerContents())
+	if !ok {
+		return parsed{}, false
+	}
+
+	b := p.Data()[ipOff:]
+	if ip.LayerType() == layers.LayerTypeIPv4 {
+		return parseIPv4(b, ipOff)
+	}
+	return parseIPv6(b, ipOff)
+}
+
+func parseIPv4(b []byte, ipOff int) (parsed, bool) {
+	if len(b) < 20 {
+		return parsed{}, false
+	}
+	hlen, total := int(b[0]&0x0f)*4, int(binary.BigEndian.Uint16(b[2:]))
+	if hlen < 20 || total < hlen || len(b) < total {
+		return parsed{}, false
+	}
+
+	flags := binary.BigEndian.Uint16(b[6:])
+	f := parsed{
+		offset:    int(flags&0x1fff) * 8,
+		more:      flags&0x2000 != 0,
+		payload:   b[hlen:total],
+		ipOff:     ipOff,
+		ipLen:     total,
+		headerLen: hlen,
+	}
+	f.key.src, _ = netip.AddrFromSlice(b[12:16])
+	f.key.dst, _ = netip.AddrFromSlice(b[16:20])
+	f.key.proto = b[9]
+	f.key.id = uint32(binary.BigEndian.Uint16(b[4:]))
+	return f, f.valid()
+}
+
+func parseIPv6(b []byte, ipOff int) (parsed, bool) {
+	if len(b) < 40 {
+		return parsed{}, false
+	}
+	// Jumbograms are never fragmented
+	end := 40 + int(binary.BigEndian.Uint16(b[4:]))
+	if end == 40 || len(b) < end {
+		return parsed{}, false
+	}
+
+	// Walk the extension headers up to the fragment header
+	nextPos, pos := 6, 40
+	for b[nextPos] != uint8(layers.IPProtocolIPv6Fragment) {
+		switch layers.IPProtocol(b[nextPos]) {
+		case layers.IPProtocolIPv6HopByHop,
+			layers.IPProtocolIPv6Routing,
+			layers.IPProtocolIPv6Destination:
+		default:
+			return parsed{}, false
+		}
+		if pos+2 > end {
+			return parsed{}, false
+		}
+		nextPos, pos = pos, pos+(int(b[pos+1])+1)*8
+	}
+	if pos+8 > end {
+		return parsed{}, false
+	}
+
+	frag := b[pos:]
+	off := binary.BigEndian.Uint16(frag[2:])
+	f := parsed{
+		offset:    int(off &^ 7),
+		more:      off&1 != 0,
+		payload:   b[pos+8 : end],
+		ipOff:     ipOff,
+		ipLen:     end,
+		headerLen: pos,
+		v6:        true,
+		nextPos:   nextPos,
+		next:      frag[0],
+	}
+	f.key.src, _ = netip.AddrFromSlice(b[8:24])
+	f.key.dst, _ = netip.AddrFromSlice(b[24:40])
+	f.key.id = binary.BigEndian.Uint32(frag[4:])
+	return f, f.valid()
+}
+
+// valid reports whether a fragment can be part of a datagram, every one but
+// the last carries a multiple of 8 bytes
+func (f parsed) valid() bool {
+	return len(f.payload) > 0 && (!f.more || len(f.payload)%8 == 0)
+}
+
+// offset returns where b starts in data, which it is a slice of
+func offset(data, b []byte) (int, bool) {
+	off := cap(data) - cap(b)
+	if len(b) == 0 || off < 0 || off+len(b) > len(data) || &data[off] != &b[0] {
+		return 0, false
+	}
+	return off, true
+}
@@ -0,0 +1,276 @@
+package defrag
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// udpDatagram returns the bytes of a UDP header and payload, as fragments
+// carry them
+func udpDatagram(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	udp := &layers.UDP{SrcPort: 40000, DstPort: 40001}
+	buf := gopacket.NewSerializeBuffer()
+	require.NoError(t, gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true},
+		udp, gopacket.Payload(payload)))
+	return buf.Bytes()
+}
+
+// packetOf serializes the layers into a captured packet
+func packetOf(t *testing.T, ts time.Time, ls ...gopacket.SerializableLayer) gopacket.Packet {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, ls...))
+
+	p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	p.Metadata().Timestamp = ts
+	p.Metadata().CaptureLength = len(buf.Bytes())
+	p.Metadata().Length = len(buf.Bytes())
+	return p
+}
+
+func ethernet(typ layers.EthernetType) *layers.Ethernet {
+	return &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: typ,
+	}
+}
+
+// fragment4 returns the IPv4 fragment of data at offset, in bytes
+func fragment4(t *testing.T, ts time.Time, id uint16, data []byte, offset int, more bool) gopacket.Packet {
+	t.Helper()
+	ip := &layers.IPv4{
+		Version:    4,
+		TTL:        64,
+		Id:         id,
+		Protocol:   layers.IPProtocolUDP,
+		SrcIP:      net.IP{10, 0, 0, 53},
+		DstIP:      net.IP{10, 0, 0, 5},
+		FragOffset: uint16(offset / 8),
+	}
+	if more {
+		ip.Flags = layers.IPv4MoreFragments
+	}
+	return packetOf(t, ts, ethernet(layers.EthernetTypeIPv4), ip, gopacket.Payload(data))
+}
+
+// fragment6 returns the IPv6 fragment of data at offset, in bytes
+func fragment6(t *testing.T, ts time.Time, id uint32, data []byte, offset int, more bool) gopacket.Packet {
+	t.Helper()
+	ip := &layers.IPv6{
+		Version:    6,
+		HopLimit:   64,
+		NextHeader: layers.IPProtocolIPv6Fragment,
+		SrcIP:      net.ParseIP("2001:db8::53"),
+		DstIP:      net.ParseIP("2001:db8::5"),
+	}
+	frag := &layers.IPv6Fragment{
+		NextHeader:     layers.IPProtocolUDP,
+		FragmentOffset: uint16(offset / 8),
+		MoreFragments:  more,
+		Identification: id,
+	}
+	return packetOf(t, ts, ethernet(layers.EthernetTypeIPv6), ip, frag, gopacket.Payload(data))
+}
+
+// requireUDP checks that p decodes to the UDP datagram with payload, the
+// innermost one when tunneled
+func requireUDP(t *testing.T, p gopacket.Packet, payload []byte) {
+	t.Helper()
+	require.NotNil(t, p)
+	require.Nil(t, p.ErrorLayer())
+	var udp *layers.UDP
+	for _, l := range p.Layers() {
+		if l, ok := l.(*layers.UDP); ok {
+			udp = l
+		}
+	}
+	require.NotNil(t, udp, "no UDP layer")
+	assert.Equal(t, layers.UDPPort(40000), udp.SrcPort)
+	assert.Equal(t, layers.UDPPort(40001), udp.DstPort)
+	assert.Equal(t, payload, udp.Payload)
+}
+
+// ******************************
+// Reassembly
+// ******************************
+
+func TestDefrag_NotFragment(t *testing.T) {
+	d := NewDefragmenter(DefaultConfig())
+	p := fragment4(t, t0, 1, udpDatagram(t, []byte("whole")), 0, false)
+	assert.Same(t, p, d.Defrag(p))
+	assert.Zero(t, d.Stats().Fragments)
+}
+
+func TestDefrag_IPv4(t *testing.T) {
+	d := NewDefragmenter(DefaultConfig())
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 5)
+	data := udpDatagram(t, payload)
+
+	// Out of order, with the first fragment seen twice
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 7, data[32:], 32, false)))
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 7, data[:16], 0, true)))
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 7, data[:16], 0, true)))
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 8, data[:16], 0, true)), "another datagram")
+	whole := d.Defrag(fragment4(t, t0.Add(time.Millisecond), 7, data[16:32], 16, true))
+
+	requireUDP(t, whole, payload)
+	ip := whole.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	assert.Equal(t, uint16(20+len(data)), ip.Length)
+	assert.Zero(t, ip.Flags&layers.IPv4MoreFragments)
+	assert.Equal(t, t0.Add(time.Millisecond), whole.Metadata().Timestamp)
+	assert.Equal(t, 14+20+len(data), whole.Metadata().Length)
+
+	// The checksum holds
+	fixed := *ip
+	buf := gopacket.NewSerializeBuffer()
+	require.NoError(t, fixed.SerializeTo(buf, gopacket.SerializeOptions{ComputeChecksums: true}))
+	assert.Equal(t, fixed.Checksum, ip.Checksum)
+
+	assert.Equal(t, Stats{
+		Fragments:       5,
+		Reassembled:     1,
+		IPv4:            1,
+		LargestFragment: 20 + len(data) - 32,
+		LargestDatagram: 20 + len(data),
+		Pending:         1,
+	}, d.Stats())
+}
+
+func TestDefrag_IPv6(t *testing.T) {
+	d := NewDefragmenter(DefaultConfig())
+	payload := bytes.Repeat([]byte("x"), 100)
+	data := udpDatagram(t, payload)
+
+	assert.Nil(t, d.Defrag(fragment6(t, t0, 0xdeadbeef, data[:56], 0, true)))
+	whole := d.Defrag(fragment6(t, t0, 0xdeadbeef, data[56:], 56, false))
+
+	requireUDP(t, whole, payload)
+	assert.Nil(t, whole.Layer(layers.LayerTypeIPv6Fragment))
+	ip := whole.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	assert.Equal(t, layers.IPProtocolUDP, ip.NextHeader)
+	assert.Equal(t, uint16(len(data)), ip.Length)
+	assert.Equal(t, 1, d.Stats().IPv6)
+}
+
+func TestDefrag_Tunneled(t *testing.T) {
+	d := NewDefragmenter(DefaultConfig())
+	payload := bytes.Repeat([]byte("y"), 40)
+	data := udpDatagram(t, payload)
+
+	// The inner fragments travel over VXLAN, the outer headers have to grow
+	// along with the inner datagram
+	vxlan := func(inner gopacket.Packet) gopacket.Packet {
+		ip := &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolUDP,
+			SrcIP:    net.IP{192, 168, 0, 1},
+			DstIP:    net.IP{192, 168, 0, 2},
+		}
+		udp := &layers.UDP{SrcPort: 50000, DstPort: 4789}
+		require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+		return packetOf(t, t0,
+			ethernet(layers.EthernetTypeIPv4), ip, udp,
+			&layers.VXLAN{ValidIDFlag: true, VNI: 42},
+			gopacket.Payload(inner.Data()),
+		)
+	}
+
+	assert.Nil(t, d.Defrag(vxlan(fragment4(t, t0, 9, data[:24], 0, true))))
+	whole := d.Defrag(vxlan(fragment4(t, t0, 9, data[24:], 24, false)))
+
+	requireUDP(t, whole, payload)
+	outer := whole.Layers()[1].(*layers.IPv4)
+	assert.Equal(t, net.IP{192, 168, 0, 1}, outer.SrcIP)
+	assert.Equal(t, uint16(len(whole.Data())-14), outer.Length)
+	assert.Equal(t, uint32(42), whole.Layer(layers.LayerTypeVXLAN).(*layers.VXLAN).VNI)
+}
+
+// ******************************
+// Limits
+// ******************************
+
+func TestDefrag_Overlap(t *testing.T) {
+	d := NewDefragmenter(DefaultConfig())
+	data := udpDatagram(t, bytes.Repeat([]byte("z"), 40))
+	evil := bytes.Clone(data[8:24])
+	evil[0] ^= 0xff
+
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 3, data[:16], 0, true)))
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 3, evil, 8, true)))
+	// The datagram stays dropped, even once its fragments are all there
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 3, data[16:], 16, false)))
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 3, data[:16], 0, true)))
+
+	stats := d.Stats()
+	assert.Equal(t, 1, stats.Overlapping)
+	assert.Zero(t, stats.Reassembled)
+	assert.Zero(t, stats.Pending)
+	assert.Zero(t, d.held)
+}
+
+func TestDefrag_OverlapSameBytes(t *testing.T) {
+	d := NewDefragmenter(DefaultConfig())
+	data := udpDatagram(t, bytes.Repeat([]byte("z"), 40))
+
+	// Only an exact duplicate is harmless
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 3, data[:16], 0, true)))
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 3, data[8:24], 8, true)))
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 3, data[16:], 16, false)))
+
+	assert.Equal(t, 1, d.Stats().Overlapping)
+	assert.Zero(t, d.Stats().Reassembled)
+}
+
+func TestDefrag_Invalid(t *testing.T) {
+	d := NewDefragmenter(DefaultConfig())
+	data := udpDatagram(t, bytes.Repeat([]byte("z"), 40))
+
+	// Fragments other than the last carry multiples of 8 bytes
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 4, data[:15], 0, true)))
+	// Past the largest datagram there can be
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 5, data, 0xfff8, false)))
+	assert.Equal(t, 2, d.Stats().Invalid)
+}
+
+func TestDefrag_Timeout(t *testing.T) {
+	d := NewDefragmenter(Config{Timeout: 5 * time.Second, MaxBuffered: 1 << 20})
+	data := udpDatagram(t, bytes.Repeat([]byte("z"), 40))
+
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 6, data[:24], 0, true)))
+	// Too late, the rest starts over and never completes
+	assert.Nil(t, d.Defrag(fragment4(t, t0.Add(6*time.Second), 6, data[24:], 24, false)))
+
+	stats := d.Stats()
+	assert.Equal(t, 1, stats.TimedOut)
+	assert.Equal(t, 1, stats.Pending)
+}
+
+func TestDefrag_MaxBuffered(t *testing.T) {
+	data := udpDatagram(t, bytes.Repeat([]byte("z"), 100))
+	d := NewDefragmenter(Config{Timeout: time.Minute, MaxBuffered: 3*(14+20+56) - 1})
+
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 1, data[:56], 0, true)))
+	assert.Nil(t, d.Defrag(fragment4(t, t0.Add(time.Millisecond), 2, data[:56], 0, true)))
+	// The oldest datagram makes room for the new one
+	assert.Nil(t, d.Defrag(fragment4(t, t0.Add(2*time.Millisecond), 3, data[:56], 0, true)))
+	assert.Nil(t, d.Defrag(fragment4(t, t0, 1, data[56:], 56, false)))
+	requireUDP(t, d.Defrag(fragment4(t, t0, 3, data[56:], 56, false)), data[8:])
+
+	stats := d.Stats()
+	assert.Equal(t, 1, stats.Evicted)
+	assert.Equal(t, 1, stats.Reassembled)
+	assert.Equal(t, 1, stats.Pending)
+}
@@ -12,14 +12,15 @@ import (
 
 // DNSInfo contains structured info from a DNS packet
 type DNSInfo struct {
-	Time        string
-	SrcIP       string
-	QueryName   string
-	QueryType   string
-	CNAMEPath   string
-	ResponseIPs []string
-	RequestType RequestType
-	TxnId       uint16
+	Time        string      `json:"time"`
+	SrcIP       string      `json:"src_ip"`
+	QueryName   string      `json:"query_name"`
+	QueryType   string      `json:"query_type"`
+	CNAMEPath   string      `json:"cname_path,omitempty"`
+	ResponseIPs []string    `json:"response_ips,omitempty"`
+	RequestType RequestType `json:"request_type"`
+	TxnId       uint16      `json:"txn_id"`
+	Interface   string      `json:"interface,omitempty"`
 }
 
 type RequestType string
@@ -96,5 +97,6 @@ func InsertDNSInfo(dnsInfo *DNSInfo, sqldb *sql.DB) error {
 		responseIPs,
 		string(dnsInfo.RequestType),
 		dnsInfo.TxnId,
+		dnsInfo.Interface,
 	)
 }
@@ -0,0 +1,81 @@
+package dns_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/capture"
+	"packeteer/internal/dns"
+	"packeteer/internal/packet"
+	"packeteer/internal/storage"
+)
+
+func dnsResponse() []gopacket.SerializableLayer {
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		SrcIP:    net.IP{8, 8, 8, 8},
+		DstIP:    net.IP{192, 168, 0, 1},
+		Protocol: layers.IPProtocolUDP,
+	}
+	udp := &layers.UDP{SrcPort: 53, DstPort: 40000}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	return []gopacket.SerializableLayer{
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+			DstMAC:       net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x02},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		ip,
+		udp,
+		&layers.DNS{
+			ID: 42,
+			QR: true,
+			Questions: []layers.DNSQuestion{
+				{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN},
+			},
+			Answers: []layers.DNSResourceRecord{
+				{
+					Name:  []byte("example.com"),
+					Type:  layers.DNSTypeA,
+					Class: layers.DNSClassIN,
+					TTL:   60,
+					IP:    net.IP{93, 184, 216, 34},
+				},
+			},
+		},
+	}
+}
+
+func TestPipeline_StoresDNS(t *testing.T) {
+	db, err := storage.OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	src := capture.NewMemorySource(layers.LinkTypeEthernet)
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, src.AddLayers(t0, dnsResponse()...))
+
+	for p := range src.Packets() {
+		_, dnsInfo := packet.ExtractPacketInfo(p)
+		require.NotNil(t, dnsInfo)
+		require.NoError(t, dns.InsertDNSInfo(dnsInfo, db))
+	}
+
+	entries, err := storage.GetDNSEntries(db)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "8.8.8.8", entries[0].SourceIP)
+	assert.Equal(t, "example.com", entries[0].QueryName)
+	assert.Equal(t, "93.184.216.34", entries[0].ResponseIPs)
+	assert.Equal(t, "response", entries[0].RequestType)
+	assert.Equal(t, uint16(42), entries[0].TxnId)
+	assert.Equal(t, t0, entries[0].Timestamp.UTC())
+}
@@ -0,0 +1,143 @@
+package filter
+
+import (
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Record is anything a filter can run against. Field returns the values of
+// the named field, which are bool, string, int64, netip.Addr or time.Duration
+// depending on the field's Type. A field can have several values, e.g.
+// ip.addr, and has none when it does not apply to the record
+type Record interface {
+	Field(name string) []any
+}
+
+// RecordFunc adapts a function to the Record interface
+type RecordFunc func(name string) []any
+
+// Field calls f(name)
+func (f RecordFunc) Field(name string) []any {
+	return f(name)
+}
+
+type node interface {
+	eval(r Record) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(r Record) bool { return n.left.eval(r) && n.right.eval(r) }
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(r Record) bool { return n.left.eval(r) || n.right.eval(r) }
+
+type notNode struct{ n node }
+
+func (n notNode) eval(r Record) bool { return !n.n.eval(r) }
+
+// fieldNode is a field named on its own, which is true when the record has
+// it. A boolean field also has to be set
+type fieldNode struct {
+	field Field
+}
+
+func (n fieldNode) eval(r Record) bool {
+	for _, v := range r.Field(n.field.Name) {
+		if b, ok := v.(bool); !ok || b {
+			return true
+		}
+	}
+	return false
+}
+
+// literal is a parsed value on the right hand side of a comparison. Which
+// members are used depends on the field's type
+type literal struct {
+	str     string
+	boolean bool
+	lo, hi  int64 // numbers and durations, lo == hi unless a range
+	prefix  netip.Prefix
+	re      *regexp.Regexp
+}
+
+// cmpNode compares a field with one literal, or a set of them for in.
+// Comparisons are true when any value of the field matches, like Wireshark,
+// except for != which is true when the field is present and no value is
+// equal, so that !(ip.addr == x) and ip.addr != x agree on IP traffic
+type cmpNode struct {
+	field Field
+	op    tokenKind
+	lits  []literal
+}
+
+func (n *cmpNode) eval(r Record) bool {
+	values := r.Field(n.field.Name)
+	if len(values) == 0 {
+		return false
+	}
+
+	if n.op == tokNeq {
+		for _, v := range values {
+			if n.compare(tokEq, v, n.lits[0]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	op := n.op
+	if op == tokIn {
+		op = tokEq
+	}
+	for _, v := range values {
+		for _, lit := range n.lits {
+			if n.compare(op, v, lit) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (n *cmpNode) compare(op tokenKind, v any, lit literal) bool {
+	switch v := v.(type) {
+	case string:
+		switch op {
+		case tokEq:
+			return v == lit.str
+		case tokContains:
+			return strings.Contains(v, lit.str)
+		case tokMatches:
+			return lit.re.MatchString(v)
+		}
+	case bool:
+		return v == lit.boolean
+	case int64:
+		return compareInt(op, v, lit)
+	case time.Duration:
+		return compareInt(op, int64(v), lit)
+	case netip.Addr:
+		return lit.prefix.Contains(v.Unmap())
+	}
+	return false
+}
+
+func compareInt(op tokenKind, v int64, lit literal) bool {
+	switch op {
+	case tokEq:
+		return v >= lit.lo && v <= lit.hi
+	case tokLt:
+		return v < lit.lo
+	case tokLe:
+		return v <= lit.lo
+	case tokGt:
+		return v > lit.lo
+	case tokGe:
+		return v >= lit.lo
+	}
+	return false
+}
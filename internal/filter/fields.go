@@ -0,0 +1,186 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type is the type of the values a field holds, which decides the operators
+// and literals it can be compared with
+type Type int
+
+const (
+	TypeBool     Type = iota // a protocol or flag, tested by naming it
+	TypeString               // compared with ==, !=, contains, matches and in
+	TypeInt                  // compared with ==, !=, <, <=, >, >= and in
+	TypeAddr                 // an IP address, compared with an address or a prefix
+	TypeDuration             // compared like an int, written as 1.5s or 2m
+	TypeMAC                  // a MAC address, compared with ==, != and in
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeBool:
+		return "boolean"
+	case TypeString:
+		return "string"
+	case TypeInt:
+		return "number"
+	case TypeAddr:
+		return "address"
+	case TypeDuration:
+		return "duration"
+	case TypeMAC:
+		return "MAC address"
+	default:
+		return "unknown"
+	}
+}
+
+// Scope is a set of the kinds of record a filter can be run against. A field
+// outside of the scope a filter is compiled for is a syntax error
+type Scope uint8
+
+const (
+	ScopePacket     Scope = 1 << iota // decoded packets, with their DNS details
+	ScopeConnection                   // tracked TCP and UDP connections and ping flows
+	ScopeDNS                          // rows of the dns_queries table
+
+	ScopeAll = ScopePacket | ScopeConnection | ScopeDNS
+)
+
+func (s Scope) String() string {
+	var names []string
+	if s&ScopePacket != 0 {
+		names = append(names, "packets")
+	}
+	if s&ScopeConnection != 0 {
+		names = append(names, "connections")
+	}
+	if s&ScopeDNS != 0 {
+		names = append(names, "dns entries")
+	}
+	return strings.Join(names, ", ")
+}
+
+// Field describes a name that can be used in a filter
+type Field struct {
+	Name   string
+	Type   Type
+	Scopes Scope
+	Help   string
+}
+
+const (
+	scopeTraffic = ScopePacket | ScopeConnection
+)
+
+var fields = map[string]Field{}
+
+func init() {
+	for _, f := range []Field{
+		{"frame.len", TypeInt, ScopePacket, "length of the packet on the wire"},
+		{"frame.cap_len", TypeInt, ScopePacket, "number of bytes captured"},
+		{"frame.interface", TypeString, ScopeAll, "interface the traffic was captured on"},
+		{"frame.protocol", TypeString, ScopePacket, "highest protocol decoded, e.g. DNS"},
+
+		{"eth", TypeBool, ScopePacket, "Ethernet frames"},
+		{"eth.src", TypeMAC, ScopePacket, "source MAC address"},
+		{"eth.dst", TypeMAC, ScopePacket, "destination MAC address"},
+		{"eth.addr", TypeMAC, ScopePacket, "either MAC address"},
+		{"eth.type", TypeInt, ScopePacket, "ethertype of the payload, after any VLAN tags"},
+		{"eth.vendor", TypeString, ScopePacket, "vendor of either MAC address, e.g. Apple"},
+		{"vlan", TypeBool, ScopePacket, "802.1Q or 802.1ad tagged frames"},
+		{"vlan.id", TypeInt, scopeTraffic, "a VLAN tag of the frame or connection"},
+
+		{"ip", TypeBool, scopeTraffic, "IPv4 traffic"},
+		{"ipv6", TypeBool, scopeTraffic, "IPv6 traffic"},
+		{"tcp", TypeBool, scopeTraffic, "TCP traffic"},
+		{"udp", TypeBool, scopeTraffic, "UDP traffic"},
+		{"icmp", TypeBool, scopeTraffic, "ICMPv4 packets or ping flows"},
+		{"icmp.type", TypeInt, ScopePacket, "ICMPv4 type, e.g. 3 for destination unreachable"},
+		{"icmp.code", TypeInt, ScopePacket, "ICMPv4 code"},
+		{"icmpv6", TypeBool, scopeTraffic, "ICMPv6 packets or ping flows"},
+		{"icmpv6.type", TypeInt, ScopePacket, "ICMPv6 type, e.g. 2 for packet too big"},
+		{"icmpv6.code", TypeInt, ScopePacket, "ICMPv6 code"},
+		{"ndp", TypeBool, ScopePacket, "IPv6 neighbor discovery messages"},
+		{"ndp.target", TypeAddr, ScopePacket, "target address of a solicitation, advertisement or redirect"},
+		{"ndp.lladdr", TypeMAC, ScopePacket, "link-layer address option"},
+		{"ndp.router", TypeBool, ScopePacket, "a router advertisement, or the router flag is set"},
+		{"arp", TypeBool, ScopePacket, "ARP packets"},
+		{"arp.opcode", TypeInt, ScopePacket, "ARP operation, 1 is a request and 2 a reply"},
+		{"arp.src.hw_mac", TypeMAC, ScopePacket, "sender MAC address"},
+		{"arp.dst.hw_mac", TypeMAC, ScopePacket, "target MAC address"},
+		{"arp.src.proto_ipv4", TypeAddr, ScopePacket, "sender IPv4 address"},
+		{"arp.dst.proto_ipv4", TypeAddr, ScopePacket, "target IPv4 address"},
+		{"arp.isgratuitous", TypeBool, ScopePacket, "a host announcing its own address"},
+		{"tls", TypeBool, scopeTraffic, "TLS records, or connections with a TLS handshake"},
+		{"tls.sni", TypeString, scopeTraffic, "server name the client asked for"},
+		{"tls.alpn", TypeString, scopeTraffic, "an application protocol offered or chosen, e.g. h2"},
+		{"tls.version", TypeString, scopeTraffic, "negotiated version, e.g. \"TLS 1.3\""},
+		{"tls.ja3", TypeString, scopeTraffic, "JA3 hash of the client hello"},
+		{"tls.ja3s", TypeString, scopeTraffic, "JA3S hash of the server hello"},
+		{"tls.ja4", TypeString, scopeTraffic, "JA4 fingerprint of the client hello"},
+		{"quic", TypeBool, scopeTraffic, "QUIC packets or connections"},
+		{"quic.version", TypeString, scopeTraffic, "\"QUIC v1\" or \"QUIC v2\""},
+		{"quic.migrated", TypeBool, scopeTraffic, "a connection seen on another path than the first"},
+		{"tunnel", TypeBool, scopeTraffic, "traffic carried in a tunnel, or an encrypted tunnel itself"},
+		{"tunnel.type", TypeString, scopeTraffic, "GRE, VXLAN, GENEVE, IPIP, ESP or WireGuard"},
+		{"tunnel.id", TypeInt, scopeTraffic, "VXLAN or GENEVE VNI, GRE key, ESP SPI or WireGuard index"},
+		{"tunnel.addr", TypeAddr, scopeTraffic, "either outer address of a tunnel"},
+		{"http", TypeBool, ScopePacket, "plaintext HTTP/1.x requests and responses"},
+		{"http.method", TypeString, ScopePacket, "request method of a transaction the packet completed"},
+		{"http.host", TypeString, ScopePacket, "Host header of a transaction the packet completed"},
+		{"http.status", TypeInt, ScopePacket, "response status of a transaction the packet completed"},
+		{"dns", TypeBool, ScopePacket | ScopeDNS, "DNS messages"},
+
+		{"ipv6.ext", TypeString, ScopePacket, "an extension header, e.g. hop-by-hop or fragment"},
+
+		{"ip.src", TypeAddr, ScopeAll, "source address, IPv4 or IPv6"},
+		{"ip.dst", TypeAddr, scopeTraffic, "destination address, IPv4 or IPv6"},
+		{"ip.addr", TypeAddr, scopeTraffic, "either address"},
+
+		{"port", TypeInt, scopeTraffic, "either TCP or UDP port"},
+		{"tcp.srcport", TypeInt, scopeTraffic, "TCP source port"},
+		{"tcp.dstport", TypeInt, scopeTraffic, "TCP destination port"},
+		{"tcp.port", TypeInt, scopeTraffic, "either TCP port"},
+		{"udp.srcport", TypeInt, scopeTraffic, "UDP source port"},
+		{"udp.dstport", TypeInt, scopeTraffic, "UDP destination port"},
+		{"udp.port", TypeInt, scopeTraffic, "either UDP port"},
+
+		{"tcp.flags.syn", TypeBool, ScopePacket, "SYN is set"},
+		{"tcp.flags.ack", TypeBool, ScopePacket, "ACK is set"},
+		{"tcp.flags.fin", TypeBool, ScopePacket, "FIN is set"},
+		{"tcp.flags.rst", TypeBool, ScopePacket, "RST is set"},
+		{"tcp.flags.psh", TypeBool, ScopePacket, "PSH is set"},
+
+		{"dns.qname", TypeString, ScopePacket | ScopeDNS, "queried name"},
+		{"dns.qtype", TypeString, ScopePacket | ScopeDNS, "queried type, e.g. AAAA"},
+		{"dns.id", TypeInt, ScopePacket | ScopeDNS, "transaction id"},
+		{"dns.response", TypeBool, ScopePacket | ScopeDNS, "the message is a response"},
+		{"dns.answer", TypeAddr, ScopePacket | ScopeDNS, "an address in the answers"},
+		{"dns.cname", TypeString, ScopePacket | ScopeDNS, "a name in the CNAME chain"},
+
+		{"conn.state", TypeString, ScopeConnection, "TCP state, e.g. ESTABLISHED"},
+		{"conn.bytes", TypeInt, ScopeConnection, "bytes in both directions"},
+		{"conn.bytes_sent", TypeInt, ScopeConnection, "bytes from the server"},
+		{"conn.bytes_received", TypeInt, ScopeConnection, "bytes from the client"},
+		{"conn.duration", TypeDuration, ScopeConnection, "time between first and last packet"},
+		{"conn.icmp_error", TypeString, ScopeConnection, "last ICMP error, e.g. PMTU 1400"},
+		{"conn.rtt", TypeDuration, ScopeConnection, "round trip time of the last answered ping"},
+	} {
+		fields[f.Name] = f
+	}
+}
+
+// lookupField finds the field name, checking that it is usable in scope
+func lookupField(name string, scope Scope) (Field, error) {
+	f, ok := fields[name]
+	if !ok {
+		return Field{}, fmt.Errorf("unknown field %q", name)
+	}
+	if f.Scopes&scope == 0 {
+		return Field{}, fmt.Errorf("field %q only applies to %s", name, f.Scopes)
+	}
+	return f, nil
+}
@@ -0,0 +1,425 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/dns"
+	"packeteer/internal/http"
+	"packeteer/internal/packet"
+	"packeteer/internal/quic"
+	"packeteer/internal/storage"
+	"packeteer/internal/tls"
+)
+
+func tcpPacket() *packet.PacketInfo {
+	return &packet.PacketInfo{
+		Length:        120,
+		CaptureLength: 100,
+		SrcIP:         "10.0.0.5",
+		SrcPort:       "51000",
+		DestIP:        "93.184.216.34",
+		DestPort:      "443(https)",
+		Protocol:      packet.TCP,
+		Transport:     packet.TCP,
+		Interface:     "eth0",
+		SrcMAC:        "00:03:93:12:34:56",
+		DstMAC:        "00:00:0c:00:00:01",
+		EtherType:     0x0800,
+		VLANs:         []uint16{100, 20},
+		TCPFlags:      packet.TCPFlags{SYN: true},
+	}
+}
+
+func dnsPacket() (*packet.PacketInfo, *dns.DNSInfo) {
+	pi := &packet.PacketInfo{
+		Length:    90,
+		SrcIP:     "1.1.1.1",
+		SrcPort:   "53(domain)",
+		DestIP:    "10.0.0.5",
+		DestPort:  "40000",
+		Protocol:  packet.DNS,
+		Transport: packet.UDP,
+	}
+	info := &dns.DNSInfo{
+		QueryName:   "www.example.com",
+		QueryType:   "A",
+		CNAMEPath:   "example.com,",
+		ResponseIPs: []string{"93.184.216.34", "2606:2800:220:1::1"},
+		RequestType: dns.Response,
+		TxnId:       0x1234,
+	}
+	return pi, info
+}
+
+func match(t *testing.T, expr string, r Record) bool {
+	t.Helper()
+	f, err := Compile(expr, ScopePacket)
+	require.NoError(t, err, expr)
+	return f.Match(r)
+}
+
+// ******************************
+// Compile errors
+// ******************************
+
+func TestCompile_SyntaxErrors(t *testing.T) {
+	tests := []struct {
+		expr   string
+		column int
+		msg    string
+	}{
+		{"", 1, "empty filter"},
+		{"tcp.port = 80", 10, "use '=='"},
+		{"tcp.prot == 80", 1, `unknown field "tcp.prot"`},
+		{"tcp.port == http", 13, "not a number"},
+		{"ip.src == 10.0.0.300", 11, "not an IP address"},
+		{"ip.src == 10.0.0.0/33", 11, "not a valid prefix"},
+		{"tcp && (udp || ip", 18, "expected ')'"},
+		{"tcp udp", 5, "expected '&&' or '||'"},
+		{"tcp)", 4, "unbalanced ')'"},
+		{"tcp.port contains 80", 10, "'contains' cannot be used with tcp.port"},
+		{"dns.qname matches \"(\"", 19, "invalid regular expression"},
+		{"dns.qname == \"abc", 14, "unterminated string"},
+		{"tcp.port in {}", 14, "empty set"},
+		{"tcp.port in {80 443", 20, "expected '}'"},
+		{"tcp.port in", 12, "expected a value"},
+		{"tcp.port in (80)", 13, "expected a value"},
+		{"tcp.port == 1..10", 13, "ranges are only allowed"},
+		{"conn.state == CLOSED", 1, "only applies to connections"},
+		{"frame.len > 10 && #", 19, "unexpected character"},
+		{"eth.src == 00:03:93", 12, "not a MAC address"},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile(tt.expr, ScopePacket)
+		require.Error(t, err, tt.expr)
+
+		var se *SyntaxError
+		require.ErrorAs(t, err, &se, tt.expr)
+		assert.Equal(t, tt.column, se.Column, tt.expr)
+		assert.Contains(t, se.Msg, tt.msg, tt.expr)
+		assert.Equal(t, tt.expr, se.Expr)
+	}
+}
+
+func TestSyntaxError_Context(t *testing.T) {
+	_, err := Compile("tcp.port == http", ScopePacket)
+	var se *SyntaxError
+	require.ErrorAs(t, err, &se)
+
+	assert.Equal(t, "tcp.port == http\n            ^", se.Context())
+	assert.Equal(t, "filter: column 13: 'http' is not a number", se.Error())
+}
+
+// ******************************
+// Match
+// ******************************
+
+func TestMatch_Packet(t *testing.T) {
+	r := Packet(tcpPacket(), nil)
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"tcp", true},
+		{"udp", false},
+		{"ip", true},
+		{"ipv6", false},
+		{"dns", false},
+		{"tcp.port == 443", true},
+		{"tcp.dstport == 443 and tcp.srcport == 51000", true},
+		{"udp.port == 443", false},
+		{"port in {80, 443}", true},
+		{"port in {1..1024}", true},
+		{"port in {1..100 8080}", false},
+		{"frame.len > 100 && frame.cap_len <= 100", true},
+		{"frame.len >= 121", false},
+		{"frame.interface == eth0", true},
+		{"frame.protocol == TCP", true},
+		{"ip.src == 10.0.0.5", true},
+		{"ip.src == 10.0.0.0/8", true},
+		{"ip.dst in {10.0.0.0/8 192.168.0.0/16}", false},
+		{"ip.src in 10.0.0.0/8", true},
+		{"ip.dst in 10.0.0.0/8", false},
+		{"port in 443", true},
+		{"ip.addr == 93.184.216.34", true},
+		{"ip.addr != 93.184.216.34", false},
+		{"ip.addr != 1.2.3.4", true},
+		{"!(ip.addr == 93.184.216.34)", false},
+		{"not ip.dst == 10.0.0.0/8", true},
+		{"tcp.flags.syn && !tcp.flags.ack", true},
+		{"tcp.flags.ack == false", true},
+		{"udp || tcp.flags.rst", false},
+		{"tcp && (udp || tcp.port == 443)", true},
+		{"TCP.PORT == 0x1bb", true},
+		{"dns.qname == example.com", false},
+		{"eth && vlan", true},
+		{"eth.src == 00:03:93:12:34:56", true},
+		{"eth.addr == 00-00-0C-00-00-01", true},
+		{"eth.dst in {ff:ff:ff:ff:ff:ff}", false},
+		{"eth.type == 0x0800", true},
+		{"eth.vendor == Apple && eth.vendor == Cisco", true},
+		{"vlan.id == 20", true},
+		{"vlan.id in {1..19 200..4094}", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, match(t, tt.expr, r), tt.expr)
+	}
+}
+
+func TestMatch_DNSPacket(t *testing.T) {
+	r := Packet(dnsPacket())
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"dns", true},
+		{"udp && udp.srcport == 53", true},
+		{"tcp", false},
+		{"frame.protocol == DNS", true},
+		{"dns.qname == www.example.com", true},
+		{`dns.qname contains "example"`, true},
+		{`dns.qname matches "^www\\."`, true},
+		{`dns.qname matches "(?i)EXAMPLE"`, true},
+		{"dns.qtype in {A AAAA}", true},
+		{"dns.id == 0x1234", true},
+		{"dns.response", true},
+		{"dns.answer == 93.184.216.0/24", true},
+		{"dns.answer == 2606:2800::/32", true},
+		{"dns.answer == 1.1.1.1", false},
+		{"dns.cname == example.com", true},
+		{"frame.interface", false},
+		{"frame.interface != eth0", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, match(t, tt.expr, r), tt.expr)
+	}
+}
+
+func TestMatch_ARPPacket(t *testing.T) {
+	pi := &packet.PacketInfo{
+		Protocol: packet.ARP,
+		SrcIP:    "10.0.0.5",
+		DestIP:   "10.0.0.5",
+		SrcMAC:   "00:03:93:12:34:56",
+		DstMAC:   "ff:ff:ff:ff:ff:ff",
+		ARP: &packet.ARPInfo{
+			Opcode:    1,
+			Operation: "request",
+			SenderMAC: "00:03:93:12:34:56",
+			SenderIP:  "10.0.0.5",
+			TargetMAC: "00:00:00:00:00:00",
+			TargetIP:  "10.0.0.5",
+		},
+	}
+	r := Packet(pi, nil)
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"arp", true},
+		{"arp.opcode == 1", true},
+		{"arp.src.hw_mac == 00:03:93:12:34:56", true},
+		{"arp.dst.hw_mac == ff:ff:ff:ff:ff:ff", false},
+		{"arp.src.proto_ipv4 == 10.0.0.0/24", true},
+		{"arp.dst.proto_ipv4 == 10.0.0.5", true},
+		{"arp.isgratuitous", true},
+		{"ip", false},
+		{"ip.addr == 10.0.0.5", false},
+		{"eth.vendor == Apple", true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, match(t, tt.expr, r), tt.expr)
+	}
+
+	assert.False(t, match(t, "arp.isgratuitous", Packet(tcpPacket(), nil)))
+}
+
+func TestMatch_ICMPPacket(t *testing.T) {
+	pi := &packet.PacketInfo{
+		SrcIP:    "10.0.0.1",
+		DestIP:   "10.0.0.5",
+		Protocol: packet.ICMPv4,
+		ICMP:     &packet.ICMPInfo{Type: 3, Code: 3, Kind: packet.ICMPError},
+	}
+	r := Packet(pi, nil)
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"icmp && !icmpv6", true},
+		{"icmp.type == 3 && icmp.code == 3", true},
+		{"icmp.type in {0 8}", false},
+		{"icmpv6.type == 3", false},
+		{"ip.src == 10.0.0.1", true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, match(t, tt.expr, r), tt.expr)
+	}
+}
+
+func TestMatch_NDPPacket(t *testing.T) {
+	pi := &packet.PacketInfo{
+		SrcIP:    "fe80::1",
+		DestIP:   "ff02::1",
+		Protocol: packet.ICMPv6,
+		ICMP:     &packet.ICMPInfo{Type: 136, Kind: packet.ICMPOther},
+		NDP: &packet.NDPInfo{
+			Op:       packet.NDPNeighborAdvertisement,
+			TargetIP: "2001:db8::1",
+			LinkAddr: "00:00:0c:00:00:01",
+			Router:   true,
+		},
+		IPv6Ext: []packet.IPv6ExtHeader{{Name: "hop-by-hop", Detail: "router-alert"}},
+	}
+	r := Packet(pi, nil)
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"ndp && icmpv6.type == 136", true},
+		{"ndp.target == 2001:db8::/64", true},
+		{"ndp.lladdr == 00:00:0c:00:00:01", true},
+		{"ndp.router", true},
+		{"ipv6", true},
+		{"ipv6.ext == hop-by-hop", true},
+		{"ipv6.ext == fragment", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, match(t, tt.expr, r), tt.expr)
+	}
+
+	assert.False(t, match(t, "ndp || ipv6.ext", Packet(tcpPacket(), nil)))
+}
+
+func TestMatch_TLSPacket(t *testing.T) {
+	pi := tcpPacket()
+	pi.Protocol = packet.TLS
+	pi.TLS = &tls.Info{
+		SNI:         "api.github.com",
+		OfferedALPN: []string{"h2", "http/1.1"},
+		Version:     "TLS 1.2",
+		ALPN:        "h2",
+		JA3:         "ada70206e40642a3e4461f35503241d5",
+		JA4:         "t13d1516h2_8daaf6152771_e5627efa2ab1",
+	}
+	r := Packet(pi, nil)
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"tls && tcp", true},
+		{"tls.sni == api.github.com", true},
+		{`tls.sni matches "\\.github\\.com$"`, true},
+		{"tls.alpn == http/1.1", true},
+		{"tls.alpn == h3", false},
+		{`tls.version == "TLS 1.2"`, true},
+		{"tls.ja3 == ada70206e40642a3e4461f35503241d5", true},
+		{`tls.ja4 matches "^t13d"`, true},
+		{"tls.ja3s", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, match(t, tt.expr, r), tt.expr)
+	}
+
+	assert.False(t, match(t, "tls || tls.sni", Packet(tcpPacket(), nil)))
+}
+
+func TestMatch_QUICPacket(t *testing.T) {
+	pi := tcpPacket()
+	pi.Protocol, pi.Transport = packet.QUIC, packet.UDP
+	pi.QUIC = &quic.Info{Version: "QUIC v2", Migrated: true}
+	r := Packet(pi, nil)
+
+	assert.True(t, match(t, "quic && udp", r))
+	assert.True(t, match(t, `quic.version == "QUIC v2"`, r))
+	assert.True(t, match(t, "quic.migrated", r))
+	assert.False(t, match(t, "tcp", r))
+
+	assert.False(t, match(t, "quic || quic.migrated", Packet(tcpPacket(), nil)))
+}
+
+func TestMatch_TunnelPacket(t *testing.T) {
+	pi := tcpPacket()
+	pi.Tunnels = []packet.Tunnel{
+		{Type: packet.VXLAN, SrcIP: "192.168.0.1", DstIP: "192.168.0.2", ID: 42},
+	}
+	r := Packet(pi, nil)
+
+	assert.True(t, match(t, "tunnel && tcp", r))
+	assert.True(t, match(t, "tunnel.type == VXLAN && tunnel.id == 42", r))
+	assert.True(t, match(t, "tunnel.addr == 192.168.0.2", r))
+	assert.True(t, match(t, "ip.addr == 10.0.0.5 && !(ip.addr == 192.168.0.1)", r))
+
+	assert.False(t, match(t, "tunnel || tunnel.type == VXLAN", Packet(tcpPacket(), nil)))
+}
+
+func TestMatch_HTTPPacket(t *testing.T) {
+	pi := tcpPacket()
+	pi.Protocol = packet.HTTP
+	pi.HTTP = []http.Transaction{
+		{Method: "GET", Host: "api.internal", Status: 200},
+		{Method: "POST", Status: 503},
+	}
+	r := Packet(pi, nil)
+
+	assert.True(t, match(t, "http && tcp", r))
+	assert.True(t, match(t, `http.method == "POST"`, r))
+	assert.True(t, match(t, `http.host == "api.internal"`, r))
+	assert.True(t, match(t, "http.status >= 500", r))
+	assert.False(t, match(t, "http.status == 404", r))
+
+	assert.False(t, match(t, "http || http.status == 200", Packet(tcpPacket(), nil)))
+}
+
+func TestMatch_NilFilter(t *testing.T) {
+	var f *Filter
+	assert.True(t, f.Match(Packet(tcpPacket(), nil)))
+	assert.Equal(t, "", f.String())
+}
+
+func TestMatch_DNSEntry(t *testing.T) {
+	e := storage.DNSEntry{
+		SourceIP:    "192.168.0.1",
+		QueryName:   "example.com",
+		QueryType:   "AAAA",
+		ResponseIPs: "2606:2800:220:1::1",
+		RequestType: "response",
+		Interface:   "wlan0",
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"dns.qtype == AAAA && dns.response", true},
+		{"ip.src == 192.168.0.0/24", true},
+		{"frame.interface == wlan0", true},
+		{"dns.answer == 2606:2800:220:1::1", true},
+		{"dns.cname", false},
+	}
+
+	for _, tt := range tests {
+		f, err := Compile(tt.expr, ScopeDNS)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.want, f.Match(DNSEntry(e)), tt.expr)
+	}
+
+	_, err := Compile("tcp.port == 53", ScopeDNS)
+	assert.Error(t, err)
+}
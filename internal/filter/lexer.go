@@ -0,0 +1,226 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF    tokenKind = iota
+	tokWord             // field names, numbers, addresses and bare values
+	tokString           // "quoted"
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokComma
+	tokAnd
+	tokOr
+	tokNot
+	tokEq
+	tokNeq
+	tokLt
+	tokLe
+	tokGt
+	tokGe
+	tokContains
+	tokMatches
+	tokIn
+)
+
+var tokenNames = map[tokenKind]string{
+	tokEOF:      "end of filter",
+	tokWord:     "value",
+	tokString:   "string",
+	tokLParen:   "'('",
+	tokRParen:   "')'",
+	tokLBrace:   "'{'",
+	tokRBrace:   "'}'",
+	tokComma:    "','",
+	tokAnd:      "'&&'",
+	tokOr:       "'||'",
+	tokNot:      "'!'",
+	tokEq:       "'=='",
+	tokNeq:      "'!='",
+	tokLt:       "'<'",
+	tokLe:       "'<='",
+	tokGt:       "'>'",
+	tokGe:       "'>='",
+	tokContains: "'contains'",
+	tokMatches:  "'matches'",
+	tokIn:       "'in'",
+}
+
+func (k tokenKind) String() string {
+	return tokenNames[k]
+}
+
+// keywords are the words which are operators rather than values
+var keywords = map[string]tokenKind{
+	"and":      tokAnd,
+	"or":       tokOr,
+	"not":      tokNot,
+	"eq":       tokEq,
+	"ne":       tokNeq,
+	"lt":       tokLt,
+	"le":       tokLe,
+	"gt":       tokGt,
+	"ge":       tokGe,
+	"contains": tokContains,
+	"matches":  tokMatches,
+	"in":       tokIn,
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	col  int // 1-based column of the first character
+}
+
+// isWordRune reports whether r may appear in a bare word. Dots, colons and
+// slashes are included so that field names, IPv6 addresses and CIDR prefixes
+// are read as a single word
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.:/-", r)
+}
+
+// lex splits the expression into tokens
+func lex(expr string) ([]token, error) {
+	var toks []token
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		col := i + 1
+
+		if unicode.IsSpace(r) {
+			i++
+			continue
+		}
+
+		two := ""
+		if i+1 < len(runes) {
+			two = string(runes[i : i+2])
+		}
+		switch two {
+		case "&&":
+			toks = append(toks, token{tokAnd, two, col})
+			i += 2
+			continue
+		case "||":
+			toks = append(toks, token{tokOr, two, col})
+			i += 2
+			continue
+		case "==":
+			toks = append(toks, token{tokEq, two, col})
+			i += 2
+			continue
+		case "!=":
+			toks = append(toks, token{tokNeq, two, col})
+			i += 2
+			continue
+		case "<=":
+			toks = append(toks, token{tokLe, two, col})
+			i += 2
+			continue
+		case ">=":
+			toks = append(toks, token{tokGe, two, col})
+			i += 2
+			continue
+		}
+
+		switch r {
+		case '(':
+			toks = append(toks, token{tokLParen, "(", col})
+			i++
+			continue
+		case ')':
+			toks = append(toks, token{tokRParen, ")", col})
+			i++
+			continue
+		case '{':
+			toks = append(toks, token{tokLBrace, "{", col})
+			i++
+			continue
+		case '}':
+			toks = append(toks, token{tokRBrace, "}", col})
+			i++
+			continue
+		case ',':
+			toks = append(toks, token{tokComma, ",", col})
+			i++
+			continue
+		case '!':
+			toks = append(toks, token{tokNot, "!", col})
+			i++
+			continue
+		case '<':
+			toks = append(toks, token{tokLt, "<", col})
+			i++
+			continue
+		case '>':
+			toks = append(toks, token{tokGt, ">", col})
+			i++
+			continue
+		case '=':
+			return nil, &SyntaxError{Column: col, Msg: "single '=', use '==' to compare"}
+		case '"':
+			s, n, err := lexString(runes[i:], col)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{tokString, s, col})
+			i += n
+			continue
+		}
+
+		if !isWordRune(r) {
+			return nil, &SyntaxError{Column: col, Msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+
+		start := i
+		for i < len(runes) && isWordRune(runes[i]) {
+			i++
+		}
+		word := string(runes[start:i])
+		if kind, ok := keywords[strings.ToLower(word)]; ok {
+			toks = append(toks, token{kind, word, col})
+		} else {
+			toks = append(toks, token{tokWord, word, col})
+		}
+	}
+
+	return append(toks, token{tokEOF, "", len(runes) + 1}), nil
+}
+
+// lexString reads a double quoted string with backslash escapes, returning
+// its value and the number of runes consumed
+func lexString(runes []rune, col int) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(runes); i++ {
+		switch runes[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 == len(runes) {
+				break
+			}
+			i++
+			switch runes[i] {
+			case 'n':
+				b.WriteRune('\n')
+			case 't':
+				b.WriteRune('\t')
+			default:
+				b.WriteRune(runes[i])
+			}
+		default:
+			b.WriteRune(runes[i])
+		}
+	}
+
+	return "", 0, &SyntaxError{Column: col, Msg: "unterminated string"}
+}
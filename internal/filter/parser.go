@@ -0,0 +1,363 @@
+package filter
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyntaxError is returned by Compile for a filter that cannot be parsed.
+// Column is the 1-based position in Expr the error was found at
+type SyntaxError struct {
+	Expr   string
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("filter: column %d: %s", e.Column, e.Msg)
+}
+
+// Context renders the expression with a caret under the column of the error
+func (e *SyntaxError) Context() string {
+	return e.Expr + "\n" + strings.Repeat(" ", max(e.Column-1, 0)) + "^"
+}
+
+// Filter is a compiled display filter
+type Filter struct {
+	expr string
+	root node
+}
+
+// Compile parses expr into a Filter that can be run against records of scope.
+// Errors are *SyntaxError
+func Compile(expr string, scope Scope) (*Filter, error) {
+	toks, err := lex(expr)
+	if err != nil {
+		err.(*SyntaxError).Expr = expr
+		return nil, err
+	}
+
+	p := &parser{toks: toks, scope: scope}
+	root, err := p.parse()
+	if err != nil {
+		err.(*SyntaxError).Expr = expr
+		return nil, err
+	}
+
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// String returns the expression the filter was compiled from
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.expr
+}
+
+// Match reports whether the record passes the filter. A nil Filter matches
+// everything
+func (f *Filter) Match(r Record) bool {
+	if f == nil {
+		return true
+	}
+	return f.root.eval(r)
+}
+
+// parser is a recursive descent parser over the tokens of one expression:
+//
+//	or      = and { ("||" | "or") and }
+//	and     = unary { ("&&" | "and") unary }
+//	unary   = ("!" | "not") unary | primary
+//	primary = "(" or ")" | field [ op value | "in" "{" value { [","] value } "}" ]
+type parser struct {
+	toks  []token
+	pos   int
+	scope Scope
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func errorAt(t token, format string, args ...any) *SyntaxError {
+	return &SyntaxError{Column: t.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// describe names a token for error messages
+func describe(t token) string {
+	switch t.kind {
+	case tokEOF:
+		return t.kind.String()
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return "'" + t.text + "'"
+	}
+}
+
+func (p *parser) parse() (node, error) {
+	if p.peek().kind == tokEOF {
+		return nil, errorAt(p.peek(), "empty filter")
+	}
+
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		if t.kind == tokRParen {
+			return nil, errorAt(t, "unbalanced ')'")
+		}
+		return nil, errorAt(t, "expected '&&' or '||', got %s", describe(t))
+	}
+	return n, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek().kind == tokNot {
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.kind != tokRParen {
+			return nil, errorAt(c, "expected ')' to close '(' at column %d, got %s", t.col, describe(c))
+		}
+		return n, nil
+	case tokWord:
+	default:
+		return nil, errorAt(t, "expected a field, got %s", describe(t))
+	}
+
+	f, err := lookupField(strings.ToLower(t.text), p.scope)
+	if err != nil {
+		return nil, errorAt(t, "%v", err)
+	}
+
+	op := p.peek()
+	switch op.kind {
+	case tokEq, tokNeq, tokLt, tokLe, tokGt, tokGe, tokContains, tokMatches:
+		p.next()
+		if err := checkOperator(f, op); err != nil {
+			return nil, err
+		}
+		v := p.next()
+		lit, err := p.literal(f, op.kind, v)
+		if err != nil {
+			return nil, err
+		}
+		return &cmpNode{field: f, op: op.kind, lits: []literal{lit}}, nil
+	case tokIn:
+		p.next()
+		if err := checkOperator(f, op); err != nil {
+			return nil, err
+		}
+		lits, err := p.parseSet(f)
+		if err != nil {
+			return nil, err
+		}
+		return &cmpNode{field: f, op: tokIn, lits: lits}, nil
+	}
+
+	return fieldNode{field: f}, nil
+}
+
+// parseSet reads the values of an in expression, e.g. {80 443 8000..8080}. A
+// single value needs no braces, as in ip.src in 10.0.0.0/8
+func (p *parser) parseSet(f Field) ([]literal, error) {
+	if t := p.next(); t.kind != tokLBrace {
+		lit, err := p.literal(f, tokIn, t)
+		if err != nil {
+			return nil, err
+		}
+		return []literal{lit}, nil
+	}
+
+	var lits []literal
+	for {
+		t := p.next()
+		switch t.kind {
+		case tokRBrace:
+			if len(lits) == 0 {
+				return nil, errorAt(t, "empty set")
+			}
+			return lits, nil
+		case tokComma:
+			if len(lits) == 0 {
+				return nil, errorAt(t, "expected a value, got ','")
+			}
+			continue
+		case tokEOF:
+			return nil, errorAt(t, "expected '}' to close the set")
+		}
+
+		lit, err := p.literal(f, tokIn, t)
+		if err != nil {
+			return nil, err
+		}
+		lits = append(lits, lit)
+	}
+}
+
+// checkOperator reports an operator the field's type does not support
+func checkOperator(f Field, op token) error {
+	ok := false
+	switch op.kind {
+	case tokEq, tokNeq:
+		ok = true
+	case tokLt, tokLe, tokGt, tokGe:
+		ok = f.Type == TypeInt || f.Type == TypeDuration
+	case tokContains, tokMatches:
+		ok = f.Type == TypeString
+	case tokIn:
+		ok = f.Type != TypeBool
+	}
+	if !ok {
+		return errorAt(op, "%s cannot be used with %s, a %s field", describe(op), f.Name, f.Type)
+	}
+	return nil
+}
+
+// literal parses the value t of a comparison against f
+func (p *parser) literal(f Field, op tokenKind, t token) (literal, error) {
+	if t.kind != tokWord && t.kind != tokString {
+		return literal{}, errorAt(t, "expected a value, got %s", describe(t))
+	}
+
+	if op == tokMatches {
+		re, err := regexp.Compile(t.text)
+		if err != nil {
+			return literal{}, errorAt(t, "invalid regular expression: %v", err)
+		}
+		return literal{re: re}, nil
+	}
+
+	switch f.Type {
+	case TypeString:
+		return literal{str: t.text}, nil
+	case TypeBool:
+		b, err := strconv.ParseBool(t.text)
+		if err != nil {
+			return literal{}, errorAt(t, "%s is not a boolean, use true or false", describe(t))
+		}
+		return literal{boolean: b}, nil
+	case TypeInt:
+		return parseInt(t, op)
+	case TypeDuration:
+		d, err := time.ParseDuration(t.text)
+		if err != nil {
+			secs, perr := strconv.ParseFloat(t.text, 64)
+			if perr != nil {
+				return literal{}, errorAt(t, "%s is not a duration, e.g. 500ms or 2m", describe(t))
+			}
+			d = time.Duration(secs * float64(time.Second))
+		}
+		return literal{lo: int64(d), hi: int64(d)}, nil
+	case TypeMAC:
+		hw, err := net.ParseMAC(t.text)
+		if err != nil {
+			return literal{}, errorAt(t, "%s is not a MAC address", describe(t))
+		}
+		return literal{str: hw.String()}, nil
+	case TypeAddr:
+		if strings.Contains(t.text, "/") {
+			prefix, err := netip.ParsePrefix(t.text)
+			if err != nil {
+				return literal{}, errorAt(t, "%s is not a valid prefix", describe(t))
+			}
+			return literal{prefix: prefix.Masked()}, nil
+		}
+		addr, err := netip.ParseAddr(t.text)
+		if err != nil {
+			return literal{}, errorAt(t, "%s is not an IP address", describe(t))
+		}
+		return literal{prefix: netip.PrefixFrom(addr, addr.BitLen())}, nil
+	}
+
+	return literal{}, errorAt(t, "cannot compare %s", f.Name)
+}
+
+// parseInt reads a number in any base strconv accepts, or a lo..hi range
+// inside of a set
+func parseInt(t token, op tokenKind) (literal, error) {
+	lo, hi, isRange := strings.Cut(t.text, "..")
+	if isRange && op != tokIn {
+		return literal{}, errorAt(t, "ranges are only allowed inside of 'in {...}'")
+	}
+	if !isRange {
+		hi = lo
+	}
+
+	l, err := strconv.ParseInt(lo, 0, 64)
+	if err != nil {
+		return literal{}, errorAt(t, "%s is not a number", describe(t))
+	}
+	h, err := strconv.ParseInt(hi, 0, 64)
+	if err != nil {
+		return literal{}, errorAt(t, "%s is not a number", describe(t))
+	}
+	if h < l {
+		return literal{}, errorAt(t, "range %s ends before it starts", describe(t))
+	}
+
+	return literal{lo: l, hi: h}, nil
+}
@@ -0,0 +1,403 @@
+package filter
+
+import (
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"packeteer/internal/dns"
+	"packeteer/internal/http"
+	"packeteer/internal/oui"
+	"packeteer/internal/packet"
+	"packeteer/internal/quic"
+	"packeteer/internal/storage"
+	"packeteer/internal/tls"
+)
+
+// Packet returns the Record of a decoded packet. dnsInfo may be nil
+func Packet(pi *packet.PacketInfo, dnsInfo *dns.DNSInfo) Record {
+	return RecordFunc(func(name string) []any {
+		if v, ok := dnsField(name, dnsInfo); ok {
+			return v
+		}
+		if pi == nil {
+			return nil
+		}
+
+		switch name {
+		case "frame.len":
+			return []any{int64(pi.Length)}
+		case "frame.cap_len":
+			return []any{int64(pi.CaptureLength)}
+		case "frame.interface":
+			return nonEmpty(pi.Interface)
+		case "frame.protocol":
+			return nonEmpty(string(pi.Protocol))
+		case "eth":
+			return []any{pi.SrcMAC != ""}
+		case "eth.src":
+			return nonEmpty(pi.SrcMAC)
+		case "eth.dst":
+			return nonEmpty(pi.DstMAC)
+		case "eth.addr":
+			return append(nonEmpty(pi.SrcMAC), nonEmpty(pi.DstMAC)...)
+		case "eth.type":
+			if pi.SrcMAC == "" {
+				return nil
+			}
+			return []any{int64(pi.EtherType)}
+		case "eth.vendor":
+			return append(nonEmpty(oui.Vendor(pi.SrcMAC)), nonEmpty(oui.Vendor(pi.DstMAC))...)
+		case "vlan":
+			return []any{len(pi.VLANs) > 0}
+		case "vlan.id":
+			v := make([]any, len(pi.VLANs))
+			for i, id := range pi.VLANs {
+				v[i] = int64(id)
+			}
+			return v
+		case "icmp":
+			return []any{pi.Protocol == packet.ICMPv4}
+		case "icmp.type", "icmp.code":
+			return icmpField(name, pi, packet.ICMPv4)
+		case "icmpv6":
+			return []any{pi.Protocol == packet.ICMPv6}
+		case "icmpv6.type", "icmpv6.code":
+			return icmpField(name, pi, packet.ICMPv6)
+		case "ndp":
+			return []any{pi.NDP != nil}
+		case "ndp.target", "ndp.lladdr", "ndp.router":
+			return ndpField(name, pi.NDP)
+		case "ipv6.ext":
+			v := make([]any, len(pi.IPv6Ext))
+			for i, h := range pi.IPv6Ext {
+				v[i] = h.Name
+			}
+			return v
+		case "arp":
+			return []any{pi.Protocol == packet.ARP}
+		case "tls":
+			return []any{pi.Protocol == packet.TLS || pi.TLS != nil}
+		case "tls.sni", "tls.alpn", "tls.version", "tls.ja3", "tls.ja3s", "tls.ja4":
+			return TLSField(name, pi.TLS)
+		case "quic":
+			return []any{pi.QUIC != nil}
+		case "quic.version", "quic.migrated":
+			return QUICField(name, pi.QUIC)
+		case "tunnel", "tunnel.type", "tunnel.id", "tunnel.addr":
+			return TunnelField(name, pi.Tunnels)
+		case "http":
+			return []any{pi.Protocol == packet.HTTP || len(pi.HTTP) > 0}
+		case "http.method", "http.host", "http.status":
+			return httpField(name, pi.HTTP)
+		case "arp.opcode", "arp.src.hw_mac", "arp.dst.hw_mac",
+			"arp.src.proto_ipv4", "arp.dst.proto_ipv4", "arp.isgratuitous":
+			return arpField(name, pi.ARP)
+		case "tcp.flags.syn":
+			return tcpFlag(pi, pi.TCPFlags.SYN)
+		case "tcp.flags.ack":
+			return tcpFlag(pi, pi.TCPFlags.ACK)
+		case "tcp.flags.fin":
+			return tcpFlag(pi, pi.TCPFlags.FIN)
+		case "tcp.flags.rst":
+			return tcpFlag(pi, pi.TCPFlags.RST)
+		case "tcp.flags.psh":
+			return tcpFlag(pi, pi.TCPFlags.PSH)
+		}
+
+		// The addresses of an ARP packet are only its arp fields
+		if pi.ARP != nil && (name == "ip" || name == "ipv6" || strings.HasPrefix(name, "ip.")) {
+			return nil
+		}
+		return Flow(name, pi.SrcIP, pi.SrcPort, pi.DestIP, pi.DestPort, pi.Transport)
+	})
+}
+
+// Flow returns the values of the address, port and transport fields shared by
+// packets and connections. Ports are gopacket port strings such as 443(https)
+func Flow(name, srcIP, srcPort, dstIP, dstPort string, transport packet.PacketProtocol) []any {
+	src, dst := parseAddr(srcIP), parseAddr(dstIP)
+
+	switch name {
+	case "ip":
+		return []any{src.Is4() || src.Is4In6()}
+	case "ipv6":
+		return []any{src.Is6() && !src.Is4In6()}
+	case "tcp":
+		return []any{transport == packet.TCP}
+	case "udp":
+		return []any{transport == packet.UDP}
+	case "icmp":
+		return []any{transport == packet.ICMPv4}
+	case "icmpv6":
+		return []any{transport == packet.ICMPv6}
+	case "ip.src":
+		return addrs(src)
+	case "ip.dst":
+		return addrs(dst)
+	case "ip.addr":
+		return addrs(src, dst)
+	case "port":
+		return ports(srcPort, dstPort)
+	}
+
+	proto, field, ok := strings.Cut(name, ".")
+	if !ok || !strings.EqualFold(proto, string(transport)) {
+		return nil
+	}
+	switch field {
+	case "srcport":
+		return ports(srcPort)
+	case "dstport":
+		return ports(dstPort)
+	case "port":
+		return ports(srcPort, dstPort)
+	}
+	return nil
+}
+
+// DNSEntry returns the Record of a row of the dns_queries table
+func DNSEntry(e storage.DNSEntry) Record {
+	info := &dns.DNSInfo{
+		SrcIP:       e.SourceIP,
+		QueryName:   e.QueryName,
+		QueryType:   e.QueryType,
+		CNAMEPath:   e.CNamePath,
+		RequestType: dns.RequestType(e.RequestType),
+		TxnId:       e.TxnId,
+		Interface:   e.Interface,
+	}
+	if e.ResponseIPs != "" {
+		info.ResponseIPs = strings.Split(e.ResponseIPs, ",")
+	}
+
+	return RecordFunc(func(name string) []any {
+		switch name {
+		case "ip.src":
+			return addrs(parseAddr(info.SrcIP))
+		case "frame.interface":
+			return nonEmpty(info.Interface)
+		}
+		v, _ := dnsField(name, info)
+		return v
+	})
+}
+
+// dnsField returns the values of the dns fields, reporting whether name is
+// one of them
+func dnsField(name string, d *dns.DNSInfo) ([]any, bool) {
+	if name != "dns" && !strings.HasPrefix(name, "dns.") {
+		return nil, false
+	}
+	if d == nil {
+		return nil, true
+	}
+
+	switch name {
+	case "dns":
+		return []any{true}, true
+	case "dns.qname":
+		return nonEmpty(d.QueryName), true
+	case "dns.qtype":
+		return nonEmpty(d.QueryType), true
+	case "dns.id":
+		return []any{int64(d.TxnId)}, true
+	case "dns.response":
+		return []any{d.RequestType == dns.Response}, true
+	case "dns.answer":
+		var v []any
+		for _, ip := range d.ResponseIPs {
+			v = append(v, addrs(parseAddr(ip))...)
+		}
+		return v, true
+	case "dns.cname":
+		var v []any
+		for _, c := range strings.Split(d.CNAMEPath, ",") {
+			if c != "" {
+				v = append(v, c)
+			}
+		}
+		return v, true
+	}
+	return nil, true
+}
+
+// icmpField returns the type or code of an ICMP packet of the given version
+func icmpField(name string, pi *packet.PacketInfo, version packet.PacketProtocol) []any {
+	if pi.ICMP == nil || pi.Protocol != version {
+		return nil
+	}
+	if strings.HasSuffix(name, ".type") {
+		return []any{int64(pi.ICMP.Type)}
+	}
+	return []any{int64(pi.ICMP.Code)}
+}
+
+// ndpField returns the values of the ndp fields other than ndp itself
+func ndpField(name string, n *packet.NDPInfo) []any {
+	if n == nil {
+		return nil
+	}
+
+	switch name {
+	case "ndp.target":
+		return addrs(parseAddr(n.TargetIP))
+	case "ndp.lladdr":
+		return nonEmpty(n.LinkAddr)
+	case "ndp.router":
+		return []any{n.Router}
+	}
+	return nil
+}
+
+// TLSField returns the values of the tls fields other than tls itself, for
+// packets and connections carrying a handshake
+func TLSField(name string, info *tls.Info) []any {
+	if info == nil {
+		return nil
+	}
+
+	switch name {
+	case "tls.sni":
+		return nonEmpty(info.SNI)
+	case "tls.alpn":
+		var v []any
+		for _, p := range info.OfferedALPN {
+			v = append(v, p)
+		}
+		if info.ALPN != "" && !slices.Contains(info.OfferedALPN, info.ALPN) {
+			v = append(v, info.ALPN)
+		}
+		return v
+	case "tls.version":
+		return nonEmpty(info.Version)
+	case "tls.ja3":
+		return nonEmpty(info.JA3)
+	case "tls.ja3s":
+		return nonEmpty(info.JA3S)
+	case "tls.ja4":
+		return nonEmpty(info.JA4)
+	}
+	return nil
+}
+
+// QUICField returns the values of the quic fields other than quic itself,
+// for packets and connections alike
+func QUICField(name string, info *quic.Info) []any {
+	if info == nil {
+		return nil
+	}
+
+	switch name {
+	case "quic.version":
+		return nonEmpty(info.Version)
+	case "quic.migrated":
+		return []any{info.Migrated}
+	}
+	return nil
+}
+
+// TunnelField returns the values of the tunnel fields, one for each tunnel,
+// for packets and connections alike
+func TunnelField(name string, tunnels []packet.Tunnel) []any {
+	if name == "tunnel" {
+		return []any{len(tunnels) > 0}
+	}
+
+	var v []any
+	for _, t := range tunnels {
+		switch name {
+		case "tunnel.type":
+			v = append(v, string(t.Type))
+		case "tunnel.id":
+			v = append(v, int64(t.ID))
+		case "tunnel.addr":
+			v = append(v, addrs(parseAddr(t.SrcIP), parseAddr(t.DstIP))...)
+		}
+	}
+	return v
+}
+
+// httpField returns the values of the http fields other than http itself,
+// one for each transaction
+func httpField(name string, txs []http.Transaction) []any {
+	var v []any
+	for _, tx := range txs {
+		switch name {
+		case "http.method":
+			v = append(v, tx.Method)
+		case "http.host":
+			v = append(v, nonEmpty(tx.Host)...)
+		case "http.status":
+			v = append(v, int64(tx.Status))
+		}
+	}
+	return v
+}
+
+// arpField returns the values of the arp fields other than arp itself
+func arpField(name string, a *packet.ARPInfo) []any {
+	if a == nil {
+		return nil
+	}
+
+	switch name {
+	case "arp.opcode":
+		return []any{int64(a.Opcode)}
+	case "arp.src.hw_mac":
+		return nonEmpty(a.SenderMAC)
+	case "arp.dst.hw_mac":
+		return nonEmpty(a.TargetMAC)
+	case "arp.src.proto_ipv4":
+		return addrs(parseAddr(a.SenderIP))
+	case "arp.dst.proto_ipv4":
+		return addrs(parseAddr(a.TargetIP))
+	case "arp.isgratuitous":
+		return []any{a.Gratuitous()}
+	}
+	return nil
+}
+
+func tcpFlag(pi *packet.PacketInfo, set bool) []any {
+	if pi.Transport != packet.TCP {
+		return nil
+	}
+	return []any{set}
+}
+
+func nonEmpty(s string) []any {
+	if s == "" {
+		return nil
+	}
+	return []any{s}
+}
+
+func parseAddr(s string) netip.Addr {
+	a, _ := netip.ParseAddr(s)
+	return a
+}
+
+// addrs returns the valid addresses of as
+func addrs(as ...netip.Addr) []any {
+	var v []any
+	for _, a := range as {
+		if a.IsValid() {
+			v = append(v, a)
+		}
+	}
+	return v
+}
+
+// ports returns the numbers of gopacket port strings, dropping the service
+// name of well known ports, e.g. 53(domain) is 53
+func ports(ps ...string) []any {
+	var v []any
+	for _, p := range ps {
+		num, _, _ := strings.Cut(p, "(")
+		if n, err := strconv.ParseInt(num, 10, 64); err == nil {
+			v = append(v, n)
+		}
+	}
+	return v
+}
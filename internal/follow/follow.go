@@ -0,0 +1,249 @@
+// Package follow picks one TCP or UDP conversation out of a capture and hands
+// over the payload its two sides sent, interleaved in the order it was sent.
+// TCP payload is put back in order first, like Wireshark's Follow TCP Stream
+package follow
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"packeteer/internal/conntrack"
+	"packeteer/internal/packet"
+	"packeteer/internal/reassembly"
+)
+
+// Key names a conversation the way the connections table does, see
+// conntrack.ConnKeyStringFormat. Ports are numbers, without the service name
+// gopacket adds
+type Key struct {
+	ClientIP   string
+	ClientPort string
+	ServerIP   string
+	ServerPort string
+	Protocol   packet.PacketProtocol // TCP or UDP
+}
+
+// ParseKey parses a key such as 10.0.0.5:51234-->10.0.0.9:443/TCP. Ports may
+// carry a service name, as in 443(https), and a vlan prefix of the
+// connections table is ignored
+func ParseKey(s string) (Key, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "vlan ") {
+		_, s, _ = strings.Cut(strings.TrimPrefix(s, "vlan "), " ")
+	}
+
+	src, rest, ok := strings.Cut(s, "-->")
+	if !ok {
+		return Key{}, fmt.Errorf("invalid conversation %q, expected src:port-->dst:port/proto", s)
+	}
+	dst, proto, ok := strings.Cut(rest, "/")
+	if !ok {
+		return Key{}, fmt.Errorf("invalid conversation %q, missing the /TCP or /UDP protocol", s)
+	}
+
+	var k Key
+	switch p := packet.PacketProtocol(strings.ToUpper(proto)); p {
+	case packet.TCP, packet.UDP:
+		k.Protocol = p
+	default:
+		return Key{}, fmt.Errorf("cannot follow %s conversations, only TCP and UDP", proto)
+	}
+
+	var err error
+	if k.ClientIP, k.ClientPort, err = splitEndpoint(src); err != nil {
+		return Key{}, err
+	}
+	if k.ServerIP, k.ServerPort, err = splitEndpoint(dst); err != nil {
+		return Key{}, err
+	}
+	return k, nil
+}
+
+// splitEndpoint splits an ip:port, the IP may be an IPv6 address with colons
+// of its own
+func splitEndpoint(s string) (ip, port string, err error) {
+	i := strings.LastIndex(s, ":")
+	if i <= 0 || i == len(s)-1 {
+		return "", "", fmt.Errorf("invalid endpoint %q, expected ip:port", s)
+	}
+	return strings.Trim(s[:i], "[]"), packet.PortNumber(s[i+1:]), nil
+}
+
+// String formats the key like the connections table does
+func (k Key) String() string {
+	return fmt.Sprintf(
+		conntrack.ConnKeyStringFormat,
+		k.ClientIP, k.ClientPort, k.ServerIP, k.ServerPort, k.Protocol,
+	)
+}
+
+// reverse returns the key seen from the other side
+func (k Key) reverse() Key {
+	return Key{k.ServerIP, k.ServerPort, k.ClientIP, k.ClientPort, k.Protocol}
+}
+
+// Chunk is a run of payload one side sent
+type Chunk struct {
+	FromClient bool
+	Offset     int64     // of the first byte within everything its side sent
+	Time       time.Time // of the packet that carried it
+	Data       []byte    // only valid during the call it is handed to
+	Gap        bool      // bytes of this side were lost before it
+}
+
+// direction is what one side sent so far
+type direction struct {
+	sent int64
+	gap  bool // bytes were lost since the last chunk
+}
+
+// Follower looks for one conversation in the packets it is fed, by key or by
+// index, and hands its payload to a function as it is read
+type Follower struct {
+	key     *Key
+	index   int
+	onChunk func(Key, Chunk)
+
+	seen      map[Key]int // the index of every conversation, by both directions
+	keys      []Key       // in the order they were first seen
+	found     *Key        // the followed conversation, client first
+	assembler *reassembly.Assembler
+	client    direction
+	server    direction
+}
+
+// ByKey returns a Follower of the conversation with key k, in either
+// direction. onChunk is called with the conversation's key, client first, and
+// every chunk of payload
+func ByKey(k Key, onChunk func(Key, Chunk)) *Follower {
+	return newFollower(&k, -1, onChunk)
+}
+
+// ByIndex returns a Follower of the index-th TCP or UDP conversation, counting
+// from 0 in the order they were first seen
+func ByIndex(index int, onChunk func(Key, Chunk)) *Follower {
+	return newFollower(nil, index, onChunk)
+}
+
+func newFollower(k *Key, index int, onChunk func(Key, Chunk)) *Follower {
+	f := &Follower{key: k, index: index, onChunk: onChunk, seen: map[Key]int{}}
+
+	// A single connection is followed, it is only forgotten once closed
+	cfg := reassembly.DefaultConfig()
+	cfg.Timeout = 0
+	f.assembler = reassembly.NewAssembler(cfg)
+	f.assembler.Register(f)
+	return f
+}
+
+// Feed adds a packet, anything other than TCP and UDP is ignored
+func (f *Follower) Feed(pi *packet.PacketInfo) {
+	if pi == nil || pi.Transport != packet.TCP && pi.Transport != packet.UDP {
+		return
+	}
+
+	k := Key{pi.SrcIP, packet.PortNumber(pi.SrcPort), pi.DestIP, packet.PortNumber(pi.DestPort), pi.Transport}
+	index, ok := f.seen[k]
+	if !ok {
+		index = len(f.keys)
+		f.keys = append(f.keys, k)
+		f.seen[k], f.seen[k.reverse()] = index, index
+	}
+
+	switch {
+	case f.key != nil && k != *f.key && k.reverse() != *f.key:
+		return
+	case f.key == nil && index != f.index:
+		return
+	}
+
+	if pi.Transport == packet.TCP {
+		f.assembler.Feed(pi.Segment())
+		return
+	}
+
+	// Whoever sent the first datagram is taken for the client
+	if f.found == nil {
+		f.found = &k
+	}
+	if len(pi.Payload) > 0 {
+		f.read(k == *f.found, pi.Payload, pi.Timestamp)
+	}
+}
+
+// Close hands over what is left of the conversation's payload, as at the end
+// of a capture
+func (f *Follower) Close() {
+	f.assembler.Flush()
+}
+
+// Found returns the key of the followed conversation, client first, or false
+// when it was not seen
+func (f *Follower) Found() (Key, bool) {
+	if f.found == nil {
+		return Key{}, false
+	}
+	return *f.found, true
+}
+
+// Keys returns the TCP and UDP conversations seen, by index
+func (f *Follower) Keys() []Key {
+	return f.keys
+}
+
+// Sent returns the payload bytes the client and the server sent
+func (f *Follower) Sent() (client, server int64) {
+	return f.client.sent, f.server.sent
+}
+
+func (f *Follower) direction(fromClient bool) *direction {
+	if fromClient {
+		return &f.client
+	}
+	return &f.server
+}
+
+// read hands a chunk to onChunk
+func (f *Follower) read(fromClient bool, data []byte, t time.Time) {
+	d := f.direction(fromClient)
+	f.onChunk(*f.found, Chunk{
+		FromClient: fromClient,
+		Offset:     d.sent,
+		Time:       t,
+		Data:       data,
+		Gap:        d.gap,
+	})
+	d.sent += int64(len(data))
+	d.gap = false
+}
+
+// Accept reads the TCP connection being followed, the Follower only feeds
+// that one to its Assembler
+func (f *Follower) Accept(c reassembly.Conn, first []byte) reassembly.Stream {
+	if f.found == nil {
+		f.found = &Key{
+			ClientIP:   c.ClientIP,
+			ClientPort: packet.PortNumber(c.ClientPort),
+			ServerIP:   c.ServerIP,
+			ServerPort: packet.PortNumber(c.ServerPort),
+			Protocol:   packet.TCP,
+		}
+	}
+	return (*stream)(f)
+}
+
+// stream reads the followed TCP connection for a Follower
+type stream Follower
+
+func (s *stream) Read(fromClient bool, data []byte, t time.Time) {
+	(*Follower)(s).read(fromClient, data, t)
+}
+
+func (s *stream) Gap(fromClient bool) {
+	(*Follower)(s).direction(fromClient).gap = true
+}
+
+func (s *stream) End(fromClient bool, t time.Time) {}
+
+func (s *stream) Close() {}
@@ -0,0 +1,155 @@
+package follow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/packet"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// recorder keeps the chunks a Follower hands over
+type recorder struct {
+	key    Key
+	chunks []Chunk
+}
+
+func (r *recorder) chunk(k Key, c Chunk) {
+	r.key = k
+	c.Data = append([]byte(nil), c.Data...)
+	r.chunks = append(r.chunks, c)
+}
+
+func tcp(src, srcPort, dst, dstPort string, seq uint32, payload string) *packet.PacketInfo {
+	return &packet.PacketInfo{
+		Timestamp: t0,
+		SrcIP:     src,
+		SrcPort:   srcPort,
+		DestIP:    dst,
+		DestPort:  dstPort,
+		Transport: packet.TCP,
+		Protocol:  packet.TCP,
+		Seq:       seq,
+		Payload:   []byte(payload),
+	}
+}
+
+func udp(src, srcPort, dst, dstPort, payload string) *packet.PacketInfo {
+	pi := tcp(src, srcPort, dst, dstPort, 0, payload)
+	pi.Transport, pi.Protocol = packet.UDP, packet.UDP
+	return pi
+}
+
+// ******************************
+// Keys
+// ******************************
+
+func TestParseKey(t *testing.T) {
+	k, err := ParseKey("10.0.0.5:51234-->10.0.0.9:443(https)/tcp")
+	require.NoError(t, err)
+	assert.Equal(t, Key{"10.0.0.5", "51234", "10.0.0.9", "443", packet.TCP}, k)
+	assert.Equal(t, "10.0.0.5:51234-->10.0.0.9:443/TCP", k.String())
+
+	k, err = ParseKey("vlan 100.20 fe80::1:5353-->ff02::fb:5353/UDP")
+	require.NoError(t, err)
+	assert.Equal(t, Key{"fe80::1", "5353", "ff02::fb", "5353", packet.UDP}, k)
+
+	for _, bad := range []string{
+		"10.0.0.5:51234",
+		"10.0.0.5:51234-->10.0.0.9:443",
+		"10.0.0.5:51234-->10.0.0.9/TCP",
+		"10.0.0.5-->10.0.0.9:443/TCP",
+		"10.0.0.5:1-->10.0.0.9:2/ICMPv4",
+	} {
+		_, err := ParseKey(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+// ******************************
+// Following
+// ******************************
+
+func TestFollower_TCP(t *testing.T) {
+	var r recorder
+	k, err := ParseKey("10.0.0.9:80-->10.0.0.5:51000/TCP") // as seen from the server
+	require.NoError(t, err)
+	f := ByKey(k, r.chunk)
+
+	syn := tcp("10.0.0.5", "51000", "10.0.0.9", "80(http)", 999, "")
+	syn.TCPFlags.SYN = true
+	f.Feed(syn)
+	f.Feed(tcp("10.0.0.5", "51001", "10.0.0.9", "80(http)", 1000, "other"))
+
+	// Out of order, with a retransmission
+	f.Feed(tcp("10.0.0.5", "51000", "10.0.0.9", "80(http)", 1004, "/ HTTP/1.1\r\n\r\n"))
+	response := tcp("10.0.0.9", "80(http)", "10.0.0.5", "51000", 5000, "HTTP/1.1 200 OK\r\n\r\n")
+	response.Timestamp = t0.Add(time.Millisecond)
+	f.Feed(response)
+	f.Feed(tcp("10.0.0.5", "51000", "10.0.0.9", "80(http)", 1000, "GET "))
+	f.Feed(tcp("10.0.0.5", "51000", "10.0.0.9", "80(http)", 1000, "GET /"))
+	f.Close()
+
+	found, ok := f.Found()
+	require.True(t, ok)
+	assert.Equal(t, "10.0.0.5:51000-->10.0.0.9:80/TCP", found.String())
+	assert.Equal(t, found, r.key)
+
+	require.Len(t, r.chunks, 3)
+	assert.Equal(t, Chunk{FromClient: true, Time: t0, Data: []byte("GET ")}, r.chunks[0])
+	assert.Equal(t, int64(4), r.chunks[1].Offset)
+	assert.Equal(t, "/ HTTP/1.1\r\n\r\n", string(r.chunks[1].Data))
+	assert.Equal(t, Chunk{
+		Time: t0.Add(time.Millisecond),
+		Data: []byte("HTTP/1.1 200 OK\r\n\r\n"),
+	}, r.chunks[2])
+
+	client, server := f.Sent()
+	assert.Equal(t, int64(18), client)
+	assert.Equal(t, int64(19), server)
+	assert.Len(t, f.Keys(), 2)
+}
+
+func TestFollower_UDPByIndex(t *testing.T) {
+	var r recorder
+	f := ByIndex(1, r.chunk)
+
+	f.Feed(udp("10.0.0.5", "5353", "224.0.0.251", "5353(mdns)", "a"))
+	f.Feed(udp("10.0.0.5", "40000", "10.0.0.53", "53(domain)", "query"))
+	f.Feed(tcp("10.0.0.5", "51000", "10.0.0.9", "80(http)", 1000, "GET "))
+	f.Feed(udp("10.0.0.53", "53(domain)", "10.0.0.5", "40000", "answer"))
+	f.Feed(udp("10.0.0.5", "40000", "10.0.0.53", "53(domain)", "again"))
+	f.Feed(&packet.PacketInfo{Protocol: packet.ARP})
+	f.Close()
+
+	found, ok := f.Found()
+	require.True(t, ok)
+	assert.Equal(t, "10.0.0.5:40000-->10.0.0.53:53/UDP", found.String())
+
+	require.Len(t, r.chunks, 3)
+	assert.True(t, r.chunks[0].FromClient)
+	assert.False(t, r.chunks[1].FromClient)
+	assert.Equal(t, "answer", string(r.chunks[1].Data))
+	assert.Equal(t, int64(5), r.chunks[2].Offset)
+
+	assert.Equal(t, []Key{
+		{"10.0.0.5", "5353", "224.0.0.251", "5353", packet.UDP},
+		{"10.0.0.5", "40000", "10.0.0.53", "53", packet.UDP},
+		{"10.0.0.5", "51000", "10.0.0.9", "80", packet.TCP},
+	}, f.Keys())
+}
+
+func TestFollower_NotFound(t *testing.T) {
+	var r recorder
+	f := ByIndex(5, r.chunk)
+	f.Feed(udp("10.0.0.5", "40000", "10.0.0.53", "53(domain)", "query"))
+	f.Close()
+
+	_, ok := f.Found()
+	assert.False(t, ok)
+	assert.Empty(t, r.chunks)
+}
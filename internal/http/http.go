@@ -0,0 +1,250 @@
+// Package http dissects plaintext HTTP/1.x conversations into transactions,
+// pairing every request with its response, pipelined or over a kept alive
+// connection
+package http
+
+import (
+	"bytes"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"packeteer/internal/reassembly"
+	"packeteer/internal/storage"
+)
+
+// methods are the request methods a conversation is recognized by
+var methods = []string{
+	"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH",
+}
+
+// Transaction is a request and its response
+type Transaction struct {
+	Time         time.Time     `json:"time"` // of the request's first segment
+	ClientIP     string        `json:"client_ip"`
+	ClientPort   string        `json:"client_port"`
+	ServerIP     string        `json:"server_ip"`
+	ServerPort   string        `json:"server_port"`
+	Interface    string        `json:"-"`
+	Method       string        `json:"method"`
+	Host         string        `json:"host,omitempty"`
+	Path         string        `json:"path"`
+	Version      string        `json:"version"` // of the response, e.g. HTTP/1.1
+	Status       int           `json:"status"`
+	ContentType  string        `json:"content_type,omitempty"` // of the response
+	RequestSize  int64         `json:"request_size"`           // body bytes
+	ResponseSize int64         `json:"response_size"`
+	Latency      time.Duration `json:"latency"` // to the start of the final response
+}
+
+// Summary returns the transaction on one line, e.g.
+// GET example.com/index.html 200 text/html 1256 bytes 12ms
+func (tx *Transaction) Summary() string {
+	parts := []string{tx.Method, tx.Host + tx.Path, strconv.Itoa(tx.Status)}
+	if tx.ContentType != "" {
+		parts = append(parts, tx.ContentType)
+	}
+	parts = append(parts, strconv.FormatInt(tx.ResponseSize, 10)+" bytes", tx.Latency.String())
+	return strings.Join(parts, " ")
+}
+
+// Tracker pairs the HTTP/1.x requests and responses of the TCP connections
+// an Assembler hands it, starting at the first request it sees
+type Tracker struct{}
+
+// NewTracker returns a Tracker to register with a reassembly.Assembler
+func NewTracker() *Tracker {
+	return &Tracker{}
+}
+
+// Accept reads the connections that start with a request
+func (t *Tracker) Accept(c reassembly.Conn, first []byte) reassembly.Stream {
+	if !isRequest(first) {
+		return nil
+	}
+	conv := &Conversation{template: Transaction{
+		ClientIP:   c.ClientIP,
+		ClientPort: c.ClientPort,
+		ServerIP:   c.ServerIP,
+		ServerPort: c.ServerPort,
+		Interface:  c.Interface,
+	}}
+	conv.requests.onHead, conv.requests.onDone = conv.request, conv.requestDone
+	conv.responses.onHead, conv.responses.onDone = conv.response, conv.responseDone
+	return conv
+}
+
+// Conversation is the HTTP traffic of one connection
+type Conversation struct {
+	mu                  sync.Mutex
+	requests, responses reader
+	pending             []*Transaction // requests waiting for their response, in order
+	current             *Transaction   // the response being read
+	completed           []Transaction
+	template            Transaction
+	broken              bool
+	over                bool // reported as no longer HTTP by Take
+}
+
+// Read adds the next bytes of one side
+func (c *Conversation) Read(fromClient bool, data []byte, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.broken {
+		return
+	}
+	if fromClient {
+		c.requests.write(t, data)
+	} else {
+		c.responses.write(t, data)
+	}
+}
+
+// Gap ends the conversation, there is no telling where the next message
+// starts
+func (c *Conversation) Gap(fromClient bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.broken = true
+}
+
+// End tells that one side closed the connection. The server closing ends a
+// response read until then, a client may close its side and still wait for
+// the answer
+func (c *Conversation) End(fromClient bool, t time.Time) {
+	if !fromClient {
+		c.Close()
+	}
+}
+
+// Close tells that the connection is gone
+func (c *Conversation) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.broken {
+		c.responses.close()
+	}
+	c.broken = true
+}
+
+// Take returns the transactions completed since the last call. ok reports
+// whether the connection still was an HTTP conversation, it is false from the
+// call after the conversation ended or turned into a tunnel
+func (c *Conversation) Take() (done []Transaction, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.over {
+		return nil, false
+	}
+	done, c.completed = c.completed, nil
+	c.over = c.broken ||
+		c.requests.state == stateDone && len(c.pending) == 0 && c.current == nil
+	return done, true
+}
+
+// isRequest reports whether a client payload starts with a request line
+func isRequest(payload []byte) bool {
+	method, _, ok := bytes.Cut(payload, []byte(" "))
+	if !ok {
+		return false
+	}
+	for _, m := range methods {
+		if string(method) == m {
+			return true
+		}
+	}
+	return false
+}
+
+// request starts a transaction for a request head
+func (c *Conversation) request(h *head, started time.Time) body {
+	tx := c.template
+	tx.Time = started
+	tx.Method = h.start[0]
+	tx.Path = h.start[1]
+	tx.Host = h.header("Host")
+	c.pending = append(c.pending, &tx)
+
+	switch {
+	case tx.Method == "CONNECT":
+		return bodyNone
+	case h.chunked():
+		return bodyChunked
+	case h.length() > 0:
+		return bodyLength
+	}
+	return bodyNone
+}
+
+func (c *Conversation) requestDone(size int64) {
+	if len(c.pending) > 0 {
+		c.pending[len(c.pending)-1].RequestSize = size
+	}
+}
+
+// response pairs a response head with the oldest request waiting for one
+func (c *Conversation) response(h *head, started time.Time) body {
+	status, _ := strconv.Atoi(h.start[1])
+
+	// Interim responses come before the real one, 101 switches protocols
+	if status >= 100 && status < 200 && status != 101 {
+		return bodyNone
+	}
+	if len(c.pending) == 0 {
+		return bodyTunnel // an answer to a request that was never seen
+	}
+
+	tx := c.pending[0]
+	c.pending = c.pending[1:]
+	tx.Version = h.start[0]
+	tx.Status = status
+	tx.ContentType = h.header("Content-Type")
+	tx.Latency = started.Sub(tx.Time)
+	c.current = tx
+
+	switch {
+	case status == 101, tx.Method == "CONNECT" && status >= 200 && status < 300:
+		c.requests.state = stateDone
+		return bodyTunnel
+	case tx.Method == "HEAD", status == 204, status == 304:
+		return bodyNone
+	case h.chunked():
+		return bodyChunked
+	case h.length() >= 0:
+		return bodyLength
+	}
+	return bodyUntilClose
+}
+
+func (c *Conversation) responseDone(size int64) {
+	if c.current == nil {
+		return // an interim response
+	}
+	c.current.ResponseSize = size
+	c.completed = append(c.completed, *c.current)
+	c.current = nil
+}
+
+// InsertTransaction stores a transaction in the database
+func InsertTransaction(tx *Transaction, sqldb *sql.DB) error {
+	return storage.InsertHTTPTransaction(sqldb, storage.HTTPTransaction{
+		Timestamp:    tx.Time,
+		ClientIP:     tx.ClientIP,
+		ClientPort:   tx.ClientPort,
+		ServerIP:     tx.ServerIP,
+		ServerPort:   tx.ServerPort,
+		Interface:    tx.Interface,
+		Method:       tx.Method,
+		Host:         tx.Host,
+		Path:         tx.Path,
+		Version:      tx.Version,
+		Status:       tx.Status,
+		ContentType:  tx.ContentType,
+		RequestSize:  tx.RequestSize,
+		ResponseSize: tx.ResponseSize,
+		Latency:      tx.Latency,
+	})
+}
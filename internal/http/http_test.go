@@ -0,0 +1,311 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/reassembly"
+	"packeteer/internal/storage"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	clientIP = "10.0.0.5"
+	serverIP = "10.0.0.80"
+)
+
+// conversation builds the segments of one connection, numbering each side's
+// bytes in order
+type conversation struct {
+	a                    *reassembly.Assembler
+	clientSeq, serverSeq uint32
+	now                  time.Time
+}
+
+func newConversation(cfg reassembly.Config) *conversation {
+	a := reassembly.NewAssembler(cfg)
+	a.Register(NewTracker())
+	return &conversation{a: a, clientSeq: 1000, serverSeq: 9000, now: t0}
+}
+
+func (c *conversation) segment(fromClient bool, payload string) reassembly.Segment {
+	seg := reassembly.Segment{
+		Time:    c.now,
+		SrcIP:   clientIP,
+		SrcPort: "51000",
+		DstIP:   serverIP,
+		DstPort: "80(http)",
+		Payload: []byte(payload),
+	}
+	seq := &c.clientSeq
+	if !fromClient {
+		seg.SrcIP, seg.SrcPort, seg.DstIP, seg.DstPort = serverIP, "80(http)", clientIP, "51000"
+		seq = &c.serverSeq
+	}
+	seg.Seq = *seq
+	*seq += uint32(len(payload))
+	return seg
+}
+
+// feed hands a segment to the Assembler, returning what its conversation
+// completed
+func (c *conversation) feed(seg reassembly.Segment) (done []Transaction, ok bool) {
+	for _, s := range c.a.Feed(seg) {
+		if conv, isHTTP := s.(*Conversation); isHTTP {
+			return conv.Take()
+		}
+	}
+	return nil, false
+}
+
+// client feeds bytes sent by the client, after some time has passed
+func (c *conversation) client(d time.Duration, payload string) []Transaction {
+	c.now = c.now.Add(d)
+	done, _ := c.feed(c.segment(true, payload))
+	return done
+}
+
+func (c *conversation) server(d time.Duration, payload string) []Transaction {
+	c.now = c.now.Add(d)
+	done, _ := c.feed(c.segment(false, payload))
+	return done
+}
+
+// ******************************
+// Tracker
+// ******************************
+
+func TestTracker_KeepAlive(t *testing.T) {
+	c := newConversation(reassembly.DefaultConfig())
+
+	assert.Empty(t, c.client(0, "GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	done := c.server(12*time.Millisecond, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/html\r\nContent-Length: 5\r\n\r\nhello")
+	require.Len(t, done, 1)
+	assert.Equal(t, Transaction{
+		Time:         t0,
+		ClientIP:     clientIP,
+		ClientPort:   "51000",
+		ServerIP:     serverIP,
+		ServerPort:   "80(http)",
+		Method:       "GET",
+		Host:         "example.com",
+		Path:         "/index.html",
+		Version:      "HTTP/1.1",
+		Status:       200,
+		ContentType:  "text/html",
+		ResponseSize: 5,
+		Latency:      12 * time.Millisecond,
+	}, done[0])
+	assert.Equal(t, "GET example.com/index.html 200 text/html 5 bytes 12ms", done[0].Summary())
+
+	// The same connection carries the next request
+	assert.Empty(t, c.client(time.Second, "POST /form HTTP/1.1\r\nHost: example.com\r\n"+
+		"Content-Length: 7\r\n\r\na=1&b=2"))
+	done = c.server(3*time.Millisecond, "HTTP/1.1 204 No Content\r\n\r\n")
+	require.Len(t, done, 1)
+	assert.Equal(t, "POST", done[0].Method)
+	assert.Equal(t, int64(7), done[0].RequestSize)
+	assert.Equal(t, 204, done[0].Status)
+	assert.Equal(t, 3*time.Millisecond, done[0].Latency)
+	assert.Len(t, c.a.Flush(), 1)
+}
+
+func TestTracker_Pipelining(t *testing.T) {
+	c := newConversation(reassembly.DefaultConfig())
+
+	c.client(0, "GET /a HTTP/1.1\r\nHost: h\r\n\r\nGET /b HTTP/1.1\r\nHost: h\r\n\r\n")
+	c.client(time.Millisecond, "HEAD /c HTTP/1.1\r\nHost: h\r\n\r\n")
+
+	// HEAD responses declare a length without a body
+	done := c.server(9*time.Millisecond, "HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\na"+
+		"HTTP/1.1 404 Not Found\r\nContent-Length: 2\r\n\r\nno"+
+		"HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n")
+	require.Len(t, done, 3)
+	assert.Equal(t, "/a", done[0].Path)
+	assert.Equal(t, 200, done[0].Status)
+	assert.Equal(t, 10*time.Millisecond, done[0].Latency)
+	assert.Equal(t, "/b", done[1].Path)
+	assert.Equal(t, 404, done[1].Status)
+	assert.Equal(t, int64(2), done[1].ResponseSize)
+	assert.Equal(t, "/c", done[2].Path)
+	assert.Equal(t, int64(0), done[2].ResponseSize)
+	assert.Equal(t, 9*time.Millisecond, done[2].Latency)
+}
+
+func TestTracker_Chunked(t *testing.T) {
+	c := newConversation(reassembly.DefaultConfig())
+
+	c.client(0, "POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"4\r\nwiki\r\n5;ext=1\r\npedia\r\n0\r\n\r\n")
+	c.server(time.Millisecond, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r")
+	assert.Empty(t, c.server(0, "\nabc\r\n0\r\nX-Trailer: 1\r\n"))
+	done := c.server(0, "\r\n")
+	require.Len(t, done, 1)
+	assert.Equal(t, int64(9), done[0].RequestSize)
+	assert.Equal(t, int64(3), done[0].ResponseSize)
+}
+
+func TestTracker_Interim(t *testing.T) {
+	c := newConversation(reassembly.DefaultConfig())
+
+	c.client(0, "PUT /f HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 3\r\n\r\n")
+	assert.Empty(t, c.server(time.Millisecond, "HTTP/1.1 100 Continue\r\n\r\n"))
+	c.client(time.Millisecond, "abc")
+	done := c.server(time.Millisecond, "HTTP/1.1 304 Not Modified\r\nContent-Length: 10\r\n\r\n")
+	require.Len(t, done, 1)
+	assert.Equal(t, 304, done[0].Status)
+	assert.Equal(t, int64(3), done[0].RequestSize)
+	assert.Equal(t, 3*time.Millisecond, done[0].Latency) // to the final response
+}
+
+func TestTracker_UntilClose(t *testing.T) {
+	c := newConversation(reassembly.DefaultConfig())
+
+	c.client(0, "GET / HTTP/1.0\r\n\r\n")
+	assert.Empty(t, c.server(time.Millisecond, "HTTP/1.0 200 OK\r\n\r\nsome"))
+	assert.Empty(t, c.server(0, " bytes"))
+
+	fin := c.segment(false, "")
+	fin.FIN = true
+	done, ok := c.feed(fin)
+	assert.True(t, ok)
+	require.Len(t, done, 1)
+	assert.Equal(t, "HTTP/1.0", done[0].Version)
+	assert.Equal(t, int64(10), done[0].ResponseSize)
+
+	_, ok = c.feed(c.segment(true, "GET / HTTP/1.0\r\n\r\n"))
+	assert.False(t, ok)
+}
+
+func TestTracker_Upgrade(t *testing.T) {
+	c := newConversation(reassembly.DefaultConfig())
+
+	c.client(0, "GET /ws HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	done := c.server(time.Millisecond, "HTTP/1.1 101 Switching Protocols\r\n\r\n\x81\x05hello")
+	require.Len(t, done, 1)
+	assert.Equal(t, 101, done[0].Status)
+
+	// What follows is not HTTP any more
+	done, ok := c.feed(c.segment(true, "\x81\x85abcdefghi"))
+	assert.False(t, ok)
+	assert.Empty(t, done)
+}
+
+func TestTracker_Split(t *testing.T) {
+	c := newConversation(reassembly.DefaultConfig())
+
+	request := "GET /split HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	c.client(0, request[:20])
+	c.client(0, request[20:])
+
+	// A retransmission and an overlapping segment are only read once
+	response := "HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nbody"
+	first := c.segment(false, response[:30])
+	c.feed(first)
+	c.feed(first)
+	overlap := first
+	overlap.Seq += 20
+	overlap.Payload = []byte(response[20:])
+	done, _ := c.feed(overlap)
+	require.Len(t, done, 1)
+	assert.Equal(t, "example.com", done[0].Host)
+	assert.Equal(t, "/split", done[0].Path)
+	assert.Equal(t, int64(4), done[0].ResponseSize)
+}
+
+func TestTracker_Gap(t *testing.T) {
+	cfg := reassembly.DefaultConfig()
+	cfg.MaxBufferedPerConn = 10
+	c := newConversation(cfg)
+
+	c.client(0, "GET / HTTP/1.1\r\n\r\n")
+	c.server(0, "HTTP/1.1 200 OK\r\n")
+	c.segment(false, "Content-Length: 0\r\n\r\n") // lost
+	done, ok := c.feed(c.segment(false, "HTTP/1.1 200 OK\r\n\r\n"))
+	assert.True(t, ok)
+	assert.Empty(t, done)
+
+	_, ok = c.feed(c.segment(true, "GET / HTTP/1.1\r\n\r\n"))
+	assert.False(t, ok)
+}
+
+func TestTracker_Ignores(t *testing.T) {
+	c := newConversation(reassembly.DefaultConfig())
+
+	done, ok := c.feed(c.segment(true, "\x16\x03\x01\x02\x00\x01"))
+	assert.False(t, ok)
+	assert.Empty(t, done)
+
+	// Responses alone do not start a conversation
+	_, ok = c.feed(c.segment(false, "HTTP/1.1 200 OK\r\n\r\n"))
+	assert.False(t, ok)
+	assert.Empty(t, c.a.Flush())
+}
+
+// ******************************
+// Parsing
+// ******************************
+
+func TestParseHead(t *testing.T) {
+	h, ok := parseHead([]byte("HTTP/1.1 404 Not Found\r\nSet-Cookie: a=1\r\n" +
+		"set-cookie: b=2\r\nContent-Length:  12 \r\nbogus"))
+	require.True(t, ok)
+	assert.Equal(t, []string{"HTTP/1.1", "404", "Not Found"}, h.start)
+	assert.Equal(t, "a=1, b=2", h.header("Set-Cookie"))
+	assert.Equal(t, int64(12), h.length())
+	assert.False(t, h.chunked())
+
+	h, ok = parseHead([]byte("HTTP/1.0 200\nTransfer-Encoding: gzip, Chunked"))
+	require.True(t, ok)
+	assert.Equal(t, "", h.start[2])
+	assert.Equal(t, int64(-1), h.length())
+	assert.True(t, h.chunked())
+
+	_, ok = parseHead([]byte("garbage"))
+	assert.False(t, ok)
+}
+
+func TestReader_HeaderLimit(t *testing.T) {
+	var r reader
+	r.onHead = func(*head, time.Time) body { return bodyNone }
+	r.onDone = func(int64) {}
+
+	r.write(t0, []byte("GET / HTTP/1.1\r\n"))
+	for r.state != stateDone {
+		r.write(t0, []byte("X-Filler: 0123456789012345678901234567890123456789\r\n"))
+	}
+	assert.Nil(t, r.buf)
+}
+
+// ******************************
+// Storage
+// ******************************
+
+func TestInsertTransaction(t *testing.T) {
+	db, err := storage.OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, InsertTransaction(&Transaction{
+		Time:       t0,
+		ClientIP:   clientIP,
+		ClientPort: "51000",
+		ServerIP:   serverIP,
+		ServerPort: "80(http)",
+		Method:     "GET",
+		Path:       "/",
+		Status:     200,
+		Latency:    5 * time.Millisecond,
+	}, db))
+
+	txs, err := storage.GetHTTPTransactions(db)
+	require.NoError(t, err)
+	require.Len(t, txs, 1)
+	assert.Equal(t, "GET", txs[0].Method)
+	assert.Equal(t, 5*time.Millisecond, txs[0].Latency)
+}
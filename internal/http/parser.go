@@ -0,0 +1,260 @@
+package http
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxHeaderBytes is how much of a message's start line and headers is
+// buffered before the stream is taken not to be HTTP
+const maxHeaderBytes = 64 * 1024
+
+// body says how the body of a message is delimited
+type body int
+
+const (
+	bodyNone   body = iota
+	bodyLength      // by Content-Length
+	bodyChunked
+	bodyUntilClose // by the connection closing, responses only
+	bodyTunnel     // the connection stops being HTTP, after an upgrade or CONNECT
+)
+
+// state is where a reader is in the message it reads
+type state int
+
+const (
+	stateHead state = iota
+	stateBody
+	stateChunkSize
+	stateChunkData
+	stateChunkEnd // the CRLF after chunk data
+	stateTrailers
+	stateUntilClose
+	stateDone // not HTTP any more
+)
+
+// head is the start line and headers of a message
+type head struct {
+	start   []string // the three parts of the start line
+	headers map[string]string
+}
+
+func (h *head) header(name string) string {
+	return h.headers[strings.ToLower(name)]
+}
+
+// length returns the declared Content-Length, or -1
+func (h *head) length() int64 {
+	n, err := strconv.ParseInt(h.header("Content-Length"), 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+func (h *head) chunked() bool {
+	return strings.Contains(strings.ToLower(h.header("Transfer-Encoding")), "chunked")
+}
+
+// reader reads the messages of one direction of a connection as its bytes
+// arrive. Bodies are counted, not kept
+type reader struct {
+	state     state
+	buf       []byte // the incomplete head, chunk size line or trailers
+	remaining int64  // of the body or chunk being read
+	size      int64  // body bytes of the current message
+	started   time.Time
+
+	// onHead is called with every message head and says how its body is
+	// delimited, onDone is called once the body was read
+	onHead func(h *head, started time.Time) body
+	onDone func(size int64)
+}
+
+// write reads the bytes of a segment that arrived at t
+func (r *reader) write(t time.Time, data []byte) {
+	for len(data) > 0 && r.state != stateDone {
+		switch r.state {
+		case stateHead:
+			if len(r.buf) == 0 {
+				// Leading empty lines are allowed between messages
+				data = bytes.TrimLeft(data, "\r\n")
+				if len(data) == 0 {
+					return
+				}
+				r.started = t
+			}
+			data = r.readHead(data)
+
+		case stateBody:
+			n := min(int64(len(data)), r.remaining)
+			r.size += n
+			r.remaining -= n
+			data = data[n:]
+			if r.remaining == 0 {
+				r.done()
+			}
+
+		case stateChunkSize:
+			line, rest, ok := r.line(data)
+			data = rest
+			if !ok {
+				continue
+			}
+			sizeText, _, _ := strings.Cut(string(line), ";") // chunk extensions
+			size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 16, 64)
+			if err != nil || size < 0 {
+				r.state = stateDone
+				continue
+			}
+			if size == 0 {
+				r.state = stateTrailers
+			} else {
+				r.remaining = size
+				r.state = stateChunkData
+			}
+
+		case stateChunkData:
+			n := min(int64(len(data)), r.remaining)
+			r.size += n
+			r.remaining -= n
+			data = data[n:]
+			if r.remaining == 0 {
+				r.state = stateChunkEnd
+			}
+
+		case stateChunkEnd:
+			_, rest, ok := r.line(data)
+			data = rest
+			if ok {
+				r.state = stateChunkSize
+			}
+
+		case stateTrailers:
+			line, rest, ok := r.line(data)
+			data = rest
+			if ok && len(line) == 0 {
+				r.done()
+			}
+
+		case stateUntilClose:
+			r.size += int64(len(data))
+			data = nil
+		}
+	}
+}
+
+// close ends a body delimited by the connection closing
+func (r *reader) close() {
+	if r.state == stateUntilClose {
+		r.done()
+	}
+	r.state = stateDone
+}
+
+func (r *reader) done() {
+	size := r.size
+	r.state, r.size, r.remaining = stateHead, 0, 0
+	r.onDone(size)
+}
+
+// line returns the next CRLF terminated line, buffering a partial one
+func (r *reader) line(data []byte) (line, rest []byte, ok bool) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		r.buffer(data)
+		return nil, nil, false
+	}
+
+	line = data[:i]
+	if len(r.buf) > 0 {
+		line = append(r.buf, line...)
+		r.buf = nil
+	}
+	return bytes.TrimSuffix(line, []byte("\r")), data[i+1:], true
+}
+
+// buffer keeps bytes of an incomplete line or head, giving up on the stream
+// when there are too many
+func (r *reader) buffer(data []byte) {
+	if len(r.buf)+len(data) > maxHeaderBytes {
+		r.state, r.buf = stateDone, nil
+		return
+	}
+	r.buf = append(r.buf, data...)
+}
+
+// readHead reads a message head, returning what follows it once complete
+func (r *reader) readHead(data []byte) []byte {
+	buffered := len(r.buf)
+	r.buf = append(r.buf, data...)
+	end := bytes.Index(r.buf, []byte("\r\n\r\n"))
+	sep := 4
+	if lf := bytes.Index(r.buf, []byte("\n\n")); lf >= 0 && (end < 0 || lf < end) {
+		end, sep = lf, 2
+	}
+	if end < 0 {
+		if len(r.buf) > maxHeaderBytes {
+			r.state, r.buf = stateDone, nil
+		}
+		return nil
+	}
+
+	h, ok := parseHead(r.buf[:end])
+	rest := data[end+sep-buffered:]
+	r.buf = nil
+	if !ok {
+		r.state = stateDone
+		return nil
+	}
+
+	switch r.onHead(h, r.started) {
+	case bodyNone:
+		r.done()
+	case bodyLength:
+		r.remaining = h.length()
+		r.state = stateBody
+		if r.remaining == 0 {
+			r.done()
+		}
+	case bodyChunked:
+		r.state = stateChunkSize
+	case bodyUntilClose:
+		r.state = stateUntilClose
+	case bodyTunnel:
+		r.done()
+		r.state = stateDone
+	}
+	return rest
+}
+
+// parseHead splits a start line and headers. Repeated headers are joined
+// with commas
+func parseHead(b []byte) (*head, bool) {
+	lines := strings.Split(strings.ReplaceAll(string(b), "\r\n", "\n"), "\n")
+	start := strings.SplitN(lines[0], " ", 3)
+	if len(start) < 2 {
+		return nil, false
+	}
+	for len(start) < 3 {
+		start = append(start, "")
+	}
+
+	h := &head{start: start, headers: map[string]string{}}
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if prev, ok := h.headers[name]; ok {
+			value = prev + ", " + value
+		}
+		h.headers[name] = value
+	}
+	return h, true
+}
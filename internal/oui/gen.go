@@ -0,0 +1,141 @@
+//go:build ignore
+
+// gen.go writes manuf.gz, the embedded vendor table, from the IEEE MA-L
+// registry that gopacket ships in its macs package. Short names are derived
+// from the registered names the way Wireshark's make-manuf.py does it
+//
+//	go generate ./internal/oui
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/gopacket/gopacket/macs"
+)
+
+// extra are prefixes seen on LANs that are not in the registry, with their
+// short and long names
+var extra = map[[3]byte][2]string{
+	{0x52, 0x54, 0x00}: {"QEMU", "QEMU/KVM virtual NIC"},
+}
+
+// shortLen is how long short names may be, as in Wireshark
+const shortLen = 12
+
+var (
+	punctuation = regexp.MustCompile(`["',./:()+-]`)
+	spaces      = regexp.MustCompile(`\s+`)
+	// generalTerms are business types and other words that say nothing
+	// about the vendor
+	generalTerms = regexp.MustCompile(`(?i)` + strings.Join([]string{
+		` a +s\b`, ` ab\b`, ` ag\b`, ` b ?v\b`, ` closed joint stock company\b`,
+		` co\b`, ` company\b`, ` corp\b`, ` corporation\b`, ` corporate\b`,
+		` de c ?v\b`, ` gmbh\b`, ` holding\b`, ` inc\b`, ` incorporated\b`,
+		` jsc\b`, ` kg\b`, ` k k\b`, ` limited\b`, ` llc\b`, ` ltd\b`, ` n ?v\b`,
+		` oao\b`, ` of\b`, ` open joint stock company\b`, ` ooo\b`, ` oü\b`,
+		` oy\b`, ` oyj\b`, ` plc\b`, ` pty\b`, ` pvt\b`, ` s ?a ?r ?l\b`,
+		` s ?a\b`, ` s ?p ?a\b`, ` sp ?k\b`, ` s ?r ?l\b`, ` systems\b`,
+		`\bthe\b`, ` zao\b`, ` z ?o ?o\b`,
+	}, "|"))
+)
+
+func main() {
+	vendors := map[[3]byte][2]string{}
+	for prefix, name := range macs.ValidMACPrefixMap {
+		name = strings.Join(strings.Fields(name), " ")
+		vendors[prefix] = [2]string{shorten(name), name}
+	}
+	for prefix, names := range extra {
+		vendors[prefix] = names
+	}
+
+	prefixes := make([][3]byte, 0, len(vendors))
+	for prefix := range vendors {
+		prefixes = append(prefixes, prefix)
+	}
+	slices.SortFunc(prefixes, func(a, b [3]byte) int { return bytes.Compare(a[:], b[:]) })
+
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintln(zw, "# OUI vendor table, in the format of Wireshark's manuf file:")
+	fmt.Fprintln(zw, "#")
+	fmt.Fprintln(zw, "#   <prefix><TAB><short name><TAB><long name>")
+	fmt.Fprintln(zw, "#")
+	fmt.Fprintln(zw, "# Generated by gen.go from the IEEE MA-L registry, don't edit")
+	for _, prefix := range prefixes {
+		names := vendors[prefix]
+		fmt.Fprintf(zw, "%02X:%02X:%02X\t%s\t%s\n", prefix[0], prefix[1], prefix[2], names[0], names[1])
+	}
+	if err := zw.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile("manuf.gz", buf.Bytes(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// shorten turns a registered name into a short name, e.g. "Apple, Inc." into
+// Apple, following make-manuf.py
+func shorten(name string) string {
+	s := " " + name + " "
+	if isUpper(s) {
+		s = title(s)
+	}
+	s = punctuation.ReplaceAllString(s, " ")
+	s = strings.ReplaceAll(s, " & ", " ")
+
+	// Unless nothing would be left
+	if plain := generalTerms.ReplaceAllString(s, ""); strings.TrimSpace(plain) != "" {
+		s = plain
+	}
+
+	s = spaces.ReplaceAllString(s, "")
+	if r := []rune(s); len(r) > shortLen {
+		s = string(r[:shortLen])
+	}
+	return s
+}
+
+// isUpper is Python's str.isupper, true when s has cased letters and all of
+// them are upper case
+func isUpper(s string) bool {
+	cased := false
+	for _, r := range s {
+		if unicode.IsLower(r) {
+			return false
+		}
+		cased = cased || unicode.IsUpper(r)
+	}
+	return cased
+}
+
+// title is Python's str.title, upper casing the first letter of every run of
+// letters and lower casing the rest
+func title(s string) string {
+	var b strings.Builder
+	prev := false
+	for _, r := range s {
+		letter := unicode.IsLetter(r)
+		switch {
+		case letter && !prev:
+			r = unicode.ToUpper(r)
+		case letter:
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+		prev = letter
+	}
+	return b.String()
+}
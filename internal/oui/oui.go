@@ -0,0 +1,143 @@
+// Package oui resolves the vendor of a MAC address from the first three
+// octets, its Organizationally Unique Identifier
+package oui
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+//go:generate go run gen.go
+
+// embedded is the IEEE registry as a gzipped manuf file, see gen.go
+//
+//go:embed manuf.gz
+var embedded []byte
+
+// Table maps an OUI to the short name of its vendor
+type Table map[[3]byte]string
+
+// defaultTable is used by Vendor and Name, Load adds to it
+var defaultTable = mustParse(embedded)
+
+// Parse reads a table in the format of Wireshark's manuf file, a prefix and a
+// short name separated by whitespace on each line. Lines starting with # are
+// comments. Prefixes longer than 24 bits, e.g. 00:1B:C5:00:00:00/36, are
+// skipped since only the OUI is looked up
+func Parse(r io.Reader) (Table, error) {
+	t := Table{}
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected a prefix and a name", line)
+		}
+
+		prefix, bits, masked := strings.Cut(fields[0], "/")
+		if masked && bits != "24" {
+			continue
+		}
+		oui, err := parseOUI(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		t[oui] = fields[1]
+	}
+
+	return t, s.Err()
+}
+
+func mustParse(gz []byte) Table {
+	zr, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		panic(err)
+	}
+	t, err := Parse(zr)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+var separators = strings.NewReplacer(":", "", "-", "", ".", "")
+
+// parseOUI reads the first three octets of a prefix written with colons,
+// dashes or dots, e.g. 00:1B:63, 00-1B-63 or 00:1B:63:00:00:00
+func parseOUI(prefix string) ([3]byte, error) {
+	var oui [3]byte
+	digits := separators.Replace(prefix)
+	if len(digits) < 6 {
+		return oui, fmt.Errorf("invalid prefix %q", prefix)
+	}
+	if _, err := hex.Decode(oui[:], []byte(digits[:6])); err != nil {
+		return oui, fmt.Errorf("invalid prefix %q", prefix)
+	}
+	return oui, nil
+}
+
+// Load adds the vendors in a manuf file to the table, replacing the embedded
+// names where both have a prefix
+func Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	t, err := Parse(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for k, v := range t {
+		defaultTable[k] = v
+	}
+	return nil
+}
+
+// Vendor returns the vendor of mac, or "" when it is unknown or mac is not a
+// valid address
+func (t Table) Vendor(mac string) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) < 3 {
+		return ""
+	}
+	return t[[3]byte{hw[0], hw[1], hw[2]}]
+}
+
+// Name renders mac the way Wireshark does with name resolution, the vendor
+// followed by the last three octets, e.g. Apple_12:34:56. Unknown vendors and
+// the broadcast address are returned as they are
+func (t Table) Name(mac string) string {
+	vendor := t.Vendor(mac)
+	if vendor == "" {
+		return mac
+	}
+	hw, _ := net.ParseMAC(mac)
+	return fmt.Sprintf("%s_%02x:%02x:%02x", vendor, hw[len(hw)-3], hw[len(hw)-2], hw[len(hw)-1])
+}
+
+// Vendor looks mac up in the embedded table and anything added with Load
+func Vendor(mac string) string {
+	return defaultTable.Vendor(mac)
+}
+
+// Name renders mac with its vendor from the embedded table and anything
+// added with Load, see Table.Name
+func Name(mac string) string {
+	return defaultTable.Name(mac)
+}
@@ -0,0 +1,62 @@
+package oui
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbedded(t *testing.T) {
+	assert.Equal(t, "Apple", Vendor("00:03:93:12:34:56"))
+	assert.Equal(t, "Apple_12:34:56", Name("00:03:93:12:34:56"))
+	assert.Equal(t, "VMware_ab:cd:ef", Name("00-50-56-AB-CD-EF"))
+	// The whole registry, with Wireshark's short names
+	assert.Equal(t, "Apple_00:00:01", Name("f0:18:98:00:00:01"))
+	assert.Equal(t, "Apple", Vendor("bc:d0:74:00:00:01"))
+	assert.Equal(t, "RaspberryPiT", Vendor("dc:a6:32:00:00:01"))
+	assert.Equal(t, "QEMU", Vendor("52:54:00:12:34:56"))
+	assert.Greater(t, len(defaultTable), 30000)
+	assert.Equal(t, "ff:ff:ff:ff:ff:ff", Name("ff:ff:ff:ff:ff:ff"))
+	assert.Equal(t, "", Vendor("not a mac"))
+	assert.Equal(t, "", Name(""))
+}
+
+func TestParse(t *testing.T) {
+	tbl, err := Parse(strings.NewReader(`# comment
+
+00:00:0C	Cisco	Cisco Systems, Inc
+00-1B-63 Apple
+00:1B:C5:00:00:00/36	Skipped
+AA:BB:CC:00:00:00/24	Example
+`))
+	require.NoError(t, err)
+	assert.Len(t, tbl, 3)
+	assert.Equal(t, "Cisco", tbl.Vendor("00:00:0c:01:02:03"))
+	assert.Equal(t, "Apple", tbl.Vendor("00:1b:63:01:02:03"))
+	assert.Equal(t, "Example", tbl.Vendor("aa:bb:cc:01:02:03"))
+	assert.Equal(t, "", tbl.Vendor("00:1b:c5:00:00:01"))
+}
+
+func TestParse_Errors(t *testing.T) {
+	_, err := Parse(strings.NewReader("00:00:0C\n"))
+	assert.ErrorContains(t, err, "line 1")
+
+	_, err = Parse(strings.NewReader("# ok\nzz:00:0C Bad\n"))
+	assert.ErrorContains(t, err, "line 2: invalid prefix")
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manuf")
+	require.NoError(t, os.WriteFile(path, []byte("02:00:00\tLab\n"), 0o644))
+	t.Cleanup(func() { delete(defaultTable, [3]byte{0x02, 0x00, 0x00}) })
+
+	require.NoError(t, Load(path))
+	assert.Equal(t, "Lab_00:00:01", Name("02:00:00:00:00:01"))
+	assert.Equal(t, "Apple", Vendor("00:03:93:00:00:01"))
+
+	assert.Error(t, Load(filepath.Join(t.TempDir(), "missing")))
+}
@@ -0,0 +1,335 @@
+package output
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// Verbosity levels of PrintDissection, set with -v and -vv
+const (
+	VerbosityLayers = 1 // the main fields of every layer
+	VerbosityFull   = 2 // adds checksums, header lengths and DNS flags and sections
+)
+
+// dissectIndent prefixes every line of a dissection, so it reads as belonging
+// to the packet line above it
+const dissectIndent = "    "
+
+// PrintDissection writes a per-layer breakdown of the packet to w, in the
+// spirit of tcpdump -v. With hexDump the bytes from the network layer on are
+// added as a hex and ASCII dump, like tcpdump -X
+func PrintDissection(w io.Writer, p gopacket.Packet, verbosity int, hexDump bool) error {
+	var b strings.Builder
+	if verbosity > 0 {
+		for _, l := range p.Layers() {
+			dissectLayer(&b, l, verbosity)
+		}
+		if err := p.ErrorLayer(); err != nil {
+			fmt.Fprintf(&b, "%sdecode error: %v\n", dissectIndent, err.Error())
+		}
+	}
+	if hexDump {
+		data := p.Data()
+		if link := p.LinkLayer(); link != nil {
+			data = data[min(len(link.LayerContents()), len(data)):]
+		}
+		writeHexDump(&b, data)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dissectLayer(b *strings.Builder, l gopacket.Layer, verbosity int) {
+	line := func(format string, args ...any) {
+		b.WriteString(dissectIndent)
+		fmt.Fprintf(b, format, args...)
+		b.WriteByte('\n')
+	}
+
+	switch l := l.(type) {
+	case *layers.Ethernet:
+		line(
+			"Ethernet %s > %s, ethertype %s (0x%04x)",
+			l.SrcMAC,
+			l.DstMAC,
+			l.EthernetType,
+			uint16(l.EthernetType),
+		)
+	case *layers.Dot1Q:
+		line("802.1Q vlan %d, priority %d, ethertype %s", l.VLANIdentifier, l.Priority, l.Type)
+	case *layers.IPv4:
+		flags := l.Flags.String()
+		if flags == "" {
+			flags = "none"
+		}
+		line(
+			"IPv4 %s > %s, ttl %d, id %d, flags [%s], offset %d, dscp %d, ecn %d, length %d, proto %s (%d)",
+			l.SrcIP,
+			l.DstIP,
+			l.TTL,
+			l.Id,
+			flags,
+			l.FragOffset,
+			l.TOS>>2,
+			l.TOS&0x3,
+			l.Length,
+			l.Protocol,
+			uint8(l.Protocol),
+		)
+		if verbosity >= VerbosityFull {
+			line("  header length %d, checksum 0x%04x, options %d", int(l.IHL)*4, l.Checksum, len(l.Options))
+		}
+	case *layers.IPv6:
+		line(
+			"IPv6 %s > %s, hop limit %d, flow 0x%05x, dscp %d, ecn %d, payload length %d, next header %s (%d)",
+			l.SrcIP,
+			l.DstIP,
+			l.HopLimit,
+			l.FlowLabel,
+			l.TrafficClass>>2,
+			l.TrafficClass&0x3,
+			l.Length,
+			l.NextHeader,
+			uint8(l.NextHeader),
+		)
+	case *layers.TCP:
+		line(
+			"TCP %d > %d, flags %s, seq %d, ack %d, win %d, options [%s]",
+			uint16(l.SrcPort),
+			uint16(l.DstPort),
+			tcpFlags(l),
+			l.Seq,
+			l.Ack,
+			l.Window,
+			tcpOptions(l.Options),
+		)
+		if verbosity >= VerbosityFull {
+			line(
+				"  header length %d, checksum 0x%04x, urgent %d",
+				int(l.DataOffset)*4,
+				l.Checksum,
+				l.Urgent,
+			)
+		}
+	case *layers.UDP:
+		line(
+			"UDP %d > %d, length %d, checksum 0x%04x",
+			uint16(l.SrcPort),
+			uint16(l.DstPort),
+			l.Length,
+			l.Checksum,
+		)
+	case *layers.ICMPv4:
+		line("ICMPv4 %s, id %d, seq %d", l.TypeCode, l.Id, l.Seq)
+		if verbosity >= VerbosityFull {
+			line("  checksum 0x%04x", l.Checksum)
+		}
+	case *layers.ICMPv6:
+		line("ICMPv6 %s", l.TypeCode)
+		if verbosity >= VerbosityFull {
+			line("  checksum 0x%04x", l.Checksum)
+		}
+	case *layers.ARP:
+		var op string
+		switch l.Operation {
+		case layers.ARPRequest:
+			op = "request"
+		case layers.ARPReply:
+			op = "reply"
+		default:
+			op = strconv.Itoa(int(l.Operation))
+		}
+		line(
+			"ARP %s, sender %v (%v), target %v (%v)",
+			op,
+			net.IP(l.SourceProtAddress), net.HardwareAddr(l.SourceHwAddress),
+			net.IP(l.DstProtAddress), net.HardwareAddr(l.DstHwAddress),
+		)
+	case *layers.DNS:
+		dissectDNS(line, l, verbosity)
+	case *gopacket.Payload:
+		line("Payload %d bytes", len(l.Payload()))
+	default:
+		line("%s %d bytes", l.LayerType(), len(l.LayerContents()))
+	}
+}
+
+func dissectDNS(line func(string, ...any), d *layers.DNS, verbosity int) {
+	kind := "query"
+	if d.QR {
+		kind = "response"
+	}
+	line(
+		"DNS %s, id 0x%04x, opcode %s, rcode %s, %d questions, %d answers, %d authorities, %d additionals",
+		kind,
+		d.ID,
+		d.OpCode,
+		d.ResponseCode,
+		len(d.Questions),
+		len(d.Answers),
+		len(d.Authorities),
+		len(d.Additionals),
+	)
+	if verbosity >= VerbosityFull {
+		var flags []string
+		for _, f := range []struct {
+			set  bool
+			name string
+		}{{d.AA, "aa"}, {d.TC, "tc"}, {d.RD, "rd"}, {d.RA, "ra"}} {
+			if f.set {
+				flags = append(flags, f.name)
+			}
+		}
+		line("  flags [%s]", strings.Join(flags, " "))
+	}
+
+	for _, q := range d.Questions {
+		line("  question %s %s %s", q.Name, q.Type, q.Class)
+	}
+
+	records := func(section string, rrs []layers.DNSResourceRecord) {
+		for _, rr := range rrs {
+			line(
+				"  %s %s %s %s ttl %d %s",
+				section,
+				rr.Name,
+				rr.Type,
+				rr.Class,
+				rr.TTL,
+				dnsRecordData(&rr),
+			)
+		}
+	}
+	records("answer", d.Answers)
+	if verbosity >= VerbosityFull {
+		records("authority", d.Authorities)
+		records("additional", d.Additionals)
+	}
+}
+
+// dnsRecordData renders the data of a record, going a little further than
+// DNSResourceRecord.String for the common types
+func dnsRecordData(rr *layers.DNSResourceRecord) string {
+	switch rr.Type {
+	case layers.DNSTypeA, layers.DNSTypeAAAA:
+		return rr.IP.String()
+	case layers.DNSTypeCNAME:
+		return string(rr.CNAME)
+	case layers.DNSTypeNS:
+		return string(rr.NS)
+	case layers.DNSTypePTR:
+		return string(rr.PTR)
+	case layers.DNSTypeMX:
+		return fmt.Sprintf("%d %s", rr.MX.Preference, rr.MX.Name)
+	case layers.DNSTypeTXT:
+		txts := make([]string, len(rr.TXTs))
+		for i, t := range rr.TXTs {
+			txts[i] = fmt.Sprintf("%q", t)
+		}
+		return strings.Join(txts, " ")
+	case layers.DNSTypeSOA:
+		return fmt.Sprintf("%s %s %d", rr.SOA.MName, rr.SOA.RName, rr.SOA.Serial)
+	case layers.DNSTypeSRV:
+		return fmt.Sprintf("%d %d %d %s", rr.SRV.Priority, rr.SRV.Weight, rr.SRV.Port, rr.SRV.Name)
+	default:
+		return rr.String()
+	}
+}
+
+// tcpFlags renders the flags of a TCP header like tcpdump, see TCPFlagString.
+// The rarer URG, ECE and CWR flags are shown as U, E and W
+func tcpFlags(t *layers.TCP) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for _, f := range []struct {
+		set bool
+		c   byte
+	}{
+		{t.SYN, 'S'}, {t.FIN, 'F'}, {t.RST, 'R'}, {t.PSH, 'P'},
+		{t.URG, 'U'}, {t.ECE, 'E'}, {t.CWR, 'W'}, {t.ACK, '.'},
+	} {
+		if f.set {
+			b.WriteByte(f.c)
+		}
+	}
+	if b.Len() == 1 {
+		b.WriteString("none")
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// tcpOptions renders TCP options the way tcpdump does, e.g.
+// mss 1460,sackOK,TS val 1 ecr 0,nop,wscale 7
+func tcpOptions(opts []layers.TCPOption) string {
+	parts := make([]string, 0, len(opts))
+	for _, o := range opts {
+		switch {
+		case o.OptionType == layers.TCPOptionKindNop:
+			parts = append(parts, "nop")
+		case o.OptionType == layers.TCPOptionKindEndList:
+			parts = append(parts, "eol")
+		case o.OptionType == layers.TCPOptionKindMSS && len(o.OptionData) == 2:
+			parts = append(parts, fmt.Sprintf("mss %d", binary.BigEndian.Uint16(o.OptionData)))
+		case o.OptionType == layers.TCPOptionKindWindowScale && len(o.OptionData) == 1:
+			parts = append(parts, fmt.Sprintf("wscale %d", o.OptionData[0]))
+		case o.OptionType == layers.TCPOptionKindSACKPermitted:
+			parts = append(parts, "sackOK")
+		case o.OptionType == layers.TCPOptionKindSACK:
+			var blocks []string
+			for d := o.OptionData; len(d) >= 8; d = d[8:] {
+				left, right := binary.BigEndian.Uint32(d), binary.BigEndian.Uint32(d[4:])
+				blocks = append(blocks, fmt.Sprintf("{%d:%d}", left, right))
+			}
+			parts = append(parts, "sack "+strings.Join(blocks, ""))
+		case o.OptionType == layers.TCPOptionKindTimestamps && len(o.OptionData) == 8:
+			parts = append(parts, fmt.Sprintf(
+				"TS val %d ecr %d",
+				binary.BigEndian.Uint32(o.OptionData[:4]),
+				binary.BigEndian.Uint32(o.OptionData[4:]),
+			))
+		default:
+			parts = append(parts, fmt.Sprintf("%s len %d", o.OptionType, o.OptionLength))
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// writeHexDump writes data 16 bytes a line in the tcpdump -X layout:
+// offset, eight groups of two bytes, then the printable ASCII
+func writeHexDump(b *strings.Builder, data []byte) {
+	for off := 0; off < len(data); off += 16 {
+		row := data[off:min(off+16, len(data))]
+
+		fmt.Fprintf(b, "%s0x%04x:  ", dissectIndent, off)
+		for i := range 16 {
+			if i < len(row) {
+				fmt.Fprintf(b, "%02x", row[i])
+			} else {
+				b.WriteString("  ")
+			}
+			if i%2 == 1 {
+				b.WriteByte(' ')
+			}
+		}
+
+		b.WriteByte(' ')
+		for _, c := range row {
+			if c >= 0x20 && c < 0x7f {
+				b.WriteByte(c)
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+}
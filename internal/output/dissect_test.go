@@ -0,0 +1,193 @@
+package output
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testSrcMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	testDstMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+func buildPacket(t *testing.T, ls ...gopacket.SerializableLayer) gopacket.Packet {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	for _, l := range ls {
+		if nl, ok := l.(interface {
+			SetNetworkLayerForChecksum(gopacket.NetworkLayer) error
+		}); ok {
+			for _, n := range ls {
+				if ip, ok := n.(gopacket.NetworkLayer); ok {
+					require.NoError(t, nl.SetNetworkLayerForChecksum(ip))
+				}
+			}
+		}
+	}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, ls...))
+	return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+}
+
+func tcpSyn(t *testing.T, payload []byte) gopacket.Packet {
+	return buildPacket(t,
+		&layers.Ethernet{SrcMAC: testSrcMAC, DstMAC: testDstMAC, EthernetType: layers.EthernetTypeIPv4},
+		&layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Id:       4321,
+			Flags:    layers.IPv4DontFragment,
+			TOS:      0xb8, // dscp 46, ecn 0
+			Protocol: layers.IPProtocolTCP,
+			SrcIP:    net.IP{192, 168, 0, 1},
+			DstIP:    net.IP{10, 0, 0, 1},
+		},
+		&layers.TCP{
+			SrcPort: 50000,
+			DstPort: 443,
+			Seq:     1000,
+			SYN:     true,
+			Window:  64240,
+			Options: []layers.TCPOption{
+				{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}},
+				{OptionType: layers.TCPOptionKindNop},
+				{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{7}},
+				{OptionType: layers.TCPOptionKindNop},
+				{OptionType: layers.TCPOptionKindNop},
+				{OptionType: layers.TCPOptionKindSACKPermitted, OptionLength: 2},
+			},
+		},
+		gopacket.Payload(payload),
+	)
+}
+
+// ******************************
+// PrintDissection
+// ******************************
+
+func TestPrintDissection_TCP(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, PrintDissection(&buf, tcpSyn(t, nil), VerbosityLayers, false))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t,
+		"    Ethernet 02:00:00:00:00:01 > 02:00:00:00:00:02, ethertype IPv4 (0x0800)",
+		lines[0],
+	)
+	assert.Equal(t,
+		"    IPv4 192.168.0.1 > 10.0.0.1, ttl 64, id 4321, flags [DF], offset 0, dscp 46, ecn 0, length 52, proto TCP (6)",
+		lines[1],
+	)
+	assert.Equal(t,
+		"    TCP 50000 > 443, flags [S], seq 1000, ack 0, win 64240, options [mss 1460,nop,wscale 7,nop,nop,sackOK]",
+		lines[2],
+	)
+}
+
+func TestPrintDissection_Full(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, PrintDissection(&buf, tcpSyn(t, nil), VerbosityFull, false))
+
+	out := buf.String()
+	assert.Contains(t, out, "      header length 20, checksum 0x")
+	assert.Contains(t, out, "      header length 32, checksum 0x")
+}
+
+func TestPrintDissection_DNS(t *testing.T) {
+	p := buildPacket(t,
+		&layers.Ethernet{SrcMAC: testSrcMAC, DstMAC: testDstMAC, EthernetType: layers.EthernetTypeIPv4},
+		&layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolUDP,
+			SrcIP:    net.IP{8, 8, 8, 8},
+			DstIP:    net.IP{192, 168, 0, 1},
+		},
+		&layers.UDP{SrcPort: 53, DstPort: 40000},
+		&layers.DNS{
+			ID: 0x1234,
+			QR: true,
+			RD: true,
+			RA: true,
+			Questions: []layers.DNSQuestion{
+				{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN},
+			},
+			Answers: []layers.DNSResourceRecord{
+				{
+					Name:  []byte("example.com"),
+					Type:  layers.DNSTypeA,
+					Class: layers.DNSClassIN,
+					TTL:   300,
+					IP:    net.IP{93, 184, 216, 34},
+				},
+			},
+		},
+	)
+
+	var buf bytes.Buffer
+	require.NoError(t, PrintDissection(&buf, p, VerbosityLayers, false))
+	out := buf.String()
+	assert.Contains(t, out, "    UDP 53 > 40000, length 64, checksum 0x")
+	assert.Contains(t, out, "    DNS response, id 0x1234, opcode Query, rcode No Error, 1 questions, 1 answers")
+	assert.Contains(t, out, "      question example.com A IN\n")
+	assert.Contains(t, out, "      answer example.com A IN ttl 300 93.184.216.34\n")
+	assert.NotContains(t, out, "flags [rd")
+
+	buf.Reset()
+	require.NoError(t, PrintDissection(&buf, p, VerbosityFull, false))
+	assert.Contains(t, buf.String(), "      flags [rd ra]\n")
+}
+
+func TestPrintDissection_ARP(t *testing.T) {
+	arpPacket := func(op uint16) gopacket.Packet {
+		return buildPacket(t,
+			&layers.Ethernet{SrcMAC: testSrcMAC, DstMAC: testDstMAC, EthernetType: layers.EthernetTypeARP},
+			&layers.ARP{
+				AddrType:          layers.LinkTypeEthernet,
+				Protocol:          layers.EthernetTypeIPv4,
+				HwAddressSize:     6,
+				ProtAddressSize:   4,
+				Operation:         op,
+				SourceHwAddress:   testSrcMAC,
+				SourceProtAddress: []byte{192, 168, 0, 1},
+				DstHwAddress:      testDstMAC,
+				DstProtAddress:    []byte{192, 168, 0, 2},
+			},
+		)
+	}
+
+	for _, tt := range []struct {
+		op   uint16
+		want string
+	}{
+		{layers.ARPRequest, "ARP request, sender 192.168.0.1 (02:00:00:00:00:01)"},
+		{layers.ARPReply, "ARP reply, sender 192.168.0.1 (02:00:00:00:00:01)"},
+		// RARP and the other opcodes are not requests
+		{3, "ARP 3, sender 192.168.0.1 (02:00:00:00:00:01)"},
+	} {
+		var buf bytes.Buffer
+		require.NoError(t, PrintDissection(&buf, arpPacket(tt.op), VerbosityLayers, false))
+		assert.Contains(t, buf.String(), tt.want)
+	}
+}
+
+func TestPrintDissection_Hex(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, PrintDissection(&buf, tcpSyn(t, []byte("hello")), 0, true))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	// 20 bytes of IPv4, 32 of TCP and 5 of payload, the Ethernet header is
+	// left out
+	require.Len(t, lines, 4)
+	assert.True(t, strings.HasPrefix(lines[0], "    0x0000:  45b8 0039 10e1 4000 4006 "), lines[0])
+	assert.True(t, strings.HasPrefix(lines[3], "    0x0030:  0101 0402 6865 6c6c 6f"), lines[3])
+	assert.True(t, strings.HasSuffix(lines[3], "hello"), lines[3])
+}
@@ -0,0 +1,104 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"packeteer/internal/oui"
+	"packeteer/internal/packet"
+	"packeteer/internal/storage"
+)
+
+// ARPSummary renders an ARP packet the way tcpdump does, e.g.
+// who-has 10.0.0.1 tell 10.0.0.5, or 10.0.0.1 is-at Cisco_00:00:01
+func ARPSummary(a *packet.ARPInfo) string {
+	switch {
+	case a.Probe():
+		return fmt.Sprintf("probe who-has %s from %s", a.TargetIP, oui.Name(a.SenderMAC))
+	case a.Gratuitous():
+		return fmt.Sprintf("gratuitous %s %s is-at %s", a.Operation, a.SenderIP, oui.Name(a.SenderMAC))
+	case a.Operation == "request":
+		return fmt.Sprintf("who-has %s tell %s", a.TargetIP, a.SenderIP)
+	case a.Operation == "reply":
+		return fmt.Sprintf("%s is-at %s", a.SenderIP, oui.Name(a.SenderMAC))
+	default:
+		return fmt.Sprintf("%s %s > %s", a.Operation, a.SenderIP, a.TargetIP)
+	}
+}
+
+// PrintARPTable writes the learned ARP bindings to w as a table. An IP held
+// by more than one MAC on the same interface and VLAN is marked as a conflict
+func PrintARPTable(w io.Writer, entries []storage.ARPEntry) error {
+	type segment struct {
+		ip, iface string
+		vlan      int
+	}
+	macs := map[segment]int{}
+	for _, e := range entries {
+		macs[segment{e.IP, e.Interface, e.VLAN}]++
+	}
+
+	tw := tabwriter.NewWriter(w, 3, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "IP\tMAC\tVENDOR\tINTERFACE\tVLAN\tFIRST SEEN\tLAST SEEN\tPACKETS\tNOTE")
+	for _, e := range entries {
+		vlan := "-"
+		if e.VLAN != 0 {
+			vlan = strconv.Itoa(e.VLAN)
+		}
+		note := "-"
+		if macs[segment{e.IP, e.Interface, e.VLAN}] > 1 {
+			note = "conflict"
+		}
+
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			e.IP,
+			e.MAC,
+			orDash(oui.Vendor(e.MAC)),
+			orDash(e.Interface),
+			vlan,
+			e.FirstSeen.Local().Format(time.DateTime),
+			e.LastSeen.Local().Format(time.DateTime),
+			e.Packets,
+			note,
+		)
+	}
+
+	return tw.Flush()
+}
+
+// PrintARPTableJSON writes the learned ARP bindings to w as a JSON array
+func PrintARPTableJSON(w io.Writer, entries []storage.ARPEntry) error {
+	if entries == nil {
+		entries = []storage.ARPEntry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// PrintARPAlerts writes the stored ARP alerts to w, one per line
+func PrintARPAlerts(w io.Writer, alerts []storage.ARPAlert) error {
+	tw := tabwriter.NewWriter(w, 3, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tKIND\tMESSAGE")
+	for _, a := range alerts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", a.Timestamp.Local().Format(time.DateTime), a.Kind, a.Message)
+	}
+
+	return tw.Flush()
+}
+
+// PrintARPAlertsJSON writes the stored ARP alerts to w as a JSON array
+func PrintARPAlertsJSON(w io.Writer, alerts []storage.ARPAlert) error {
+	if alerts == nil {
+		alerts = []storage.ARPAlert{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(alerts)
+}
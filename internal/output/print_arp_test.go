@@ -0,0 +1,79 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/packet"
+	"packeteer/internal/storage"
+)
+
+// ******************************
+// ARPSummary
+// ******************************
+
+func TestARPSummary(t *testing.T) {
+	a := &packet.ARPInfo{
+		Operation: "request",
+		SenderMAC: "00:03:93:00:00:0a",
+		SenderIP:  "10.0.0.5",
+		TargetMAC: "00:00:00:00:00:00",
+		TargetIP:  "10.0.0.1",
+	}
+	assert.Equal(t, "who-has 10.0.0.1 tell 10.0.0.5", ARPSummary(a))
+
+	a.Operation = "reply"
+	assert.Equal(t, "10.0.0.5 is-at Apple_00:00:0a", ARPSummary(a))
+
+	a.TargetIP = "10.0.0.5"
+	assert.Equal(t, "gratuitous reply 10.0.0.5 is-at Apple_00:00:0a", ARPSummary(a))
+
+	a.SenderIP = "0.0.0.0"
+	assert.Equal(t, "probe who-has 10.0.0.5 from Apple_00:00:0a", ARPSummary(a))
+}
+
+// ******************************
+// PrintARPTable
+// ******************************
+
+func TestPrintARPTable(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []storage.ARPEntry{
+		{
+			IP:        "10.0.0.1",
+			MAC:       "00:00:0c:00:00:01",
+			Interface: "eth0",
+			VLAN:      20,
+			FirstSeen: t0,
+			LastSeen:  t0,
+			Packets:   3,
+		},
+		{IP: "10.0.0.5", MAC: "00:50:56:00:00:0b", Interface: "eth0", FirstSeen: t0, LastSeen: t0},
+		{IP: "10.0.0.5", MAC: "02:00:00:00:00:0a", Interface: "eth0", FirstSeen: t0, LastSeen: t0},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, PrintARPTable(&buf, entries))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+
+	assert.Equal(
+		t,
+		"IP MAC VENDOR INTERFACE VLAN FIRST SEEN LAST SEEN PACKETS NOTE",
+		strings.Join(strings.Fields(lines[0]), " "),
+	)
+
+	first := strings.Fields(lines[1])
+	assert.Equal(t, "Cisco", first[2])
+	assert.Equal(t, "20", first[4])
+	assert.Equal(t, "-", first[len(first)-1])
+
+	assert.Contains(t, lines[2], "VMware")
+	assert.True(t, strings.HasSuffix(lines[2], "conflict"))
+	assert.True(t, strings.HasSuffix(lines[3], "conflict"))
+}
@@ -0,0 +1,225 @@
+package output
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"charm.land/lipgloss/v2"
+
+	"packeteer/internal/follow"
+)
+
+// Formats of a FollowPrinter
+const (
+	FollowASCII = "ascii" // printable text, other bytes as dots
+	FollowHex   = "hex"   // offsets, hex and ASCII, the server's indented
+	FollowYAML  = "yaml"  // base64 chunks with their peer, offset and time
+)
+
+// followRule frames the header and footer of the text formats
+var followRule = strings.Repeat("=", 67)
+
+// followStyles color the client's payload red and the server's blue, like
+// Wireshark does
+var followStyles = map[bool]lipgloss.Style{
+	true:  lipgloss.NewStyle().Foreground(lipgloss.Red),
+	false: lipgloss.NewStyle().Foreground(lipgloss.Blue),
+}
+
+// FollowPrinter writes the payload of a followed conversation to w as it is
+// read, interleaving both sides. Colors are dropped when w is not a terminal
+type FollowPrinter struct {
+	w       io.Writer
+	format  string
+	started bool
+	atLine  bool // the ASCII output is at the start of a line
+	client  bool // the last chunk was the client's
+}
+
+// NewFollowPrinter returns a FollowPrinter writing format to w
+func NewFollowPrinter(w io.Writer, format string) (*FollowPrinter, error) {
+	switch format {
+	case FollowASCII, FollowHex, FollowYAML:
+	default:
+		return nil, fmt.Errorf("unknown follow format %q, use ascii, hex, yaml or raw", format)
+	}
+	return &FollowPrinter{w: w, format: format, atLine: true}, nil
+}
+
+// Chunk writes a chunk of the conversation k, printing the header first
+func (p *FollowPrinter) Chunk(k follow.Key, c follow.Chunk) error {
+	var b strings.Builder
+	if !p.started {
+		p.header(&b, k)
+		if p.format == FollowYAML {
+			b.WriteString("packets:\n")
+		}
+	}
+
+	switch p.format {
+	case FollowASCII:
+		p.ascii(&b, c)
+	case FollowHex:
+		hexChunk(&b, c)
+	case FollowYAML:
+		yamlChunk(&b, c)
+	}
+
+	_, err := lipgloss.Fprint(p.w, b.String())
+	return err
+}
+
+// Close writes the footer of the conversation k, with the bytes each side
+// sent
+func (p *FollowPrinter) Close(k follow.Key, client, server int64) error {
+	var b strings.Builder
+	if !p.started {
+		p.header(&b, k)
+		if p.format == FollowYAML {
+			b.WriteString("packets: []\n")
+		}
+	}
+
+	if p.format != FollowYAML {
+		if !p.atLine {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "%s\n%s:%s sent %d bytes, %s:%s sent %d bytes\n",
+			followRule, k.ClientIP, k.ClientPort, client, k.ServerIP, k.ServerPort, server)
+	}
+
+	_, err := io.WriteString(p.w, b.String())
+	return err
+}
+
+func (p *FollowPrinter) header(b *strings.Builder, k follow.Key) {
+	p.started = true
+	if p.format == FollowYAML {
+		b.WriteString("peers:\n")
+		for i, peer := range [][2]string{{k.ClientIP, k.ClientPort}, {k.ServerIP, k.ServerPort}} {
+			fmt.Fprintf(b, "  - peer: %d\n    host: %s\n    port: %s\n", i, peer[0], peer[1])
+		}
+		return
+	}
+
+	fmt.Fprintf(b, "%s\nFollow: %s %s\n", followRule, k, p.format)
+	fmt.Fprintf(b, "Client: %s:%s\n", k.ClientIP, k.ClientPort)
+	fmt.Fprintf(b, "Server: %s:%s\n%s\n", k.ServerIP, k.ServerPort, followRule)
+}
+
+// PrintConversations writes the TCP and UDP conversations that can be
+// followed to w, with their index
+func PrintConversations(w io.Writer, keys []follow.Key) error {
+	tw := tabwriter.NewWriter(w, 3, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tCONVERSATION")
+	for i, k := range keys {
+		fmt.Fprintf(tw, "%d\t%s\n", i, k)
+	}
+
+	return tw.Flush()
+}
+
+// ascii writes the printable bytes of a chunk, other ones as dots. CRLF line
+// endings become plain newlines
+func (p *FollowPrinter) ascii(b *strings.Builder, c follow.Chunk) {
+	// A side's text starts a line of its own, and so does a gap
+	if !p.atLine && (c.FromClient != p.client || c.Gap) {
+		b.WriteByte('\n')
+	}
+	p.client = c.FromClient
+	style := followStyles[c.FromClient]
+	if c.Gap {
+		b.WriteString(style.Render("[bytes missing]") + "\n")
+	}
+
+	var text strings.Builder
+	for i, ch := range c.Data {
+		switch {
+		case ch == '\r' && i+1 < len(c.Data) && c.Data[i+1] == '\n':
+		case ch == '\n', ch == '\t', ch >= 0x20 && ch < 0x7f:
+			text.WriteByte(ch)
+		default:
+			text.WriteByte('.')
+		}
+	}
+
+	// Styled line by line, as a multi-line render pads every line to the
+	// widest one
+	lines := strings.Split(text.String(), "\n")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		if line != "" {
+			b.WriteString(style.Render(line))
+		}
+	}
+	if len(c.Data) > 0 {
+		p.atLine = c.Data[len(c.Data)-1] == '\n'
+	}
+}
+
+// hexChunk writes a chunk as rows of 16 bytes, numbered by their offset in
+// what the side sent. The server's rows are indented
+func hexChunk(b *strings.Builder, c follow.Chunk) {
+	indent := ""
+	if !c.FromClient {
+		indent = "    "
+	}
+	style := followStyles[c.FromClient]
+	if c.Gap {
+		b.WriteString(indent + style.Render("[bytes missing]") + "\n")
+	}
+
+	for off := 0; off < len(c.Data); off += 16 {
+		row := c.Data[off:min(off+16, len(c.Data))]
+
+		var line strings.Builder
+		fmt.Fprintf(&line, "%08X  ", c.Offset+int64(off))
+		for i := range 16 {
+			if i < len(row) {
+				fmt.Fprintf(&line, "%02x ", row[i])
+			} else {
+				line.WriteString("   ")
+			}
+			if i == 7 {
+				line.WriteByte(' ')
+			}
+		}
+		line.WriteByte(' ')
+		for _, ch := range row {
+			if ch >= 0x20 && ch < 0x7f {
+				line.WriteByte(ch)
+			} else {
+				line.WriteByte('.')
+			}
+		}
+		b.WriteString(indent + style.Render(line.String()) + "\n")
+	}
+}
+
+// yamlChunk writes a chunk as an item of the packets list, the data base64
+// encoded in lines of 76 characters
+func yamlChunk(b *strings.Builder, c follow.Chunk) {
+	peer := 1
+	if c.FromClient {
+		peer = 0
+	}
+	fmt.Fprintf(b, "  - peer: %d\n    offset: %d\n    timestamp: %s\n",
+		peer, c.Offset, c.Time.UTC().Format(time.RFC3339Nano))
+	if c.Gap {
+		b.WriteString("    gap: true\n")
+	}
+
+	data := base64.StdEncoding.EncodeToString(c.Data)
+	b.WriteString("    data: !!binary |\n")
+	for len(data) > 0 {
+		n := min(76, len(data))
+		fmt.Fprintf(b, "      %s\n", data[:n])
+		data = data[n:]
+	}
+}
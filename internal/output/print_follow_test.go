@@ -0,0 +1,115 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/follow"
+	"packeteer/internal/packet"
+)
+
+// ******************************
+// Follow
+// ******************************
+
+var followKey = follow.Key{
+	ClientIP:   "10.0.0.5",
+	ClientPort: "51000",
+	ServerIP:   "10.0.0.9",
+	ServerPort: "80",
+	Protocol:   packet.TCP,
+}
+
+// followChunks are a request split in two and its response
+func followChunks() []follow.Chunk {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []follow.Chunk{
+		{FromClient: true, Time: t0, Data: []byte("GET / HTTP")},
+		{FromClient: true, Offset: 10, Time: t0, Data: []byte("/1.1\r\n\r\n")},
+		{Time: t0.Add(time.Millisecond), Data: []byte("HTTP/1.1 200 OK\r\n\r\nhi\x00"), Gap: true},
+	}
+}
+
+func printFollow(t *testing.T, format string, chunks []follow.Chunk) string {
+	t.Helper()
+	var buf bytes.Buffer
+	p, err := NewFollowPrinter(&buf, format)
+	require.NoError(t, err)
+	for _, c := range chunks {
+		require.NoError(t, p.Chunk(followKey, c))
+	}
+	require.NoError(t, p.Close(followKey, 18, 22))
+	return buf.String()
+}
+
+func TestFollowPrinter_ASCII(t *testing.T) {
+	assert.Equal(t, followRule+`
+Follow: 10.0.0.5:51000-->10.0.0.9:80/TCP ascii
+Client: 10.0.0.5:51000
+Server: 10.0.0.9:80
+`+followRule+`
+GET / HTTP/1.1
+
+[bytes missing]
+HTTP/1.1 200 OK
+
+hi.
+`+followRule+`
+10.0.0.5:51000 sent 18 bytes, 10.0.0.9:80 sent 22 bytes
+`, printFollow(t, FollowASCII, followChunks()))
+}
+
+func TestFollowPrinter_Hex(t *testing.T) {
+	out := printFollow(t, FollowHex, followChunks())
+	assert.Regexp(t, "\n00000000  47 45 54 20 2f 20 48 54  54 50 +GET / HTTP\n", out)
+	assert.Regexp(t, "\n0000000A  2f 31 2e 31 0d 0a 0d 0a +/1\\.1\\.\\.\\.\\.\n", out)
+	assert.Contains(t, out, "\n    [bytes missing]\n    00000000  48 54 54 50")
+	assert.Contains(t, out, "\n    00000010  0a 0d 0a 68 69 00 ")
+}
+
+func TestFollowPrinter_YAML(t *testing.T) {
+	assert.Equal(t, `peers:
+  - peer: 0
+    host: 10.0.0.5
+    port: 51000
+  - peer: 1
+    host: 10.0.0.9
+    port: 80
+packets:
+  - peer: 0
+    offset: 0
+    timestamp: 2024-01-01T00:00:00Z
+    data: !!binary |
+      R0VUIC8gSFRUUA==
+  - peer: 0
+    offset: 10
+    timestamp: 2024-01-01T00:00:00Z
+    data: !!binary |
+      LzEuMQ0KDQo=
+  - peer: 1
+    offset: 0
+    timestamp: 2024-01-01T00:00:00.001Z
+    gap: true
+    data: !!binary |
+      SFRUUC8xLjEgMjAwIE9LDQoNCmhpAA==
+`, printFollow(t, FollowYAML, followChunks()))
+
+	assert.True(t, strings.HasSuffix(printFollow(t, FollowYAML, nil), "port: 80\npackets: []\n"))
+
+	_, err := NewFollowPrinter(&bytes.Buffer{}, "raw")
+	assert.Error(t, err)
+}
+
+func TestPrintConversations(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, PrintConversations(&buf, []follow.Key{followKey}))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, []string{"0", "10.0.0.5:51000-->10.0.0.9:80/TCP"}, strings.Fields(lines[1]))
+}
@@ -0,0 +1,66 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"packeteer/internal/storage"
+)
+
+// PrintHTTPStats writes the transactions grouped by host, status, path or
+// method to w, one group per line
+func PrintHTTPStats(w io.Writer, by string, stats []storage.HTTPStat) error {
+	tw := tabwriter.NewWriter(w, 3, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tREQUESTS\tERRORS\tSENT\tRECEIVED\tAVG LATENCY\tMAX LATENCY\n", strings.ToUpper(by))
+	for _, s := range stats {
+		fmt.Fprintf(
+			tw,
+			"%s\t%d\t%d\t%d\t%d\t%s\t%s\n",
+			orDash(s.Key), s.Transactions, s.Errors, s.RequestBytes, s.ResponseBytes,
+			s.AvgLatency.Round(time.Microsecond), s.MaxLatency.Round(time.Microsecond),
+		)
+	}
+
+	return tw.Flush()
+}
+
+// PrintHTTPStatsJSON writes the grouped transactions to w as a JSON array
+func PrintHTTPStatsJSON(w io.Writer, stats []storage.HTTPStat) error {
+	if stats == nil {
+		stats = []storage.HTTPStat{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(stats)
+}
+
+// PrintHTTPTransactions writes stored transactions to w, one per line
+func PrintHTTPTransactions(w io.Writer, txs []storage.HTTPTransaction) error {
+	tw := tabwriter.NewWriter(w, 3, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tCLIENT\tMETHOD\tURL\tSTATUS\tCONTENT TYPE\tRECEIVED\tLATENCY")
+	for _, tx := range txs {
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%d\t%s\t%d\t%s\n",
+			tx.Timestamp.Local().Format(time.DateTime), tx.ClientIP, tx.Method,
+			tx.Host+tx.Path, tx.Status, orDash(tx.ContentType), tx.ResponseSize,
+			tx.Latency.Round(time.Microsecond),
+		)
+	}
+
+	return tw.Flush()
+}
+
+// PrintHTTPTransactionsJSON writes stored transactions to w as a JSON array
+func PrintHTTPTransactionsJSON(w io.Writer, txs []storage.HTTPTransaction) error {
+	if txs == nil {
+		txs = []storage.HTTPTransaction{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(txs)
+}
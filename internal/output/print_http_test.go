@@ -0,0 +1,55 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/storage"
+)
+
+// ******************************
+// HTTP
+// ******************************
+
+func TestPrintHTTPStats(t *testing.T) {
+	stats := []storage.HTTPStat{
+		{
+			Key:           "api.internal",
+			Transactions:  3,
+			Errors:        1,
+			RequestBytes:  10,
+			ResponseBytes: 2048,
+			AvgLatency:    1500 * time.Microsecond,
+			MaxLatency:    3 * time.Millisecond,
+		},
+		{Transactions: 1},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, PrintHTTPStats(&buf, "host", stats))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "HOST"))
+	assert.Equal(
+		t,
+		[]string{"api.internal", "3", "1", "10", "2048", "1.5ms", "3ms"},
+		strings.Fields(lines[1]),
+	)
+	assert.Equal(t, "-", strings.Fields(lines[2])[0])
+}
+
+func TestPrintHTTPTransactionsJSON_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, PrintHTTPTransactionsJSON(&buf, nil))
+
+	var got []any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Empty(t, got)
+}
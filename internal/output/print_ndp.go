@@ -0,0 +1,138 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"packeteer/internal/oui"
+	"packeteer/internal/packet"
+	"packeteer/internal/storage"
+)
+
+// NDPSummary renders a neighbor discovery message, e.g.
+// who-has 2001:db8::1 from Apple_12:34:56, or for a router advertisement its
+// lifetime and prefixes
+func NDPSummary(n *packet.NDPInfo) string {
+	var s string
+	switch n.Op {
+	case packet.NDPNeighborSolicitation:
+		s = "who-has " + n.TargetIP
+		if n.LinkAddr != "" {
+			s += " from " + oui.Name(n.LinkAddr)
+		}
+	case packet.NDPNeighborAdvertisement:
+		s = n.TargetIP + " is-at "
+		if n.LinkAddr != "" {
+			s += oui.Name(n.LinkAddr)
+		} else {
+			s += "sender"
+		}
+		var flags []string
+		if n.Router {
+			flags = append(flags, "router")
+		}
+		if n.Solicited {
+			flags = append(flags, "solicited")
+		}
+		if n.Override {
+			flags = append(flags, "override")
+		}
+		if len(flags) > 0 {
+			s += " [" + strings.Join(flags, ",") + "]"
+		}
+	case packet.NDPRouterSolicitation:
+		s = "router solicitation"
+		if n.LinkAddr != "" {
+			s += " from " + oui.Name(n.LinkAddr)
+		}
+	case packet.NDPRouterAdvertisement:
+		s = fmt.Sprintf("router advertisement, lifetime %ds", n.RouterLifetime)
+		if n.Managed {
+			s += ", managed"
+		}
+		if n.OtherConfig {
+			s += ", other config"
+		}
+		if len(n.Prefixes) > 0 {
+			s += ", prefixes " + strings.Join(n.Prefixes, ",")
+		}
+		if n.MTU > 0 {
+			s += fmt.Sprintf(", mtu %d", n.MTU)
+		}
+	case packet.NDPRedirect:
+		s = fmt.Sprintf("redirect %s to %s", n.RedirectDest, n.TargetIP)
+	}
+	return s
+}
+
+// IPv6ExtString renders the extension headers of a packet, e.g.
+// hop-by-hop router-alert, fragment id 0x1 offset 0 more
+func IPv6ExtString(hs []packet.IPv6ExtHeader) string {
+	parts := make([]string, len(hs))
+	for i, h := range hs {
+		parts[i] = h.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// PrintNDPTable writes the learned IPv6 neighbors to w as a table. An IP held
+// by more than one MAC on the same interface and VLAN is marked as a conflict
+func PrintNDPTable(w io.Writer, neighbors []storage.NDPNeighbor) error {
+	type segment struct {
+		ip, iface string
+		vlan      int
+	}
+	macs := map[segment]int{}
+	for _, n := range neighbors {
+		macs[segment{n.IP, n.Interface, n.VLAN}]++
+	}
+
+	tw := tabwriter.NewWriter(w, 3, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "IP\tMAC\tVENDOR\tINTERFACE\tVLAN\tROUTER\tFIRST SEEN\tLAST SEEN\tPACKETS\tNOTE")
+	for _, n := range neighbors {
+		vlan := "-"
+		if n.VLAN != 0 {
+			vlan = strconv.Itoa(n.VLAN)
+		}
+		router := "-"
+		if n.Router {
+			router = "yes"
+		}
+		note := "-"
+		if macs[segment{n.IP, n.Interface, n.VLAN}] > 1 {
+			note = "conflict"
+		}
+
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			n.IP,
+			n.MAC,
+			orDash(oui.Vendor(n.MAC)),
+			orDash(n.Interface),
+			vlan,
+			router,
+			n.FirstSeen.Local().Format(time.DateTime),
+			n.LastSeen.Local().Format(time.DateTime),
+			n.Packets,
+			note,
+		)
+	}
+
+	return tw.Flush()
+}
+
+// PrintNDPTableJSON writes the learned IPv6 neighbors to w as a JSON array
+func PrintNDPTableJSON(w io.Writer, neighbors []storage.NDPNeighbor) error {
+	if neighbors == nil {
+		neighbors = []storage.NDPNeighbor{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(neighbors)
+}
@@ -0,0 +1,119 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/packet"
+	"packeteer/internal/storage"
+)
+
+// ******************************
+// NDPSummary
+// ******************************
+
+func TestNDPSummary(t *testing.T) {
+	tests := []struct {
+		ndp  *packet.NDPInfo
+		want string
+	}{
+		{
+			&packet.NDPInfo{
+				Op:       packet.NDPNeighborSolicitation,
+				TargetIP: "fe80::1",
+				LinkAddr: "00:03:93:00:00:0a",
+			},
+			"who-has fe80::1 from Apple_00:00:0a",
+		},
+		{
+			&packet.NDPInfo{
+				Op:        packet.NDPNeighborAdvertisement,
+				TargetIP:  "fe80::1",
+				LinkAddr:  "00:00:0c:00:00:01",
+				Router:    true,
+				Solicited: true,
+			},
+			"fe80::1 is-at Cisco_00:00:01 [router,solicited]",
+		},
+		{
+			&packet.NDPInfo{
+				Op:             packet.NDPRouterAdvertisement,
+				RouterLifetime: 1800,
+				OtherConfig:    true,
+				Prefixes:       []string{"2001:db8:1::/64"},
+				MTU:            1500,
+			},
+			"router advertisement, lifetime 1800s, other config, prefixes 2001:db8:1::/64, mtu 1500",
+		},
+		{
+			&packet.NDPInfo{Op: packet.NDPRedirect, TargetIP: "fe80::2", RedirectDest: "2001:db8:9::1"},
+			"redirect 2001:db8:9::1 to fe80::2",
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, NDPSummary(tt.ndp))
+	}
+}
+
+func TestIPv6ExtString(t *testing.T) {
+	assert.Equal(t, "", IPv6ExtString(nil))
+	assert.Equal(
+		t,
+		"hop-by-hop router-alert, fragment id 0x1 offset 0 more",
+		IPv6ExtString([]packet.IPv6ExtHeader{
+			{Name: "hop-by-hop", Detail: "router-alert"},
+			{Name: "fragment", Detail: "id 0x1 offset 0 more"},
+		}),
+	)
+}
+
+// ******************************
+// PrintNDPTable
+// ******************************
+
+func TestPrintNDPTable(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	neighbors := []storage.NDPNeighbor{
+		{
+			IP:        "fe80::1",
+			MAC:       "00:00:0c:00:00:01",
+			Interface: "eth0",
+			Router:    true,
+			FirstSeen: t0,
+			LastSeen:  t0,
+		},
+		{IP: "fe80::5", MAC: "00:50:56:00:00:0b", Interface: "eth0", FirstSeen: t0, LastSeen: t0},
+		{IP: "fe80::5", MAC: "02:00:00:00:00:0a", Interface: "eth0", FirstSeen: t0, LastSeen: t0},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, PrintNDPTable(&buf, neighbors))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+
+	assert.Equal(
+		t,
+		"IP MAC VENDOR INTERFACE VLAN ROUTER FIRST SEEN LAST SEEN PACKETS NOTE",
+		strings.Join(strings.Fields(lines[0]), " "),
+	)
+
+	first := strings.Fields(lines[1])
+	assert.Equal(t, "Cisco", first[2])
+	assert.Equal(t, "yes", first[5])
+	assert.Equal(t, "-", first[len(first)-1])
+
+	assert.True(t, strings.HasSuffix(lines[2], "conflict"))
+	assert.True(t, strings.HasSuffix(lines[3], "conflict"))
+}
+
+func TestPrintNDPTableJSON_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, PrintNDPTableJSON(&buf, nil))
+	assert.Equal(t, "[]\n", buf.String())
+}
@@ -1,11 +1,21 @@
 package output
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
+	"github.com/gopacket/gopacket/layers"
+
+	"packeteer/internal/capture"
+	"packeteer/internal/defrag"
+	"packeteer/internal/dns"
+	"packeteer/internal/oui"
 	"packeteer/internal/packet"
 	"packeteer/internal/storage"
 )
@@ -13,20 +23,130 @@ import (
 // PrintPacketInfo takes a *PacketInfo and nicely prints it to stdout
 func PrintPacketInfo(pi *packet.PacketInfo, packetNum int) {
 	fmt.Printf("PACKET: %d | ", packetNum)
+	if pi.Interface != "" {
+		fmt.Printf("%s | ", pi.Interface)
+	}
+	fmt.Printf("%s | length %v read: %v | ", pi.Timestamp, pi.Length, pi.CaptureLength)
+	if eth := EthernetSummary(pi); eth != "" {
+		fmt.Printf("%s | ", eth)
+	}
+	if pi.ARP != nil {
+		fmt.Printf("%s %s\n", pi.Protocol, ARPSummary(pi.ARP))
+		return
+	}
+	if len(pi.Tunnels) > 0 {
+		fmt.Printf("tunnel: %s | ", TunnelString(pi.Tunnels))
+	}
+	if len(pi.IPv6Ext) > 0 {
+		fmt.Printf("ext: %s | ", IPv6ExtString(pi.IPv6Ext))
+	}
+	if pi.ICMP != nil {
+		summary := ICMPSummary(pi.ICMP)
+		if pi.NDP != nil {
+			summary = NDPSummary(pi.NDP)
+		}
+		fmt.Printf("%s %s > %s %s\n", pi.Protocol, pi.SrcIP, pi.DestIP, summary)
+		return
+	}
 	fmt.Printf(
-		"%s | length %v read: %v | %s src: %s:%s, dst: %s:%s",
-		pi.Timestamp,
-		pi.Length,
-		pi.CaptureLength,
+		"%s src: %s:%s, dst: %s:%s",
 		pi.Protocol,
 		pi.SrcIP,
 		pi.SrcPort,
 		pi.DestIP,
 		pi.DestPort,
 	)
+	if pi.TLS != nil {
+		fmt.Printf(" | tls: %s", pi.TLS.Summary())
+	}
+	for _, tx := range pi.HTTP {
+		fmt.Printf(" | http: %s", tx.Summary())
+	}
 	fmt.Println()
 }
 
+// EthernetSummary renders the link layer of the packet, e.g.
+// Apple_12:34:56 > Cisco_00:00:01, vlan 100,20. The ethertype is added for
+// packets nothing above Ethernet was decoded for. Empty without MACs
+func EthernetSummary(pi *packet.PacketInfo) string {
+	if pi.SrcMAC == "" && pi.DstMAC == "" {
+		return ""
+	}
+
+	s := oui.Name(pi.SrcMAC) + " > " + oui.Name(pi.DstMAC)
+	if len(pi.VLANs) > 0 {
+		s += ", vlan " + VLANString(pi.VLANs)
+	}
+	if pi.Protocol == packet.ETH {
+		s += fmt.Sprintf(", ethertype %s (0x%04x)", layers.EthernetType(pi.EtherType), pi.EtherType)
+	}
+	return s
+}
+
+// ICMPSummary renders an ICMP message, e.g. echo request id 7 seq 1, or for
+// errors the flow they are about, e.g.
+// port unreachable for UDP 10.0.0.5:40001 > 10.0.0.1:53(domain)
+func ICMPSummary(icmp *packet.ICMPInfo) string {
+	s := icmp.Message
+	switch icmp.Kind {
+	case packet.ICMPEchoRequest, packet.ICMPEchoReply:
+		s += fmt.Sprintf(" id %d seq %d", icmp.ID, icmp.Seq)
+	case packet.ICMPError:
+		if icmp.MTU > 0 {
+			s += fmt.Sprintf(" (mtu %d)", icmp.MTU)
+		}
+		if o := icmp.Original; o != nil {
+			src, dst := o.SrcIP, o.DestIP
+			if o.SrcPort != "" {
+				src += ":" + o.SrcPort
+				dst += ":" + o.DestPort
+			}
+			s += fmt.Sprintf(" for %s %s > %s", o.Protocol, src, dst)
+		}
+	}
+	return s
+}
+
+// VLANString renders a VLAN tag stack outermost first, e.g. 100,20
+func VLANString(vlans []uint16) string {
+	ids := make([]string, len(vlans))
+	for i, v := range vlans {
+		ids[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(ids, ",")
+}
+
+// TunnelString renders the tunnels of a packet outermost first, e.g.
+// VXLAN 42 192.168.0.1 > 192.168.0.2, IPIP 10.0.0.1 > 10.0.0.2
+func TunnelString(tunnels []packet.Tunnel) string {
+	parts := make([]string, len(tunnels))
+	for i, t := range tunnels {
+		parts[i] = t.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// packetLine is a captured packet as a single JSON object
+type packetLine struct {
+	Packet int `json:"packet"`
+	*packet.PacketInfo
+	SrcVendor string       `json:"src_mac_vendor,omitempty"`
+	DstVendor string       `json:"dst_mac_vendor,omitempty"`
+	DNS       *dns.DNSInfo `json:"dns,omitempty"`
+}
+
+// PrintPacketJSON writes the packet, and its DNS details when it carries any,
+// as a JSON line to w
+func PrintPacketJSON(w io.Writer, pi *packet.PacketInfo, dnsInfo *dns.DNSInfo, packetNum int) error {
+	return json.NewEncoder(w).Encode(packetLine{
+		Packet:     packetNum,
+		PacketInfo: pi,
+		SrcVendor:  oui.Vendor(pi.SrcMAC),
+		DstVendor:  oui.Vendor(pi.DstMAC),
+		DNS:        dnsInfo,
+	})
+}
+
 // PrintMostQueriedDomains pretty-prints the Most Queried Domains
 func PrintMostQueriedDomains(mqd []storage.DNSMostQueriedDomain) {
 	fmt.Println(strings.Repeat("*", 40))
@@ -81,3 +201,114 @@ func PrintUniqueDomains(dqs []storage.DNSDistinctQuery) {
 
 	fmt.Println(strings.Repeat("*", 40))
 }
+
+// PrintCaptureStats pretty-prints the capture counters at the end of a run to
+// out
+func PrintCaptureStats(out io.Writer, s capture.Stats) {
+	fmt.Fprintln(out, strings.Repeat("*", 40))
+	fmt.Fprintln(out, "\tCapture Statistics")
+	fmt.Fprintln(out, strings.Repeat("*", 40))
+
+	w := tabwriter.NewWriter(out, 3, 4, 1, ' ', 0)
+	fmt.Fprintf(w, "Received:\t%d\n", s.Received)
+	fmt.Fprintf(w, "Dropped by kernel:\t%d\n", s.KernelDropped)
+	fmt.Fprintf(w, "Dropped by interface:\t%d\n", s.IfaceDropped)
+	fmt.Fprintf(w, "Dropped by packeteer (queue full):\t%d\n", s.QueueDropped)
+	w.Flush()
+
+	fmt.Fprintln(out, strings.Repeat("*", 40))
+}
+
+// PrintFragmentStats prints what IP defragmentation saw, in the style of
+// PrintCaptureStats. Nothing is printed when there were no fragments
+func PrintFragmentStats(out io.Writer, s defrag.Stats) {
+	if s.Fragments == 0 {
+		return
+	}
+
+	fmt.Fprintln(out, "\tIP Fragments")
+	fmt.Fprintln(out, strings.Repeat("*", 40))
+
+	w := tabwriter.NewWriter(out, 3, 4, 1, ' ', 0)
+	fmt.Fprintf(w, "Fragments:\t%d\n", s.Fragments)
+	fmt.Fprintf(w, "Reassembled datagrams:\t%d (IPv4 %d, IPv6 %d)\n", s.Reassembled, s.IPv4, s.IPv6)
+	fmt.Fprintf(w, "Largest fragment (path MTU):\t%d\n", s.LargestFragment)
+	fmt.Fprintf(w, "Largest datagram:\t%d\n", s.LargestDatagram)
+	fmt.Fprintf(w, "Timed out incomplete:\t%d\n", s.TimedOut)
+	fmt.Fprintf(w, "Dropped overlapping:\t%d\n", s.Overlapping)
+	fmt.Fprintf(w, "Dropped invalid:\t%d\n", s.Invalid)
+	fmt.Fprintf(w, "Dropped over buffer limit:\t%d\n", s.Evicted)
+	fmt.Fprintf(w, "Incomplete at the end:\t%d\n", s.Pending)
+	w.Flush()
+
+	fmt.Fprintln(out, strings.Repeat("*", 40))
+}
+
+// statsLine is a capture statistics report as a single JSON object
+type statsLine struct {
+	Time time.Time `json:"time"`
+	capture.Stats
+}
+
+// PrintStatsJSON writes the capture counters as a JSON line to w, for
+// scripted runs to pick up
+func PrintStatsJSON(w io.Writer, ts time.Time, s capture.Stats) error {
+	return json.NewEncoder(w).Encode(statsLine{Time: ts.UTC(), Stats: s})
+}
+
+// PrintInterfaces writes the capture devices to w as a table
+func PrintInterfaces(w io.Writer, ifaces []packet.Interface) error {
+	tw := tabwriter.NewWriter(w, 3, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tFLAGS\tADDRESSES\tLINK TYPES\tDESCRIPTION")
+	for _, iface := range ifaces {
+		var flags []string
+		if iface.Up {
+			flags = append(flags, "up")
+		}
+		if iface.Running {
+			flags = append(flags, "running")
+		}
+		if iface.Loopback {
+			flags = append(flags, "loopback")
+		}
+		if iface.Wireless {
+			flags = append(flags, "wireless")
+		}
+
+		addrs := make([]string, len(iface.Addresses))
+		for i, a := range iface.Addresses {
+			addrs[i] = a.String()
+		}
+
+		links := strings.Join(iface.LinkTypes, ",")
+		if iface.LinkTypeError != "" {
+			links = "?"
+		}
+
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\n",
+			iface.Name,
+			orDash(strings.Join(flags, ",")),
+			orDash(strings.Join(addrs, ", ")),
+			orDash(links),
+			orDash(iface.Description),
+		)
+	}
+
+	return tw.Flush()
+}
+
+// PrintInterfacesJSON writes the capture devices to w as a JSON array
+func PrintInterfacesJSON(w io.Writer, ifaces []packet.Interface) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ifaces)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
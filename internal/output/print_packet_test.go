@@ -0,0 +1,215 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/capture"
+	"packeteer/internal/defrag"
+	"packeteer/internal/dns"
+	"packeteer/internal/packet"
+	"packeteer/internal/tls"
+)
+
+// ******************************
+// EthernetSummary
+// ******************************
+
+func TestEthernetSummary(t *testing.T) {
+	pi := testPacket(time.Time{})
+	assert.Equal(t, "", EthernetSummary(pi))
+
+	pi.SrcMAC = "00:03:93:12:34:56"
+	pi.DstMAC = "ff:ff:ff:ff:ff:ff"
+	assert.Equal(t, "Apple_12:34:56 > ff:ff:ff:ff:ff:ff", EthernetSummary(pi))
+
+	pi.VLANs = []uint16{100, 20}
+	assert.Equal(t, "Apple_12:34:56 > ff:ff:ff:ff:ff:ff, vlan 100,20", EthernetSummary(pi))
+
+	pi.Protocol = packet.ETH
+	pi.EtherType = 0x0806
+	assert.Equal(
+		t,
+		"Apple_12:34:56 > ff:ff:ff:ff:ff:ff, vlan 100,20, ethertype ARP (0x0806)",
+		EthernetSummary(pi),
+	)
+}
+
+// ******************************
+// ICMPSummary
+// ******************************
+
+func TestICMPSummary(t *testing.T) {
+	tests := []struct {
+		icmp *packet.ICMPInfo
+		want string
+	}{
+		{
+			&packet.ICMPInfo{Kind: packet.ICMPEchoRequest, Message: "echo request", ID: 7, Seq: 1},
+			"echo request id 7 seq 1",
+		},
+		{
+			&packet.ICMPInfo{Kind: packet.ICMPError, Message: "port unreachable", Original: &packet.ICMPOriginal{
+				SrcIP:    "10.0.0.5",
+				SrcPort:  "40001",
+				DestIP:   "10.0.0.1",
+				DestPort: "53(domain)",
+				Protocol: packet.UDP,
+			}},
+			"port unreachable for UDP 10.0.0.5:40001 > 10.0.0.1:53(domain)",
+		},
+		{
+			&packet.ICMPInfo{Kind: packet.ICMPError, Message: "packet too big", MTU: 1280},
+			"packet too big (mtu 1280)",
+		},
+		{
+			&packet.ICMPInfo{Kind: packet.ICMPError, Message: "ttl exceeded", Original: &packet.ICMPOriginal{
+				SrcIP:    "10.0.0.5",
+				DestIP:   "8.8.8.8",
+				Protocol: packet.ICMPv4,
+			}},
+			"ttl exceeded for ICMPv4 10.0.0.5 > 8.8.8.8",
+		},
+		{&packet.ICMPInfo{Kind: packet.ICMPOther, Message: "router advertisement"}, "router advertisement"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ICMPSummary(tt.icmp))
+	}
+}
+
+// ******************************
+// PrintPacketJSON
+// ******************************
+
+func TestPrintPacketJSON(t *testing.T) {
+	pi := testPacket(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	pi.Interface = "eth0"
+
+	var buf bytes.Buffer
+	require.NoError(t, PrintPacketJSON(&buf, pi, nil, 7))
+	require.NoError(t, PrintPacketJSON(&buf, pi, nil, 8))
+
+	// One object per line
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(lines[0], &got))
+	assert.Equal(t, float64(7), got["packet"])
+	assert.Equal(t, "2024-01-01T00:00:00Z", got["timestamp"])
+	assert.Equal(t, "192.168.0.1", got["src_ip"])
+	assert.Equal(t, "443", got["dst_port"])
+	assert.Equal(t, "TCP", got["protocol"])
+	assert.Equal(t, "eth0", got["interface"])
+	assert.Equal(t, true, got["tcp_flags"].(map[string]any)["syn"])
+	assert.NotContains(t, got, "dns")
+	assert.NotContains(t, got, "Seq")
+}
+
+func TestPrintPacketJSON_DNS(t *testing.T) {
+	pi := testPacket(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	dnsInfo := &dns.DNSInfo{
+		SrcIP:       "10.0.0.53",
+		QueryName:   "example.com",
+		QueryType:   "A",
+		ResponseIPs: []string{"93.184.216.34"},
+		RequestType: dns.Response,
+		TxnId:       0x1234,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, PrintPacketJSON(&buf, pi, dnsInfo, 0))
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	require.Contains(t, got, "dns")
+	d := got["dns"].(map[string]any)
+	assert.Equal(t, "example.com", d["query_name"])
+	assert.Equal(t, []any{"93.184.216.34"}, d["response_ips"])
+	assert.Equal(t, float64(0x1234), d["txn_id"])
+	assert.NotContains(t, d, "cname_path")
+}
+
+func TestPrintPacketJSON_Ethernet(t *testing.T) {
+	pi := testPacket(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	pi.SrcMAC = "00:50:56:00:00:01"
+	pi.DstMAC = "02:00:00:00:00:01"
+	pi.EtherType = 0x0800
+	pi.VLANs = []uint16{10}
+
+	var buf bytes.Buffer
+	require.NoError(t, PrintPacketJSON(&buf, pi, nil, 3))
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Equal(t, "00:50:56:00:00:01", got["src_mac"])
+	assert.Equal(t, "VMware", got["src_mac_vendor"])
+	assert.NotContains(t, got, "dst_mac_vendor")
+	assert.Equal(t, float64(0x0800), got["ethertype"])
+	assert.Equal(t, []any{float64(10)}, got["vlans"])
+}
+
+func TestPrintPacketJSON_TLS(t *testing.T) {
+	pi := testPacket(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	pi.Payload = []byte{0x16, 0x03, 0x01}
+	pi.TLS = &tls.Info{SNI: "api.github.com", OfferedALPN: []string{"h2"}}
+
+	var buf bytes.Buffer
+	require.NoError(t, PrintPacketJSON(&buf, pi, nil, 0))
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	require.Contains(t, got, "tls")
+	assert.Equal(t, "api.github.com", got["tls"].(map[string]any)["sni"])
+	assert.NotContains(t, got, "Payload")
+}
+
+// ******************************
+// PrintCaptureStats
+// ******************************
+
+func TestPrintCaptureStats(t *testing.T) {
+	var buf bytes.Buffer
+	PrintCaptureStats(&buf, capture.Stats{Received: 10, KernelDropped: 2, QueueDropped: 1})
+
+	assert.Contains(t, buf.String(), "Capture Statistics")
+	assert.Regexp(t, `Received: +10\n`, buf.String())
+	assert.Regexp(t, `Dropped by kernel: +2\n`, buf.String())
+	assert.Regexp(t, `Dropped by packeteer \(queue full\): +1\n`, buf.String())
+}
+
+// ******************************
+// TunnelString
+// ******************************
+
+func TestTunnelString(t *testing.T) {
+	assert.Equal(t, "", TunnelString(nil))
+	assert.Equal(t,
+		"VXLAN 42 192.168.0.1 > 192.168.0.2, IPIP 10.0.0.1 > 10.0.0.2",
+		TunnelString([]packet.Tunnel{
+			{Type: packet.VXLAN, SrcIP: "192.168.0.1", DstIP: "192.168.0.2", ID: 42},
+			{Type: packet.IPIP, SrcIP: "10.0.0.1", DstIP: "10.0.0.2"},
+		}),
+	)
+}
+
+// ******************************
+// PrintFragmentStats
+// ******************************
+
+func TestPrintFragmentStats(t *testing.T) {
+	var buf bytes.Buffer
+	PrintFragmentStats(&buf, defrag.Stats{})
+	assert.Empty(t, buf.String())
+
+	PrintFragmentStats(&buf, defrag.Stats{Fragments: 3, Reassembled: 1, IPv4: 1, LargestFragment: 1500})
+	assert.Contains(t, buf.String(), "IP Fragments")
+	assert.Regexp(t, `Reassembled datagrams: +1 \(IPv4 1, IPv6 0\)`, buf.String())
+	assert.Regexp(t, `Largest fragment \(path MTU\): +1500\n`, buf.String())
+}
@@ -0,0 +1,64 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"packeteer/internal/storage"
+)
+
+// PrintFingerprints writes the fingerprint report to w, every fingerprint
+// followed by the hosts that presented it and the server names they asked for
+func PrintFingerprints(w io.Writer, fps []storage.TLSFingerprint) error {
+	tw := tabwriter.NewWriter(w, 3, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FINGERPRINT / HOST\tHANDSHAKES\tSNIS")
+	for _, fp := range fps {
+		fmt.Fprintf(tw, "%s\t%d\t\n", fp.Fingerprint, fp.Handshakes)
+		for _, h := range fp.Hosts {
+			fmt.Fprintf(
+				tw,
+				"  %s\t%d\t%s\n",
+				h.IP, h.Handshakes, orDash(strings.Join(h.SNIs, ", ")),
+			)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// PrintFingerprintsJSON writes the fingerprint report to w as a JSON array
+func PrintFingerprintsJSON(w io.Writer, fps []storage.TLSFingerprint) error {
+	if fps == nil {
+		fps = []storage.TLSFingerprint{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(fps)
+}
+
+// PrintTLSAlerts writes the stored known bad fingerprint alerts to w, one per
+// line
+func PrintTLSAlerts(w io.Writer, alerts []storage.TLSAlert) error {
+	tw := tabwriter.NewWriter(w, 3, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tKIND\tMESSAGE")
+	for _, a := range alerts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", a.Timestamp.Local().Format(time.DateTime), a.Kind, a.Message)
+	}
+
+	return tw.Flush()
+}
+
+// PrintTLSAlertsJSON writes the stored known bad fingerprint alerts to w as a
+// JSON array
+func PrintTLSAlertsJSON(w io.Writer, alerts []storage.TLSAlert) error {
+	if alerts == nil {
+		alerts = []storage.TLSAlert{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(alerts)
+}
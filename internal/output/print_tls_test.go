@@ -0,0 +1,53 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/storage"
+)
+
+// ******************************
+// Fingerprints
+// ******************************
+
+func TestPrintFingerprints(t *testing.T) {
+	fps := []storage.TLSFingerprint{{
+		Fingerprint: "t13d1516h2_8daaf6152771_e5627efa2ab1",
+		Handshakes:  3,
+		Hosts: []storage.TLSFingerprintHost{
+			{IP: "10.0.0.5", Handshakes: 2, SNIs: []string{"api.github.com", "example.com"}},
+			{IP: "10.0.0.6", Handshakes: 1, SNIs: []string{}},
+		},
+	}}
+
+	var buf bytes.Buffer
+	require.NoError(t, PrintFingerprints(&buf, fps))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.Contains(t, lines[0], "FINGERPRINT / HOST")
+	assert.Equal(t, []string{"t13d1516h2_8daaf6152771_e5627efa2ab1", "3"}, strings.Fields(lines[1]))
+	assert.Equal(
+		t,
+		[]string{"10.0.0.5", "2", "api.github.com,", "example.com"},
+		strings.Fields(lines[2]),
+	)
+	assert.True(t, strings.HasPrefix(lines[3], "  10.0.0.6"))
+	assert.Equal(t, "-", strings.Fields(lines[3])[2])
+}
+
+func TestPrintFingerprintsJSON_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, PrintFingerprintsJSON(&buf, nil))
+
+	var got []any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Empty(t, got)
+	assert.Equal(t, "[]\n", buf.String())
+}
@@ -0,0 +1,195 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"charm.land/lipgloss/v2"
+
+	"packeteer/internal/dns"
+	"packeteer/internal/oui"
+	"packeteer/internal/packet"
+)
+
+// TemplateData is what a --template is executed against. Every PacketInfo
+// field is available directly, e.g. {{.SrcIP}}, and the DNS details under
+// .DNS when the packet carries any, e.g. {{with .DNS}}{{.QueryName}}{{end}}
+type TemplateData struct {
+	*packet.PacketInfo
+	DNS *dns.DNSInfo
+
+	N        int           // packet number
+	Relative time.Duration // time since the first packet
+	Delta    time.Duration // time since the previous packet
+}
+
+// templateColors are the names accepted by the color helper, anything else is
+// passed to lipgloss.Color as a hex or ANSI color
+var templateColors = map[string]color.Color{
+	"black":   lipgloss.Black,
+	"red":     lipgloss.Red,
+	"green":   lipgloss.Green,
+	"yellow":  lipgloss.Yellow,
+	"blue":    lipgloss.Blue,
+	"magenta": lipgloss.Magenta,
+	"cyan":    lipgloss.Cyan,
+	"white":   lipgloss.White,
+	"gray":    lipgloss.BrightBlack,
+}
+
+// templateFuncs are the helpers available to every packet template
+var templateFuncs = template.FuncMap{
+	"size":    HumanSize,
+	"flags":   TCPFlagString,
+	"rel":     formatRelative,
+	"color":   colorize,
+	"bold":    func(s any) string { return lipgloss.NewStyle().Bold(true).Render(fmt.Sprint(s)) },
+	"join":    strings.Join,
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"pad":     func(n int, s any) string { return fmt.Sprintf("%-*v", n, s) },
+	"padLeft": func(n int, s any) string { return fmt.Sprintf("%*v", n, s) },
+	"timefmt": func(layout string, t time.Time) string { return t.Format(layout) },
+	"clock":   func(t time.Time) string { return t.Format("15:04:05.000000") },
+	"default": defaultString,
+	"macname": oui.Name,
+	"vendor":  oui.Vendor,
+	"vlans":   VLANString,
+}
+
+// TemplatePrinter prints packets through a user-defined text/template
+type TemplatePrinter struct {
+	tmpl *template.Template
+	w    io.Writer
+	buf  bytes.Buffer
+
+	first time.Time
+	prev  time.Time
+}
+
+// NewTemplatePrinter parses text as a packet template writing to w. A newline
+// is added after every packet unless the template ends with one, and packets
+// for which the template renders only whitespace are skipped
+func NewTemplatePrinter(w io.Writer, text string) (*TemplatePrinter, error) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+
+	tmpl, err := template.New("packet").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TemplatePrinter{tmpl: tmpl, w: w}, nil
+}
+
+// Print executes the template for one packet, reporting whether it printed
+// anything
+func (p *TemplatePrinter) Print(pi *packet.PacketInfo, dnsInfo *dns.DNSInfo, packetNum int) (bool, error) {
+	if p.first.IsZero() {
+		p.first = pi.Timestamp
+		p.prev = pi.Timestamp
+	}
+
+	data := TemplateData{
+		PacketInfo: pi,
+		DNS:        dnsInfo,
+		N:          packetNum,
+		Relative:   pi.Timestamp.Sub(p.first),
+		Delta:      pi.Timestamp.Sub(p.prev),
+	}
+	p.prev = pi.Timestamp
+
+	// Render first so a failing template does not leave half a line behind,
+	// and so a template rendering only whitespace can skip the packet.
+	// Colors are dropped when w is not a terminal
+	p.buf.Reset()
+	if err := p.tmpl.Execute(&p.buf, data); err != nil {
+		return false, err
+	}
+	if len(bytes.TrimSpace(p.buf.Bytes())) == 0 {
+		return false, nil
+	}
+	if _, err := lipgloss.Fprint(p.w, p.buf.String()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// HumanSize renders a byte count with a binary unit, e.g. 1.5KiB
+func HumanSize(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := unit, 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// TCPFlagString renders TCP flags the way tcpdump does, e.g. [S.] for a
+// SYN-ACK. S is SYN, F FIN, R RST, P PSH and . ACK
+func TCPFlagString(f packet.TCPFlags) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	if f.SYN {
+		b.WriteByte('S')
+	}
+	if f.FIN {
+		b.WriteByte('F')
+	}
+	if f.RST {
+		b.WriteByte('R')
+	}
+	if f.PSH {
+		b.WriteByte('P')
+	}
+	if f.ACK {
+		b.WriteByte('.')
+	}
+	if b.Len() == 1 {
+		b.WriteString("none")
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// formatRelative renders a duration as seconds with microsecond precision,
+// like the relative time column of tcpdump and tshark
+func formatRelative(d time.Duration) string {
+	return fmt.Sprintf("%.6f", d.Seconds())
+}
+
+func colorize(name string, s any) string {
+	c, ok := templateColors[strings.ToLower(name)]
+	if !ok {
+		c = lipgloss.Color(name)
+	}
+	return lipgloss.NewStyle().Foreground(c).Render(fmt.Sprint(s))
+}
+
+func defaultString(def string, s any) string {
+	if v := fmt.Sprint(s); v != "" {
+		return v
+	}
+	return def
+}
+
+// BuiltinTemplates are named templates available without any configuration.
+// Templates under templates: in the config file are added to these, and
+// replace them when they share a name
+var BuiltinTemplates = map[string]string{
+	"brief": `{{clock .Timestamp}} {{.Protocol}} {{.SrcIP}}:{{.SrcPort}} > {{.DestIP}}:{{.DestPort}} {{size .Length}}`,
+	"tcp":   `{{rel .Relative}} {{.SrcIP}}:{{.SrcPort}} > {{.DestIP}}:{{.DestPort}} {{if eq .Transport "TCP"}}{{flags .TCPFlags}} {{end}}{{.Length}}`,
+	"eth":   `{{clock .Timestamp}} {{macname .SrcMAC}} > {{macname .DstMAC}}{{with .VLANs}} vlan {{vlans .}}{{end}} {{.Protocol}} {{size .Length}}`,
+	"dns":   `{{with .DNS}}{{clock $.Timestamp}} {{.RequestType}} {{.QueryType}} {{.QueryName}}{{with .ResponseIPs}} -> {{join . ","}}{{end}}{{end}}`,
+}
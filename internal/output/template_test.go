@@ -0,0 +1,159 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/dns"
+	"packeteer/internal/packet"
+)
+
+func testPacket(ts time.Time) *packet.PacketInfo {
+	return &packet.PacketInfo{
+		Timestamp: ts,
+		Length:    1536,
+		SrcIP:     "192.168.0.1",
+		SrcPort:   "50000",
+		DestIP:    "10.0.0.1",
+		DestPort:  "443",
+		Protocol:  packet.TCP,
+		TCPFlags:  packet.TCPFlags{SYN: true, ACK: true},
+	}
+}
+
+// ******************************
+// TemplatePrinter
+// ******************************
+
+func TestTemplatePrinter_Fields(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewTemplatePrinter(&buf, `{{.N}} {{.SrcIP}}:{{.SrcPort}} {{flags .TCPFlags}} {{size .Length}}`)
+	require.NoError(t, err)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = p.Print(testPacket(t0), nil, 7)
+	require.NoError(t, err)
+	assert.Equal(t, "7 192.168.0.1:50000 [S.] 1.5KiB\n", buf.String())
+}
+
+func TestTemplatePrinter_Relative(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewTemplatePrinter(&buf, "{{rel .Relative}} {{rel .Delta}}\n")
+	require.NoError(t, err)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = p.Print(testPacket(t0), nil, 0)
+	require.NoError(t, err)
+	_, err = p.Print(testPacket(t0.Add(1500*time.Millisecond)), nil, 1)
+	require.NoError(t, err)
+	_, err = p.Print(testPacket(t0.Add(2*time.Second)), nil, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "0.000000 0.000000\n1.500000 1.500000\n2.000000 0.500000\n", buf.String())
+}
+
+func TestTemplatePrinter_DNS(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewTemplatePrinter(&buf, BuiltinTemplates["dns"])
+	require.NoError(t, err)
+
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	printed, err := p.Print(testPacket(t0), nil, 0)
+	require.NoError(t, err)
+	assert.False(t, printed)
+	printed, err = p.Print(testPacket(t0), &dns.DNSInfo{
+		QueryName:   "example.com",
+		QueryType:   "A",
+		RequestType: dns.Response,
+		ResponseIPs: []string{"1.2.3.4", "5.6.7.8"},
+	}, 1)
+	require.NoError(t, err)
+	assert.True(t, printed)
+
+	// The non-DNS packet renders nothing and is skipped
+	assert.Equal(t, "12:00:00.000000 response A example.com -> 1.2.3.4,5.6.7.8\n", buf.String())
+}
+
+func TestTemplatePrinter_TCP(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewTemplatePrinter(&buf, BuiltinTemplates["tcp"])
+	require.NoError(t, err)
+
+	// The flags are shown for TCP carrying an application protocol too
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	pi := testPacket(t0)
+	pi.Protocol, pi.Transport = packet.TLS, packet.TCP
+	_, err = p.Print(pi, nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "0.000000 192.168.0.1:50000 > 10.0.0.1:443 [S.] 1536\n", buf.String())
+}
+
+func TestTemplatePrinter_Ethernet(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewTemplatePrinter(&buf, BuiltinTemplates["eth"])
+	require.NoError(t, err)
+
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	pi := testPacket(t0)
+	pi.SrcMAC = "00:03:93:12:34:56"
+	pi.DstMAC = "02:00:00:00:00:01"
+	pi.VLANs = []uint16{100, 20}
+	_, err = p.Print(pi, nil, 0)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		"12:00:00.000000 Apple_12:34:56 > 02:00:00:00:00:01 vlan 100,20 TCP 1.5KiB\n",
+		buf.String(),
+	)
+}
+
+func TestTemplatePrinter_ColorStrippedWhenNotATerminal(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewTemplatePrinter(&buf, `{{color "red" .SrcIP}} {{bold .DestIP}}`)
+	require.NoError(t, err)
+
+	_, err = p.Print(testPacket(time.Now()), nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.0.1 10.0.0.1\n", buf.String())
+}
+
+func TestNewTemplatePrinter_Invalid(t *testing.T) {
+	_, err := NewTemplatePrinter(&bytes.Buffer{}, "{{.SrcIP")
+	assert.Error(t, err)
+}
+
+func TestTemplatePrinter_UnknownField(t *testing.T) {
+	p, err := NewTemplatePrinter(&bytes.Buffer{}, "{{.Nope}}")
+	require.NoError(t, err)
+	_, err = p.Print(testPacket(time.Now()), nil, 0)
+	assert.Error(t, err)
+}
+
+// ******************************
+// Helpers
+// ******************************
+
+func TestHumanSize(t *testing.T) {
+	for n, want := range map[int]string{
+		0:       "0B",
+		1023:    "1023B",
+		1024:    "1.0KiB",
+		1536:    "1.5KiB",
+		5 << 20: "5.0MiB",
+		3 << 30: "3.0GiB",
+	} {
+		assert.Equal(t, want, HumanSize(n), n)
+	}
+}
+
+func TestTCPFlagString(t *testing.T) {
+	assert.Equal(t, "[S]", TCPFlagString(packet.TCPFlags{SYN: true}))
+	assert.Equal(t, "[S.]", TCPFlagString(packet.TCPFlags{SYN: true, ACK: true}))
+	assert.Equal(t, "[P.]", TCPFlagString(packet.TCPFlags{PSH: true, ACK: true}))
+	assert.Equal(t, "[F.]", TCPFlagString(packet.TCPFlags{FIN: true, ACK: true}))
+	assert.Equal(t, "[R]", TCPFlagString(packet.TCPFlags{RST: true}))
+	assert.Equal(t, "[none]", TCPFlagString(packet.TCPFlags{}))
+}
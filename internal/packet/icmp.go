@@ -0,0 +1,281 @@
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// ICMPKind sorts ICMP messages by what they mean for the flows they are about
+type ICMPKind string
+
+var (
+	ICMPEchoRequest ICMPKind = "echo-request"
+	ICMPEchoReply   ICMPKind = "echo-reply"
+	ICMPError       ICMPKind = "error" // unreachable, time exceeded, packet too big, ...
+	ICMPOther       ICMPKind = "other"
+)
+
+// ICMPInfo is the decoded ICMPv4 or ICMPv6 layer of a packet
+type ICMPInfo struct {
+	Type    uint8    `json:"type"`
+	Code    uint8    `json:"code"`
+	Kind    ICMPKind `json:"kind"`
+	Message string   `json:"message"`       // e.g. echo request or port unreachable
+	ID      uint16   `json:"id,omitempty"`  // of echo requests and replies
+	Seq     uint16   `json:"seq,omitempty"` // of echo requests and replies
+	MTU     int      `json:"mtu,omitempty"` // of fragmentation needed and packet too big
+
+	// Original is the packet an error was sent about, decoded from the
+	// headers the error quotes
+	Original *ICMPOriginal `json:"original,omitempty"`
+}
+
+// ICMPOriginal is the start of the packet quoted by an ICMP error. Only the
+// addresses, the ports of TCP and UDP and the id of echo requests are kept
+type ICMPOriginal struct {
+	SrcIP    string         `json:"src_ip"`
+	SrcPort  string         `json:"src_port,omitempty"`
+	DestIP   string         `json:"dst_ip"`
+	DestPort string         `json:"dst_port,omitempty"`
+	Protocol PacketProtocol `json:"protocol,omitempty"` // TCP, UDP, ICMPv4 or ICMPv6
+	ID       uint16         `json:"id,omitempty"`       // of a quoted echo request
+}
+
+// decodeICMPv4 reads the type, code and the fields that depend on them
+func decodeICMPv4(icmp *layers.ICMPv4) *ICMPInfo {
+	t, code := icmp.TypeCode.Type(), icmp.TypeCode.Code()
+	info := &ICMPInfo{Type: t, Code: code, Kind: ICMPOther, Message: icmpv4Message(t, code)}
+
+	switch t {
+	case layers.ICMPv4TypeEchoRequest, layers.ICMPv4TypeEchoReply:
+		info.Kind = ICMPEchoReply
+		if t == layers.ICMPv4TypeEchoRequest {
+			info.Kind = ICMPEchoRequest
+		}
+		info.ID, info.Seq = icmp.Id, icmp.Seq
+	case layers.ICMPv4TypeDestinationUnreachable,
+		layers.ICMPv4TypeSourceQuench,
+		layers.ICMPv4TypeRedirect,
+		layers.ICMPv4TypeTimeExceeded,
+		layers.ICMPv4TypeParameterProblem:
+		info.Kind = ICMPError
+		if t == layers.ICMPv4TypeDestinationUnreachable &&
+			code == layers.ICMPv4CodeFragmentationNeeded {
+			// RFC 1191 puts the next-hop MTU where echo messages have the seq
+			info.MTU = int(icmp.Seq)
+		}
+		info.Original = decodeOriginal(icmp.Payload)
+	}
+
+	return info
+}
+
+// decodeICMPv6 reads the type, code and the fields that depend on them. The
+// id and seq of echo messages are in the ICMPv6Echo layer that follows
+func decodeICMPv6(icmp *layers.ICMPv6) *ICMPInfo {
+	t, code := icmp.TypeCode.Type(), icmp.TypeCode.Code()
+	info := &ICMPInfo{Type: t, Code: code, Kind: ICMPOther, Message: icmpv6Message(t, code)}
+
+	switch t {
+	case layers.ICMPv6TypeEchoRequest:
+		info.Kind = ICMPEchoRequest
+	case layers.ICMPv6TypeEchoReply:
+		info.Kind = ICMPEchoReply
+	case layers.ICMPv6TypeDestinationUnreachable,
+		layers.ICMPv6TypePacketTooBig,
+		layers.ICMPv6TypeTimeExceeded,
+		layers.ICMPv6TypeParameterProblem:
+		info.Kind = ICMPError
+		// The first 4 bytes are unused, the MTU, or the problem pointer
+		if len(icmp.Payload) < 4 {
+			break
+		}
+		if t == layers.ICMPv6TypePacketTooBig {
+			info.MTU = int(binary.BigEndian.Uint32(icmp.Payload[:4]))
+		}
+		info.Original = decodeOriginal(icmp.Payload[4:])
+	}
+
+	return info
+}
+
+// decodeOriginal reads the IP header quoted by an ICMP error and the start of
+// what it carried. The quote is usually cut short, which the full layer
+// decoders would reject, so the few fields needed are read by hand
+func decodeOriginal(data []byte) *ICMPOriginal {
+	if len(data) == 0 {
+		return nil
+	}
+
+	o := &ICMPOriginal{}
+	var proto layers.IPProtocol
+	var rest []byte
+	switch data[0] >> 4 {
+	case 4:
+		ihl := int(data[0]&0x0f) * 4
+		if ihl < 20 || len(data) < ihl {
+			return nil
+		}
+		proto = layers.IPProtocol(data[9])
+		o.SrcIP = net.IP(data[12:16]).String()
+		o.DestIP = net.IP(data[16:20]).String()
+		rest = data[ihl:]
+	case 6:
+		if len(data) < 40 {
+			return nil
+		}
+		proto = layers.IPProtocol(data[6])
+		o.SrcIP = net.IP(data[8:24]).String()
+		o.DestIP = net.IP(data[24:40]).String()
+		proto, rest = skipIPv6Ext(proto, data[40:])
+	default:
+		return nil
+	}
+
+	switch proto {
+	case layers.IPProtocolTCP:
+		o.Protocol = TCP
+		if len(rest) >= 4 {
+			o.SrcPort = layers.TCPPort(binary.BigEndian.Uint16(rest[0:2])).String()
+			o.DestPort = layers.TCPPort(binary.BigEndian.Uint16(rest[2:4])).String()
+		}
+	case layers.IPProtocolUDP:
+		o.Protocol = UDP
+		if len(rest) >= 4 {
+			o.SrcPort = layers.UDPPort(binary.BigEndian.Uint16(rest[0:2])).String()
+			o.DestPort = layers.UDPPort(binary.BigEndian.Uint16(rest[2:4])).String()
+		}
+	case layers.IPProtocolICMPv4:
+		o.Protocol = ICMPv4
+		if len(rest) >= 6 && rest[0] == layers.ICMPv4TypeEchoRequest {
+			o.ID = binary.BigEndian.Uint16(rest[4:6])
+		}
+	case layers.IPProtocolICMPv6:
+		o.Protocol = ICMPv6
+		if len(rest) >= 6 && rest[0] == layers.ICMPv6TypeEchoRequest {
+			o.ID = binary.BigEndian.Uint16(rest[4:6])
+		}
+	}
+
+	return o
+}
+
+// skipIPv6Ext steps over the extension headers at the start of data,
+// returning the protocol after them and its data
+func skipIPv6Ext(proto layers.IPProtocol, data []byte) (layers.IPProtocol, []byte) {
+	for {
+		var n int
+		switch proto {
+		case layers.IPProtocolIPv6HopByHop,
+			layers.IPProtocolIPv6Routing,
+			layers.IPProtocolIPv6Destination:
+			if len(data) < 2 {
+				return proto, nil
+			}
+			n = (int(data[1]) + 1) * 8
+		case layers.IPProtocolIPv6Fragment:
+			n = 8
+		default:
+			return proto, data
+		}
+		if len(data) < n {
+			return proto, nil
+		}
+		proto, data = layers.IPProtocol(data[0]), data[n:]
+	}
+}
+
+// icmpv4Message names an ICMPv4 type and code
+func icmpv4Message(t, code uint8) string {
+	switch t {
+	case layers.ICMPv4TypeEchoReply:
+		return "echo reply"
+	case layers.ICMPv4TypeDestinationUnreachable:
+		switch code {
+		case layers.ICMPv4CodeNet:
+			return "net unreachable"
+		case layers.ICMPv4CodeHost:
+			return "host unreachable"
+		case layers.ICMPv4CodeProtocol:
+			return "protocol unreachable"
+		case layers.ICMPv4CodePort:
+			return "port unreachable"
+		case layers.ICMPv4CodeFragmentationNeeded:
+			return "fragmentation needed"
+		case layers.ICMPv4CodeNetAdminProhibited,
+			layers.ICMPv4CodeHostAdminProhibited,
+			layers.ICMPv4CodeCommAdminProhibited:
+			return "administratively prohibited"
+		}
+		return "destination unreachable"
+	case layers.ICMPv4TypeSourceQuench:
+		return "source quench"
+	case layers.ICMPv4TypeRedirect:
+		return "redirect"
+	case layers.ICMPv4TypeEchoRequest:
+		return "echo request"
+	case layers.ICMPv4TypeRouterAdvertisement:
+		return "router advertisement"
+	case layers.ICMPv4TypeRouterSolicitation:
+		return "router solicitation"
+	case layers.ICMPv4TypeTimeExceeded:
+		if code == layers.ICMPv4CodeFragmentReassemblyTimeExceeded {
+			return "reassembly time exceeded"
+		}
+		return "ttl exceeded"
+	case layers.ICMPv4TypeParameterProblem:
+		return "parameter problem"
+	case layers.ICMPv4TypeTimestampRequest:
+		return "timestamp request"
+	case layers.ICMPv4TypeTimestampReply:
+		return "timestamp reply"
+	}
+	return fmt.Sprintf("type %d code %d", t, code)
+}
+
+// icmpv6Message names an ICMPv6 type and code
+func icmpv6Message(t, code uint8) string {
+	switch t {
+	case layers.ICMPv6TypeDestinationUnreachable:
+		switch code {
+		case layers.ICMPv6CodeNoRouteToDst:
+			return "no route to destination"
+		case layers.ICMPv6CodeAdminProhibited:
+			return "administratively prohibited"
+		case layers.ICMPv6CodeAddressUnreachable:
+			return "address unreachable"
+		case layers.ICMPv6CodePortUnreachable:
+			return "port unreachable"
+		case layers.ICMPv6CodeRejectRouteToDst:
+			return "reject route"
+		}
+		return "destination unreachable"
+	case layers.ICMPv6TypePacketTooBig:
+		return "packet too big"
+	case layers.ICMPv6TypeTimeExceeded:
+		if code == layers.ICMPv6CodeFragmentReassemblyTimeExceeded {
+			return "reassembly time exceeded"
+		}
+		return "hop limit exceeded"
+	case layers.ICMPv6TypeParameterProblem:
+		return "parameter problem"
+	case layers.ICMPv6TypeEchoRequest:
+		return "echo request"
+	case layers.ICMPv6TypeEchoReply:
+		return "echo reply"
+	case layers.ICMPv6TypeRouterSolicitation:
+		return "router solicitation"
+	case layers.ICMPv6TypeRouterAdvertisement:
+		return "router advertisement"
+	case layers.ICMPv6TypeNeighborSolicitation:
+		return "neighbor solicitation"
+	case layers.ICMPv6TypeNeighborAdvertisement:
+		return "neighbor advertisement"
+	case layers.ICMPv6TypeRedirect:
+		return "redirect"
+	}
+	return fmt.Sprintf("type %d code %d", t, code)
+}
@@ -0,0 +1,221 @@
+package packet
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	host   = net.IP{10, 0, 0, 5}
+	router = net.IP{10, 0, 0, 1}
+	server = net.IP{93, 184, 216, 34}
+	host6  = net.ParseIP("2001:db8::5")
+	rtr6   = net.ParseIP("2001:db8::1")
+	srv6   = net.ParseIP("2001:db8:1::34")
+)
+
+// serialize builds a packet from ls, computing lengths and checksums
+func serialize(t *testing.T, ls ...gopacket.SerializableLayer) []byte {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, ls...))
+	return buf.Bytes()
+}
+
+// quote returns the start of a packet the way an ICMP error quotes it, the IP
+// header and the first 8 bytes of its payload
+func quote(
+	t *testing.T,
+	ip gopacket.SerializableLayer,
+	hdrLen int,
+	l gopacket.SerializableLayer,
+) []byte {
+	t.Helper()
+	if tcp, ok := l.(*layers.TCP); ok {
+		require.NoError(t, tcp.SetNetworkLayerForChecksum(ip.(gopacket.NetworkLayer)))
+	}
+	if udp, ok := l.(*layers.UDP); ok {
+		require.NoError(t, udp.SetNetworkLayerForChecksum(ip.(gopacket.NetworkLayer)))
+	}
+	return serialize(t, ip, l)[:hdrLen+8]
+}
+
+func decode(data []byte, first gopacket.LayerType) *PacketInfo {
+	pi, _ := ExtractPacketInfo(gopacket.NewPacket(data, first, gopacket.Default))
+	return pi
+}
+
+// ******************************
+// ICMPv4
+// ******************************
+
+func TestExtractPacketInfo_ICMPv4Echo(t *testing.T) {
+	data := serialize(t,
+		&layers.IPv4{Version: 4, TTL: 64, SrcIP: host, DstIP: server, Protocol: layers.IPProtocolICMPv4},
+		&layers.ICMPv4{
+			TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0),
+			Id:       0x1234,
+			Seq:      7,
+		},
+	)
+
+	pi := decode(data, layers.LayerTypeIPv4)
+	require.NotNil(t, pi.ICMP)
+	assert.Equal(t, ICMPv4, pi.Protocol)
+	assert.Equal(t, &ICMPInfo{
+		Type:    8,
+		Kind:    ICMPEchoRequest,
+		Message: "echo request",
+		ID:      0x1234,
+		Seq:     7,
+	}, pi.ICMP)
+}
+
+func TestExtractPacketInfo_ICMPv4PortUnreachable(t *testing.T) {
+	orig := quote(t,
+		&layers.IPv4{Version: 4, TTL: 64, SrcIP: host, DstIP: router, Protocol: layers.IPProtocolUDP},
+		20,
+		&layers.UDP{SrcPort: 40001, DstPort: 53},
+	)
+	data := serialize(t,
+		&layers.IPv4{Version: 4, TTL: 64, SrcIP: router, DstIP: host, Protocol: layers.IPProtocolICMPv4},
+		&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(3, 3)},
+		gopacket.Payload(orig),
+	)
+
+	pi := decode(data, layers.LayerTypeIPv4)
+	require.NotNil(t, pi.ICMP)
+	assert.Equal(t, "10.0.0.1", pi.SrcIP)
+	assert.Equal(t, ICMPError, pi.ICMP.Kind)
+	assert.Equal(t, "port unreachable", pi.ICMP.Message)
+	assert.Equal(t, &ICMPOriginal{
+		SrcIP:    "10.0.0.5",
+		SrcPort:  "40001",
+		DestIP:   "10.0.0.1",
+		DestPort: "53(domain)",
+		Protocol: UDP,
+	}, pi.ICMP.Original)
+}
+
+func TestExtractPacketInfo_ICMPv4FragmentationNeeded(t *testing.T) {
+	orig := quote(t,
+		&layers.IPv4{Version: 4, TTL: 64, SrcIP: host, DstIP: server, Protocol: layers.IPProtocolTCP},
+		20,
+		&layers.TCP{SrcPort: 51000, DstPort: 443, ACK: true},
+	)
+	data := serialize(t,
+		&layers.IPv4{Version: 4, TTL: 64, SrcIP: router, DstIP: host, Protocol: layers.IPProtocolICMPv4},
+		&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(3, 4), Seq: 1400},
+		gopacket.Payload(orig),
+	)
+
+	pi := decode(data, layers.LayerTypeIPv4)
+	require.NotNil(t, pi.ICMP)
+	assert.Equal(t, "fragmentation needed", pi.ICMP.Message)
+	assert.Equal(t, 1400, pi.ICMP.MTU)
+	require.NotNil(t, pi.ICMP.Original)
+	assert.Equal(t, TCP, pi.ICMP.Original.Protocol)
+	assert.Equal(t, "51000", pi.ICMP.Original.SrcPort)
+	assert.Equal(t, "443(https)", pi.ICMP.Original.DestPort)
+}
+
+func TestExtractPacketInfo_ICMPv4TimeExceededEcho(t *testing.T) {
+	orig := serialize(t,
+		&layers.IPv4{Version: 4, TTL: 1, SrcIP: host, DstIP: server, Protocol: layers.IPProtocolICMPv4},
+		&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(8, 0), Id: 99, Seq: 1},
+	)
+	data := serialize(t,
+		&layers.IPv4{Version: 4, TTL: 64, SrcIP: router, DstIP: host, Protocol: layers.IPProtocolICMPv4},
+		&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(11, 0)},
+		gopacket.Payload(orig),
+	)
+
+	pi := decode(data, layers.LayerTypeIPv4)
+	require.NotNil(t, pi.ICMP)
+	assert.Equal(t, "ttl exceeded", pi.ICMP.Message)
+	require.NotNil(t, pi.ICMP.Original)
+	assert.Equal(t, ICMPv4, pi.ICMP.Original.Protocol)
+	assert.Equal(t, uint16(99), pi.ICMP.Original.ID)
+}
+
+func TestDecodeOriginal_Truncated(t *testing.T) {
+	assert.Nil(t, decodeOriginal(nil))
+	assert.Nil(t, decodeOriginal([]byte{0x45, 0, 0}))
+	assert.Nil(t, decodeOriginal([]byte{0x60, 0, 0, 0}))
+
+	// The header is there, the ports are not
+	o := decodeOriginal(quote(t,
+		&layers.IPv4{Version: 4, TTL: 64, SrcIP: host, DstIP: server, Protocol: layers.IPProtocolUDP},
+		20,
+		&layers.UDP{SrcPort: 1, DstPort: 2},
+	)[:22])
+	require.NotNil(t, o)
+	assert.Equal(t, UDP, o.Protocol)
+	assert.Empty(t, o.SrcPort)
+}
+
+// ******************************
+// ICMPv6
+// ******************************
+
+func TestExtractPacketInfo_ICMPv6Echo(t *testing.T) {
+	ip := &layers.IPv6{
+		Version:    6,
+		HopLimit:   64,
+		SrcIP:      host6,
+		DstIP:      srv6,
+		NextHeader: layers.IPProtocolICMPv6,
+	}
+	icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeEchoReply, 0)}
+	require.NoError(t, icmp.SetNetworkLayerForChecksum(ip))
+	data := serialize(t, ip, icmp, &layers.ICMPv6Echo{Identifier: 5, SeqNumber: 3})
+
+	pi := decode(data, layers.LayerTypeIPv6)
+	require.NotNil(t, pi.ICMP)
+	assert.Equal(t, ICMPv6, pi.Protocol)
+	assert.Equal(t, ICMPEchoReply, pi.ICMP.Kind)
+	assert.Equal(t, "echo reply", pi.ICMP.Message)
+	assert.Equal(t, uint16(5), pi.ICMP.ID)
+	assert.Equal(t, uint16(3), pi.ICMP.Seq)
+}
+
+func TestExtractPacketInfo_ICMPv6PacketTooBig(t *testing.T) {
+	orig := quote(t,
+		&layers.IPv6{Version: 6, HopLimit: 64, SrcIP: host6, DstIP: srv6, NextHeader: layers.IPProtocolTCP},
+		40,
+		&layers.TCP{SrcPort: 51000, DstPort: 443, ACK: true},
+	)
+	mtu := make([]byte, 4)
+	binary.BigEndian.PutUint32(mtu, 1280)
+
+	ip := &layers.IPv6{
+		Version:    6,
+		HopLimit:   64,
+		SrcIP:      rtr6,
+		DstIP:      host6,
+		NextHeader: layers.IPProtocolICMPv6,
+	}
+	icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypePacketTooBig, 0)}
+	require.NoError(t, icmp.SetNetworkLayerForChecksum(ip))
+	data := serialize(t, ip, icmp, gopacket.Payload(append(mtu, orig...)))
+
+	pi := decode(data, layers.LayerTypeIPv6)
+	require.NotNil(t, pi.ICMP)
+	assert.Equal(t, ICMPError, pi.ICMP.Kind)
+	assert.Equal(t, "packet too big", pi.ICMP.Message)
+	assert.Equal(t, 1280, pi.ICMP.MTU)
+	assert.Equal(t, &ICMPOriginal{
+		SrcIP:    "2001:db8::5",
+		SrcPort:  "51000",
+		DestIP:   "2001:db8:1::34",
+		DestPort: "443(https)",
+		Protocol: TCP,
+	}, pi.ICMP.Original)
+}
@@ -0,0 +1,121 @@
+package packet
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gopacket/gopacket/pcap"
+)
+
+// pcap_if_t flags, see pcap_findalldevs(3PCAP)
+const (
+	pcapIfLoopback = 0x1
+	pcapIfUp       = 0x2
+	pcapIfRunning  = 0x4
+	pcapIfWireless = 0x8
+)
+
+// Interface describes a capture device as reported by libpcap
+type Interface struct {
+	Name          string    `json:"name"`
+	Description   string    `json:"description,omitempty"`
+	Addresses     []Address `json:"addresses"`
+	Up            bool      `json:"up"`
+	Running       bool      `json:"running"`
+	Loopback      bool      `json:"loopback"`
+	Wireless      bool      `json:"wireless"`
+	LinkTypes     []string  `json:"link_types"`
+	LinkTypeError string    `json:"link_type_error,omitempty"` // why LinkTypes could not be read
+	Flags         uint32    `json:"flags"`
+}
+
+// Address is one address of an Interface
+type Address struct {
+	IP        string `json:"ip"`
+	Netmask   string `json:"netmask,omitempty"`
+	PrefixLen int    `json:"prefix_len,omitempty"`
+	Broadcast string `json:"broadcast,omitempty"`
+}
+
+// String renders the address in CIDR notation when the netmask is known
+func (a Address) String() string {
+	if a.Netmask == "" {
+		return a.IP
+	}
+	return fmt.Sprintf("%s/%d", a.IP, a.PrefixLen)
+}
+
+// ListInterfaces returns every device libpcap knows about, along with the link
+// types it supports. Without all, devices without addresses are left out like
+// the interface picker does. `findDevs` is a stub for `pcap.FindAllDevs` and
+// `dataLinks` for DataLinks
+func ListInterfaces(
+	findDevs func() ([]pcap.Interface, error),
+	dataLinks func(device string) ([]pcap.Datalink, error),
+	all bool,
+) ([]Interface, error) {
+	devs, err := findDevs()
+	if err != nil {
+		return nil, err
+	}
+
+	ifaces := make([]Interface, 0, len(devs))
+	for _, d := range devs {
+		if !all && len(d.Addresses) == 0 {
+			continue
+		}
+
+		iface := Interface{
+			Name:        d.Name,
+			Description: d.Description,
+			Addresses:   make([]Address, 0, len(d.Addresses)),
+			Up:          d.Flags&pcapIfUp != 0,
+			Running:     d.Flags&pcapIfRunning != 0,
+			Loopback:    d.Flags&pcapIfLoopback != 0,
+			Wireless:    d.Flags&pcapIfWireless != 0,
+			LinkTypes:   []string{},
+			Flags:       d.Flags,
+		}
+		for _, a := range d.Addresses {
+			iface.Addresses = append(iface.Addresses, newAddress(a))
+		}
+
+		links, err := dataLinks(d.Name)
+		if err != nil {
+			iface.LinkTypeError = err.Error()
+		}
+		for _, l := range links {
+			iface.LinkTypes = append(iface.LinkTypes, l.Name)
+		}
+
+		ifaces = append(ifaces, iface)
+	}
+
+	return ifaces, nil
+}
+
+// DataLinks opens the device briefly to list the link types it supports. This
+// usually needs the same privileges as capturing
+func DataLinks(device string) ([]pcap.Datalink, error) {
+	handle, err := pcap.OpenLive(device, 64, false, time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	return handle.ListDataLinks()
+}
+
+func newAddress(a pcap.InterfaceAddress) Address {
+	addr := Address{IP: a.IP.String()}
+	if ones, bits := a.Netmask.Size(); bits != 0 {
+		addr.Netmask = net.IP(a.Netmask).String()
+		addr.PrefixLen = ones
+	}
+	if a.Broadaddr != nil {
+		addr.Broadcast = a.Broadaddr.String()
+	}
+
+	return addr
+}
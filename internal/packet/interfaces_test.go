@@ -0,0 +1,92 @@
+package packet
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/gopacket/gopacket/pcap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDevs() ([]pcap.Interface, error) {
+	return []pcap.Interface{
+		{
+			Name:        "eth0",
+			Description: "uplink",
+			Flags:       pcapIfUp | pcapIfRunning,
+			Addresses: []pcap.InterfaceAddress{
+				{
+					IP:        net.IP{192, 168, 0, 5},
+					Netmask:   net.CIDRMask(24, 32),
+					Broadaddr: net.IP{192, 168, 0, 255},
+				},
+				{
+					IP: net.ParseIP("fe80::1"),
+				},
+			},
+		},
+		{
+			Name:  "wlan0mon",
+			Flags: pcapIfUp | pcapIfWireless,
+		},
+	}, nil
+}
+
+func testDataLinks(device string) ([]pcap.Datalink, error) {
+	if device == "wlan0mon" {
+		return nil, errors.New("permission denied")
+	}
+	return []pcap.Datalink{{Name: "EN10MB"}, {Name: "DOCSIS"}}, nil
+}
+
+// ******************************
+// ListInterfaces
+// ******************************
+
+func TestListInterfaces(t *testing.T) {
+	ifaces, err := ListInterfaces(testDevs, testDataLinks, false)
+	require.NoError(t, err)
+	require.Len(t, ifaces, 1)
+
+	eth0 := ifaces[0]
+	assert.Equal(t, "eth0", eth0.Name)
+	assert.Equal(t, "uplink", eth0.Description)
+	assert.True(t, eth0.Up)
+	assert.True(t, eth0.Running)
+	assert.False(t, eth0.Loopback)
+	assert.False(t, eth0.Wireless)
+	assert.Equal(t, []string{"EN10MB", "DOCSIS"}, eth0.LinkTypes)
+	assert.Empty(t, eth0.LinkTypeError)
+
+	require.Len(t, eth0.Addresses, 2)
+	assert.Equal(t, Address{
+		IP:        "192.168.0.5",
+		Netmask:   "255.255.255.0",
+		PrefixLen: 24,
+		Broadcast: "192.168.0.255",
+	}, eth0.Addresses[0])
+	assert.Equal(t, "192.168.0.5/24", eth0.Addresses[0].String())
+	assert.Equal(t, "fe80::1", eth0.Addresses[1].String())
+}
+
+func TestListInterfaces_All(t *testing.T) {
+	ifaces, err := ListInterfaces(testDevs, testDataLinks, true)
+	require.NoError(t, err)
+	require.Len(t, ifaces, 2)
+
+	mon := ifaces[1]
+	assert.Equal(t, "wlan0mon", mon.Name)
+	assert.True(t, mon.Wireless)
+	assert.Empty(t, mon.Addresses)
+	assert.Empty(t, mon.LinkTypes)
+	assert.Equal(t, "permission denied", mon.LinkTypeError)
+}
+
+func TestListInterfaces_Error(t *testing.T) {
+	_, err := ListInterfaces(func() ([]pcap.Interface, error) {
+		return nil, errors.New("no devices")
+	}, testDataLinks, true)
+	assert.Error(t, err)
+}
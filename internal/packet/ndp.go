@@ -0,0 +1,211 @@
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// NDPOp names the IPv6 neighbor discovery message of a packet
+type NDPOp string
+
+var (
+	NDPRouterSolicitation    NDPOp = "router-solicitation"
+	NDPRouterAdvertisement   NDPOp = "router-advertisement"
+	NDPNeighborSolicitation  NDPOp = "neighbor-solicitation"
+	NDPNeighborAdvertisement NDPOp = "neighbor-advertisement"
+	NDPRedirect              NDPOp = "redirect"
+)
+
+// NDPInfo is the decoded neighbor discovery message of an ICMPv6 packet
+type NDPInfo struct {
+	Op       NDPOp  `json:"op"`
+	TargetIP string `json:"target_ip,omitempty"` // of solicitations, advertisements and redirects
+	LinkAddr string `json:"link_addr,omitempty"` // source or target link-layer address option
+
+	// Flags of neighbor advertisements. Router is also set for router
+	// advertisements
+	Router    bool `json:"router,omitempty"`
+	Solicited bool `json:"solicited,omitempty"`
+	Override  bool `json:"override,omitempty"`
+
+	// Router advertisements only
+	RouterLifetime uint16   `json:"router_lifetime,omitempty"` // in seconds, 0 is not a default router
+	Managed        bool     `json:"managed,omitempty"`         // addresses are from DHCPv6
+	OtherConfig    bool     `json:"other_config,omitempty"`    // other settings are from DHCPv6
+	Prefixes       []string `json:"prefixes,omitempty"`        // of the prefix information options
+	MTU            int      `json:"mtu,omitempty"`
+
+	// The destination a redirect is for, TargetIP is the better hop
+	RedirectDest string `json:"redirect_dest,omitempty"`
+}
+
+// IPv6ExtHeader is an extension header of an IPv6 packet
+type IPv6ExtHeader struct {
+	Name   string `json:"name"`             // hop-by-hop, routing, fragment or destination
+	Detail string `json:"detail,omitempty"` // e.g. id 0x1234 offset 1232 more
+}
+
+func (h IPv6ExtHeader) String() string {
+	if h.Detail == "" {
+		return h.Name
+	}
+	return h.Name + " " + h.Detail
+}
+
+// Neighbor returns the IP to MAC binding an ARP or NDP packet claims. ARP
+// probes and NDP messages without a link-layer address, such as duplicate
+// address detection from ::, claim nothing
+func (pi *PacketInfo) Neighbor() (ip, mac string, ok bool) {
+	switch {
+	case pi.ARP != nil:
+		ip, mac = pi.ARP.SenderIP, pi.ARP.SenderMAC
+		if pi.ARP.Probe() {
+			return "", "", false
+		}
+	case pi.NDP != nil:
+		switch pi.NDP.Op {
+		case NDPNeighborAdvertisement:
+			ip, mac = pi.NDP.TargetIP, pi.NDP.LinkAddr
+			if mac == "" {
+				mac = pi.SrcMAC
+			}
+		case NDPRouterAdvertisement:
+			ip, mac = pi.SrcIP, pi.NDP.LinkAddr
+			if mac == "" {
+				mac = pi.SrcMAC
+			}
+		case NDPNeighborSolicitation, NDPRouterSolicitation:
+			ip, mac = pi.SrcIP, pi.NDP.LinkAddr
+		}
+		if ip == "::" {
+			return "", "", false
+		}
+	}
+
+	return ip, mac, ip != "" && mac != ""
+}
+
+// decodeNDP reads a neighbor discovery layer, returning nil for any other
+func decodeNDP(l gopacket.Layer) *NDPInfo {
+	switch m := l.(type) {
+	case *layers.ICMPv6RouterSolicitation:
+		info := &NDPInfo{Op: NDPRouterSolicitation}
+		info.options(m.Options)
+		return info
+	case *layers.ICMPv6RouterAdvertisement:
+		info := &NDPInfo{
+			Op:             NDPRouterAdvertisement,
+			Router:         true,
+			RouterLifetime: m.RouterLifetime,
+			Managed:        m.ManagedAddressConfig(),
+			OtherConfig:    m.OtherConfig(),
+		}
+		info.options(m.Options)
+		return info
+	case *layers.ICMPv6NeighborSolicitation:
+		info := &NDPInfo{Op: NDPNeighborSolicitation, TargetIP: m.TargetAddress.String()}
+		info.options(m.Options)
+		return info
+	case *layers.ICMPv6NeighborAdvertisement:
+		info := &NDPInfo{
+			Op:        NDPNeighborAdvertisement,
+			TargetIP:  m.TargetAddress.String(),
+			Router:    m.Router(),
+			Solicited: m.Solicited(),
+			Override:  m.Override(),
+		}
+		info.options(m.Options)
+		return info
+	case *layers.ICMPv6Redirect:
+		info := &NDPInfo{
+			Op:           NDPRedirect,
+			TargetIP:     m.TargetAddress.String(),
+			RedirectDest: m.DestinationAddress.String(),
+		}
+		info.options(m.Options)
+		return info
+	}
+	return nil
+}
+
+// options reads the neighbor discovery options worth keeping
+func (n *NDPInfo) options(opts layers.ICMPv6Options) {
+	for _, o := range opts {
+		switch o.Type {
+		case layers.ICMPv6OptSourceAddress, layers.ICMPv6OptTargetAddress:
+			if len(o.Data) == 6 {
+				n.LinkAddr = net.HardwareAddr(o.Data).String()
+			}
+		case layers.ICMPv6OptPrefixInfo:
+			// prefix length, flags, valid and preferred lifetimes, reserved,
+			// then the prefix
+			if len(o.Data) < 30 {
+				continue
+			}
+			addr := netip.AddrFrom16([16]byte(o.Data[14:30]))
+			if prefix, err := addr.Prefix(int(o.Data[0])); err == nil {
+				n.Prefixes = append(n.Prefixes, prefix.String())
+			}
+		case layers.ICMPv6OptMTU:
+			if len(o.Data) >= 6 {
+				n.MTU = int(binary.BigEndian.Uint32(o.Data[2:6]))
+			}
+		}
+	}
+}
+
+// decodeIPv6Ext names an IPv6 extension header layer, returning false for any
+// other layer. The hop-by-hop header is decoded along with the IPv6 header
+// but still shows up as a layer of its own
+func decodeIPv6Ext(l gopacket.Layer) (IPv6ExtHeader, bool) {
+	switch h := l.(type) {
+	case *layers.IPv6HopByHop:
+		opts := make([]byte, 0, len(h.Options))
+		for _, o := range h.Options {
+			opts = append(opts, o.OptionType)
+		}
+		return IPv6ExtHeader{Name: "hop-by-hop", Detail: tlvOptions(opts)}, true
+	case *layers.IPv6Destination:
+		opts := make([]byte, 0, len(h.Options))
+		for _, o := range h.Options {
+			opts = append(opts, o.OptionType)
+		}
+		return IPv6ExtHeader{Name: "destination", Detail: tlvOptions(opts)}, true
+	case *layers.IPv6Routing:
+		return IPv6ExtHeader{
+			Name:   "routing",
+			Detail: fmt.Sprintf("type %d segments left %d", h.RoutingType, h.SegmentsLeft),
+		}, true
+	case *layers.IPv6Fragment:
+		detail := fmt.Sprintf("id 0x%x offset %d", h.Identification, int(h.FragmentOffset)*8)
+		if h.MoreFragments {
+			detail += " more"
+		}
+		return IPv6ExtHeader{Name: "fragment", Detail: detail}, true
+	}
+	return IPv6ExtHeader{}, false
+}
+
+// tlvOptions names the options of a hop-by-hop or destination header,
+// leaving out the padding
+func tlvOptions(types []byte) string {
+	var names []string
+	for _, t := range types {
+		switch t {
+		case 0x00, 0x01: // Pad1 and PadN
+		case 0x05:
+			names = append(names, "router-alert")
+		case layers.IPv6HopByHopOptionJumbogram:
+			names = append(names, "jumbo")
+		default:
+			names = append(names, fmt.Sprintf("opt 0x%02x", t))
+		}
+	}
+	return strings.Join(names, ",")
+}
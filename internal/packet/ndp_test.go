@@ -0,0 +1,226 @@
+package packet
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	routerMAC = net.HardwareAddr{0x00, 0x00, 0x0c, 0x00, 0x00, 0x01}
+	hostMAC   = net.HardwareAddr{0x00, 0x03, 0x93, 0x12, 0x34, 0x56}
+	llRouter  = net.ParseIP("fe80::1")
+	llHost    = net.ParseIP("fe80::5")
+	allNodes  = net.ParseIP("ff02::1")
+)
+
+// icmpv6 builds an Ethernet frame carrying an ICMPv6 message from src to dst
+func icmpv6(t *testing.T, src, dst net.IP, typ uint8, msg gopacket.SerializableLayer) []byte {
+	t.Helper()
+	ip := &layers.IPv6{
+		Version:    6,
+		HopLimit:   255,
+		SrcIP:      src,
+		DstIP:      dst,
+		NextHeader: layers.IPProtocolICMPv6,
+	}
+	icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(typ, 0)}
+	require.NoError(t, icmp.SetNetworkLayerForChecksum(ip))
+	return serialize(t,
+		&layers.Ethernet{SrcMAC: routerMAC, DstMAC: hostMAC, EthernetType: layers.EthernetTypeIPv6},
+		ip, icmp, msg,
+	)
+}
+
+// ******************************
+// NDP
+// ******************************
+
+func TestExtractPacketInfo_RouterAdvertisement(t *testing.T) {
+	prefix := make([]byte, 30)
+	prefix[0] = 64
+	prefix[1] = 0xc0 // on-link, autonomous
+	copy(prefix[14:], net.ParseIP("2001:db8:1::"))
+	mtu := make([]byte, 6)
+	binary.BigEndian.PutUint32(mtu[2:], 1500)
+
+	data := icmpv6(t, llRouter, allNodes, layers.ICMPv6TypeRouterAdvertisement,
+		&layers.ICMPv6RouterAdvertisement{
+			HopLimit:       64,
+			Flags:          0x40, // other config
+			RouterLifetime: 1800,
+			Options: layers.ICMPv6Options{
+				{Type: layers.ICMPv6OptSourceAddress, Data: routerMAC},
+				{Type: layers.ICMPv6OptPrefixInfo, Data: prefix},
+				{Type: layers.ICMPv6OptMTU, Data: mtu},
+			},
+		},
+	)
+
+	pi := decode(data, layers.LayerTypeEthernet)
+	require.NotNil(t, pi.NDP)
+	assert.Equal(t, "router advertisement", pi.ICMP.Message)
+	assert.Equal(t, &NDPInfo{
+		Op:             NDPRouterAdvertisement,
+		LinkAddr:       "00:00:0c:00:00:01",
+		Router:         true,
+		RouterLifetime: 1800,
+		OtherConfig:    true,
+		Prefixes:       []string{"2001:db8:1::/64"},
+		MTU:            1500,
+	}, pi.NDP)
+
+	ip, mac, ok := pi.Neighbor()
+	assert.True(t, ok)
+	assert.Equal(t, "fe80::1", ip)
+	assert.Equal(t, "00:00:0c:00:00:01", mac)
+}
+
+func TestExtractPacketInfo_NeighborAdvertisement(t *testing.T) {
+	data := icmpv6(t, llHost, llRouter, layers.ICMPv6TypeNeighborAdvertisement,
+		&layers.ICMPv6NeighborAdvertisement{
+			Flags:         0x60, // solicited, override
+			TargetAddress: net.ParseIP("2001:db8:1::5"),
+		},
+	)
+
+	pi := decode(data, layers.LayerTypeEthernet)
+	require.NotNil(t, pi.NDP)
+	assert.Equal(t, NDPNeighborAdvertisement, pi.NDP.Op)
+	assert.Equal(t, "2001:db8:1::5", pi.NDP.TargetIP)
+	assert.True(t, pi.NDP.Solicited)
+	assert.True(t, pi.NDP.Override)
+	assert.False(t, pi.NDP.Router)
+
+	// Without a target link-layer option the frame's source is the MAC
+	ip, mac, ok := pi.Neighbor()
+	assert.True(t, ok)
+	assert.Equal(t, "2001:db8:1::5", ip)
+	assert.Equal(t, "00:00:0c:00:00:01", mac)
+}
+
+func TestExtractPacketInfo_NeighborSolicitationDAD(t *testing.T) {
+	data := icmpv6(t, net.IPv6unspecified, net.ParseIP("ff02::1:ff00:5"),
+		layers.ICMPv6TypeNeighborSolicitation,
+		&layers.ICMPv6NeighborSolicitation{TargetAddress: llHost},
+	)
+
+	pi := decode(data, layers.LayerTypeEthernet)
+	require.NotNil(t, pi.NDP)
+	assert.Equal(t, NDPNeighborSolicitation, pi.NDP.Op)
+	assert.Equal(t, "fe80::5", pi.NDP.TargetIP)
+
+	_, _, ok := pi.Neighbor()
+	assert.False(t, ok)
+}
+
+func TestExtractPacketInfo_Redirect(t *testing.T) {
+	data := icmpv6(t, llRouter, llHost, layers.ICMPv6TypeRedirect,
+		&layers.ICMPv6Redirect{
+			TargetAddress:      net.ParseIP("fe80::2"),
+			DestinationAddress: net.ParseIP("2001:db8:9::1"),
+		},
+	)
+
+	pi := decode(data, layers.LayerTypeEthernet)
+	require.NotNil(t, pi.NDP)
+	assert.Equal(t, NDPRedirect, pi.NDP.Op)
+	assert.Equal(t, "fe80::2", pi.NDP.TargetIP)
+	assert.Equal(t, "2001:db8:9::1", pi.NDP.RedirectDest)
+
+	_, _, ok := pi.Neighbor()
+	assert.False(t, ok)
+}
+
+func TestNeighbor_ARP(t *testing.T) {
+	pi := &PacketInfo{ARP: &ARPInfo{SenderIP: "10.0.0.5", SenderMAC: "00:03:93:12:34:56"}}
+	ip, mac, ok := pi.Neighbor()
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.5", ip)
+	assert.Equal(t, "00:03:93:12:34:56", mac)
+
+	pi.ARP.SenderIP = "0.0.0.0"
+	_, _, ok = pi.Neighbor()
+	assert.False(t, ok)
+
+	_, _, ok = (&PacketInfo{}).Neighbor()
+	assert.False(t, ok)
+}
+
+// ******************************
+// Extension headers
+// ******************************
+
+// routerAlert returns a hop-by-hop header with a router alert option
+func routerAlert(next layers.IPProtocol) *layers.IPv6HopByHop {
+	hbh := &layers.IPv6HopByHop{
+		Options: []*layers.IPv6HopByHopOption{
+			{OptionType: 0x05, OptionLength: 2, OptionData: []byte{0, 0}},
+			{OptionType: 0x01}, // PadN to 8 bytes
+		},
+	}
+	hbh.NextHeader = next
+	return hbh
+}
+
+func TestExtractPacketInfo_IPv6HopByHop(t *testing.T) {
+	// MLD reports carry a router alert
+	ip := &layers.IPv6{
+		Version:    6,
+		HopLimit:   1,
+		SrcIP:      llHost,
+		DstIP:      net.ParseIP("ff02::16"),
+		NextHeader: layers.IPProtocolUDP,
+		HopByHop:   routerAlert(layers.IPProtocolUDP),
+	}
+	udp := &layers.UDP{SrcPort: 5000, DstPort: 5001}
+	require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+	data := serialize(t, ip, udp)
+
+	pi := decode(data, layers.LayerTypeIPv6)
+	assert.Equal(t, []IPv6ExtHeader{{Name: "hop-by-hop", Detail: "router-alert"}}, pi.IPv6Ext)
+	assert.Equal(t, UDP, pi.Protocol)
+}
+
+func TestExtractPacketInfo_IPv6Fragment(t *testing.T) {
+	data := serialize(t,
+		&layers.IPv6{
+			Version:    6,
+			HopLimit:   64,
+			SrcIP:      llHost,
+			DstIP:      llRouter,
+			NextHeader: layers.IPProtocolIPv6Fragment,
+		},
+		&layers.IPv6Fragment{
+			NextHeader:     layers.IPProtocolUDP,
+			FragmentOffset: 154,
+			MoreFragments:  true,
+			Identification: 0xbeef,
+		},
+		gopacket.Payload(make([]byte, 16)),
+	)
+
+	pi := decode(data, layers.LayerTypeIPv6)
+	require.Len(t, pi.IPv6Ext, 1)
+	assert.Equal(t, "fragment id 0xbeef offset 1232 more", pi.IPv6Ext[0].String())
+}
+
+func TestDecodeOriginal_IPv6Ext(t *testing.T) {
+	ip := &layers.IPv6{
+		Version:    6,
+		HopLimit:   64,
+		SrcIP:      host6,
+		DstIP:      srv6,
+		NextHeader: layers.IPProtocolUDP,
+		HopByHop:   routerAlert(layers.IPProtocolUDP),
+	}
+	o := decodeOriginal(quote(t, ip, 48, &layers.UDP{SrcPort: 40001, DstPort: 53}))
+	require.NotNil(t, o)
+	assert.Equal(t, UDP, o.Protocol)
+	assert.Equal(t, "53(domain)", o.DestPort)
+}
@@ -1,7 +1,12 @@
 package packet
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/charmbracelet/huh"
@@ -10,30 +15,74 @@ import (
 	"github.com/gopacket/gopacket/pcap"
 
 	"packeteer/internal/dns"
+	"packeteer/internal/http"
+	"packeteer/internal/quic"
+	"packeteer/internal/reassembly"
+	"packeteer/internal/tls"
 )
 
 // PacketInfo is a neat little struct that has the important gopacket.Packet
 // info needed for current functionalities
 type PacketInfo struct {
-	Timestamp     time.Time
-	Length        int
-	CaptureLength int
-	SrcIP         string
-	SrcPort       string
-	DestIP        string
-	DestPort      string
-	Protocol      PacketProtocol
-
-	TCPFlags TCPFlags
+	Timestamp     time.Time      `json:"timestamp"`
+	Length        int            `json:"length"`
+	CaptureLength int            `json:"capture_length"`
+	SrcIP         string         `json:"src_ip"` // of the innermost IP header, see Tunnels
+	SrcPort       string         `json:"src_port"`
+	DestIP        string         `json:"dst_ip"`
+	DestPort      string         `json:"dst_port"`
+	Protocol      PacketProtocol `json:"protocol"`
+	Transport     PacketProtocol `json:"transport,omitempty"` // TCP or UDP, kept when Protocol is above it
+	Interface     string         `json:"interface,omitempty"` // interface the packet was captured on
+	SrcMAC        string         `json:"src_mac,omitempty"`
+	DstMAC        string         `json:"dst_mac,omitempty"`
+	EtherType     uint16         `json:"ethertype,omitempty"` // of the payload, after any VLAN tags
+	VLANs         []uint16       `json:"vlans,omitempty"`     // 802.1Q and 802.1ad tags, outermost first
+	Tunnels       []Tunnel       `json:"tunnels,omitempty"`   // encapsulations with their outer addresses, outermost first
+
+	TCPFlags TCPFlags           `json:"tcp_flags"`
+	ARP      *ARPInfo           `json:"arp,omitempty"`
+	ICMP     *ICMPInfo          `json:"icmp,omitempty"`
+	NDP      *NDPInfo           `json:"ndp,omitempty"`
+	IPv6Ext  []IPv6ExtHeader    `json:"ipv6_ext,omitempty"` // in the order they appear
+	TLS      *tls.Info          `json:"tls,omitempty"`      // set from a tls.Session or quic.Tracker
+	QUIC     *quic.Info         `json:"quic,omitempty"`     // set by a quic.Tracker, see Datagram
+	HTTP     []http.Transaction `json:"http,omitempty"`     // completed by this packet, see Segment
+
+	Seq     uint32 `json:"-"` // TCP sequence number
+	Payload []byte `json:"-"` // TCP or UDP payload
+}
+
+// ARPInfo is the decoded ARP layer of a packet. The sender and target IPs
+// are also the SrcIP and DestIP of the PacketInfo
+type ARPInfo struct {
+	Opcode    uint16 `json:"opcode"`
+	Operation string `json:"operation"` // request, reply or op <n>
+	SenderMAC string `json:"sender_mac"`
+	SenderIP  string `json:"sender_ip"`
+	TargetMAC string `json:"target_mac"`
+	TargetIP  string `json:"target_ip"`
+}
+
+// Gratuitous reports whether the packet announces the sender's own address,
+// asking for or answering the sender IP itself
+func (a *ARPInfo) Gratuitous() bool {
+	return a.SenderIP == a.TargetIP && !a.Probe()
+}
+
+// Probe reports whether the packet is an RFC 5227 probe, which checks if an
+// address is taken without claiming it
+func (a *ARPInfo) Probe() bool {
+	return a.SenderIP == "0.0.0.0"
 }
 
 // TCPFlags is a struct that contains TCP-specific flags
 type TCPFlags struct {
-	SYN bool
-	ACK bool
-	FIN bool
-	RST bool
-	PSH bool
+	SYN bool `json:"syn"`
+	ACK bool `json:"ack"`
+	FIN bool `json:"fin"`
+	RST bool `json:"rst"`
+	PSH bool `json:"psh"`
 }
 
 type PacketProtocol string
@@ -48,16 +97,31 @@ var (
 	ICMPv4 PacketProtocol = "ICMPv4"
 	ICMPv6 PacketProtocol = "ICMPv6"
 	TLS    PacketProtocol = "TLS"
+	QUIC   PacketProtocol = "QUIC"
+	HTTP   PacketProtocol = "HTTP"
 	ARP    PacketProtocol = "ARP"
+
+	// Tunnels, see Tunnel
+	GRE       PacketProtocol = "GRE"
+	VXLAN     PacketProtocol = "VXLAN"
+	GENEVE    PacketProtocol = "GENEVE"
+	IPIP      PacketProtocol = "IPIP" // IPv4 or IPv6 directly in IPv4 or IPv6
+	ESP       PacketProtocol = "ESP"
+	WireGuard PacketProtocol = "WireGuard"
 )
 
 // ExtractPacketInfo extracts all the information into an instance of a
 // PacketInfo. This function can return nil if all the fields in the PacketInfo
-// are falsy
+// are falsy. The addresses and ports are those of the innermost headers, the
+// outer ones of tunnels are kept in Tunnels
 func ExtractPacketInfo(p gopacket.Packet) (*PacketInfo, *dns.DNSInfo) {
 	pi := &PacketInfo{}
 	var dnsInfo *dns.DNSInfo
 
+	// An IP header right after another one, extension headers aside, is IP
+	// in IP
+	var afterIP bool
+
 	md := p.Metadata()
 	pi.Timestamp = md.Timestamp.UTC()
 	pi.Length = md.Length
@@ -65,18 +129,38 @@ func ExtractPacketInfo(p gopacket.Packet) (*PacketInfo, *dns.DNSInfo) {
 
 	ls := p.Layers()
 	for _, l := range ls {
+		inIP := afterIP
+		afterIP = false
+
 		switch l.LayerType() {
 		case layers.LayerTypeEthernet:
+			eth := l.(*layers.Ethernet)
+			pi.SrcMAC = eth.SrcMAC.String()
+			pi.DstMAC = eth.DstMAC.String()
+			pi.EtherType = uint16(eth.EthernetType)
 			pi.Protocol = ETH
-			// 	eth := l.(*layers.Ethernet)
+
+		case layers.LayerTypeDot1Q:
+			// 802.1ad (QinQ) tags decode as Dot1Q too, one layer per tag
+			tag := l.(*layers.Dot1Q)
+			pi.VLANs = append(pi.VLANs, tag.VLANIdentifier)
+			pi.EtherType = uint16(tag.Type)
 
 		case layers.LayerTypeIPv4:
+			if inIP {
+				pi.enterTunnel(IPIP, 0)
+			}
+			afterIP = true
 			ip4 := l.(*layers.IPv4)
 			pi.SrcIP = ip4.SrcIP.String()
 			pi.DestIP = ip4.DstIP.String()
 			pi.Protocol = IPv4
 
 		case layers.LayerTypeIPv6:
+			if inIP {
+				pi.enterTunnel(IPIP, 0)
+			}
+			afterIP = true
 			ip6 := l.(*layers.IPv6)
 			pi.SrcIP = ip6.SrcIP.String()
 			pi.DestIP = ip6.DstIP.String()
@@ -91,25 +175,69 @@ func ExtractPacketInfo(p gopacket.Packet) (*PacketInfo, *dns.DNSInfo) {
 			pi.SrcPort = tcp.SrcPort.String()
 			pi.DestPort = tcp.DstPort.String()
 			pi.Protocol = TCP
+			pi.Transport = TCP
 			pi.TCPFlags.ACK = tcp.ACK
 			pi.TCPFlags.SYN = tcp.SYN
 			pi.TCPFlags.PSH = tcp.PSH
 			pi.TCPFlags.RST = tcp.RST
 			pi.TCPFlags.FIN = tcp.FIN
+			pi.Seq = tcp.Seq
+			pi.Payload = tcp.Payload
 
 		case layers.LayerTypeUDP:
 			udp := l.(*layers.UDP)
 			pi.SrcPort = udp.SrcPort.String()
 			pi.DestPort = udp.DstPort.String()
 			pi.Protocol = UDP
+			pi.Transport = UDP
+			pi.Payload = udp.Payload
 
 		case layers.LayerTypeICMPv4:
 			pi.Protocol = ICMPv4
-			// 	icmp4 := l.(*layers.ICMPv4)
+			pi.ICMP = decodeICMPv4(l.(*layers.ICMPv4))
 
 		case layers.LayerTypeICMPv6:
 			pi.Protocol = ICMPv6
-			// icmp6 := l.(*layers.ICMPv6)
+			pi.ICMP = decodeICMPv6(l.(*layers.ICMPv6))
+
+		case layers.LayerTypeICMPv6Echo:
+			if pi.ICMP != nil {
+				echo := l.(*layers.ICMPv6Echo)
+				pi.ICMP.ID, pi.ICMP.Seq = echo.Identifier, echo.SeqNumber
+			}
+
+		case layers.LayerTypeICMPv6RouterSolicitation,
+			layers.LayerTypeICMPv6RouterAdvertisement,
+			layers.LayerTypeICMPv6NeighborSolicitation,
+			layers.LayerTypeICMPv6NeighborAdvertisement,
+			layers.LayerTypeICMPv6Redirect:
+			pi.NDP = decodeNDP(l)
+
+		case layers.LayerTypeIPv6HopByHop,
+			layers.LayerTypeIPv6Destination,
+			layers.LayerTypeIPv6Routing,
+			layers.LayerTypeIPv6Fragment:
+			afterIP = inIP
+			if h, ok := decodeIPv6Ext(l); ok {
+				pi.IPv6Ext = append(pi.IPv6Ext, h)
+			}
+
+		case layers.LayerTypeGRE:
+			gre := l.(*layers.GRE)
+			var key uint32
+			if gre.KeyPresent {
+				key = gre.Key
+			}
+			pi.enterTunnel(GRE, key)
+
+		case layers.LayerTypeVXLAN:
+			pi.enterTunnel(VXLAN, l.(*layers.VXLAN).VNI)
+
+		case layers.LayerTypeGeneve:
+			pi.enterTunnel(GENEVE, l.(*layers.Geneve).VNI)
+
+		case layers.LayerTypeIPSecESP:
+			pi.opaqueTunnel(ESP, l.(*layers.IPSecESP).SPI)
 
 		case layers.LayerTypeTLS:
 			pi.Protocol = TLS
@@ -117,10 +245,16 @@ func ExtractPacketInfo(p gopacket.Packet) (*PacketInfo, *dns.DNSInfo) {
 
 		case layers.LayerTypeARP:
 			pi.Protocol = ARP
-			// arp := l.(*layers.ARP)
+			pi.ARP = decodeARP(l.(*layers.ARP))
+			pi.SrcIP = pi.ARP.SenderIP
+			pi.DestIP = pi.ARP.TargetIP
 		}
 	}
 
+	if pi.Protocol == UDP {
+		pi.labelOpaqueUDP()
+	}
+
 	if isPacketInfoNil(pi) {
 		if dnsInfo == nil {
 			return nil, nil
@@ -131,28 +265,98 @@ func ExtractPacketInfo(p gopacket.Packet) (*PacketInfo, *dns.DNSInfo) {
 	return pi, dnsInfo
 }
 
-// SelectInterface wraps a Charmbracelet Huh selection for the user to pick a
-// network to sniff. `findDevs` is a stub for `pcap.FindAllDevs`
-func SelectInterface(findDevs func() ([]pcap.Interface, error)) (string, error) {
+// Segment returns the TCP segment of the packet to feed to a
+// reassembly.Assembler
+func (pi *PacketInfo) Segment() reassembly.Segment {
+	return reassembly.Segment{
+		Time:      pi.Timestamp,
+		SrcIP:     pi.SrcIP,
+		SrcPort:   pi.SrcPort,
+		DstIP:     pi.DestIP,
+		DstPort:   pi.DestPort,
+		Interface: pi.Interface,
+		Seq:       pi.Seq,
+		Payload:   pi.Payload,
+		SYN:       pi.TCPFlags.SYN,
+		ACK:       pi.TCPFlags.ACK,
+		FIN:       pi.TCPFlags.FIN,
+		RST:       pi.TCPFlags.RST,
+	}
+}
+
+// Datagram returns the UDP datagram of the packet to feed to a quic.Tracker
+func (pi *PacketInfo) Datagram() quic.Datagram {
+	return quic.Datagram{
+		Time:      pi.Timestamp,
+		SrcIP:     pi.SrcIP,
+		SrcPort:   pi.SrcPort,
+		DstIP:     pi.DestIP,
+		DstPort:   pi.DestPort,
+		Interface: pi.Interface,
+		Payload:   pi.Payload,
+	}
+}
+
+// PortNumber drops the service name gopacket adds to well known ports, e.g.
+// 443(https) becomes 443
+func PortNumber(port string) string {
+	n, _, _ := strings.Cut(port, "(")
+	return n
+}
+
+// decodeARP reads the operation and addresses of an ARP layer. Only Ethernet
+// and IPv4 addresses are decoded, as with anything else ARP is rarely seen
+func decodeARP(a *layers.ARP) *ARPInfo {
+	info := &ARPInfo{Opcode: a.Operation}
+	switch a.Operation {
+	case layers.ARPRequest:
+		info.Operation = "request"
+	case layers.ARPReply:
+		info.Operation = "reply"
+	default:
+		info.Operation = fmt.Sprintf("op %d", a.Operation)
+	}
+
+	if a.AddrType == layers.LinkTypeEthernet && a.HwAddressSize == 6 {
+		info.SenderMAC = net.HardwareAddr(a.SourceHwAddress).String()
+		info.TargetMAC = net.HardwareAddr(a.DstHwAddress).String()
+	}
+	if a.Protocol == layers.EthernetTypeIPv4 && a.ProtAddressSize == 4 {
+		info.SenderIP = net.IP(a.SourceProtAddress).String()
+		info.TargetIP = net.IP(a.DstProtAddress).String()
+	}
+
+	return info
+}
+
+// SelectInterfaces wraps a Charmbracelet Huh multi-selection for the user to
+// pick one or more networks to sniff. `findDevs` is a stub for
+// `pcap.FindAllDevs`
+func SelectInterfaces(findDevs func() ([]pcap.Interface, error)) ([]string, error) {
 	ifaces, err := findDevs()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	names := filterNetworkInterfaces(ifaces)
 
-	var selected string
+	var selected []string
 	form := huh.NewForm(
 		huh.NewGroup(
-			huh.NewSelect[string]().
-				Title("Select a Interface").
-				OptionsFunc(func() []huh.Option[string] {
-					return huh.NewOptions(names...)
-				}, &selected).
+			huh.NewMultiSelect[string]().
+				Title("Select Interfaces").
+				Description("space to toggle, enter to confirm").
+				Options(huh.NewOptions(names...)...).
 				Value(&selected).
+				Validate(func(s []string) error {
+					if len(s) == 0 {
+						return errors.New("select at least one interface")
+					}
+					return nil
+				}).
 				Height(10),
 		),
-	)
+	).WithOutput(os.Stderr) // keep stdout clean for piped output
 	if err := form.Run(); err != nil {
 		log.Fatal(err)
 	}
@@ -61,6 +61,60 @@ func TestExtractPacketInfo_Ethernet(t *testing.T) {
 	assert.Equal(PacketProtocol("ETH"), pi.Protocol)
 }
 
+func TestExtractPacketInfo_EthernetAddresses(t *testing.T) {
+	assert := assert.New(t)
+	buf := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buf,
+		gopacket.SerializeOptions{},
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x03, 0x93, 0x12, 0x34, 0x56},
+			DstMAC:       net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		&layers.IPv4{
+			Version: 4,
+			IHL:     5,
+			SrcIP:   net.IP{192, 168, 0, 1},
+			DstIP:   net.IP{192, 168, 0, 255},
+		},
+	)
+
+	testPacket := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	pi, _ := ExtractPacketInfo(testPacket)
+	assert.Equal("00:03:93:12:34:56", pi.SrcMAC)
+	assert.Equal("ff:ff:ff:ff:ff:ff", pi.DstMAC)
+	assert.Equal(uint16(0x0800), pi.EtherType)
+	assert.Empty(pi.VLANs)
+}
+
+func TestExtractPacketInfo_VLAN(t *testing.T) {
+	assert := assert.New(t)
+	buf := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buf,
+		gopacket.SerializeOptions{},
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x0c, 0x00, 0x00, 0x01},
+			DstMAC:       net.HardwareAddr{0x00, 0x00, 0x0c, 0x00, 0x00, 0x02},
+			EthernetType: layers.EthernetTypeQinQ,
+		},
+		&layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeDot1Q},
+		&layers.Dot1Q{VLANIdentifier: 20, Type: layers.EthernetTypeIPv6},
+		&layers.IPv6{
+			Version:    6,
+			NextHeader: layers.IPProtocolNoNextHeader,
+			SrcIP:      net.ParseIP("fe80::1"),
+			DstIP:      net.ParseIP("fe80::2"),
+		},
+	)
+
+	testPacket := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	pi, _ := ExtractPacketInfo(testPacket)
+	assert.Equal([]uint16{100, 20}, pi.VLANs)
+	assert.Equal(uint16(0x86dd), pi.EtherType)
+	assert.Equal(PacketProtocol("IPv6"), pi.Protocol)
+	assert.Equal("fe80::1", pi.SrcIP)
+}
+
 func TestExtractPacketInfo_IPv4(t *testing.T) {
 	assert := assert.New(t)
 
@@ -337,6 +391,70 @@ func TestExtractPacketInfo_TLS(t *testing.T) {
 	assert.Equal(PacketProtocol("TLS"), pi.Protocol)
 }
 
+func TestExtractPacketInfo_TCPSegment(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buf,
+		gopacket.SerializeOptions{},
+		&layers.IPv4{
+			Version:  4,
+			IHL:      5,
+			SrcIP:    net.IP{192, 168, 0, 1},
+			DstIP:    net.IP{192, 168, 0, 2},
+			Protocol: layers.IPProtocolTCP,
+		},
+		&layers.TCP{
+			SrcPort:    layers.TCPPort(54321),
+			DstPort:    layers.TCPPort(443),
+			Seq:        1000,
+			DataOffset: 5,
+			FIN:        true,
+		},
+		gopacket.Payload{0x16, 0x03, 0x01},
+	)
+
+	testPacket := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	pi, _ := ExtractPacketInfo(testPacket)
+	assert.NotNil(pi)
+
+	seg := pi.Segment()
+	assert.Equal(uint32(1000), seg.Seq)
+	assert.Equal([]byte{0x16, 0x03, 0x01}, seg.Payload)
+	assert.Equal("443(https)", seg.DstPort)
+	assert.True(seg.FIN)
+}
+
+func TestExtractPacketInfo_UDPDatagram(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buf,
+		gopacket.SerializeOptions{},
+		&layers.IPv4{
+			Version:  4,
+			IHL:      5,
+			SrcIP:    net.IP{192, 168, 0, 1},
+			DstIP:    net.IP{192, 168, 0, 2},
+			Protocol: layers.IPProtocolUDP,
+		},
+		&layers.UDP{
+			SrcPort: layers.UDPPort(54321),
+			DstPort: layers.UDPPort(443),
+		},
+		gopacket.Payload{0xc3, 0x00, 0x00, 0x00, 0x01},
+	)
+
+	testPacket := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	pi, _ := ExtractPacketInfo(testPacket)
+	assert.NotNil(pi)
+
+	d := pi.Datagram()
+	assert.Equal([]byte{0xc3, 0x00, 0x00, 0x00, 0x01}, d.Payload)
+	assert.Equal("192.168.0.1", d.SrcIP)
+	assert.Equal("443(https)", d.DstPort)
+}
+
 func TestExtractPacketInfo_Empty(t *testing.T) {
 	assert := assert.New(t)
 
@@ -475,3 +593,9 @@ func TestIsPacketInfoNil_False_Protocol(t *testing.T) {
 func TestIsPacketInfoNil_True(t *testing.T) {
 	assert.True(t, isPacketInfoNil(&PacketInfo{}))
 }
+
+func TestPortNumber(t *testing.T) {
+	assert.Equal(t, "443", PortNumber("443(https)"))
+	assert.Equal(t, "51000", PortNumber("51000"))
+	assert.Equal(t, "", PortNumber(""))
+}
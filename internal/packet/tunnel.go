@@ -0,0 +1,101 @@
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Tunnel is an encapsulation a packet travelled in. Its addresses are those of
+// the outer headers, the PacketInfo's own are those of the innermost headers
+// that could be decoded
+type Tunnel struct {
+	Type    PacketProtocol `json:"type"` // GRE, VXLAN, GENEVE, IPIP, or the opaque ESP and WireGuard
+	SrcIP   string         `json:"src_ip"`
+	DstIP   string         `json:"dst_ip"`
+	SrcPort string         `json:"src_port,omitempty"` // of the tunnels carried over UDP
+	DstPort string         `json:"dst_port,omitempty"`
+	ID      uint32         `json:"id,omitempty"` // VXLAN or GENEVE VNI, GRE key, ESP SPI or WireGuard index
+}
+
+// Opaque reports whether the tunnel's payload is encrypted, so that the
+// packet's addresses are the tunnel's own
+func (t Tunnel) Opaque() bool {
+	return t.Type == ESP || t.Type == WireGuard
+}
+
+// String renders the tunnel, e.g. VXLAN 42 192.168.0.1 > 192.168.0.2
+func (t Tunnel) String() string {
+	s := string(t.Type)
+	if t.ID != 0 {
+		s += fmt.Sprintf(" %d", t.ID)
+	}
+	return s + " " + t.SrcIP + " > " + t.DstIP
+}
+
+// enterTunnel starts decoding what a tunnel carries, the endpoints decoded so
+// far become the tunnel's and the inner headers fill them in again
+func (pi *PacketInfo) enterTunnel(typ PacketProtocol, id uint32) {
+	t := Tunnel{Type: typ, SrcIP: pi.SrcIP, DstIP: pi.DestIP, ID: id}
+	if pi.Transport == UDP {
+		t.SrcPort, t.DstPort = pi.SrcPort, pi.DestPort
+	}
+	pi.Tunnels = append(pi.Tunnels, t)
+
+	pi.Protocol = typ
+	pi.SrcPort, pi.DestPort, pi.Transport = "", "", ""
+	pi.TCPFlags, pi.Seq, pi.Payload = TCPFlags{}, 0, nil
+}
+
+// opaqueTunnel labels a packet as the encrypted tunnel it is, keeping its
+// endpoints as they are
+func (pi *PacketInfo) opaqueTunnel(typ PacketProtocol, id uint32) {
+	pi.Tunnels = append(pi.Tunnels, Tunnel{
+		Type:    typ,
+		SrcIP:   pi.SrcIP,
+		DstIP:   pi.DestIP,
+		SrcPort: pi.SrcPort,
+		DstPort: pi.DestPort,
+		ID:      id,
+	})
+	pi.Protocol = typ
+}
+
+// ipsecNATPort is the UDP port ESP is carried over behind NAT, RFC 3948
+const ipsecNATPort = "4500"
+
+// labelOpaqueUDP labels a UDP datagram nothing else was decoded from as ESP
+// behind NAT or WireGuard, when its payload looks like one
+func (pi *PacketInfo) labelOpaqueUDP() {
+	b := pi.Payload
+	if PortNumber(pi.SrcPort) == ipsecNATPort || PortNumber(pi.DestPort) == ipsecNATPort {
+		// IKE starts with four zero bytes instead of an SPI, a lone 0xff is a
+		// keepalive
+		if len(b) >= 8 && binary.BigEndian.Uint32(b) != 0 {
+			pi.opaqueTunnel(ESP, binary.BigEndian.Uint32(b))
+		}
+		return
+	}
+
+	if index, ok := wireGuardIndex(b); ok {
+		pi.opaqueTunnel(WireGuard, index)
+	}
+}
+
+// wireGuardIndex returns the sender index of a WireGuard handshake initiation
+// or the receiver index of its other messages, which each have a type
+// followed by three zero bytes and a length of their own
+func wireGuardIndex(b []byte) (uint32, bool) {
+	if len(b) < 16 || b[1] != 0 || b[2] != 0 || b[3] != 0 {
+		return 0, false
+	}
+
+	switch {
+	case b[0] == 1 && len(b) == 148, // handshake initiation
+		b[0] == 3 && len(b) == 64,                   // cookie reply
+		b[0] == 4 && len(b) >= 32 && len(b)%16 == 0: // transport data
+		return binary.LittleEndian.Uint32(b[4:]), true
+	case b[0] == 2 && len(b) == 92: // handshake response
+		return binary.LittleEndian.Uint32(b[8:]), true
+	}
+	return 0, false
+}
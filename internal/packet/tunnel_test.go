@@ -0,0 +1,246 @@
+package packet
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tunnelPacket serializes the layers, fixing lengths, and
+// decodes them again from Ethernet
+func tunnelPacket(t *testing.T, ls ...gopacket.SerializableLayer) gopacket.Packet {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, ls...))
+	return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+}
+
+func outerEthernet(typ layers.EthernetType) *layers.Ethernet {
+	return &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x03, 0x93, 0x00, 0x00, 0x01},
+		DstMAC:       net.HardwareAddr{0x00, 0x03, 0x93, 0x00, 0x00, 0x02},
+		EthernetType: typ,
+	}
+}
+
+func outerIPv4(proto layers.IPProtocol) *layers.IPv4 {
+	return &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: proto,
+		SrcIP:    net.IP{192, 168, 0, 1},
+		DstIP:    net.IP{192, 168, 0, 2},
+	}
+}
+
+func innerIPv4(proto layers.IPProtocol) *layers.IPv4 {
+	return &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: proto,
+		SrcIP:    net.IP{10, 244, 1, 5},
+		DstIP:    net.IP{10, 244, 2, 9},
+	}
+}
+
+// assertInnerTCP checks that the packet's own fields are those of the inner
+// TCP segment
+func assertInnerTCP(t *testing.T, pi *PacketInfo) {
+	t.Helper()
+	assert.Equal(t, "10.244.1.5", pi.SrcIP)
+	assert.Equal(t, "10.244.2.9", pi.DestIP)
+	assert.Equal(t, "51000", pi.SrcPort)
+	assert.Equal(t, "443(https)", pi.DestPort)
+	assert.Equal(t, TCP, pi.Protocol)
+	assert.Equal(t, TCP, pi.Transport)
+	assert.True(t, pi.TCPFlags.SYN)
+}
+
+// ******************************
+// Tunnels
+// ******************************
+
+func TestExtractPacketInfo_VXLAN(t *testing.T) {
+	p := tunnelPacket(t,
+		outerEthernet(layers.EthernetTypeIPv4),
+		outerIPv4(layers.IPProtocolUDP),
+		&layers.UDP{SrcPort: 50000, DstPort: 4789},
+		&layers.VXLAN{ValidIDFlag: true, VNI: 42},
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 0x05},
+			DstMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 0x09},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		innerIPv4(layers.IPProtocolTCP),
+		&layers.TCP{SrcPort: 51000, DstPort: 443, SYN: true},
+	)
+
+	pi, _ := ExtractPacketInfo(p)
+	require.NotNil(t, pi)
+	assertInnerTCP(t, pi)
+	assert.Equal(t, "02:00:00:00:00:05", pi.SrcMAC)
+	assert.Equal(t, []Tunnel{{
+		Type:    VXLAN,
+		SrcIP:   "192.168.0.1",
+		DstIP:   "192.168.0.2",
+		SrcPort: "50000",
+		DstPort: "4789(vxlan)",
+		ID:      42,
+	}}, pi.Tunnels)
+	assert.Equal(t, "VXLAN 42 192.168.0.1 > 192.168.0.2", pi.Tunnels[0].String())
+}
+
+func TestExtractPacketInfo_GENEVE(t *testing.T) {
+	p := tunnelPacket(t,
+		outerEthernet(layers.EthernetTypeIPv4),
+		outerIPv4(layers.IPProtocolUDP),
+		&layers.UDP{SrcPort: 50000, DstPort: 6081},
+		&layers.Geneve{Protocol: layers.EthernetTypeTransparentEthernetBridging, VNI: 7},
+		outerEthernet(layers.EthernetTypeIPv4),
+		innerIPv4(layers.IPProtocolTCP),
+		&layers.TCP{SrcPort: 51000, DstPort: 443, SYN: true},
+	)
+
+	pi, _ := ExtractPacketInfo(p)
+	require.NotNil(t, pi)
+	assertInnerTCP(t, pi)
+	require.Len(t, pi.Tunnels, 1)
+	assert.Equal(t, GENEVE, pi.Tunnels[0].Type)
+	assert.Equal(t, uint32(7), pi.Tunnels[0].ID)
+}
+
+func TestExtractPacketInfo_GRE(t *testing.T) {
+	p := tunnelPacket(t,
+		outerEthernet(layers.EthernetTypeIPv4),
+		outerIPv4(layers.IPProtocolGRE),
+		&layers.GRE{Protocol: layers.EthernetTypeIPv4, KeyPresent: true, Key: 1001},
+		innerIPv4(layers.IPProtocolTCP),
+		&layers.TCP{SrcPort: 51000, DstPort: 443, SYN: true},
+	)
+
+	pi, _ := ExtractPacketInfo(p)
+	require.NotNil(t, pi)
+	assertInnerTCP(t, pi)
+	assert.Equal(t, []Tunnel{
+		{Type: GRE, SrcIP: "192.168.0.1", DstIP: "192.168.0.2", ID: 1001},
+	}, pi.Tunnels)
+}
+
+func TestExtractPacketInfo_IPIP(t *testing.T) {
+	// IPv6 in IPv4, then IPv4 in that
+	p := tunnelPacket(t,
+		outerEthernet(layers.EthernetTypeIPv4),
+		outerIPv4(layers.IPProtocolIPv6),
+		&layers.IPv6{
+			Version:    6,
+			HopLimit:   64,
+			NextHeader: layers.IPProtocolIPv4,
+			SrcIP:      net.ParseIP("2001:db8::1"),
+			DstIP:      net.ParseIP("2001:db8::2"),
+		},
+		innerIPv4(layers.IPProtocolTCP),
+		&layers.TCP{SrcPort: 51000, DstPort: 443, SYN: true},
+	)
+
+	pi, _ := ExtractPacketInfo(p)
+	require.NotNil(t, pi)
+	assertInnerTCP(t, pi)
+	assert.Equal(t, []Tunnel{
+		{Type: IPIP, SrcIP: "192.168.0.1", DstIP: "192.168.0.2"},
+		{Type: IPIP, SrcIP: "2001:db8::1", DstIP: "2001:db8::2"},
+	}, pi.Tunnels)
+}
+
+func TestExtractPacketInfo_NoTunnel(t *testing.T) {
+	p := tunnelPacket(t,
+		outerEthernet(layers.EthernetTypeIPv4),
+		innerIPv4(layers.IPProtocolTCP),
+		&layers.TCP{SrcPort: 51000, DstPort: 443, SYN: true},
+	)
+
+	pi, _ := ExtractPacketInfo(p)
+	require.NotNil(t, pi)
+	assertInnerTCP(t, pi)
+	assert.Empty(t, pi.Tunnels)
+}
+
+// ******************************
+// Opaque tunnels
+// ******************************
+
+func TestExtractPacketInfo_ESP(t *testing.T) {
+	esp := make([]byte, 32)
+	binary.BigEndian.PutUint32(esp, 0x1234)
+	p := tunnelPacket(t,
+		outerEthernet(layers.EthernetTypeIPv4),
+		outerIPv4(layers.IPProtocolESP),
+		gopacket.Payload(esp),
+	)
+
+	pi, _ := ExtractPacketInfo(p)
+	require.NotNil(t, pi)
+	assert.Equal(t, ESP, pi.Protocol)
+	assert.Equal(t, "192.168.0.1", pi.SrcIP)
+	require.Len(t, pi.Tunnels, 1)
+	assert.Equal(t, uint32(0x1234), pi.Tunnels[0].ID)
+	assert.True(t, pi.Tunnels[0].Opaque())
+}
+
+func TestExtractPacketInfo_OpaqueUDP(t *testing.T) {
+	udp := func(srcPort, dstPort layers.UDPPort, payload []byte) *PacketInfo {
+		t.Helper()
+		pi, _ := ExtractPacketInfo(tunnelPacket(t,
+			outerEthernet(layers.EthernetTypeIPv4),
+			outerIPv4(layers.IPProtocolUDP),
+			&layers.UDP{SrcPort: srcPort, DstPort: dstPort},
+			gopacket.Payload(payload),
+		))
+		require.NotNil(t, pi)
+		return pi
+	}
+
+	esp := make([]byte, 32)
+	binary.BigEndian.PutUint32(esp, 0xc0ffee)
+	pi := udp(4500, 4500, esp)
+	assert.Equal(t, ESP, pi.Protocol)
+	assert.Equal(t, UDP, pi.Transport)
+	assert.Equal(t, []Tunnel{{
+		Type:    ESP,
+		SrcIP:   "192.168.0.1",
+		DstIP:   "192.168.0.2",
+		SrcPort: "4500(ipsec-nat-t)",
+		DstPort: "4500(ipsec-nat-t)",
+		ID:      0xc0ffee,
+	}}, pi.Tunnels)
+
+	// IKE and keepalives
+	assert.Equal(t, UDP, udp(4500, 4500, make([]byte, 32)).Protocol)
+	assert.Equal(t, UDP, udp(4500, 4500, []byte{0xff}).Protocol)
+
+	initiation := make([]byte, 148)
+	initiation[0] = 1
+	binary.LittleEndian.PutUint32(initiation[4:], 77)
+	pi = udp(40000, 51820, initiation)
+	assert.Equal(t, WireGuard, pi.Protocol)
+	assert.Equal(t, uint32(77), pi.Tunnels[0].ID)
+
+	data := make([]byte, 64)
+	data[0] = 4
+	binary.LittleEndian.PutUint32(data[4:], 78)
+	pi = udp(40000, 51820, data)
+	assert.Equal(t, WireGuard, pi.Protocol)
+	assert.Equal(t, uint32(78), pi.Tunnels[0].ID)
+
+	// Not quite WireGuard
+	data[1] = 1
+	assert.Equal(t, UDP, udp(40000, 51820, data).Protocol)
+	assert.Equal(t, UDP, udp(40000, 51820, initiation[:100]).Protocol)
+}
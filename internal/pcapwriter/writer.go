@@ -0,0 +1,342 @@
+package pcapwriter
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/pcapgo"
+)
+
+// segmentTimeFormat is used in the name of every rotated segment, in the same
+// <name>_<seq>_<time>.pcapng layout Wireshark uses for its ring buffers
+const segmentTimeFormat = "20060102150405"
+
+// Config controls where packets are written and when the output is rotated
+type Config struct {
+	Path        string                  // output file, rotated segments are named after it
+	Interfaces  []pcapgo.NgInterface    // interfaces written to every segment, by InterfaceIndex
+	RotateSize  int64                   // rotate once a segment reaches this many bytes, 0 disables
+	RotateEvery time.Duration           // rotate once a segment covers this much time, 0 disables
+	MaxFiles    int                     // closed segments to keep around, 0 keeps everything
+	Gzip        bool                    // compress segments once they are closed
+	Options     *pcapgo.NgWriterOptions // section header options, defaults when nil
+}
+
+// rotates reports whether any rotation is configured. Without it the output
+// goes to Path as-is
+func (c Config) rotates() bool {
+	return c.RotateSize > 0 || c.RotateEvery > 0
+}
+
+// Writer saves packets to pcapng, starting a new segment file whenever the
+// configured size or time limit is reached. Closed segments are handed to a
+// background worker which compresses and prunes them, so rotation never
+// stalls the capture
+type Writer struct {
+	mu  sync.Mutex
+	cfg Config
+
+	file   *os.File
+	ng     *pcapgo.NgWriter
+	size   int64     // bytes of packet blocks in the current segment
+	opened time.Time // packet timestamp the current segment started at
+	seq    int
+
+	closed chan string
+	wg     sync.WaitGroup
+	kept   []string
+	done   bool // set by Close
+}
+
+// ErrClosed is returned when writing to a closed Writer
+var ErrClosed = errors.New("pcap writer is closed")
+
+// NewWriter returns a Writer for the given config. The first segment is
+// created when the first packet is written
+func NewWriter(cfg Config) (*Writer, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("no output path set")
+	}
+	if cfg.RotateSize < 0 || cfg.RotateEvery < 0 || cfg.MaxFiles < 0 {
+		return nil, fmt.Errorf("rotation limits cannot be negative")
+	}
+	if len(cfg.Interfaces) == 0 {
+		return nil, fmt.Errorf("no interfaces to describe the packets")
+	}
+
+	// Copy so the defaults below do not leak into the caller's slice
+	cfg.Interfaces = append([]pcapgo.NgInterface(nil), cfg.Interfaces...)
+	for i := range cfg.Interfaces {
+		if cfg.Interfaces[i].OS == "" {
+			cfg.Interfaces[i].OS = runtime.GOOS
+		}
+		if cfg.Interfaces[i].TimestampResolution == 0 {
+			cfg.Interfaces[i].TimestampResolution = 9
+		}
+	}
+
+	w := &Writer{
+		cfg:    cfg,
+		closed: make(chan string, 8),
+	}
+
+	w.wg.Add(1)
+	go w.finishSegments()
+
+	return w, nil
+}
+
+// WritePacket appends the packet to the current segment, rotating first if
+// the packet would fall outside its limits
+func (w *Writer) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	w.mu.Lock()
+	closed, err := w.writePacket(ci, data)
+	w.mu.Unlock()
+
+	// Handed over once unlocked, so a busy worker only holds up the packet
+	// that rotated and not every writer waiting on the lock
+	if closed != "" {
+		w.closed <- closed
+	}
+	return err
+}
+
+// writePacket does the work of WritePacket with the lock held, returning the
+// name of the segment it closed, if any
+func (w *Writer) writePacket(ci gopacket.CaptureInfo, data []byte) (string, error) {
+	if w.done {
+		return "", ErrClosed
+	}
+
+	var closed string
+	if w.ng != nil && w.shouldRotate(ci.Timestamp) {
+		var err error
+		if closed, err = w.closeSegment(); err != nil {
+			return closed, err
+		}
+	}
+
+	if w.ng == nil {
+		if err := w.openSegment(ci.Timestamp); err != nil {
+			return closed, err
+		}
+	}
+
+	if err := w.ng.WritePacket(ci, data); err != nil {
+		return closed, err
+	}
+
+	// An enhanced packet block is 32 bytes of framing plus the data padded
+	// to 32 bits. Counting here instead of at the file keeps the limit exact
+	// despite the NgWriter buffering
+	w.size += 32 + int64(len(data)+3)&^3
+	return closed, nil
+}
+
+// Close flushes and closes the current segment and waits for compression of
+// any closed segments to finish. Closing again does nothing
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.done {
+		w.mu.Unlock()
+		return nil
+	}
+	w.done = true
+
+	var (
+		closed string
+		err    error
+	)
+	if w.ng != nil {
+		closed, err = w.closeSegment()
+	}
+	w.mu.Unlock()
+
+	if closed != "" {
+		w.closed <- closed
+	}
+	close(w.closed)
+	w.wg.Wait()
+
+	return err
+}
+
+func (w *Writer) shouldRotate(ts time.Time) bool {
+	if w.cfg.RotateSize > 0 && w.size >= w.cfg.RotateSize {
+		return true
+	}
+	if w.cfg.RotateEvery > 0 && ts.Sub(w.opened) >= w.cfg.RotateEvery {
+		return true
+	}
+	return false
+}
+
+func (w *Writer) openSegment(ts time.Time) error {
+	name := w.cfg.Path
+	if w.cfg.rotates() {
+		w.seq++
+		name = SegmentName(w.cfg.Path, w.seq, ts)
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	opts := pcapgo.DefaultNgWriterOptions
+	opts.SectionInfo.Application = "packeteer"
+	if w.cfg.Options != nil {
+		opts = *w.cfg.Options
+	}
+
+	ng, err := pcapgo.NewNgWriterInterface(f, w.cfg.Interfaces[0], opts)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	for _, intf := range w.cfg.Interfaces[1:] {
+		if _, err := ng.AddInterface(intf); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	w.file = f
+	w.ng = ng
+	w.size = 0
+	w.opened = ts
+	return nil
+}
+
+// closeSegment flushes and closes the current segment, returning its name for
+// the caller to hand to the worker once the lock is released
+func (w *Writer) closeSegment() (string, error) {
+	err := w.ng.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+
+	name := w.file.Name()
+	w.file = nil
+	w.ng = nil
+	return name, err
+}
+
+// finishSegments compresses closed segments and removes the oldest ones once
+// more than MaxFiles are on disk. It runs until Close is called
+func (w *Writer) finishSegments() {
+	defer w.wg.Done()
+
+	for name := range w.closed {
+		if w.cfg.Gzip {
+			gz, err := gzipFile(name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "compressing %s: %v\n", name, err)
+			} else {
+				name = gz
+			}
+		}
+
+		w.kept = append(w.kept, name)
+		for w.cfg.MaxFiles > 0 && len(w.kept) > w.cfg.MaxFiles {
+			if err := os.Remove(w.kept[0]); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "removing %s: %v\n", w.kept[0], err)
+			}
+			w.kept = w.kept[1:]
+		}
+	}
+}
+
+// gzipFile compresses the file to <name>.gz and removes the original
+func gzipFile(name string) (string, error) {
+	in, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	gzName := name + ".gz"
+	out, err := os.Create(gzName)
+	if err != nil {
+		return "", err
+	}
+
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(name)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(gzName)
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(gzName)
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+
+	return gzName, os.Remove(name)
+}
+
+// SegmentName builds the file name of the seq-th segment of path, for example
+// capture.pcapng becomes capture_00001_20240101120000.pcapng
+func SegmentName(path string, seq int, ts time.Time) string {
+	ext := filepath.Ext(path)
+	if ext == "" {
+		ext = ".pcapng"
+	}
+	stem := strings.TrimSuffix(path, filepath.Ext(path))
+
+	return fmt.Sprintf("%s_%05d_%s%s", stem, seq, ts.UTC().Format(segmentTimeFormat), ext)
+}
+
+// ParseSize parses a byte count with an optional KB, MB or GB suffix (powers
+// of 1024), e.g. "512KB" or "100MB". A bare number is taken as bytes
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"G", 1 << 30},
+		{"M", 1 << 20},
+		{"K", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(s, u.suffix) {
+			mult = u.mult
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("size cannot be negative")
+	}
+
+	return n * mult, nil
+}
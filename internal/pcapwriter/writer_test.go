@@ -0,0 +1,336 @@
+package pcapwriter
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig(dir string) Config {
+	return Config{
+		Path: filepath.Join(dir, "capture.pcapng"),
+		Interfaces: []pcapgo.NgInterface{
+			{
+				Name:     "eth0",
+				Filter:   "tcp",
+				LinkType: layers.LinkTypeEthernet,
+			},
+		},
+	}
+}
+
+func writeN(t *testing.T, w *Writer, n int, start time.Time, step time.Duration) {
+	t.Helper()
+	data := make([]byte, 60)
+	for i := range n {
+		ci := gopacket.CaptureInfo{
+			Timestamp:     start.Add(time.Duration(i) * step),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}
+		require.NoError(t, w.WritePacket(ci, data))
+	}
+}
+
+func segments(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "capture*"))
+	require.NoError(t, err)
+	return files
+}
+
+func readAll(t *testing.T, r io.Reader) (*pcapgo.NgReader, int) {
+	t.Helper()
+	ng, err := pcapgo.NewNgReader(r, pcapgo.DefaultNgReaderOptions)
+	require.NoError(t, err)
+
+	n := 0
+	for {
+		_, _, err := ng.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		n++
+	}
+	return ng, n
+}
+
+// ******************************
+// Writer
+// ******************************
+
+func TestWriter_NoRotation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(testConfig(dir))
+	require.NoError(t, err)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeN(t, w, 5, t0, time.Second)
+	require.NoError(t, w.Close())
+
+	files := segments(t, dir)
+	require.Len(t, files, 1)
+	assert.Equal(t, filepath.Join(dir, "capture.pcapng"), files[0])
+
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+
+	ng, n := readAll(t, f)
+	assert.Equal(t, 5, n)
+	intf, err := ng.Interface(0)
+	require.NoError(t, err)
+	assert.Equal(t, "eth0", intf.Name)
+	assert.Equal(t, "tcp", intf.Filter)
+	assert.Equal(t, layers.LinkTypeEthernet, intf.LinkType)
+}
+
+func TestWriter_RotateSize(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testConfig(dir)
+	// 60 bytes of data + 32 bytes of framing per packet, so two per segment
+	cfg.RotateSize = 150
+	w, err := NewWriter(cfg)
+	require.NoError(t, err)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeN(t, w, 6, t0, time.Millisecond)
+	require.NoError(t, w.Close())
+
+	files := segments(t, dir)
+	require.Len(t, files, 3)
+	for _, name := range files {
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		_, n := readAll(t, f)
+		f.Close()
+		assert.Equal(t, 2, n)
+	}
+}
+
+func TestWriter_RotateSlowWorker(t *testing.T) {
+	cfg := testConfig(t.TempDir())
+	cfg.RotateSize = 150
+	// Without a worker handing over a closed segment blocks
+	w := &Writer{cfg: cfg, closed: make(chan string)}
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeN(t, w, 2, t0, time.Millisecond)
+
+	done := make(chan error)
+	go func() {
+		data := make([]byte, 60)
+		ci := gopacket.CaptureInfo{Timestamp: t0, CaptureLength: len(data), Length: len(data)}
+		done <- w.WritePacket(ci, data)
+	}()
+
+	// The next segment is open and the lock free while the handover waits
+	assert.Eventually(t, func() bool {
+		if !w.mu.TryLock() {
+			return false
+		}
+		defer w.mu.Unlock()
+		return w.seq == 2 && w.size > 0
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, SegmentName(cfg.Path, 1, t0), <-w.closed)
+	require.NoError(t, <-done)
+	require.NoError(t, w.file.Close())
+}
+
+func TestWriter_RotateEvery(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testConfig(dir)
+	cfg.RotateEvery = time.Minute
+	w, err := NewWriter(cfg)
+	require.NoError(t, err)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeN(t, w, 4, t0, 30*time.Second)
+	require.NoError(t, w.Close())
+
+	files := segments(t, dir)
+	require.Len(t, files, 2)
+	assert.Equal(t, SegmentName(cfg.Path, 1, t0), files[0])
+	assert.Equal(t, SegmentName(cfg.Path, 2, t0.Add(time.Minute)), files[1])
+}
+
+func TestWriter_MaxFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testConfig(dir)
+	cfg.RotateEvery = time.Minute
+	cfg.MaxFiles = 2
+	w, err := NewWriter(cfg)
+	require.NoError(t, err)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeN(t, w, 5, t0, time.Minute)
+	require.NoError(t, w.Close())
+
+	files := segments(t, dir)
+	require.Len(t, files, 2)
+	assert.Equal(t, SegmentName(cfg.Path, 4, t0.Add(3*time.Minute)), files[0])
+	assert.Equal(t, SegmentName(cfg.Path, 5, t0.Add(4*time.Minute)), files[1])
+}
+
+func TestWriter_Gzip(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testConfig(dir)
+	cfg.RotateEvery = time.Minute
+	cfg.Gzip = true
+	w, err := NewWriter(cfg)
+	require.NoError(t, err)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeN(t, w, 2, t0, time.Minute)
+	require.NoError(t, w.Close())
+
+	files := segments(t, dir)
+	require.Len(t, files, 2)
+	for _, name := range files {
+		assert.Equal(t, ".gz", filepath.Ext(name))
+
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		zr, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		_, n := readAll(t, zr)
+		f.Close()
+		assert.Equal(t, 1, n)
+	}
+}
+
+func TestWriter_MultipleInterfaces(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testConfig(dir)
+	cfg.Interfaces = append(cfg.Interfaces, pcapgo.NgInterface{
+		Name:     "eth1",
+		LinkType: layers.LinkTypeEthernet,
+	})
+	w, err := NewWriter(cfg)
+	require.NoError(t, err)
+
+	data := make([]byte, 60)
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, idx := range []int{0, 1} {
+		ci := gopacket.CaptureInfo{
+			Timestamp:      ts,
+			CaptureLength:  len(data),
+			Length:         len(data),
+			InterfaceIndex: idx,
+		}
+		require.NoError(t, w.WritePacket(ci, data))
+	}
+	require.NoError(t, w.Close())
+
+	f, err := os.Open(cfg.Path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	ng, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	require.NoError(t, err)
+	var got []int
+	for {
+		_, ci, err := ng.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, ci.InterfaceIndex)
+	}
+	assert.Equal(t, []int{0, 1}, got)
+
+	intf, err := ng.Interface(1)
+	require.NoError(t, err)
+	assert.Equal(t, "eth1", intf.Name)
+}
+
+func TestWriter_Closed(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testConfig(dir)
+	w, err := NewWriter(cfg)
+	require.NoError(t, err)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeN(t, w, 2, t0, time.Millisecond)
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+
+	// A late packet neither starts the file over nor panics
+	data := make([]byte, 60)
+	ci := gopacket.CaptureInfo{Timestamp: t0, CaptureLength: len(data), Length: len(data)}
+	assert.ErrorIs(t, w.WritePacket(ci, data), ErrClosed)
+
+	f, err := os.Open(cfg.Path)
+	require.NoError(t, err)
+	defer f.Close()
+	_, n := readAll(t, f)
+	assert.Equal(t, 2, n)
+}
+
+func TestNewWriter_NoInterfaces(t *testing.T) {
+	cfg := testConfig(t.TempDir())
+	cfg.Interfaces = nil
+	_, err := NewWriter(cfg)
+	assert.Error(t, err)
+}
+
+func TestNewWriter_NoPath(t *testing.T) {
+	_, err := NewWriter(Config{})
+	assert.Error(t, err)
+}
+
+func TestNewWriter_Negative(t *testing.T) {
+	cfg := testConfig(t.TempDir())
+	cfg.MaxFiles = -1
+	_, err := NewWriter(cfg)
+	assert.Error(t, err)
+}
+
+// ******************************
+// SegmentName
+// ******************************
+
+func TestSegmentName(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Equal(t, "/tmp/cap_00001_20240102030405.pcapng", SegmentName("/tmp/cap.pcapng", 1, ts))
+	assert.Equal(t, "cap_00012_20240102030405.pcapng", SegmentName("cap", 12, ts))
+}
+
+// ******************************
+// ParseSize
+// ******************************
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"":       0,
+		"100":    100,
+		"512KB":  512 << 10,
+		"100MB":  100 << 20,
+		"2gb":    2 << 30,
+		"10 M":   10 << 20,
+		"1024B":  1024,
+		" 64k  ": 64 << 10,
+	} {
+		got, err := ParseSize(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+}
+
+func TestParseSize_Invalid(t *testing.T) {
+	for _, in := range []string{"MB", "ten", "-5MB", "1.5GB"} {
+		_, err := ParseSize(in)
+		assert.Error(t, err, in)
+	}
+}
@@ -0,0 +1,350 @@
+package quic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// Versions whose Initial packets can be read
+const (
+	Version1 = 0x00000001 // RFC 9000
+	Version2 = 0x6b3343cf // RFC 9369
+)
+
+// Long header packet types, as numbered by version 1. Version 2 shuffles the
+// bits on the wire, see packetType
+const (
+	typeInitial = iota
+	type0RTT
+	typeHandshake
+	typeRetry
+)
+
+// Frame types found in Initial packets
+const (
+	framePadding  = 0x00
+	framePing     = 0x01
+	frameAck      = 0x02
+	frameAckECN   = 0x03
+	frameCrypto   = 0x06
+	frameClose    = 0x1c
+	frameCloseApp = 0x1d
+)
+
+const (
+	maxCIDLength   = 20
+	sampleLength   = 16        // of the ciphertext, for header protection
+	maxCryptoBytes = 64 * 1024 // buffered per side before giving up
+)
+
+// initialSalts derive the Initial secrets from the client's first
+// destination connection ID
+var initialSalts = map[uint32][]byte{
+	Version1: {
+		0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+		0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+	},
+	Version2: {
+		0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93,
+		0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9,
+	},
+}
+
+var (
+	errShort       = errors.New("quic: truncated packet")
+	errUnsupported = errors.New("quic: unsupported version")
+)
+
+// header is the cleartext part of a long header packet
+type header struct {
+	version uint32
+	typ     int // typeInitial and so on, whatever the version
+	dcid    []byte
+	scid    []byte
+	pnStart int // offset of the packet number, Initial packets only
+	end     int // offset of the next coalesced packet
+}
+
+// isLong reports whether a packet starts with a long header
+func isLong(b []byte) bool {
+	return len(b) > 0 && b[0]&0x80 != 0
+}
+
+// parseLong parses the long header at the start of b
+func parseLong(b []byte) (*header, error) {
+	if len(b) < 7 {
+		return nil, errShort
+	}
+	h := &header{version: binary.BigEndian.Uint32(b[1:5]), end: len(b)}
+
+	i := 5
+	for _, cid := range []*[]byte{&h.dcid, &h.scid} {
+		if i >= len(b) || int(b[i]) > maxCIDLength || i+1+int(b[i]) > len(b) {
+			return nil, errShort
+		}
+		*cid = b[i+1 : i+1+int(b[i])]
+		i += 1 + int(b[i])
+	}
+
+	// Version negotiation and unknown versions tell nothing more
+	if _, ok := initialSalts[h.version]; !ok {
+		return h, errUnsupported
+	}
+	h.typ = packetType(h.version, b[0])
+
+	switch h.typ {
+	case typeRetry:
+		return h, nil
+	case typeInitial:
+		tokenLen, n := varint(b[i:])
+		if n == 0 || uint64(len(b)-i-n) < tokenLen {
+			return nil, errShort
+		}
+		i += n + int(tokenLen)
+	}
+
+	length, n := varint(b[i:])
+	if n == 0 || uint64(len(b)-i-n) < length {
+		return nil, errShort
+	}
+	h.pnStart = i + n
+	h.end = h.pnStart + int(length)
+	return h, nil
+}
+
+// packetType reads the long header packet type of the first byte
+func packetType(version uint32, first byte) int {
+	t := int(first>>4) & 0x03
+	if version == Version2 {
+		// Initial 1, 0-RTT 2, Handshake 3, Retry 0
+		return (t + 3) % 4
+	}
+	return t
+}
+
+// varint decodes a variable length integer, returning its value and size or
+// 0 when b is too short
+func varint(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, 0
+	}
+	v := uint64(b[0] & 0x3f)
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n
+}
+
+// keys protect the Initial packets one side sends
+type keys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block
+}
+
+// initialKeys derives the Initial keys of the client, or of the server, from
+// the destination connection ID of the client's first Initial, RFC 9001
+// section 5.2
+func initialKeys(version uint32, dcid []byte, client bool) (*keys, error) {
+	salt, ok := initialSalts[version]
+	if !ok {
+		return nil, errUnsupported
+	}
+	initial, err := hkdf.Extract(sha256.New, dcid, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	label := "server in"
+	if client {
+		label = "client in"
+	}
+	secret, err := expandLabel(initial, label, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := "quic "
+	if version == Version2 {
+		prefix = "quicv2 "
+	}
+	key, err := expandLabel(secret, prefix+"key", 16)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := expandLabel(secret, prefix+"iv", 12)
+	if err != nil {
+		return nil, err
+	}
+	hpKey, err := expandLabel(secret, prefix+"hp", 16)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(hpKey)
+	if err != nil {
+		return nil, err
+	}
+	return &keys{aead: aead, iv: iv, hp: hp}, nil
+}
+
+// expandLabel is HKDF-Expand-Label of TLS 1.3 with an empty context
+func expandLabel(secret []byte, label string, length int) ([]byte, error) {
+	label = "tls13 " + label
+	info := make([]byte, 0, 4+len(label))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+	return hkdf.Expand(sha256.New, secret, string(info), length)
+}
+
+// open removes the header protection of an Initial packet and decrypts its
+// payload, RFC 9001 section 5.4. The packet is left untouched
+func (k *keys) open(b []byte, h *header) ([]byte, error) {
+	if h.pnStart+4+sampleLength > h.end {
+		return nil, errShort
+	}
+
+	mask := make([]byte, aes.BlockSize)
+	k.hp.Encrypt(mask, b[h.pnStart+4:h.pnStart+4+sampleLength])
+
+	first := b[0] ^ mask[0]&0x0f
+	pnLen := int(first&0x03) + 1
+	hdr := make([]byte, h.pnStart+pnLen)
+	copy(hdr, b)
+	hdr[0] = first
+
+	// The full packet number is close enough to the truncated one this early
+	// in a connection
+	var pn uint64
+	for i := range pnLen {
+		hdr[h.pnStart+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(hdr[h.pnStart+i])
+	}
+
+	nonce := make([]byte, len(k.iv))
+	copy(nonce, k.iv)
+	for i := range 8 {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+
+	return k.aead.Open(nil, nonce, b[h.pnStart+pnLen:h.end], hdr)
+}
+
+// cryptoFrames calls handle with the offset and data of every CRYPTO frame of
+// a decrypted Initial payload
+func cryptoFrames(payload []byte, handle func(offset uint64, data []byte)) error {
+	for i := 0; i < len(payload); {
+		typ := payload[i]
+		i++
+
+		switch typ {
+		case framePadding, framePing:
+		case frameAck, frameAckECN:
+			// largest, delay, range count and first range, then the ranges
+			var fields [4]uint64
+			for f := range fields {
+				v, n := varint(payload[i:])
+				if n == 0 {
+					return errShort
+				}
+				fields[f], i = v, i+n
+			}
+			skip := 2 * fields[2]
+			if typ == frameAckECN {
+				skip += 3
+			}
+			for range skip {
+				_, n := varint(payload[i:])
+				if n == 0 {
+					return errShort
+				}
+				i += n
+			}
+		case frameCrypto:
+			offset, n := varint(payload[i:])
+			if n == 0 {
+				return errShort
+			}
+			i += n
+			length, n := varint(payload[i:])
+			if n == 0 || uint64(len(payload)-i-n) < length {
+				return errShort
+			}
+			i += n
+			handle(offset, payload[i:i+int(length)])
+			i += int(length)
+		case frameClose, frameCloseApp:
+			return nil
+		default:
+			return errors.New("quic: unexpected frame in Initial packet")
+		}
+	}
+	return nil
+}
+
+// cryptoStream puts the CRYPTO frames of one side back in order. Clients
+// split and shuffle their ClientHello across frames and packets on purpose
+type cryptoStream struct {
+	data    []byte            // in order from offset 0
+	pending map[uint64][]byte // frames past the end of data, by offset
+	read    int               // bytes of data already handed out as messages
+	done    bool
+}
+
+// write adds a CRYPTO frame and calls handle with every handshake message
+// completed by it
+func (s *cryptoStream) write(offset uint64, frame []byte, handle func(typ uint8, body []byte)) {
+	if s.done || offset+uint64(len(frame)) > maxCryptoBytes {
+		return
+	}
+	if s.pending == nil {
+		s.pending = map[uint64][]byte{}
+	}
+	if prev, ok := s.pending[offset]; !ok || len(prev) < len(frame) {
+		s.pending[offset] = frame
+	}
+
+	// Keep appending the pending frames that reach past the end of data
+	for grown := true; grown; {
+		grown = false
+		for off, f := range s.pending {
+			end := off + uint64(len(f))
+			if off > uint64(len(s.data)) {
+				continue
+			}
+			delete(s.pending, off)
+			if end > uint64(len(s.data)) {
+				s.data = append(s.data, f[uint64(len(s.data))-off:]...)
+				grown = true
+			}
+		}
+	}
+
+	for !s.done && len(s.data)-s.read >= 4 {
+		msg := s.data[s.read:]
+		n := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+		if len(msg) < 4+n {
+			return
+		}
+		s.read += 4 + n
+		handle(msg[0], msg[4:4+n])
+	}
+}
@@ -0,0 +1,349 @@
+// Package quic reads the Initial packets of QUIC connections, whose
+// protection only depends on values sent in the clear, to recover the TLS
+// ClientHello and ServerHello inside them. It also follows the connection IDs
+// of each connection so its packets are recognized on any path
+package quic
+
+import (
+	"encoding/hex"
+	"slices"
+	"sync"
+	"time"
+
+	"packeteer/internal/tls"
+)
+
+// connTimeout is how long a connection may go without a packet before it is
+// forgotten
+const connTimeout = 5 * time.Minute
+
+// Handshake message types carried by the CRYPTO frames of Initial packets
+const (
+	typeClientHello = 1
+	typeServerHello = 2
+)
+
+// Info is what the cleartext parts of a connection's packets tell about it.
+// The addresses are those of the client's first Initial, whatever path the
+// connection has moved to since
+type Info struct {
+	Version    string   `json:"version"` // QUIC v1 or QUIC v2
+	ClientIP   string   `json:"client_ip"`
+	ClientPort string   `json:"client_port"`
+	ServerIP   string   `json:"server_ip"`
+	ServerPort string   `json:"server_port"`
+	ConnIDs    []string `json:"conn_ids,omitempty"` // hex, as seen in long headers
+	Migrated   bool     `json:"migrated,omitempty"` // seen on another path than the first
+}
+
+// Datagram is a UDP datagram handed to a Tracker. Ports are gopacket port
+// strings such as 443(https)
+type Datagram struct {
+	Time      time.Time
+	SrcIP     string
+	SrcPort   string
+	DstIP     string
+	DstPort   string
+	Interface string
+	Payload   []byte
+}
+
+type path struct {
+	clientIP, clientPort, serverIP, serverPort string
+}
+
+// side is the endpoint that chose a connection ID, packets to it carry the ID
+// as their destination
+type side struct {
+	conn   *conn
+	server bool
+}
+
+type conn struct {
+	info           Info
+	tls            tls.Info
+	version        uint32
+	initialCID     []byte // the Initial keys derive from it
+	client, server cryptoStream
+	lastSeen       time.Time
+	changed        bool
+	completed      bool // the ServerHello was reported
+}
+
+// Tracker follows QUIC connections from the client's first Initial, reading
+// their handshake and recognizing their packets by connection ID when they
+// switch to a new path, as after a NAT rebinding. IDs issued later are
+// encrypted, so a migration that also changes IDs looks like a new flow
+type Tracker struct {
+	mu      sync.Mutex
+	paths   map[path]*conn
+	ids     map[string]side // by connection ID bytes
+	lengths []int           // of the IDs, to find them in short headers
+}
+
+// NewTracker returns a Tracker following no connections
+func NewTracker() *Tracker {
+	return &Tracker{paths: map[path]*conn{}, ids: map[string]side{}}
+}
+
+// Feed adds a UDP datagram. When it belongs to a known QUIC connection, or
+// starts one, a snapshot of the connection's Info is returned. hs is a
+// snapshot of the handshake when the datagram taught something new about it,
+// and complete reports whether the ServerHello, the last message readable,
+// was seen
+func (t *Tracker) Feed(d Datagram) (info *Info, hs *tls.Info, complete bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(d.Payload) == 0 || d.Payload[0]&0x40 == 0 { // the fixed bit
+		return nil, nil, false
+	}
+
+	c, fromClient := t.lookup(d)
+	if c == nil {
+		c = t.start(d)
+		if c == nil {
+			return nil, nil, false
+		}
+		fromClient = true
+	}
+	c.lastSeen = d.Time
+
+	if fromClient {
+		t.addPath(c, path{d.SrcIP, d.SrcPort, d.DstIP, d.DstPort})
+	} else {
+		t.addPath(c, path{d.DstIP, d.DstPort, d.SrcIP, d.SrcPort})
+	}
+
+	for b := d.Payload; isLong(b); {
+		h, err := parseLong(b)
+		if err != nil {
+			break
+		}
+		t.readLong(c, b[:h.end], h, fromClient)
+		b = b[h.end:]
+	}
+
+	snapshot := c.info
+	snapshot.ConnIDs = slices.Clone(c.info.ConnIDs)
+	info = &snapshot
+
+	if c.changed || c.server.done && !c.completed {
+		c.changed = false
+		snapshot := c.tls
+		hs = &snapshot
+	}
+	if c.server.done && !c.completed {
+		c.completed = true
+		complete = true
+	}
+	return info, hs, complete
+}
+
+// lookup finds the connection of a datagram by its connection IDs, then by its
+// addresses
+func (t *Tracker) lookup(d Datagram) (*conn, bool) {
+	b := d.Payload
+	if isLong(b) {
+		h, err := parseLong(b)
+		if err != nil && h == nil {
+			return nil, false
+		}
+		if s, ok := t.ids[string(h.dcid)]; ok {
+			return s.conn, s.server
+		}
+		if s, ok := t.ids[string(h.scid)]; ok {
+			return s.conn, !s.server
+		}
+		// A client Initial to a new destination ID starts a new connection,
+		// even on a path that was used before
+		if h.typ == typeInitial && err == nil {
+			return nil, false
+		}
+	} else {
+		for _, n := range t.lengths {
+			if len(b) < 1+n {
+				continue
+			}
+			if s, ok := t.ids[string(b[1:1+n])]; ok {
+				return s.conn, s.server
+			}
+		}
+	}
+
+	if c, ok := t.paths[path{d.SrcIP, d.SrcPort, d.DstIP, d.DstPort}]; ok {
+		return c, true
+	}
+	if c, ok := t.paths[path{d.DstIP, d.DstPort, d.SrcIP, d.SrcPort}]; ok {
+		return c, false
+	}
+	return nil, false
+}
+
+// start begins following a connection when the datagram is a client Initial
+// carrying the start of a ClientHello
+func (t *Tracker) start(d Datagram) *conn {
+	if !isLong(d.Payload) {
+		return nil
+	}
+	h, err := parseLong(d.Payload)
+	if err != nil || h.typ != typeInitial {
+		return nil
+	}
+	k, err := initialKeys(h.version, h.dcid, true)
+	if err != nil {
+		return nil
+	}
+	payload, err := k.open(d.Payload, h)
+	if err != nil {
+		return nil
+	}
+
+	// Any CRYPTO frame will do, the ClientHello may be shuffled
+	var crypto bool
+	_ = cryptoFrames(payload, func(uint64, []byte) { crypto = true })
+	if !crypto {
+		return nil
+	}
+
+	t.purge(d.Time)
+	version := "QUIC v1"
+	if h.version == Version2 {
+		version = "QUIC v2"
+	}
+	c := &conn{
+		info: Info{
+			Version:    version,
+			ClientIP:   d.SrcIP,
+			ClientPort: d.SrcPort,
+			ServerIP:   d.DstIP,
+			ServerPort: d.DstPort,
+		},
+		tls: tls.Info{
+			Time:       d.Time,
+			ClientIP:   d.SrcIP,
+			ClientPort: d.SrcPort,
+			ServerIP:   d.DstIP,
+			ServerPort: d.DstPort,
+			Interface:  d.Interface,
+		},
+		version:    h.version,
+		initialCID: slices.Clone(h.dcid),
+	}
+	t.paths[path{d.SrcIP, d.SrcPort, d.DstIP, d.DstPort}] = c
+	return c
+}
+
+// readLong learns the connection IDs of a long header packet and reads the
+// handshake messages of an Initial
+func (t *Tracker) readLong(c *conn, b []byte, h *header, fromClient bool) {
+	if h.version != c.version {
+		return
+	}
+	// Packets from the client are addressed to IDs the server chose, and the
+	// other way around
+	t.addID(c, h.dcid, fromClient)
+	t.addID(c, h.scid, !fromClient)
+
+	switch {
+	case h.typ == typeRetry && !fromClient:
+		// The client starts over, with keys from the ID the server picked
+		c.initialCID = slices.Clone(h.scid)
+		c.client = cryptoStream{}
+		return
+	case h.typ != typeInitial:
+		return
+	}
+
+	k, err := initialKeys(c.version, c.initialCID, fromClient)
+	if err != nil {
+		return
+	}
+	payload, err := k.open(b, h)
+	if err != nil {
+		return
+	}
+
+	if fromClient {
+		_ = cryptoFrames(payload, func(offset uint64, data []byte) {
+			c.client.write(offset, data, c.handleClient)
+		})
+	} else {
+		_ = cryptoFrames(payload, func(offset uint64, data []byte) {
+			c.server.write(offset, data, c.handleServer)
+		})
+	}
+}
+
+// handleClient reads the ClientHello, the only message a client sends in
+// Initial packets
+func (c *conn) handleClient(typ uint8, body []byte) {
+	if typ != typeClientHello {
+		return
+	}
+	c.client.done = true
+
+	h, err := tls.ParseClientHello(body)
+	if err != nil {
+		return
+	}
+	c.tls.SetClientHello(h, 'q')
+	c.changed = true
+}
+
+// handleServer reads the ServerHello, what follows it is in Handshake packets
+func (c *conn) handleServer(typ uint8, body []byte) {
+	if typ != typeServerHello {
+		return
+	}
+	c.server.done = true
+
+	h, err := tls.ParseServerHello(body)
+	if err != nil {
+		return
+	}
+	c.tls.SetServerHello(h)
+	c.changed = true
+}
+
+// addID remembers a connection ID chosen by the server, or by the client
+func (t *Tracker) addID(c *conn, id []byte, server bool) {
+	if len(id) == 0 {
+		return
+	}
+	if s, ok := t.ids[string(id)]; ok && s.conn == c {
+		return
+	}
+
+	t.ids[string(id)] = side{conn: c, server: server}
+	c.info.ConnIDs = append(c.info.ConnIDs, hex.EncodeToString(id))
+	if !slices.Contains(t.lengths, len(id)) {
+		t.lengths = append(t.lengths, len(id))
+	}
+}
+
+// addPath records a client to server path of a connection, marking it
+// migrated when it is not the first
+func (t *Tracker) addPath(c *conn, p path) {
+	if cur, ok := t.paths[p]; ok && cur == c {
+		return
+	}
+	t.paths[p] = c
+	if p != (path{c.info.ClientIP, c.info.ClientPort, c.info.ServerIP, c.info.ServerPort}) {
+		c.info.Migrated = true
+	}
+}
+
+// purge forgets the connections that went quiet
+func (t *Tracker) purge(now time.Time) {
+	for p, c := range t.paths {
+		if now.Sub(c.lastSeen) > connTimeout {
+			delete(t.paths, p)
+		}
+	}
+	for id, s := range t.ids {
+		if now.Sub(s.conn.lastSeen) > connTimeout {
+			delete(t.ids, id)
+		}
+	}
+}
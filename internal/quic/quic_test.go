@@ -0,0 +1,462 @@
+package quic
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	cryptotls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	clientIP = "10.0.0.5"
+	serverIP = "142.250.74.100"
+)
+
+var (
+	clientDCID = []byte{0x83, 0x94, 0xc8, 0xf0, 0x3e, 0x51, 0x57, 0x08}
+	clientSCID = []byte{0xc1, 0xc2, 0xc3, 0xc4}
+	serverSCID = []byte{0x5e, 0x5e, 0x5e, 0x5e, 0x5e, 0x5e, 0x5e, 0x5e}
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+// handshake runs crypto/tls's QUIC client and server, returning the CRYPTO
+// data each sends at the Initial level: the ClientHello and the ServerHello
+func handshake(t *testing.T) (clientHello, serverHello []byte) {
+	t.Helper()
+	ctx := context.Background()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    t0,
+		NotAfter:     t0.Add(24 * time.Hour),
+	}, &x509.Certificate{SerialNumber: big.NewInt(1)}, pub, priv)
+	require.NoError(t, err)
+
+	cli := cryptotls.QUICClient(&cryptotls.QUICConfig{TLSConfig: &cryptotls.Config{
+		ServerName:         "example.com",
+		NextProtos:         []string{"h3"},
+		InsecureSkipVerify: true,
+		MinVersion:         cryptotls.VersionTLS13,
+	}})
+	cli.SetTransportParameters([]byte{})
+	require.NoError(t, cli.Start(ctx))
+	defer cli.Close()
+	for e := cli.NextEvent(); e.Kind != cryptotls.QUICNoEvent; e = cli.NextEvent() {
+		if e.Kind == cryptotls.QUICWriteData && e.Level == cryptotls.QUICEncryptionLevelInitial {
+			clientHello = append(clientHello, e.Data...)
+		}
+	}
+
+	srv := cryptotls.QUICServer(&cryptotls.QUICConfig{TLSConfig: &cryptotls.Config{
+		Certificates: []cryptotls.Certificate{{Certificate: [][]byte{der}, PrivateKey: priv}},
+		NextProtos:   []string{"h3"},
+		MinVersion:   cryptotls.VersionTLS13,
+	}})
+	require.NoError(t, srv.Start(ctx))
+	defer srv.Close()
+	require.NoError(t, srv.HandleData(cryptotls.QUICEncryptionLevelInitial, clientHello))
+	for e := srv.NextEvent(); e.Kind != cryptotls.QUICNoEvent; e = srv.NextEvent() {
+		switch {
+		case e.Kind == cryptotls.QUICTransportParametersRequired:
+			srv.SetTransportParameters([]byte{})
+		case e.Kind == cryptotls.QUICWriteData && e.Level == cryptotls.QUICEncryptionLevelInitial:
+			serverHello = append(serverHello, e.Data...)
+		}
+	}
+
+	require.NotEmpty(t, clientHello)
+	require.NotEmpty(t, serverHello)
+	return clientHello, serverHello
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return binary.BigEndian.AppendUint16(b, uint16(v)|0x4000)
+	default:
+		return binary.BigEndian.AppendUint32(b, uint32(v)|0x80000000)
+	}
+}
+
+// crypto returns a CRYPTO frame
+func crypto(offset uint64, data []byte) []byte {
+	b := appendVarint([]byte{frameCrypto}, offset)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// longHeader returns the start of a long header packet of type typ, as
+// numbered by version 1, up to its length
+func longHeader(version uint32, typ int, dcid, scid []byte) []byte {
+	bits := typ
+	if version == Version2 {
+		bits = (typ + 1) % 4
+	}
+	b := []byte{0xc0 | byte(bits)<<4 | 0x03} // 4 byte packet numbers
+	b = binary.BigEndian.AppendUint32(b, version)
+	b = append(b, byte(len(dcid)))
+	b = append(b, dcid...)
+	b = append(b, byte(len(scid)))
+	b = append(b, scid...)
+	if typ == typeInitial {
+		b = append(b, 0) // no token
+	}
+	return b
+}
+
+// initial returns an Initial packet carrying frames, protected with the keys
+// derived from keyCID as RFC 9001 describes
+func initial(
+	t *testing.T, version uint32, keyCID, dcid, scid []byte, client bool, pn uint32, frames []byte,
+) []byte {
+	t.Helper()
+	k, err := initialKeys(version, keyCID, client)
+	require.NoError(t, err)
+
+	b := longHeader(version, typeInitial, dcid, scid)
+	b = binary.BigEndian.AppendUint16(b, uint16(4+len(frames)+16)|0x4000)
+	pnStart := len(b)
+	b = binary.BigEndian.AppendUint32(b, pn)
+
+	nonce := make([]byte, len(k.iv))
+	copy(nonce, k.iv)
+	for i := range 4 {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	b = k.aead.Seal(b, nonce, frames, b)
+
+	mask := make([]byte, aes.BlockSize)
+	k.hp.Encrypt(mask, b[pnStart+4:pnStart+4+sampleLength])
+	b[0] ^= mask[0] & 0x0f
+	for i := range 4 {
+		b[pnStart+i] ^= mask[1+i]
+	}
+	return b
+}
+
+// clientInitial is the Initial packet number pn of the client, to the random
+// ID its keys derive from
+func clientInitial(t *testing.T, pn uint32, frames []byte) []byte {
+	return initial(t, Version1, clientDCID, clientDCID, clientSCID, true, pn, frames)
+}
+
+// serverInitial is the server's answer, from an ID of its own
+func serverInitial(t *testing.T, frames []byte) []byte {
+	return initial(t, Version1, clientDCID, clientSCID, serverSCID, false, 0, frames)
+}
+
+func datagram(from, to string, payload ...[]byte) Datagram {
+	d := Datagram{Time: t0, SrcIP: from, DstIP: to, SrcPort: "51000", DstPort: "443(https)"}
+	if from == serverIP {
+		d.SrcPort, d.DstPort = d.DstPort, d.SrcPort
+	}
+	for _, p := range payload {
+		d.Payload = append(d.Payload, p...)
+	}
+	return d
+}
+
+// ******************************
+// Packets
+// ******************************
+
+func TestInitialKeys(t *testing.T) {
+	// RFC 9001 appendix A.1
+	tests := []struct {
+		version     uint32
+		client      bool
+		key, iv, hp string
+	}{
+		{
+			Version1, true,
+			"1f369613dd76d5467730efcbe3b1a22d",
+			"fa044b2f42a3fd3b46fb255c",
+			"9f50449e04a0e810283a1e9933adedd2",
+		},
+		{
+			Version1, false,
+			"cf3a5331653c364c88f0f379b6067e37",
+			"0ac1493ca1905853b0bba03e",
+			"c206b8d9b9f0f37644430b490eeaa314",
+		},
+		// RFC 9369 appendix A.1
+		{
+			Version2, true,
+			"8b1a0bc121284290a29e0971b5cd045d",
+			"91f73e2351d8fa91660e909f",
+			"45b95e15235d6f45a6b19cbcb0294ba9",
+		},
+	}
+
+	for _, tt := range tests {
+		k, err := initialKeys(tt.version, clientDCID, tt.client)
+		require.NoError(t, err)
+		assert.Equal(t, tt.iv, hex.EncodeToString(k.iv))
+
+		zero := make([]byte, aes.BlockSize)
+		want, got := make([]byte, aes.BlockSize), make([]byte, aes.BlockSize)
+		hp, err := aes.NewCipher(unhex(t, tt.hp))
+		require.NoError(t, err)
+		hp.Encrypt(want, zero)
+		k.hp.Encrypt(got, zero)
+		assert.Equal(t, want, got, "hp")
+
+		block, err := aes.NewCipher(unhex(t, tt.key))
+		require.NoError(t, err)
+		aead, err := cipher.NewGCM(block)
+		require.NoError(t, err)
+		assert.Equal(t, aead.Seal(nil, k.iv, zero, nil), k.aead.Seal(nil, k.iv, zero, nil), "key")
+	}
+
+	// The header protection mask of the client Initial of appendix A.2
+	k, err := initialKeys(Version1, clientDCID, true)
+	require.NoError(t, err)
+	mask := make([]byte, aes.BlockSize)
+	k.hp.Encrypt(mask, unhex(t, "d1b1c98dd7689fb8ec11d242b123dc9b"))
+	assert.Equal(t, "437b9aec36", hex.EncodeToString(mask[:5]))
+
+	_, err = initialKeys(0xff00001d, clientDCID, true)
+	assert.ErrorIs(t, err, errUnsupported)
+}
+
+func TestVarint(t *testing.T) {
+	// RFC 9000 appendix A.1
+	tests := []struct {
+		in   string
+		want uint64
+		n    int
+	}{
+		{"c2197c5eff14e88c", 151288809941952652, 8},
+		{"9d7f3e7d", 494878333, 4},
+		{"7bbd", 15293, 2},
+		{"25", 37, 1},
+		{"4025", 37, 2},
+		{"7b", 0, 0}, // truncated
+		{"", 0, 0},
+	}
+
+	for _, tt := range tests {
+		v, n := varint(unhex(t, tt.in))
+		assert.Equal(t, tt.want, v, tt.in)
+		assert.Equal(t, tt.n, n, tt.in)
+	}
+}
+
+func TestCryptoStream(t *testing.T) {
+	msg := []byte{typeClientHello, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}
+
+	var s cryptoStream
+	var got [][]byte
+	handle := func(typ uint8, body []byte) { got = append(got, append([]byte{typ}, body...)) }
+
+	s.write(6, msg[6:], handle)
+	s.write(2, msg[2:4], handle)
+	assert.Empty(t, got)
+	s.write(0, msg[:3], handle) // overlaps the frame at 2
+	assert.Empty(t, got)
+	s.write(3, msg[3:7], handle)
+	assert.Equal(t, [][]byte{{typeClientHello, 'h', 'e', 'l', 'l', 'o'}}, got)
+
+	s.write(0, msg, handle) // retransmitted
+	assert.Len(t, got, 1)
+}
+
+// ******************************
+// Tracker
+// ******************************
+
+func TestTracker_Handshake(t *testing.T) {
+	ch, sh := handshake(t)
+	tr := NewTracker()
+
+	// The ClientHello split over two packets, the second half first
+	half := len(ch) / 2
+	p1 := clientInitial(t, 0, crypto(uint64(half), ch[half:]))
+	p2 := clientInitial(t, 1, crypto(0, ch[:half]))
+
+	info, hs, complete := tr.Feed(datagram(clientIP, serverIP, p1))
+	require.NotNil(t, info)
+	assert.Nil(t, hs)
+	assert.False(t, complete)
+	assert.Equal(t, "QUIC v1", info.Version)
+
+	info, hs, complete = tr.Feed(datagram(clientIP, serverIP, p2))
+	require.NotNil(t, hs)
+	assert.False(t, complete)
+	assert.Equal(t, "example.com", hs.SNI)
+	assert.Equal(t, []string{"h3"}, hs.OfferedALPN)
+	assert.Equal(t, []string{"TLS 1.3"}, hs.OfferedVersions)
+	assert.True(t, strings.HasPrefix(hs.JA4, "q13d"), hs.JA4)
+	assert.Len(t, hs.JA3, 32)
+	assert.Equal(t, clientIP, hs.ClientIP)
+	assert.Equal(t, []string{"8394c8f03e515708", "c1c2c3c4"}, info.ConnIDs)
+
+	// The server answers with its own ID, followed by a coalesced Handshake
+	// packet that can not be read
+	handshakePkt := longHeader(Version1, typeHandshake, clientSCID, serverSCID)
+	handshakePkt = append(handshakePkt, 0x40, 30)
+	handshakePkt = append(handshakePkt, make([]byte, 30)...)
+	reply := serverInitial(t, crypto(0, sh))
+	info, hs, complete = tr.Feed(datagram(serverIP, clientIP, reply, handshakePkt))
+	require.NotNil(t, hs)
+	assert.True(t, complete)
+	assert.Equal(t, "TLS 1.3", hs.Version)
+	assert.Contains(t, hs.CipherSuite, "TLS_")
+	assert.Equal(t, "example.com", hs.SNI)
+	assert.Equal(t, []string{"8394c8f03e515708", "c1c2c3c4", "5e5e5e5e5e5e5e5e"}, info.ConnIDs)
+	assert.False(t, info.Migrated)
+
+	// Later packets still belong to the connection, the handshake is only
+	// reported complete once
+	info, hs, complete = tr.Feed(datagram(serverIP, clientIP, reply))
+	require.NotNil(t, info)
+	assert.Nil(t, hs)
+	assert.False(t, complete)
+}
+
+func TestTracker_Migration(t *testing.T) {
+	ch, sh := handshake(t)
+	tr := NewTracker()
+
+	tr.Feed(datagram(clientIP, serverIP, clientInitial(t, 0, crypto(0, ch))))
+	tr.Feed(datagram(serverIP, clientIP, serverInitial(t, crypto(0, sh))))
+
+	// A short header packet from a new address and port, after a NAT
+	// rebinding, to the server's ID
+	short := append([]byte{0x40}, serverSCID...)
+	short = append(short, make([]byte, 40)...)
+	d := datagram("198.51.100.7", serverIP, short)
+	d.SrcPort = "61000"
+	info, hs, _ := tr.Feed(d)
+	require.NotNil(t, info)
+	assert.Nil(t, hs)
+	assert.True(t, info.Migrated)
+	assert.Equal(t, clientIP, info.ClientIP)
+	assert.Equal(t, "51000", info.ClientPort)
+
+	// The server's answers on the new path carry the client's ID, or no ID
+	// when the client went without one
+	reply := append([]byte{0x41}, clientSCID...)
+	reply = append(reply, make([]byte, 40)...)
+	d = Datagram{
+		Time:    t0,
+		SrcIP:   serverIP,
+		SrcPort: "443(https)",
+		DstIP:   "198.51.100.7",
+		DstPort: "61000",
+		Payload: reply,
+	}
+	info, _, _ = tr.Feed(d)
+	require.NotNil(t, info)
+	assert.Equal(t, clientIP, info.ClientIP)
+
+	d.Payload = []byte{0x41, 0xff, 0xff}
+	info, _, _ = tr.Feed(d)
+	require.NotNil(t, info, "by path")
+
+	// Unknown IDs on an unknown path are not QUIC as far as it can tell
+	d.DstPort = "61001"
+	info, _, _ = tr.Feed(d)
+	assert.Nil(t, info)
+}
+
+func TestTracker_Version2(t *testing.T) {
+	ch, _ := handshake(t)
+	tr := NewTracker()
+
+	p := initial(t, Version2, clientDCID, clientDCID, nil, true, 0, crypto(0, ch))
+	_, hs, _ := tr.Feed(datagram(clientIP, serverIP, p))
+	require.NotNil(t, hs)
+	assert.Equal(t, "example.com", hs.SNI)
+
+	info, _, _ := tr.Feed(datagram(clientIP, serverIP, []byte{0x40, 1, 2, 3}))
+	require.NotNil(t, info)
+	assert.Equal(t, "QUIC v2", info.Version)
+}
+
+func TestTracker_Retry(t *testing.T) {
+	ch, _ := handshake(t)
+	tr := NewTracker()
+
+	// The server asks the client to retry with a token and a new ID, the
+	// first ClientHello is never read in full
+	first := clientInitial(t, 0, crypto(100, ch[100:]))
+	info, _, _ := tr.Feed(datagram(clientIP, serverIP, first))
+	require.NotNil(t, info)
+
+	retry := longHeader(Version1, typeRetry, clientSCID, serverSCID)
+	retry = append(retry, "token"...)
+	retry = append(retry, make([]byte, 16)...) // integrity tag
+	tr.Feed(datagram(serverIP, clientIP, retry))
+
+	again := initial(t, Version1, serverSCID, serverSCID, clientSCID, true, 1, crypto(0, ch))
+	_, hs, _ := tr.Feed(datagram(clientIP, serverIP, again))
+	require.NotNil(t, hs)
+	assert.Equal(t, "example.com", hs.SNI)
+}
+
+func TestTracker_Ignores(t *testing.T) {
+	ch, _ := handshake(t)
+	tr := NewTracker()
+
+	tests := map[string][]byte{
+		"empty":       nil,
+		"dns":         unhex(t, "abcd01000001000000000000076578616d706c6503636f6d0000010001"),
+		"short":       {0x40, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		"truncated":   {0xc0, 0, 0, 0, 1, 8},
+		"negotiation": append(longHeader(0, typeInitial, clientSCID, clientDCID), 0, 0, 0, 1),
+		"bad keys":    initial(t, Version1, serverSCID, clientDCID, clientSCID, true, 0, crypto(0, ch)),
+		"no crypto":   clientInitial(t, 0, make([]byte, 32)),
+	}
+
+	for name, payload := range tests {
+		info, hs, _ := tr.Feed(datagram(clientIP, serverIP, payload))
+		assert.Nil(t, info, name)
+		assert.Nil(t, hs, name)
+	}
+	assert.Empty(t, tr.paths)
+}
+
+func TestTracker_Purge(t *testing.T) {
+	ch, _ := handshake(t)
+	tr := NewTracker()
+
+	tr.Feed(datagram(clientIP, serverIP, clientInitial(t, 0, crypto(0, ch))))
+	require.Len(t, tr.paths, 1)
+	require.Len(t, tr.ids, 2)
+
+	other := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	d := datagram(clientIP, serverIP, initial(t, Version1, other, other, nil, true, 0, crypto(0, ch)))
+	d.SrcPort = "51001"
+	d.Time = t0.Add(connTimeout + time.Second)
+	tr.Feed(d)
+
+	assert.Len(t, tr.paths, 1)
+	assert.Len(t, tr.ids, 1)
+}
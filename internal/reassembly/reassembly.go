@@ -0,0 +1,431 @@
+// Package reassembly puts the TCP segments of a connection back in order and
+// hands each direction's bytes to the application layer parsers registered
+// with an Assembler. Segments may arrive out of order, twice or overlapping,
+// the bytes of each direction are read exactly once
+package reassembly
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Config limits the out of order bytes an Assembler holds on to
+type Config struct {
+	// MaxBuffered and MaxBufferedPerConn cap the bytes held waiting for a
+	// missing segment, in total and for one connection. Past them the
+	// missing bytes are given up on
+	MaxBuffered        int
+	MaxBufferedPerConn int
+	// Timeout is how long a connection may go without a segment before
+	// Expire forgets it, 0 leaves that to Remove
+	Timeout time.Duration
+}
+
+// DefaultConfig returns the limits used unless configured otherwise
+func DefaultConfig() Config {
+	return Config{
+		MaxBuffered:        64 << 20,
+		MaxBufferedPerConn: 1 << 20,
+		Timeout:            2 * time.Minute,
+	}
+}
+
+// expireEvery is how often, in packet time, Expire looks for idle connections
+const expireEvery = time.Second
+
+// Segment is a TCP segment handed to an Assembler. Ports are gopacket port
+// strings such as 443(https)
+type Segment struct {
+	Time          time.Time
+	SrcIP         string
+	SrcPort       string
+	DstIP         string
+	DstPort       string
+	Interface     string
+	Seq           uint32
+	Payload       []byte
+	SYN, ACK, FIN bool
+	RST           bool
+}
+
+// Conn describes a connection to the parsers. The client is the side that
+// sent the SYN or, for connections already open when the capture started, the
+// first bytes
+type Conn struct {
+	Time       time.Time // of the client's first bytes
+	ClientIP   string
+	ClientPort string
+	ServerIP   string
+	ServerPort string
+	Interface  string
+}
+
+// Parser is an application layer protocol reader registered with an
+// Assembler
+type Parser interface {
+	// Accept is asked about a connection with the first bytes its client
+	// sent, and returns a Stream to read it or nil. Those bytes are handed to
+	// Read next. Connections already open when the capture started are asked
+	// about again with every segment until a parser accepts
+	Accept(c Conn, first []byte) Stream
+}
+
+// Stream reads the bytes of one connection. Its methods are called with the
+// Assembler locked, one at a time
+type Stream interface {
+	// Read is given the next bytes the client or the server sent, in order,
+	// with the time of the segment that carried them
+	Read(fromClient bool, data []byte, t time.Time)
+	// Gap tells that bytes of one side were lost, Read continues after them
+	Gap(fromClient bool)
+	// End tells that one side sent a FIN, after all its bytes were read
+	End(fromClient bool, t time.Time)
+	// Close is called once the connection is forgotten, because both sides
+	// ended, either reset it or it was removed
+	Close()
+}
+
+type flow struct {
+	srcIP, srcPort, dstIP, dstPort string
+}
+
+// chunk is a segment's bytes held until the bytes before them arrive
+type chunk struct {
+	seq  uint32
+	data []byte
+	time time.Time
+}
+
+// half is one direction of a connection
+type half struct {
+	started bool
+	next    uint32  // sequence number of the next byte to read
+	pending []chunk // sorted by sequence number, may overlap
+	held    int     // bytes in pending
+	fin     bool
+	finSeq  uint32
+	ended   bool
+}
+
+// conn is a connection, its halves are those of the flow it is stored under
+// and of the reverse flow. Which of them is the client's can change until a
+// parser accepts the connection
+type conn struct {
+	key      flow
+	info     Conn
+	fwd, rev half
+	reversed bool // the client is the destination of key
+	streams  []Stream
+	known    bool // the client's SYN was seen, so its first bytes are too
+	decided  bool // the parsers were asked for good
+	lastSeen time.Time
+}
+
+func (c *conn) half(forward bool) *half {
+	if forward {
+		return &c.fwd
+	}
+	return &c.rev
+}
+
+// fromClient reports whether the half of a direction is the client's
+func (c *conn) fromClient(forward bool) bool {
+	return forward != c.reversed
+}
+
+// Assembler follows TCP connections, handing their bytes to the streams of
+// the parsers that accepted them
+type Assembler struct {
+	mu         sync.Mutex
+	cfg        Config
+	parsers    []Parser
+	conns      map[flow]*conn // by the flow of the first segment seen
+	held       int            // bytes held across connections
+	lastExpire time.Time
+}
+
+// NewAssembler returns an Assembler following no connections
+func NewAssembler(cfg Config) *Assembler {
+	return &Assembler{cfg: cfg, conns: map[flow]*conn{}}
+}
+
+// Register adds a parser to ask about new connections
+func (a *Assembler) Register(p Parser) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.parsers = append(a.parsers, p)
+}
+
+// Feed adds a segment and returns the streams reading its connection, once
+// they read what it made available
+func (a *Assembler) Feed(seg Segment) []Stream {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := flow{seg.SrcIP, seg.SrcPort, seg.DstIP, seg.DstPort}
+	reverse := flow{seg.DstIP, seg.DstPort, seg.SrcIP, seg.SrcPort}
+
+	c, forward := a.conns[key], true
+	if c == nil {
+		c, forward = a.conns[reverse], false
+	}
+	if c == nil {
+		if seg.RST || !seg.SYN && len(seg.Payload) == 0 {
+			return nil
+		}
+		c = &conn{key: key, info: Conn{
+			ClientIP:   seg.SrcIP,
+			ClientPort: seg.SrcPort,
+			ServerIP:   seg.DstIP,
+			ServerPort: seg.DstPort,
+			Interface:  seg.Interface,
+		}}
+		if seg.SYN && seg.ACK {
+			// The SYN was missed, the SYN-ACK tells the client all the same
+			c.swap()
+		}
+		a.conns[key] = c
+		forward = true
+	}
+	c.lastSeen = seg.Time
+
+	if seg.RST {
+		a.close(c)
+		return c.streams
+	}
+	a.write(c, forward, seg)
+	if c.fwd.ended && c.rev.ended {
+		a.close(c)
+	}
+	return c.streams
+}
+
+// swap makes the server the client
+func (c *conn) swap() {
+	c.reversed = !c.reversed
+	i := &c.info
+	i.ClientIP, i.ClientPort, i.ServerIP, i.ServerPort =
+		i.ServerIP, i.ServerPort, i.ClientIP, i.ClientPort
+}
+
+// write reads a segment into one half of a connection
+func (a *Assembler) write(c *conn, forward bool, seg Segment) {
+	h := c.half(forward)
+	seq := seg.Seq
+	if seg.SYN {
+		// The SYN takes up a sequence number of its own
+		seq++
+		if !h.started {
+			h.started, h.next = true, seq
+			c.known = c.known || c.fromClient(forward)
+		}
+	}
+	if c.decided && len(c.streams) == 0 {
+		// Nobody reads the connection, only its end is of interest
+		h.ended = h.ended || seg.FIN
+		return
+	}
+	if !h.started && len(seg.Payload) > 0 {
+		h.started, h.next = true, seq
+	}
+
+	if len(seg.Payload) > 0 {
+		a.insert(c, forward, chunk{seq: seq, data: seg.Payload, time: seg.Time})
+	}
+	if seg.FIN && !h.fin {
+		h.fin, h.finSeq = true, seq+uint32(len(seg.Payload))
+		if !h.started {
+			h.started, h.next = true, h.finSeq
+		}
+	}
+	if h.fin && !h.ended && int32(h.next-h.finSeq) >= 0 {
+		h.ended = true
+		for _, s := range c.streams {
+			s.End(c.fromClient(forward), seg.Time)
+		}
+	}
+}
+
+// insert reads a chunk when it is next, or holds it until it is
+func (a *Assembler) insert(c *conn, forward bool, ch chunk) {
+	h := c.half(forward)
+
+	// When the connection's start was seen the parsers are asked about the
+	// client's first bytes, what the server sends before them is held
+	wait := !c.decided && c.known && !c.fromClient(forward)
+	if wait || int32(ch.seq-h.next) > 0 {
+		// Copied, as the packet's buffer may be reused
+		ch.data = slices.Clone(ch.data)
+		i, _ := slices.BinarySearchFunc(h.pending, ch, func(p, ch chunk) int {
+			return int(int32(p.seq - ch.seq))
+		})
+		h.pending = slices.Insert(h.pending, i, ch)
+		h.held += len(ch.data)
+		a.held += len(ch.data)
+
+		// Over the limits, the missing bytes are not waited for any longer
+		for len(h.pending) > 0 &&
+			(h.held > a.cfg.MaxBufferedPerConn || a.held > a.cfg.MaxBuffered) {
+			a.gap(c, forward)
+		}
+		return
+	}
+
+	a.read(c, forward, ch)
+	a.drain(c, forward)
+	// And the other side's, once it is no longer held for the parsers
+	a.drain(c, !forward)
+}
+
+// read hands the new bytes of a chunk to the streams, skipping what was read
+// before
+func (a *Assembler) read(c *conn, forward bool, ch chunk) {
+	h := c.half(forward)
+	if diff := int32(h.next - ch.seq); diff > 0 {
+		if int(diff) >= len(ch.data) {
+			return // a retransmission
+		}
+		ch.data = ch.data[diff:]
+	}
+	h.next += uint32(len(ch.data))
+
+	if !c.decided && (c.fromClient(forward) || !c.known) {
+		a.accept(c, forward, ch)
+
+		// What the other side sent meanwhile is read in the order it was
+		// sent, such as a server's banner before the client's first bytes
+		a.drainBefore(c, !forward, ch.time)
+	}
+	for _, s := range c.streams {
+		s.Read(c.fromClient(forward), ch.data, ch.time)
+	}
+}
+
+// drain reads the held chunks that became next
+func (a *Assembler) drain(c *conn, forward bool) {
+	a.drainBefore(c, forward, time.Time{})
+}
+
+// drainBefore reads the held chunks that became next and were sent before t,
+// all of them when t is zero
+func (a *Assembler) drainBefore(c *conn, forward bool, t time.Time) {
+	h := c.half(forward)
+	for len(h.pending) > 0 && int32(h.pending[0].seq-h.next) <= 0 &&
+		(t.IsZero() || h.pending[0].time.Before(t)) {
+		ch := h.pending[0]
+		h.pending = h.pending[1:]
+		h.held -= len(ch.data)
+		a.held -= len(ch.data)
+		a.read(c, forward, ch)
+	}
+}
+
+// gap gives up on the bytes missing before the first held chunk
+func (a *Assembler) gap(c *conn, forward bool) {
+	h := c.half(forward)
+	h.next = h.pending[0].seq
+	if c.known && c.fromClient(forward) {
+		c.decided = true // the first bytes are lost
+	}
+	for _, s := range c.streams {
+		s.Gap(c.fromClient(forward))
+	}
+	a.drain(c, forward)
+}
+
+// accept asks the parsers about a connection with the next bytes of one
+// side. When the connection's start was seen only the client's first bytes
+// are asked about, otherwise either side may turn out to be the client
+func (a *Assembler) accept(c *conn, forward bool, ch chunk) {
+	swapped := !c.fromClient(forward)
+	if swapped {
+		c.swap()
+	}
+	c.info.Time = ch.time
+	for _, p := range a.parsers {
+		if s := p.Accept(c.info, ch.data); s != nil {
+			c.streams = append(c.streams, s)
+		}
+	}
+	if len(c.streams) == 0 && swapped {
+		c.swap()
+	}
+
+	c.decided = c.known || len(c.streams) > 0
+	if c.decided && len(c.streams) == 0 {
+		a.release(c)
+	}
+}
+
+// release frees the chunks a connection holds
+func (a *Assembler) release(c *conn) {
+	for _, h := range []*half{&c.fwd, &c.rev} {
+		a.held -= h.held
+		h.pending, h.held = nil, 0
+	}
+}
+
+// close forgets a connection, closing its streams
+func (a *Assembler) close(c *conn) {
+	a.release(c)
+	if a.conns[c.key] != c {
+		return // already closed
+	}
+	delete(a.conns, c.key)
+	for _, s := range c.streams {
+		s.Close()
+	}
+}
+
+// Remove forgets the connection between two endpoints, in either direction,
+// and returns the streams that were reading it
+func (a *Assembler) Remove(ip1, port1, ip2, port2 string) []Stream {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	c := a.conns[flow{ip1, port1, ip2, port2}]
+	if c == nil {
+		c = a.conns[flow{ip2, port2, ip1, port1}]
+	}
+	if c == nil {
+		return nil
+	}
+	a.close(c)
+	return c.streams
+}
+
+// Expire forgets the connections that went without a segment for longer than
+// the timeout before now, and returns the streams that were reading them
+func (a *Assembler) Expire(now time.Time) []Stream {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.cfg.Timeout <= 0 || now.Sub(a.lastExpire) < expireEvery {
+		return nil
+	}
+	a.lastExpire = now
+
+	var streams []Stream
+	for _, c := range a.conns {
+		if now.Sub(c.lastSeen) > a.cfg.Timeout {
+			a.close(c)
+			streams = append(streams, c.streams...)
+		}
+	}
+	return streams
+}
+
+// Flush forgets every connection, as at the end of a capture, and returns the
+// streams that were reading them
+func (a *Assembler) Flush() []Stream {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var streams []Stream
+	for _, c := range a.conns {
+		a.close(c)
+		streams = append(streams, c.streams...)
+	}
+	return streams
+}
@@ -0,0 +1,410 @@
+package reassembly
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	clientIP = "10.0.0.5"
+	serverIP = "10.0.0.80"
+)
+
+// parser accepts the connections whose first bytes start with prefix
+type parser struct {
+	prefix  string
+	asked   int
+	streams []*recording
+}
+
+func (p *parser) Accept(c Conn, first []byte) Stream {
+	p.asked++
+	if !strings.HasPrefix(string(first), p.prefix) {
+		return nil
+	}
+	r := &recording{conn: c}
+	p.streams = append(p.streams, r)
+	return r
+}
+
+// recording keeps what a stream was told
+type recording struct {
+	conn           Conn
+	client, server strings.Builder
+	events         []string
+	closed         int
+}
+
+func (r *recording) Read(fromClient bool, data []byte, t time.Time) {
+	if fromClient {
+		r.client.Write(data)
+	} else {
+		r.server.Write(data)
+	}
+}
+
+func (r *recording) Gap(fromClient bool) {
+	r.events = append(r.events, side(fromClient)+" gap")
+}
+
+func (r *recording) End(fromClient bool, t time.Time) {
+	r.events = append(r.events, side(fromClient)+" end")
+}
+
+func (r *recording) Close() {
+	r.closed++
+}
+
+func side(fromClient bool) string {
+	if fromClient {
+		return "client"
+	}
+	return "server"
+}
+
+func newAssembler(cfg Config) (*Assembler, *parser) {
+	a := NewAssembler(cfg)
+	p := &parser{prefix: "GET"}
+	a.Register(p)
+	return a, p
+}
+
+// client returns a segment from the client, seq is relative to its ISN
+func client(seq uint32, payload string) Segment {
+	return Segment{
+		Time:    t0,
+		SrcIP:   clientIP,
+		SrcPort: "51000",
+		DstIP:   serverIP,
+		DstPort: "80(http)",
+		Seq:     1000 + seq,
+		Payload: []byte(payload),
+		ACK:     true,
+	}
+}
+
+func server(seq uint32, payload string) Segment {
+	return Segment{
+		Time:    t0,
+		SrcIP:   serverIP,
+		SrcPort: "80(http)",
+		DstIP:   clientIP,
+		DstPort: "51000",
+		Seq:     9000 + seq,
+		Payload: []byte(payload),
+		ACK:     true,
+	}
+}
+
+// handshake opens a connection with a SYN and a SYN-ACK
+func handshake(a *Assembler) {
+	syn := client(0, "")
+	syn.SYN, syn.ACK = true, false
+	a.Feed(syn)
+	synAck := server(0, "")
+	synAck.SYN = true
+	a.Feed(synAck)
+}
+
+// ******************************
+// Ordering
+// ******************************
+
+func TestAssembler_InOrder(t *testing.T) {
+	a, p := newAssembler(DefaultConfig())
+	handshake(a)
+
+	streams := a.Feed(client(1, "GET / HTTP/1.1\r\n\r\n"))
+	require.Len(t, p.streams, 1)
+	r := p.streams[0]
+	assert.Equal(t, []Stream{r}, streams)
+	assert.Equal(t, Conn{
+		Time:       t0,
+		ClientIP:   clientIP,
+		ClientPort: "51000",
+		ServerIP:   serverIP,
+		ServerPort: "80(http)",
+	}, r.conn)
+
+	a.Feed(server(1, "HTTP/1.1 200 OK\r\n\r\n"))
+	fin := server(20, "")
+	fin.FIN = true
+	a.Feed(fin)
+	assert.Equal(t, []string{"server end"}, r.events)
+	assert.Zero(t, r.closed)
+
+	fin = client(19, "")
+	fin.FIN = true
+	a.Feed(fin)
+	assert.Equal(t, []string{"server end", "client end"}, r.events)
+	assert.Equal(t, 1, r.closed)
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", r.client.String())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n\r\n", r.server.String())
+	assert.Empty(t, a.conns)
+}
+
+func TestAssembler_OutOfOrder(t *testing.T) {
+	a, p := newAssembler(DefaultConfig())
+	handshake(a)
+
+	// The first bytes are held too, the connection's start is known
+	a.Feed(client(9, "two "))
+	a.Feed(client(13, "three"))
+	assert.Empty(t, p.streams)
+	assert.Equal(t, 9, a.held)
+
+	a.Feed(client(1, "GET one "))
+	require.Len(t, p.streams, 1)
+	assert.Equal(t, "GET one two three", p.streams[0].client.String())
+	assert.Zero(t, a.held)
+	assert.Equal(t, 1, p.asked)
+}
+
+func TestAssembler_Retransmissions(t *testing.T) {
+	a, p := newAssembler(DefaultConfig())
+	handshake(a)
+
+	a.Feed(client(1, "GET abc"))
+	a.Feed(client(1, "GET abc"))      // retransmitted
+	a.Feed(client(13, "ijkl"))        // held
+	a.Feed(client(11, "ghij"))        // held, overlapping the one after
+	a.Feed(client(6, "bcdefgh"))      // overlapping what was read
+	a.Feed(client(15, "klmnop"))      // overlapping what was read once drained
+	a.Feed(client(11, "ghijklmnopq")) // covering all of the above
+
+	require.Len(t, p.streams, 1)
+	assert.Equal(t, "GET abcdefghijklmnopq", p.streams[0].client.String())
+	assert.Zero(t, a.held)
+}
+
+func TestAssembler_SequenceWrap(t *testing.T) {
+	a, p := newAssembler(DefaultConfig())
+
+	first := client(0, "GET ")
+	first.Seq = 0xfffffffe
+	second := client(0, "wrapped")
+	second.Seq = 2
+	a.Feed(first)
+	a.Feed(second)
+
+	require.Len(t, p.streams, 1)
+	assert.Equal(t, "GET wrapped", p.streams[0].client.String())
+}
+
+func TestAssembler_FINOutOfOrder(t *testing.T) {
+	a, p := newAssembler(DefaultConfig())
+	handshake(a)
+
+	a.Feed(client(1, "GET "))
+	fin := client(8, "end")
+	fin.FIN = true
+	a.Feed(fin)
+	r := p.streams[0]
+	assert.Empty(t, r.events)
+
+	a.Feed(client(5, "the"))
+	assert.Equal(t, []string{"client end"}, r.events)
+	assert.Equal(t, "GET theend", r.client.String())
+}
+
+// ******************************
+// Limits
+// ******************************
+
+func TestAssembler_ConnLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxBufferedPerConn = 8
+	a, p := newAssembler(cfg)
+	handshake(a)
+
+	a.Feed(client(1, "GET "))
+	a.Feed(client(10, "held"))
+	assert.Equal(t, 4, a.held)
+
+	// Past the limit the lost bytes are skipped
+	a.Feed(client(14, "too much"))
+	r := p.streams[0]
+	assert.Equal(t, []string{"client gap"}, r.events)
+	assert.Equal(t, "GET heldtoo much", r.client.String())
+	assert.Zero(t, a.held)
+
+	a.Feed(client(5, "late!"))
+	assert.Equal(t, "GET heldtoo much", r.client.String())
+}
+
+func TestAssembler_TotalLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxBuffered = 10
+	a, p := newAssembler(cfg)
+
+	first := client(1, "GET ")
+	a.Feed(first)
+	a.Feed(client(10, "123456"))
+
+	other := client(1, "GET ")
+	other.SrcPort = "51001"
+	a.Feed(other)
+	other.Seq += 10
+	other.Payload = []byte("abcdef")
+	a.Feed(other)
+
+	require.Len(t, p.streams, 2)
+	assert.Equal(t, "GET ", p.streams[0].client.String())
+	assert.Equal(t, "GET abcdef", p.streams[1].client.String())
+	assert.Equal(t, 6, a.held)
+}
+
+// ******************************
+// Parsers
+// ******************************
+
+func TestAssembler_NotAccepted(t *testing.T) {
+	a, p := newAssembler(DefaultConfig())
+	handshake(a)
+
+	a.Feed(client(1, "SSH-2.0-OpenSSH_9.6\r\n"))
+	a.Feed(client(40, "not held"))
+	assert.Equal(t, 1, p.asked)
+	assert.Empty(t, p.streams)
+	assert.Zero(t, a.held)
+	assert.Len(t, a.conns, 1)
+
+	for _, seg := range []Segment{client(22, ""), server(1, "")} {
+		seg.FIN = true
+		assert.Nil(t, a.Feed(seg))
+	}
+	assert.Empty(t, a.conns)
+}
+
+func TestAssembler_MidStream(t *testing.T) {
+	a, p := newAssembler(DefaultConfig())
+
+	// The end of a response, then the next request of the connection
+	a.Feed(server(1, "</html>"))
+	a.Feed(client(1, "GET /next HTTP/1.1\r\n\r\n"))
+	require.Len(t, p.streams, 1)
+	r := p.streams[0]
+	assert.Equal(t, clientIP, r.conn.ClientIP)
+	assert.Equal(t, "80(http)", r.conn.ServerPort)
+
+	a.Feed(server(8, "HTTP/1.1 304 Not Modified\r\n\r\n"))
+	assert.Equal(t, "GET /next HTTP/1.1\r\n\r\n", r.client.String())
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\n\r\n", r.server.String())
+	assert.Equal(t, 2, p.asked)
+}
+
+func TestAssembler_ServerFirst(t *testing.T) {
+	a, p := newAssembler(DefaultConfig())
+	handshake(a)
+
+	// A banner is held until the parsers were asked about the client
+	banner := server(1, "220 ready\r\n")
+	a.Feed(banner)
+	assert.Equal(t, 11, a.held)
+
+	request := client(1, "GET / HTTP/1.1\r\n\r\n")
+	request.Time = t0.Add(time.Second)
+	a.Feed(request)
+	late := server(12, "late")
+	late.Time = t0.Add(2 * time.Second)
+	a.Feed(late)
+
+	require.Len(t, p.streams, 1)
+	assert.Equal(t, "220 ready\r\nlate", p.streams[0].server.String())
+	assert.Zero(t, a.held)
+}
+
+func TestAssembler_SYNACKOnly(t *testing.T) {
+	a, p := newAssembler(DefaultConfig())
+
+	synAck := server(0, "")
+	synAck.SYN = true
+	a.Feed(synAck)
+	a.Feed(client(1, "GET / HTTP/1.1\r\n\r\n"))
+	a.Feed(server(1, "HTTP/1.1 200 OK\r\n\r\n"))
+
+	require.Len(t, p.streams, 1)
+	assert.Equal(t, clientIP, p.streams[0].conn.ClientIP)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n\r\n", p.streams[0].server.String())
+}
+
+// ******************************
+// Lifecycle
+// ******************************
+
+func TestAssembler_Reset(t *testing.T) {
+	a, p := newAssembler(DefaultConfig())
+	handshake(a)
+
+	a.Feed(client(1, "GET "))
+	a.Feed(client(20, "held"))
+	rst := server(1, "")
+	rst.RST = true
+	streams := a.Feed(rst)
+	require.Len(t, streams, 1)
+	assert.Equal(t, 1, p.streams[0].closed)
+	assert.Empty(t, a.conns)
+	assert.Zero(t, a.held)
+}
+
+func TestAssembler_Remove(t *testing.T) {
+	a, p := newAssembler(DefaultConfig())
+
+	a.Feed(client(1, "GET "))
+	a.Feed(client(20, "held"))
+	assert.Nil(t, a.Remove(clientIP, "51000", serverIP, "443(https)"))
+
+	streams := a.Remove(serverIP, "80(http)", clientIP, "51000")
+	require.Len(t, streams, 1)
+	assert.Equal(t, 1, p.streams[0].closed)
+	assert.Empty(t, a.conns)
+	assert.Zero(t, a.held)
+	assert.Nil(t, a.Remove(clientIP, "51000", serverIP, "80(http)"))
+}
+
+func TestAssembler_Expire(t *testing.T) {
+	cfg := DefaultConfig()
+	a, p := newAssembler(cfg)
+
+	a.Feed(client(1, "GET "))
+	other := client(1, "GET ")
+	other.SrcPort = "51001"
+	other.Time = t0.Add(cfg.Timeout)
+	a.Feed(other)
+
+	assert.Empty(t, a.Expire(t0.Add(cfg.Timeout)))
+	// Not looked at again within a second
+	assert.Empty(t, a.Expire(t0.Add(cfg.Timeout+time.Second/2)))
+
+	streams := a.Expire(t0.Add(cfg.Timeout + time.Second))
+	require.Len(t, streams, 1)
+	assert.Same(t, p.streams[0], streams[0])
+	assert.Len(t, a.conns, 1)
+
+	cfg.Timeout = 0
+	a = NewAssembler(cfg)
+	a.Register(p)
+	a.Feed(client(1, "GET "))
+	assert.Empty(t, a.Expire(t0.Add(time.Hour)))
+}
+
+func TestAssembler_Flush(t *testing.T) {
+	a, p := newAssembler(DefaultConfig())
+
+	a.Feed(client(1, "GET "))
+	other := client(1, "GET ")
+	other.SrcPort = "51001"
+	a.Feed(other)
+
+	assert.Len(t, a.Flush(), 2)
+	assert.Empty(t, a.conns)
+	for _, r := range p.streams {
+		assert.Equal(t, 1, r.closed)
+	}
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ARPEntry is an IP to MAC binding learned from ARP traffic
+type ARPEntry struct {
+	IP        string    `json:"ip"`
+	MAC       string    `json:"mac"`
+	Interface string    `json:"interface,omitempty"`
+	VLAN      int       `json:"vlan,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Packets   int       `json:"packets"`
+}
+
+// ARPAlert is a stored alert of the ARP monitor
+type ARPAlert struct {
+	Id        int       `json:"id"`
+	Timestamp time.Time `json:"time"`
+	Kind      string    `json:"kind"`
+	IP        string    `json:"ip,omitempty"`
+	MAC       string    `json:"mac"`
+	OldMAC    string    `json:"old_mac,omitempty"`
+	Interface string    `json:"interface,omitempty"`
+	Message   string    `json:"message"`
+}
+
+// migrateARP creates the tables of the ARP monitor
+func migrateARP(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS arp_entries (
+			ip         TEXT NOT NULL,
+			mac        TEXT NOT NULL,
+			interface  TEXT NOT NULL DEFAULT '',
+			vlan       INTEGER NOT NULL DEFAULT 0,
+			first_seen TIMESTAMP NOT NULL,
+			last_seen  TIMESTAMP NOT NULL,
+			packets    INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (ip, mac, interface, vlan)
+		);
+		CREATE TABLE IF NOT EXISTS arp_alerts (
+			id        INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp TIMESTAMP NOT NULL,
+			kind      TEXT NOT NULL,
+			ip        TEXT NOT NULL,
+			mac       TEXT NOT NULL,
+			old_mac   TEXT NOT NULL,
+			interface TEXT NOT NULL,
+			message   TEXT NOT NULL
+		);
+	`)
+	return err
+}
+
+// UpsertARPEntry records that mac claimed ip at ts, adding the binding or
+// bumping its last seen time and packet count
+func UpsertARPEntry(sqlDb *sql.DB, ip, mac, iface string, vlan int, ts time.Time) error {
+	_, err := sqlDb.Exec(`
+		INSERT INTO arp_entries (ip, mac, interface, vlan, first_seen, last_seen, packets)
+		VALUES ($1, $2, $3, $4, $5, $5, 1)
+		ON CONFLICT (ip, mac, interface, vlan) DO UPDATE SET
+			first_seen = MIN(first_seen, excluded.first_seen),
+			last_seen = MAX(last_seen, excluded.last_seen),
+			packets = packets + 1`,
+		ip, mac, iface, vlan, ts.UTC(),
+	)
+	return err
+}
+
+// GetARPEntries returns the learned bindings ordered by IP, most recently
+// seen first for an IP with several MACs
+func GetARPEntries(sqlDb *sql.DB) ([]ARPEntry, error) {
+	rows, err := sqlDb.Query(`SELECT
+		ip, mac, interface, vlan, first_seen, last_seen, packets
+		FROM arp_entries
+		ORDER BY ip, last_seen DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ARPEntry
+	for rows.Next() {
+		var e ARPEntry
+		if err := rows.Scan(
+			&e.IP,
+			&e.MAC,
+			&e.Interface,
+			&e.VLAN,
+			&e.FirstSeen,
+			&e.LastSeen,
+			&e.Packets,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// InsertARPAlert stores an alert of the ARP monitor
+func InsertARPAlert(sqlDb *sql.DB, a ARPAlert) error {
+	_, err := sqlDb.Exec(`
+		INSERT INTO arp_alerts (timestamp, kind, ip, mac, old_mac, interface, message)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		a.Timestamp.UTC(), a.Kind, a.IP, a.MAC, a.OldMAC, a.Interface, a.Message,
+	)
+	return err
+}
+
+// GetARPAlerts returns the stored alerts, oldest first
+func GetARPAlerts(sqlDb *sql.DB) ([]ARPAlert, error) {
+	rows, err := sqlDb.Query(`SELECT
+		id, timestamp, kind, ip, mac, old_mac, interface, message
+		FROM arp_alerts
+		ORDER BY timestamp, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []ARPAlert
+	for rows.Next() {
+		var a ARPAlert
+		if err := rows.Scan(
+			&a.Id,
+			&a.Timestamp,
+			&a.Kind,
+			&a.IP,
+			&a.MAC,
+			&a.OldMAC,
+			&a.Interface,
+			&a.Message,
+		); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+
+	return alerts, rows.Err()
+}
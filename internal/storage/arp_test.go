@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ******************************
+// ARP entries
+// ******************************
+
+func TestUpsertARPEntry(t *testing.T) {
+	db, err := OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, UpsertARPEntry(db, "10.0.0.5", "00:03:93:00:00:0a", "eth0", 0, t0))
+	require.NoError(
+		t,
+		UpsertARPEntry(db, "10.0.0.5", "00:03:93:00:00:0a", "eth0", 0, t0.Add(time.Minute)),
+	)
+	require.NoError(
+		t,
+		UpsertARPEntry(db, "10.0.0.5", "00:50:56:00:00:0b", "eth0", 0, t0.Add(time.Hour)),
+	)
+	require.NoError(t, UpsertARPEntry(db, "10.0.0.1", "00:00:0c:00:00:01", "eth0", 20, t0))
+
+	entries, err := GetARPEntries(db)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, "10.0.0.1", entries[0].IP)
+	assert.Equal(t, 20, entries[0].VLAN)
+
+	// The newest MAC of 10.0.0.5 comes first
+	assert.Equal(t, "00:50:56:00:00:0b", entries[1].MAC)
+	assert.Equal(t, 1, entries[1].Packets)
+
+	assert.Equal(t, "00:03:93:00:00:0a", entries[2].MAC)
+	assert.Equal(t, 2, entries[2].Packets)
+	assert.True(t, t0.Equal(entries[2].FirstSeen))
+	assert.True(t, t0.Add(time.Minute).Equal(entries[2].LastSeen))
+}
+
+func TestGetARPEntries_Empty(t *testing.T) {
+	db, err := OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	entries, err := GetARPEntries(db)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+// ******************************
+// ARP alerts
+// ******************************
+
+func TestInsertARPAlert(t *testing.T) {
+	db, err := OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, InsertARPAlert(db, ARPAlert{
+		Timestamp: t0.Add(time.Second),
+		Kind:      "duplicate-ip",
+		IP:        "10.0.0.5",
+		MAC:       "00:50:56:00:00:0b",
+		OldMAC:    "00:03:93:00:00:0a",
+		Interface: "eth0",
+		Message:   "10.0.0.5 is used by both",
+	}))
+	require.NoError(t, InsertARPAlert(db, ARPAlert{
+		Timestamp: t0,
+		Kind:      "gratuitous",
+		IP:        "10.0.0.1",
+		MAC:       "00:00:0c:00:00:01",
+		Message:   "announced",
+	}))
+
+	alerts, err := GetARPAlerts(db)
+	require.NoError(t, err)
+	require.Len(t, alerts, 2)
+	assert.Equal(t, "gratuitous", alerts[0].Kind)
+	assert.Equal(t, "duplicate-ip", alerts[1].Kind)
+	assert.Equal(t, "00:03:93:00:00:0a", alerts[1].OldMAC)
+	assert.True(t, t0.Add(time.Second).Equal(alerts[1].Timestamp))
+}
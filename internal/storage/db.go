@@ -2,7 +2,9 @@ package storage
 
 import (
 	"database/sql"
+	"encoding/json"
 	"log"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -35,6 +37,7 @@ type DNSEntry struct {
 	ResponseIPs string
 	RequestType string
 	TxnId       uint16
+	Interface   string
 }
 
 // TODO: Integrate migrations when necessary
@@ -72,11 +75,55 @@ func migrate(db *sql.DB) error {
 			  cname_path  TEXT,
               response_ips TEXT,
 			  request_type TEXT NOT NULL,
-		      event INTEGER
+		      event INTEGER,
+              interface   TEXT
           );
           CREATE INDEX IF NOT EXISTS idx_dns_queries_query_name ON dns_queries(query_name);
           CREATE INDEX IF NOT EXISTS idx_dns_queries_source_ip ON dns_queries(source_ip);
       `)
+	if err != nil {
+		return err
+	}
+	if err := addColumn(db, "dns_queries", "interface", "TEXT"); err != nil {
+		return err
+	}
+
+	if err := migrateARP(db); err != nil {
+		return err
+	}
+	if err := migrateNDP(db); err != nil {
+		return err
+	}
+	if err := migrateTLS(db); err != nil {
+		return err
+	}
+	return migrateHTTP(db)
+}
+
+// addColumn adds a column to a table created by an older version, doing
+// nothing when the column is already there
+func addColumn(db *sql.DB, table, column, def string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + def)
 	return err
 }
 
@@ -86,12 +133,13 @@ func InsertDNSEntry(
 	sqlDb *sql.DB,
 	time, srcIP, queryName, queryType, cnamePath, responseIPs, responseType string,
 	eventNum uint16,
+	iface string,
 ) error {
 	_, err := sqlDb.Exec(`
 		INSERT INTO dns_queries
-		(timestamp, source_ip, query_name, query_type, cname_path, response_ips, request_type, event)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`,
-		time, srcIP, queryName, queryType, cnamePath, responseIPs, responseType, eventNum)
+		(timestamp, source_ip, query_name, query_type, cname_path, response_ips, request_type, event, interface)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`,
+		time, srcIP, queryName, queryType, cnamePath, responseIPs, responseType, eventNum, iface)
 	if err != nil {
 		log.Printf("cannot insert: %v", err)
 		return err
@@ -99,6 +147,47 @@ func InsertDNSEntry(
 	return nil
 }
 
+// QueryOption narrows down the rows the DNS stats queries are run over
+type QueryOption func(*queryOptions)
+
+type queryOptions struct {
+	ids    []int
+	useIDs bool
+}
+
+// OnlyIDs limits a query to the rows with the given ids, e.g. the ones that
+// passed a display filter. An empty ids matches no rows
+func OnlyIDs(ids []int) QueryOption {
+	return func(o *queryOptions) {
+		o.ids = ids
+		o.useIDs = true
+	}
+}
+
+// where builds the WHERE clause of a query from conds and the options, along
+// with its arguments
+func where(opts []QueryOption, conds ...string) (string, []any) {
+	var o queryOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var args []any
+	if o.useIDs {
+		ids, err := json.Marshal(o.ids)
+		if err != nil {
+			panic(err) // a []int always marshals
+		}
+		conds = append(conds, "id IN (SELECT value FROM json_each(?))")
+		args = append(args, string(ids))
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
 // GetMostQueriedDomains queries the database 'dns_queries' table to get:
 // - Query Name
 // - Events / DNS Txn Ids
@@ -106,16 +195,18 @@ func InsertDNSEntry(
 //
 // Events/ Txn Ids are concat together to display all the Txn Ids associated
 // with each DNS query
-func GetMostQueriedDomains(sqlDb *sql.DB) ([]DNSMostQueriedDomain, error) {
+func GetMostQueriedDomains(sqlDb *sql.DB, opts ...QueryOption) ([]DNSMostQueriedDomain, error) {
+	cond, args := where(opts, "request_type = 'query'")
 	rows, err := sqlDb.Query(
 		`SELECT
 			query_name,
 			GROUP_CONCAT(event) AS events,
 			COUNT(*) AS count 
 		FROM dns_queries 
-		WHERE request_type = 'query'
+		`+cond+`
 		GROUP BY query_name
 		ORDER BY count DESC`,
+		args...,
 	)
 	if err != nil {
 		return nil, err
@@ -134,13 +225,15 @@ func GetMostQueriedDomains(sqlDb *sql.DB) ([]DNSMostQueriedDomain, error) {
 	return mqd, nil
 }
 
-func GetQueriesOverTime(sqlDb *sql.DB) ([]DNSOverTime, error) {
+func GetQueriesOverTime(sqlDb *sql.DB, opts ...QueryOption) ([]DNSOverTime, error) {
+	cond, args := where(opts)
 	rows, err := sqlDb.Query(`SELECT strftime('%Y-%m-%d %H:%M', timestamp) as hour,
 		COUNT(*) as query_count
 		FROM dns_queries
+		`+cond+`
 		GROUP BY hour
 		ORDER BY hour
-	`)
+	`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -159,11 +252,12 @@ func GetQueriesOverTime(sqlDb *sql.DB) ([]DNSOverTime, error) {
 	return ots, nil
 }
 
-func GetUniqueDomains(sqlDb *sql.DB) ([]DNSDistinctQuery, error) {
+func GetUniqueDomains(sqlDb *sql.DB, opts ...QueryOption) ([]DNSDistinctQuery, error) {
+	cond, args := where(opts)
 	rows, err := sqlDb.Query(`SELECT
 		DISTINCT source_ip, query_name, request_type
 		FROM dns_queries
-	`)
+		`+cond, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -181,9 +275,12 @@ func GetUniqueDomains(sqlDb *sql.DB) ([]DNSDistinctQuery, error) {
 	return dqs, nil
 }
 
-// GetDNSEntries wraps a 'SELECT *' statement for the dns_queries table
+// GetDNSEntries returns every row of the dns_queries table
 func GetDNSEntries(sqlDb *sql.DB) ([]DNSEntry, error) {
-	rows, err := sqlDb.Query("SELECT * from dns_queries")
+	rows, err := sqlDb.Query(`SELECT
+		id, timestamp, source_ip, query_name, query_type, cname_path,
+		response_ips, request_type, event, COALESCE(interface, '')
+		FROM dns_queries`)
 	if err != nil {
 		return nil, err
 	}
@@ -201,6 +298,7 @@ func GetDNSEntries(sqlDb *sql.DB) ([]DNSEntry, error) {
 			&e.ResponseIPs,
 			&e.RequestType,
 			&e.TxnId,
+			&e.Interface,
 		); err != nil {
 			return nil, err
 		}
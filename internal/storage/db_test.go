@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"database/sql"
 	"fmt"
 	"testing"
 
@@ -51,6 +52,7 @@ func TestOpenDb_Idempotent(t *testing.T) {
 		"1.2.3.4",
 		"query",
 		1,
+		"",
 	)
 	require.NoError(t, err)
 	db.Close()
@@ -80,6 +82,56 @@ func TestOpenDb_CreatesIndexes(t *testing.T) {
 	}
 }
 
+func TestOpenDb_AddsInterfaceColumn(t *testing.T) {
+	path := t.TempDir() + "/test.db"
+
+	// A dns_queries table from before the interface column existed
+	old, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	_, err = old.Exec(`
+		CREATE TABLE dns_queries (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp    DATETIME NOT NULL,
+			source_ip    TEXT NOT NULL,
+			query_name   TEXT NOT NULL,
+			query_type   TEXT NOT NULL,
+			cname_path   TEXT,
+			response_ips TEXT,
+			request_type TEXT NOT NULL,
+			event        INTEGER
+		);
+		INSERT INTO dns_queries
+		(timestamp, source_ip, query_name, query_type, cname_path, response_ips, request_type, event)
+		VALUES ('2024-01-01T00:00:00Z', '192.168.0.1', 'example.com', 'A', '', '', 'query', 1);
+	`)
+	require.NoError(t, err)
+	old.Close()
+
+	db, err := OpenDb(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = InsertDNSEntry(
+		db,
+		"2024-01-01T00:00:01Z",
+		"192.168.0.1",
+		"example.org",
+		"A",
+		"",
+		"",
+		"query",
+		2,
+		"eth0",
+	)
+	require.NoError(t, err)
+
+	entries, err := GetDNSEntries(db)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "", entries[0].Interface)
+	assert.Equal(t, "eth0", entries[1].Interface)
+}
+
 // ******************************
 // InsertDNSEntry
 // ******************************
@@ -99,6 +151,7 @@ func TestInsertDNSEntry(t *testing.T) {
 		"1.2.3.4",
 		"query",
 		123,
+		"",
 	)
 	assert.NoError(t, err)
 }
@@ -118,6 +171,7 @@ func TestInsertDNSEntry_VerifyFields(t *testing.T) {
 		"1.2.3.4",
 		"response",
 		456,
+		"",
 	)
 	require.NoError(t, err)
 
@@ -197,6 +251,7 @@ func TestInsertDNSEntry_MultipleEntries(t *testing.T) {
 			e.responseIPs,
 			e.requestType,
 			e.event,
+			"",
 		)
 		assert.NoError(t, err)
 	}
@@ -223,6 +278,7 @@ func TestInsertDNSEntry_EmptyOptionalFields(t *testing.T) {
 		"",
 		"query",
 		2,
+		"",
 	)
 	assert.NoError(t, err)
 }
@@ -256,6 +312,7 @@ func TestGetMostQueriedDomains_Basic(t *testing.T) {
 		"",
 		"query",
 		1,
+		"",
 	)
 	require.NoError(t, err)
 	err = InsertDNSEntry(
@@ -268,6 +325,7 @@ func TestGetMostQueriedDomains_Basic(t *testing.T) {
 		"",
 		"query",
 		2,
+		"",
 	)
 	require.NoError(t, err)
 	err = InsertDNSEntry(
@@ -280,6 +338,7 @@ func TestGetMostQueriedDomains_Basic(t *testing.T) {
 		"",
 		"query",
 		3,
+		"",
 	)
 	require.NoError(t, err)
 
@@ -307,6 +366,7 @@ func TestGetMostQueriedDomains_ExcludesResponses(t *testing.T) {
 		"1.2.3.4",
 		"response",
 		1,
+		"",
 	)
 	require.NoError(t, err)
 	err = InsertDNSEntry(
@@ -319,6 +379,7 @@ func TestGetMostQueriedDomains_ExcludesResponses(t *testing.T) {
 		"",
 		"query",
 		2,
+		"",
 	)
 	require.NoError(t, err)
 
@@ -343,6 +404,7 @@ func TestGetMostQueriedDomains_EventsConcatenated(t *testing.T) {
 		"",
 		"query",
 		10,
+		"",
 	)
 	require.NoError(t, err)
 	err = InsertDNSEntry(
@@ -355,6 +417,7 @@ func TestGetMostQueriedDomains_EventsConcatenated(t *testing.T) {
 		"",
 		"query",
 		20,
+		"",
 	)
 	require.NoError(t, err)
 
@@ -382,6 +445,7 @@ func TestGetMostQueriedDomains_OrderedByCountDesc(t *testing.T) {
 			"",
 			"query",
 			uint16(i),
+			"",
 		)
 		require.NoError(t, err)
 	}
@@ -395,6 +459,7 @@ func TestGetMostQueriedDomains_OrderedByCountDesc(t *testing.T) {
 		"",
 		"query",
 		10,
+		"",
 	)
 	require.NoError(t, err)
 	err = InsertDNSEntry(
@@ -407,6 +472,7 @@ func TestGetMostQueriedDomains_OrderedByCountDesc(t *testing.T) {
 		"",
 		"query",
 		11,
+		"",
 	)
 	require.NoError(t, err)
 	err = InsertDNSEntry(
@@ -419,6 +485,7 @@ func TestGetMostQueriedDomains_OrderedByCountDesc(t *testing.T) {
 		"",
 		"query",
 		20,
+		"",
 	)
 	require.NoError(t, err)
 
@@ -459,6 +526,7 @@ func TestGetDNSEntries_VerifyFields(t *testing.T) {
 		"1.2.3.4",
 		"query",
 		42,
+		"",
 	)
 	require.NoError(t, err)
 
@@ -491,6 +559,7 @@ func TestGetDNSEntries_VerifyTimestamp(t *testing.T) {
 		"",
 		"query",
 		1,
+		"",
 	)
 	require.NoError(t, err)
 
@@ -523,6 +592,7 @@ func TestGetDNSEntries_MultipleEntries(t *testing.T) {
 			"",
 			"query",
 			uint16(i+1),
+			"",
 		)
 		require.NoError(t, err)
 	}
@@ -561,6 +631,7 @@ func TestGetQueriesOverTime_VerifyFields(t *testing.T) {
 		"1.2.3.4",
 		"query",
 		42,
+		"",
 	)
 	require.NoError(t, err)
 
@@ -590,6 +661,7 @@ func TestGetQueriesOverTime_MultipleEntries(t *testing.T) {
 			"",
 			"query",
 			uint16(i+1),
+			"",
 		)
 		require.NoError(t, err)
 	}
@@ -622,6 +694,7 @@ func TestGetQueriesOverTime_IncludesResponses(t *testing.T) {
 		"",
 		"query",
 		1,
+		"",
 	)
 	require.NoError(t, err)
 	err = InsertDNSEntry(
@@ -634,6 +707,7 @@ func TestGetQueriesOverTime_IncludesResponses(t *testing.T) {
 		"1.2.3.4",
 		"response",
 		1,
+		"",
 	)
 	require.NoError(t, err)
 
@@ -659,6 +733,7 @@ func TestGetQueriesOverTime_MultipleEntriesSameTime(t *testing.T) {
 			"",
 			"query",
 			uint16(i+1),
+			"",
 		)
 		require.NoError(t, err)
 	}
@@ -673,6 +748,7 @@ func TestGetQueriesOverTime_MultipleEntriesSameTime(t *testing.T) {
 		"",
 		"query",
 		uint16(3),
+		"",
 	)
 	require.NoError(t, err)
 
@@ -714,6 +790,7 @@ func TestGetUniqueDomains_VerifyFields(t *testing.T) {
 		"1.2.3.4",
 		"query",
 		42,
+		"",
 	)
 	require.NoError(t, err)
 
@@ -743,6 +820,7 @@ func TestGetUniqueDomains_MultipleFields(t *testing.T) {
 			"",
 			"query",
 			uint16(i+1),
+			"",
 		)
 		require.NoError(t, err)
 	}
@@ -774,6 +852,7 @@ func TestGetUniqueDomains_Deduplication(t *testing.T) {
 			"",
 			"query",
 			uint16(i+1),
+			"",
 		)
 		require.NoError(t, err)
 	}
@@ -798,6 +877,7 @@ func TestGetUniqueDomains_ReqestAndResponse(t *testing.T) {
 		"",
 		"query",
 		uint16(1),
+		"",
 	)
 	require.NoError(t, err)
 
@@ -811,6 +891,7 @@ func TestGetUniqueDomains_ReqestAndResponse(t *testing.T) {
 		"",
 		"response",
 		uint16(1),
+		"",
 	)
 	require.NoError(t, err)
 
@@ -830,3 +911,73 @@ func TestGetUniqueDomains_ReqestAndResponse(t *testing.T) {
 	assert.Equal("example.com", e2.QueryName)
 	assert.Equal("response", e2.RequestType)
 }
+
+// ******************************
+// OnlyIDs
+// ******************************
+
+func TestOnlyIDs_LimitsQueries(t *testing.T) {
+	db, err := OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i, name := range []string{"example.com", "example.com", "google.com"} {
+		err = InsertDNSEntry(
+			db,
+			fmt.Sprintf("2024-01-01 00:0%d:00", i),
+			"192.168.0.1",
+			name,
+			"A",
+			"",
+			"",
+			"query",
+			uint16(i),
+			"",
+		)
+		require.NoError(t, err)
+	}
+
+	entries, err := GetDNSEntries(db)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	ids := []int{entries[1].Id, entries[2].Id}
+
+	mqd, err := GetMostQueriedDomains(db, OnlyIDs(ids))
+	require.NoError(t, err)
+	require.Len(t, mqd, 2)
+	assert.Equal(t, 1, mqd[0].Count)
+	assert.Equal(t, 1, mqd[1].Count)
+
+	ots, err := GetQueriesOverTime(db, OnlyIDs(ids))
+	require.NoError(t, err)
+	assert.Len(t, ots, 2)
+
+	dqs, err := GetUniqueDomains(db, OnlyIDs(ids[1:]))
+	require.NoError(t, err)
+	require.Len(t, dqs, 1)
+	assert.Equal(t, "google.com", dqs[0].QueryName)
+}
+
+func TestOnlyIDs_Empty(t *testing.T) {
+	db, err := OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = InsertDNSEntry(
+		db,
+		"2024-01-01 00:00:00",
+		"192.168.0.1",
+		"example.com",
+		"A",
+		"",
+		"",
+		"query",
+		1,
+		"",
+	)
+	require.NoError(t, err)
+
+	mqd, err := GetMostQueriedDomains(db, OnlyIDs(nil))
+	require.NoError(t, err)
+	assert.Empty(t, mqd)
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// HTTPTransaction is an HTTP/1.x request and its response. The sizes are of
+// the bodies and Latency runs from the request to the start of the response
+type HTTPTransaction struct {
+	Id           int           `json:"id"`
+	Timestamp    time.Time     `json:"timestamp"`
+	ClientIP     string        `json:"client_ip"`
+	ClientPort   string        `json:"client_port"`
+	ServerIP     string        `json:"server_ip"`
+	ServerPort   string        `json:"server_port"`
+	Interface    string        `json:"interface,omitempty"`
+	Method       string        `json:"method"`
+	Host         string        `json:"host,omitempty"`
+	Path         string        `json:"path"`
+	Version      string        `json:"version,omitempty"`
+	Status       int           `json:"status"`
+	ContentType  string        `json:"content_type,omitempty"`
+	RequestSize  int64         `json:"request_size"`
+	ResponseSize int64         `json:"response_size"`
+	Latency      time.Duration `json:"latency"`
+}
+
+// HTTPStat sums up the transactions sharing a host, status, path or method
+type HTTPStat struct {
+	Key           string        `json:"key"`
+	Transactions  int           `json:"transactions"`
+	Errors        int           `json:"errors"` // with a 4xx or 5xx status
+	RequestBytes  int64         `json:"request_bytes"`
+	ResponseBytes int64         `json:"response_bytes"`
+	AvgLatency    time.Duration `json:"avg_latency"`
+	MaxLatency    time.Duration `json:"max_latency"`
+}
+
+// httpStatColumns are the columns transactions can be grouped by
+var httpStatColumns = map[string]string{
+	"host":   "host",
+	"status": "status",
+	"path":   "path",
+	"method": "method",
+}
+
+// migrateHTTP creates the table of HTTP transactions
+func migrateHTTP(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS http_transactions (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp     TIMESTAMP NOT NULL,
+			client_ip     TEXT NOT NULL,
+			client_port   TEXT NOT NULL,
+			server_ip     TEXT NOT NULL,
+			server_port   TEXT NOT NULL,
+			interface     TEXT NOT NULL DEFAULT '',
+			method        TEXT NOT NULL,
+			host          TEXT NOT NULL DEFAULT '',
+			path          TEXT NOT NULL,
+			version       TEXT NOT NULL DEFAULT '',
+			status        INTEGER NOT NULL,
+			content_type  TEXT NOT NULL DEFAULT '',
+			request_size  INTEGER NOT NULL DEFAULT 0,
+			response_size INTEGER NOT NULL DEFAULT 0,
+			latency_ns    INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_http_transactions_host ON http_transactions(host);
+		CREATE INDEX IF NOT EXISTS idx_http_transactions_server_ip ON http_transactions(server_ip);
+	`)
+	return err
+}
+
+// InsertHTTPTransaction stores a transaction, the Id of tx is ignored
+func InsertHTTPTransaction(sqlDb *sql.DB, tx HTTPTransaction) error {
+	_, err := sqlDb.Exec(`
+		INSERT INTO http_transactions (
+			timestamp, client_ip, client_port, server_ip, server_port, interface,
+			method, host, path, version, status, content_type,
+			request_size, response_size, latency_ns
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		tx.Timestamp.UTC(), tx.ClientIP, tx.ClientPort, tx.ServerIP, tx.ServerPort, tx.Interface,
+		tx.Method, tx.Host, tx.Path, tx.Version, tx.Status, tx.ContentType,
+		tx.RequestSize, tx.ResponseSize, int64(tx.Latency),
+	)
+	return err
+}
+
+// GetHTTPTransactions returns the stored transactions, oldest first
+func GetHTTPTransactions(sqlDb *sql.DB) ([]HTTPTransaction, error) {
+	return queryHTTPTransactions(sqlDb, "ORDER BY timestamp, id")
+}
+
+// GetSlowestHTTPTransactions returns the n transactions that waited longest
+// for their response, slowest first
+func GetSlowestHTTPTransactions(sqlDb *sql.DB, n int) ([]HTTPTransaction, error) {
+	return queryHTTPTransactions(sqlDb, "ORDER BY latency_ns DESC, id LIMIT $1", n)
+}
+
+func queryHTTPTransactions(sqlDb *sql.DB, order string, args ...any) ([]HTTPTransaction, error) {
+	rows, err := sqlDb.Query(`SELECT
+		id, timestamp, client_ip, client_port, server_ip, server_port, interface,
+		method, host, path, version, status, content_type,
+		request_size, response_size, latency_ns
+		FROM http_transactions
+		`+order, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var txs []HTTPTransaction
+	for rows.Next() {
+		var tx HTTPTransaction
+		var latency int64
+		if err := rows.Scan(
+			&tx.Id,
+			&tx.Timestamp,
+			&tx.ClientIP,
+			&tx.ClientPort,
+			&tx.ServerIP,
+			&tx.ServerPort,
+			&tx.Interface,
+			&tx.Method,
+			&tx.Host,
+			&tx.Path,
+			&tx.Version,
+			&tx.Status,
+			&tx.ContentType,
+			&tx.RequestSize,
+			&tx.ResponseSize,
+			&latency,
+		); err != nil {
+			return nil, err
+		}
+		tx.Latency = time.Duration(latency)
+		txs = append(txs, tx)
+	}
+
+	return txs, rows.Err()
+}
+
+// GetHTTPStats groups the stored transactions by host, status, path or
+// method. The busiest come first
+func GetHTTPStats(sqlDb *sql.DB, by string) ([]HTTPStat, error) {
+	column, ok := httpStatColumns[by]
+	if !ok {
+		return nil, fmt.Errorf("unknown grouping %q, use host, status, path or method", by)
+	}
+
+	rows, err := sqlDb.Query(`SELECT CAST(` + column + ` AS TEXT), COUNT(*),
+		SUM(status >= 400), SUM(request_size), SUM(response_size),
+		CAST(AVG(latency_ns) AS INTEGER), MAX(latency_ns)
+		FROM http_transactions
+		GROUP BY 1
+		ORDER BY 2 DESC, 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []HTTPStat
+	for rows.Next() {
+		var s HTTPStat
+		var avg, slowest int64
+		if err := rows.Scan(
+			&s.Key,
+			&s.Transactions,
+			&s.Errors,
+			&s.RequestBytes,
+			&s.ResponseBytes,
+			&avg,
+			&slowest,
+		); err != nil {
+			return nil, err
+		}
+		s.AvgLatency, s.MaxLatency = time.Duration(avg), time.Duration(slowest)
+		stats = append(stats, s)
+	}
+
+	return stats, rows.Err()
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ******************************
+// HTTP transactions
+// ******************************
+
+func TestInsertHTTPTransaction(t *testing.T) {
+	db, err := OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, InsertHTTPTransaction(db, HTTPTransaction{
+		Timestamp:    t0.Add(time.Second),
+		ClientIP:     "10.0.0.5",
+		ClientPort:   "51001",
+		ServerIP:     "10.0.0.80",
+		ServerPort:   "80(http)",
+		Method:       "POST",
+		Host:         "api.internal",
+		Path:         "/v1/orders",
+		Version:      "HTTP/1.1",
+		Status:       201,
+		ContentType:  "application/json",
+		RequestSize:  512,
+		ResponseSize: 64,
+		Latency:      35 * time.Millisecond,
+	}))
+	require.NoError(t, InsertHTTPTransaction(db, HTTPTransaction{
+		Timestamp:  t0,
+		ClientIP:   "10.0.0.5",
+		ClientPort: "51000",
+		ServerIP:   "10.0.0.80",
+		ServerPort: "80(http)",
+		Interface:  "eth0",
+		Method:     "GET",
+		Path:       "/",
+		Status:     200,
+	}))
+
+	txs, err := GetHTTPTransactions(db)
+	require.NoError(t, err)
+	require.Len(t, txs, 2)
+
+	assert.Equal(t, "GET", txs[0].Method)
+	assert.Equal(t, "eth0", txs[0].Interface)
+	assert.True(t, t0.Equal(txs[0].Timestamp))
+
+	tx := txs[1]
+	assert.Equal(t, "api.internal", tx.Host)
+	assert.Equal(t, "/v1/orders", tx.Path)
+	assert.Equal(t, 201, tx.Status)
+	assert.Equal(t, "application/json", tx.ContentType)
+	assert.Equal(t, int64(512), tx.RequestSize)
+	assert.Equal(t, int64(64), tx.ResponseSize)
+	assert.Equal(t, 35*time.Millisecond, tx.Latency)
+}
+
+func TestGetHTTPStats(t *testing.T) {
+	db, err := OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tx := range []HTTPTransaction{
+		{Host: "a.internal", Path: "/", Status: 200, ResponseSize: 100, Latency: 10 * time.Millisecond},
+		{Host: "a.internal", Path: "/x", Status: 404, ResponseSize: 10, Latency: 30 * time.Millisecond},
+		{Host: "a.internal", Path: "/", Status: 200, ResponseSize: 100, Latency: 20 * time.Millisecond},
+		{Host: "b.internal", Path: "/", Status: 503, Latency: 900 * time.Millisecond},
+	} {
+		tx.Timestamp = t0
+		tx.ClientIP, tx.ClientPort = "10.0.0.5", "51000"
+		tx.ServerIP, tx.ServerPort = "10.0.0.80", "80(http)"
+		tx.Method = "GET"
+		require.NoError(t, InsertHTTPTransaction(db, tx))
+	}
+
+	stats, err := GetHTTPStats(db, "host")
+	require.NoError(t, err)
+	require.Len(t, stats, 2)
+	assert.Equal(t, HTTPStat{
+		Key:           "a.internal",
+		Transactions:  3,
+		Errors:        1,
+		ResponseBytes: 210,
+		AvgLatency:    20 * time.Millisecond,
+		MaxLatency:    30 * time.Millisecond,
+	}, stats[0])
+	assert.Equal(t, "b.internal", stats[1].Key)
+	assert.Equal(t, 1, stats[1].Errors)
+
+	stats, err = GetHTTPStats(db, "status")
+	require.NoError(t, err)
+	require.Len(t, stats, 3)
+	assert.Equal(t, "200", stats[0].Key)
+	assert.Equal(t, 2, stats[0].Transactions)
+
+	_, err = GetHTTPStats(db, "client_ip")
+	assert.Error(t, err)
+
+	slowest, err := GetSlowestHTTPTransactions(db, 2)
+	require.NoError(t, err)
+	require.Len(t, slowest, 2)
+	assert.Equal(t, "b.internal", slowest[0].Host)
+	assert.Equal(t, "/x", slowest[1].Path)
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// NDPNeighbor is an IPv6 to MAC binding learned from neighbor discovery, the
+// IPv6 counterpart of an ARPEntry
+type NDPNeighbor struct {
+	IP        string    `json:"ip"`
+	MAC       string    `json:"mac"`
+	Interface string    `json:"interface,omitempty"`
+	VLAN      int       `json:"vlan,omitempty"`
+	Router    bool      `json:"router"` // sent router advertisements or set the router flag
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Packets   int       `json:"packets"`
+}
+
+// migrateNDP creates the IPv6 neighbor table. Alerts go to arp_alerts
+func migrateNDP(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ndp_neighbors (
+			ip         TEXT NOT NULL,
+			mac        TEXT NOT NULL,
+			interface  TEXT NOT NULL DEFAULT '',
+			vlan       INTEGER NOT NULL DEFAULT 0,
+			router     BOOLEAN NOT NULL DEFAULT 0,
+			first_seen TIMESTAMP NOT NULL,
+			last_seen  TIMESTAMP NOT NULL,
+			packets    INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (ip, mac, interface, vlan)
+		);
+	`)
+	return err
+}
+
+// UpsertNDPNeighbor records that mac claimed ip at ts, adding the binding or
+// bumping its last seen time and packet count. Once a neighbor has been seen
+// acting as a router it stays marked as one
+func UpsertNDPNeighbor(
+	sqlDb *sql.DB,
+	ip, mac, iface string,
+	vlan int,
+	router bool,
+	ts time.Time,
+) error {
+	_, err := sqlDb.Exec(`
+		INSERT INTO ndp_neighbors (ip, mac, interface, vlan, router, first_seen, last_seen, packets)
+		VALUES ($1, $2, $3, $4, $5, $6, $6, 1)
+		ON CONFLICT (ip, mac, interface, vlan) DO UPDATE SET
+			router = MAX(router, excluded.router),
+			first_seen = MIN(first_seen, excluded.first_seen),
+			last_seen = MAX(last_seen, excluded.last_seen),
+			packets = packets + 1`,
+		ip, mac, iface, vlan, router, ts.UTC(),
+	)
+	return err
+}
+
+// GetNDPNeighbors returns the learned IPv6 neighbors ordered by IP, most
+// recently seen first for an IP with several MACs
+func GetNDPNeighbors(sqlDb *sql.DB) ([]NDPNeighbor, error) {
+	rows, err := sqlDb.Query(`SELECT
+		ip, mac, interface, vlan, router, first_seen, last_seen, packets
+		FROM ndp_neighbors
+		ORDER BY ip, last_seen DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var neighbors []NDPNeighbor
+	for rows.Next() {
+		var n NDPNeighbor
+		if err := rows.Scan(
+			&n.IP,
+			&n.MAC,
+			&n.Interface,
+			&n.VLAN,
+			&n.Router,
+			&n.FirstSeen,
+			&n.LastSeen,
+			&n.Packets,
+		); err != nil {
+			return nil, err
+		}
+		neighbors = append(neighbors, n)
+	}
+
+	return neighbors, rows.Err()
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ******************************
+// NDP neighbors
+// ******************************
+
+func TestUpsertNDPNeighbor(t *testing.T) {
+	db, err := OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mac := "00:00:0c:00:00:01"
+	require.NoError(t, UpsertNDPNeighbor(db, "fe80::1", mac, "eth0", 0, true, t0))
+	require.NoError(t, UpsertNDPNeighbor(db, "fe80::1", mac, "eth0", 0, false, t0.Add(time.Minute)))
+	require.NoError(t, UpsertNDPNeighbor(db, "2001:db8::5", "00:03:93:00:00:0a", "eth0", 0, false, t0))
+
+	neighbors, err := GetNDPNeighbors(db)
+	require.NoError(t, err)
+	require.Len(t, neighbors, 2)
+
+	assert.Equal(t, "2001:db8::5", neighbors[0].IP)
+	assert.False(t, neighbors[0].Router)
+
+	// A router stays a router
+	r := neighbors[1]
+	assert.Equal(t, "fe80::1", r.IP)
+	assert.True(t, r.Router)
+	assert.Equal(t, 2, r.Packets)
+	assert.True(t, t0.Equal(r.FirstSeen))
+	assert.True(t, t0.Add(time.Minute).Equal(r.LastSeen))
+}
+
+func TestGetNDPNeighbors_Empty(t *testing.T) {
+	db, err := OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	neighbors, err := GetNDPNeighbors(db)
+	assert.NoError(t, err)
+	assert.Empty(t, neighbors)
+}
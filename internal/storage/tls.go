@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// TLSSession is a TLS handshake read off the wire. The offered lists are
+// comma separated and Certificates is the server's chain as a JSON array
+type TLSSession struct {
+	Id              int       `json:"id"`
+	Timestamp       time.Time `json:"timestamp"`
+	ClientIP        string    `json:"client_ip"`
+	ClientPort      string    `json:"client_port"`
+	ServerIP        string    `json:"server_ip"`
+	ServerPort      string    `json:"server_port"`
+	Interface       string    `json:"interface,omitempty"`
+	SNI             string    `json:"sni,omitempty"`
+	ALPN            string    `json:"alpn,omitempty"`
+	Version         string    `json:"version,omitempty"`
+	CipherSuite     string    `json:"cipher_suite,omitempty"`
+	OfferedALPN     string    `json:"offered_alpn,omitempty"`
+	OfferedVersions string    `json:"offered_versions,omitempty"`
+	OfferedCiphers  string    `json:"offered_ciphers,omitempty"`
+	Certificates    string    `json:"certificates,omitempty"`
+	JA3             string    `json:"ja3,omitempty"`
+	JA3S            string    `json:"ja3s,omitempty"`
+	JA4             string    `json:"ja4,omitempty"`
+}
+
+// TLSAlert is a stored alert about a handshake with a known bad fingerprint
+type TLSAlert struct {
+	Id          int       `json:"id"`
+	Timestamp   time.Time `json:"time"`
+	Kind        string    `json:"kind"`
+	Fingerprint string    `json:"fingerprint"`
+	Description string    `json:"description,omitempty"`
+	ClientIP    string    `json:"client_ip"`
+	ServerIP    string    `json:"server_ip"`
+	ServerPort  string    `json:"server_port"`
+	SNI         string    `json:"sni,omitempty"`
+	Message     string    `json:"message"`
+}
+
+// TLSFingerprint is a fingerprint with the hosts that presented it
+type TLSFingerprint struct {
+	Fingerprint string               `json:"fingerprint"`
+	Handshakes  int                  `json:"handshakes"`
+	Hosts       []TLSFingerprintHost `json:"hosts"`
+}
+
+// TLSFingerprintHost is a host that presented a fingerprint, with the server
+// names of its handshakes
+type TLSFingerprintHost struct {
+	IP         string   `json:"ip"`
+	Handshakes int      `json:"handshakes"`
+	SNIs       []string `json:"snis"`
+}
+
+// migrateTLS creates the tables of TLS handshakes and fingerprint alerts,
+// adding the fingerprints to a handshake table from before they were taken
+func migrateTLS(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS tls_sessions (
+			id               INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp        TIMESTAMP NOT NULL,
+			client_ip        TEXT NOT NULL,
+			client_port      TEXT NOT NULL,
+			server_ip        TEXT NOT NULL,
+			server_port      TEXT NOT NULL,
+			interface        TEXT NOT NULL DEFAULT '',
+			sni              TEXT NOT NULL DEFAULT '',
+			alpn             TEXT NOT NULL DEFAULT '',
+			version          TEXT NOT NULL DEFAULT '',
+			cipher_suite     TEXT NOT NULL DEFAULT '',
+			offered_alpn     TEXT NOT NULL DEFAULT '',
+			offered_versions TEXT NOT NULL DEFAULT '',
+			offered_ciphers  TEXT NOT NULL DEFAULT '',
+			certificates     TEXT NOT NULL DEFAULT '',
+			ja3              TEXT NOT NULL DEFAULT '',
+			ja3s             TEXT NOT NULL DEFAULT '',
+			ja4              TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS idx_tls_sessions_sni ON tls_sessions(sni);
+		CREATE INDEX IF NOT EXISTS idx_tls_sessions_server_ip ON tls_sessions(server_ip);
+		CREATE TABLE IF NOT EXISTS tls_alerts (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp   TIMESTAMP NOT NULL,
+			kind        TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			description TEXT NOT NULL,
+			client_ip   TEXT NOT NULL,
+			server_ip   TEXT NOT NULL,
+			server_port TEXT NOT NULL,
+			sni         TEXT NOT NULL,
+			message     TEXT NOT NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	for _, column := range []string{"ja3", "ja3s", "ja4"} {
+		if err := addColumn(db, "tls_sessions", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertTLSSession stores a handshake, the Id of s is ignored
+func InsertTLSSession(sqlDb *sql.DB, s TLSSession) error {
+	_, err := sqlDb.Exec(`
+		INSERT INTO tls_sessions (
+			timestamp, client_ip, client_port, server_ip, server_port, interface,
+			sni, alpn, version, cipher_suite,
+			offered_alpn, offered_versions, offered_ciphers, certificates,
+			ja3, ja3s, ja4
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
+		s.Timestamp.UTC(), s.ClientIP, s.ClientPort, s.ServerIP, s.ServerPort, s.Interface,
+		s.SNI, s.ALPN, s.Version, s.CipherSuite,
+		s.OfferedALPN, s.OfferedVersions, s.OfferedCiphers, s.Certificates,
+		s.JA3, s.JA3S, s.JA4,
+	)
+	return err
+}
+
+// GetTLSSessions returns the stored handshakes, oldest first
+func GetTLSSessions(sqlDb *sql.DB) ([]TLSSession, error) {
+	rows, err := sqlDb.Query(`SELECT
+		id, timestamp, client_ip, client_port, server_ip, server_port, interface,
+		sni, alpn, version, cipher_suite,
+		offered_alpn, offered_versions, offered_ciphers, certificates,
+		ja3, ja3s, ja4
+		FROM tls_sessions
+		ORDER BY timestamp, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []TLSSession
+	for rows.Next() {
+		var s TLSSession
+		if err := rows.Scan(
+			&s.Id,
+			&s.Timestamp,
+			&s.ClientIP,
+			&s.ClientPort,
+			&s.ServerIP,
+			&s.ServerPort,
+			&s.Interface,
+			&s.SNI,
+			&s.ALPN,
+			&s.Version,
+			&s.CipherSuite,
+			&s.OfferedALPN,
+			&s.OfferedVersions,
+			&s.OfferedCiphers,
+			&s.Certificates,
+			&s.JA3,
+			&s.JA3S,
+			&s.JA4,
+		); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// InsertTLSAlert stores an alert about a known bad fingerprint
+func InsertTLSAlert(sqlDb *sql.DB, a TLSAlert) error {
+	_, err := sqlDb.Exec(`
+		INSERT INTO tls_alerts (
+			timestamp, kind, fingerprint, description,
+			client_ip, server_ip, server_port, sni, message
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		a.Timestamp.UTC(), a.Kind, a.Fingerprint, a.Description,
+		a.ClientIP, a.ServerIP, a.ServerPort, a.SNI, a.Message,
+	)
+	return err
+}
+
+// GetTLSAlerts returns the stored alerts, oldest first
+func GetTLSAlerts(sqlDb *sql.DB) ([]TLSAlert, error) {
+	rows, err := sqlDb.Query(`SELECT
+		id, timestamp, kind, fingerprint, description,
+		client_ip, server_ip, server_port, sni, message
+		FROM tls_alerts
+		ORDER BY timestamp, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []TLSAlert
+	for rows.Next() {
+		var a TLSAlert
+		if err := rows.Scan(
+			&a.Id,
+			&a.Timestamp,
+			&a.Kind,
+			&a.Fingerprint,
+			&a.Description,
+			&a.ClientIP,
+			&a.ServerIP,
+			&a.ServerPort,
+			&a.SNI,
+			&a.Message,
+		); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+
+	return alerts, rows.Err()
+}
+
+// fingerprintHosts is the column of the hosts a fingerprint describes, the
+// clients for JA3 and JA4 and the servers for JA3S
+var fingerprintHosts = map[string]string{
+	"ja3":  "client_ip",
+	"ja3s": "server_ip",
+	"ja4":  "client_ip",
+}
+
+// GetTLSFingerprints groups the stored handshakes by their ja3, ja3s or ja4
+// fingerprint, then by host and server name. The most seen fingerprints come
+// first
+func GetTLSFingerprints(sqlDb *sql.DB, kind string) ([]TLSFingerprint, error) {
+	host, ok := fingerprintHosts[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown fingerprint %q, use ja3, ja3s or ja4", kind)
+	}
+
+	rows, err := sqlDb.Query(`SELECT ` + kind + `, ` + host + `, sni, COUNT(*)
+		FROM tls_sessions
+		WHERE ` + kind + ` != ''
+		GROUP BY 1, 2, 3
+		ORDER BY 1, 2, 3`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fps []TLSFingerprint
+	for rows.Next() {
+		var fp, ip, sni string
+		var count int
+		if err := rows.Scan(&fp, &ip, &sni, &count); err != nil {
+			return nil, err
+		}
+
+		if len(fps) == 0 || fps[len(fps)-1].Fingerprint != fp {
+			fps = append(fps, TLSFingerprint{Fingerprint: fp})
+		}
+		f := &fps[len(fps)-1]
+		f.Handshakes += count
+
+		if len(f.Hosts) == 0 || f.Hosts[len(f.Hosts)-1].IP != ip {
+			f.Hosts = append(f.Hosts, TLSFingerprintHost{IP: ip, SNIs: []string{}})
+		}
+		h := &f.Hosts[len(f.Hosts)-1]
+		h.Handshakes += count
+		if sni != "" {
+			h.SNIs = append(h.SNIs, sni)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(fps, func(a, b TLSFingerprint) int {
+		return b.Handshakes - a.Handshakes
+	})
+	return fps, nil
+}
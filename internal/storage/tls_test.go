@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ******************************
+// TLS sessions
+// ******************************
+
+func TestInsertTLSSession(t *testing.T) {
+	db, err := OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, InsertTLSSession(db, TLSSession{
+		Timestamp:       t0.Add(time.Second),
+		ClientIP:        "10.0.0.5",
+		ClientPort:      "51001",
+		ServerIP:        "140.82.112.6",
+		ServerPort:      "443(https)",
+		SNI:             "api.github.com",
+		ALPN:            "h2",
+		Version:         "TLS 1.3",
+		CipherSuite:     "TLS_AES_128_GCM_SHA256",
+		OfferedALPN:     "h2,http/1.1",
+		OfferedVersions: "TLS 1.3,TLS 1.2",
+	}))
+	require.NoError(t, InsertTLSSession(db, TLSSession{
+		Timestamp:    t0,
+		ClientIP:     "10.0.0.5",
+		ClientPort:   "51000",
+		ServerIP:     "93.184.216.34",
+		ServerPort:   "443(https)",
+		Interface:    "eth0",
+		Version:      "TLS 1.2",
+		Certificates: `[{"subject":"CN=example.com"}]`,
+	}))
+
+	sessions, err := GetTLSSessions(db)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+
+	assert.Equal(t, "93.184.216.34", sessions[0].ServerIP)
+	assert.Equal(t, "eth0", sessions[0].Interface)
+	assert.Equal(t, `[{"subject":"CN=example.com"}]`, sessions[0].Certificates)
+	assert.True(t, t0.Equal(sessions[0].Timestamp))
+
+	s := sessions[1]
+	assert.Equal(t, "api.github.com", s.SNI)
+	assert.Equal(t, "h2", s.ALPN)
+	assert.Equal(t, "TLS 1.3,TLS 1.2", s.OfferedVersions)
+	assert.Empty(t, s.Certificates)
+}
+
+func TestGetTLSFingerprints(t *testing.T) {
+	db, err := OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, s := range []TLSSession{
+		{ClientIP: "10.0.0.5", ServerIP: "140.82.112.6", SNI: "api.github.com", JA4: "ja4-a", JA3S: "s1"},
+		{ClientIP: "10.0.0.5", ServerIP: "140.82.112.6", SNI: "api.github.com", JA4: "ja4-a", JA3S: "s1"},
+		{ClientIP: "10.0.0.5", ServerIP: "93.184.216.34", SNI: "example.com", JA4: "ja4-a", JA3S: "s2"},
+		{ClientIP: "10.0.0.6", ServerIP: "93.184.216.34", JA4: "ja4-a", JA3S: "s2"},
+		{ClientIP: "10.0.0.7", ServerIP: "93.184.216.34", SNI: "example.com", JA4: "ja4-b"},
+		{ClientIP: "10.0.0.8", ServerIP: "93.184.216.34"},
+	} {
+		s.Timestamp = t0
+		s.ClientPort, s.ServerPort = "51000", "443(https)"
+		require.NoError(t, InsertTLSSession(db, s))
+	}
+
+	fps, err := GetTLSFingerprints(db, "ja4")
+	require.NoError(t, err)
+	assert.Equal(t, []TLSFingerprint{
+		{Fingerprint: "ja4-a", Handshakes: 4, Hosts: []TLSFingerprintHost{
+			{IP: "10.0.0.5", Handshakes: 3, SNIs: []string{"api.github.com", "example.com"}},
+			{IP: "10.0.0.6", Handshakes: 1, SNIs: []string{}},
+		}},
+		{Fingerprint: "ja4-b", Handshakes: 1, Hosts: []TLSFingerprintHost{
+			{IP: "10.0.0.7", Handshakes: 1, SNIs: []string{"example.com"}},
+		}},
+	}, fps)
+
+	// JA3S describes servers, ties stay in fingerprint order
+	fps, err = GetTLSFingerprints(db, "ja3s")
+	require.NoError(t, err)
+	require.Len(t, fps, 2)
+	assert.Equal(t, "140.82.112.6", fps[0].Hosts[0].IP)
+	assert.Equal(t, "93.184.216.34", fps[1].Hosts[0].IP)
+
+	_, err = GetTLSFingerprints(db, "ja5")
+	assert.Error(t, err)
+}
+
+// ******************************
+// TLS alerts
+// ******************************
+
+func TestInsertTLSAlert(t *testing.T) {
+	db, err := OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, InsertTLSAlert(db, TLSAlert{
+		Timestamp:   t0,
+		Kind:        "ja3",
+		Fingerprint: "72a589da586844d7f0818ce684948eea",
+		Description: "default beacon",
+		ClientIP:    "10.0.0.5",
+		ServerIP:    "93.184.216.34",
+		ServerPort:  "443(https)",
+		Message:     "10.0.0.5 connecting to 93.184.216.34:443(https) has known bad JA3",
+	}))
+
+	alerts, err := GetTLSAlerts(db)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "ja3", alerts[0].Kind)
+	assert.Equal(t, "default beacon", alerts[0].Description)
+	assert.True(t, t0.Equal(alerts[0].Timestamp))
+}
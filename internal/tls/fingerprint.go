@@ -0,0 +1,253 @@
+package tls
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JA3 returns the JA3 string of a ClientHello and its MD5 hash, the
+// fingerprint of https://github.com/salesforce/ja3
+func JA3(h *ClientHello) (string, string) {
+	formats := make([]uint16, len(h.ECPointFormats))
+	for i, f := range h.ECPointFormats {
+		formats[i] = uint16(f)
+	}
+
+	s := strings.Join([]string{
+		strconv.Itoa(int(h.Version)),
+		decimals(h.CipherSuites),
+		decimals(h.Extensions),
+		decimals(h.SupportedGroups),
+		decimals(formats),
+	}, ",")
+	sum := md5.Sum([]byte(s))
+	return s, hex.EncodeToString(sum[:])
+}
+
+// JA3S returns the JA3S string of a ServerHello and its MD5 hash
+func JA3S(h *ServerHello) (string, string) {
+	s := strings.Join([]string{
+		strconv.Itoa(int(h.Version)),
+		strconv.Itoa(int(h.CipherSuite)),
+		decimals(h.Extensions),
+	}, ",")
+	sum := md5.Sum([]byte(s))
+	return s, hex.EncodeToString(sum[:])
+}
+
+// decimals joins the values that are not GREASE with dashes
+func decimals(vs []uint16) string {
+	var s []string
+	for _, v := range vs {
+		if !IsGREASE(v) {
+			s = append(s, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(s, "-")
+}
+
+// JA4 returns the JA4 fingerprint of a ClientHello, see
+// https://github.com/FoxIO-LLC/ja4. protocol is t for TLS over TCP and q for
+// QUIC
+func JA4(h *ClientHello, protocol byte) string {
+	var ciphers, exts []uint16
+	for _, c := range h.CipherSuites {
+		if !IsGREASE(c) {
+			ciphers = append(ciphers, c)
+		}
+	}
+	for _, e := range h.Extensions {
+		if !IsGREASE(e) {
+			exts = append(exts, e)
+		}
+	}
+
+	sni := byte('i')
+	if slices.Contains(exts, extServerName) {
+		sni = 'd'
+	}
+	a := fmt.Sprintf(
+		"%c%s%c%02d%02d%s",
+		protocol, ja4Version(h), sni, min(len(ciphers), 99), min(len(exts), 99), ja4ALPN(h.ALPN),
+	)
+
+	b := "000000000000"
+	if len(ciphers) > 0 {
+		b = truncatedHash(hexList(slices.Sorted(slices.Values(ciphers))))
+	}
+
+	// The server name and ALPN are left out, they are already part of a
+	c := "000000000000"
+	exts = slices.DeleteFunc(exts, func(e uint16) bool {
+		return e == extServerName || e == extALPN
+	})
+	if len(exts) > 0 {
+		s := hexList(slices.Sorted(slices.Values(exts)))
+		if len(h.SignatureAlgorithms) > 0 {
+			s += "_" + hexList(h.SignatureAlgorithms)
+		}
+		c = truncatedHash(s)
+	}
+
+	return a + "_" + b + "_" + c
+}
+
+// ja4Version is the highest version offered, as two characters
+func ja4Version(h *ClientHello) string {
+	v := h.Version
+	for _, sv := range h.SupportedVersions {
+		if !IsGREASE(sv) && sv > v {
+			v = sv
+		}
+	}
+
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	default:
+		return "00"
+	}
+}
+
+// ja4ALPN is the first and last character of the first ALPN protocol, or of
+// its hex when those are not alphanumeric
+func ja4ALPN(alpn []string) string {
+	if len(alpn) == 0 || alpn[0] == "" {
+		return "00"
+	}
+
+	p := alpn[0]
+	first, last := p[0], p[len(p)-1]
+	if !alphanumeric(first) || !alphanumeric(last) {
+		x := hex.EncodeToString([]byte(p))
+		return string([]byte{x[0], x[len(x)-1]})
+	}
+	return string([]byte{first, last})
+}
+
+func alphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// hexList joins the values as four digit hex with commas
+func hexList(vs []uint16) string {
+	s := make([]string, len(vs))
+	for i, v := range vs {
+		s[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(s, ",")
+}
+
+// truncatedHash is the first 12 hex digits of the SHA-256 of s
+func truncatedHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// KnownBad maps fingerprints, JA3 and JA3S hashes or JA4 strings, to what is
+// known about them
+type KnownBad map[string]string
+
+// LoadKnownBad reads a list of known bad fingerprints, one per line followed
+// by an optional description, e.g.
+//
+//	# Cobalt Strike
+//	72a589da586844d7f0818ce684948eea default beacon
+//
+// Blank lines and lines starting with # are skipped
+func LoadKnownBad(path string) (KnownBad, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	known := KnownBad{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fp, desc, _ := strings.Cut(line, " ")
+		known[strings.ToLower(fp)] = strings.TrimSpace(desc)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	return known, nil
+}
+
+// Alert is raised for a handshake with a known bad fingerprint
+type Alert struct {
+	Time        time.Time `json:"time"`
+	Kind        string    `json:"kind"` // ja3, ja3s or ja4
+	Fingerprint string    `json:"fingerprint"`
+	Description string    `json:"description,omitempty"`
+	ClientIP    string    `json:"client_ip"`
+	ServerIP    string    `json:"server_ip"`
+	ServerPort  string    `json:"server_port"`
+	SNI         string    `json:"sni,omitempty"`
+	Message     string    `json:"message"`
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("%s %s: %s", a.Time.Format(time.RFC3339), a.Kind, a.Message)
+}
+
+// Check returns an alert for every fingerprint of the handshake on the list
+func (k KnownBad) Check(info *Info) []Alert {
+	var alerts []Alert
+	for _, fp := range []struct{ kind, value string }{
+		{"ja3", info.JA3},
+		{"ja3s", info.JA3S},
+		{"ja4", info.JA4},
+	} {
+		desc, ok := k[fp.value]
+		if fp.value == "" || !ok {
+			continue
+		}
+
+		server := info.ServerIP + ":" + info.ServerPort
+		if info.SNI != "" {
+			server = info.SNI + " (" + server + ")"
+		}
+		msg := fmt.Sprintf(
+			"%s connecting to %s has known bad %s %s",
+			info.ClientIP, server, strings.ToUpper(fp.kind), fp.value,
+		)
+		if desc != "" {
+			msg += ": " + desc
+		}
+
+		alerts = append(alerts, Alert{
+			Time:        info.Time,
+			Kind:        fp.kind,
+			Fingerprint: fp.value,
+			Description: desc,
+			ClientIP:    info.ClientIP,
+			ServerIP:    info.ServerIP,
+			ServerPort:  info.ServerPort,
+			SNI:         info.SNI,
+			Message:     msg,
+		})
+	}
+	return alerts
+}
@@ -0,0 +1,151 @@
+package tls
+
+import (
+	cryptotls "crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/reassembly"
+)
+
+// ******************************
+// Fingerprints
+// ******************************
+
+func TestJA3(t *testing.T) {
+	h := &ClientHello{
+		Version: 0x0301,
+		CipherSuites: []uint16{
+			0x0a0a, 47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4,
+		},
+		Extensions:      []uint16{0x1a1a, 0, 10, 11},
+		SupportedGroups: []uint16{0x2a2a, 23, 24, 25},
+		ECPointFormats:  []uint8{0},
+	}
+
+	s, hash := JA3(h)
+	assert.Equal(t, "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0", s)
+	assert.Equal(t, "ada70206e40642a3e4461f35503241d5", hash)
+}
+
+func TestJA3S(t *testing.T) {
+	s, hash := JA3S(&ServerHello{Version: 0x0303, CipherSuite: 0xc02f, Extensions: []uint16{65281, 0, 11}})
+	assert.Equal(t, "771,49199,65281-0-11", s)
+	assert.Len(t, hash, 32)
+}
+
+func TestJA4(t *testing.T) {
+	h := &ClientHello{
+		Version: 0x0303,
+		CipherSuites: []uint16{
+			0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9,
+			0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035,
+		},
+		Extensions: []uint16{
+			0x001b, 0x0000, 0x0033, 0x0010, 0x4469, 0x0017, 0x002d, 0x000d,
+			0x0005, 0x0023, 0x0012, 0x002b, 0xff01, 0x000b, 0x000a, 0x0015,
+		},
+		ALPN:              []string{"h2", "http/1.1"},
+		SupportedVersions: []uint16{0x0304, 0x0303},
+		SignatureAlgorithms: []uint16{
+			0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601,
+		},
+	}
+	assert.Equal(t, "t13d1516h2_8daaf6152771_e5627efa2ab1", JA4(h, 't'))
+
+	// GREASE is left out of the counts and hashes
+	greased := *h
+	greased.CipherSuites = append([]uint16{0x3a3a}, h.CipherSuites...)
+	greased.Extensions = append([]uint16{0x4a4a}, h.Extensions...)
+	greased.SupportedVersions = append([]uint16{0x5a5a}, h.SupportedVersions...)
+	assert.Equal(t, "t13d1516h2_8daaf6152771_e5627efa2ab1", JA4(&greased, 't'))
+
+	assert.Equal(t, "q12i000000_000000000000_000000000000", JA4(&ClientHello{Version: 0x0303}, 'q'))
+}
+
+func TestJA4ALPN(t *testing.T) {
+	assert.Equal(t, "00", ja4ALPN(nil))
+	assert.Equal(t, "h2", ja4ALPN([]string{"h2"}))
+	assert.Equal(t, "h1", ja4ALPN([]string{"http/1.1"}))
+	assert.Equal(t, "ab", ja4ALPN([]string{"\xab"}))
+}
+
+func TestTracker_Fingerprints(t *testing.T) {
+	client, server := handshake(t, cryptotls.VersionTLS12)
+	a := newAssembler(reassembly.DefaultConfig())
+
+	info, _ := feed(a, segments(clientIP, serverIP, 1000, client, 1460)...)
+	require.NotNil(t, info)
+	assert.Len(t, info.JA3, 32)
+	assert.Regexp(t, `^t12d\d{4}h2_[0-9a-f]{12}_[0-9a-f]{12}$`, info.JA4)
+	assert.Empty(t, info.JA3S)
+
+	info, _ = feed(a, segments(serverIP, clientIP, 9000, server, 1460)...)
+	require.NotNil(t, info)
+	assert.Len(t, info.JA3S, 32)
+}
+
+// ******************************
+// Known bad
+// ******************************
+
+func TestLoadKnownBad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.txt")
+	require.NoError(t, os.WriteFile(path, []byte(`# Cobalt Strike
+72A589DA586844D7F0818CE684948EEA default beacon
+
+t13d1516h2_8daaf6152771_e5627efa2ab1
+`), 0o644))
+
+	known, err := LoadKnownBad(path)
+	require.NoError(t, err)
+	assert.Equal(t, KnownBad{
+		"72a589da586844d7f0818ce684948eea":     "default beacon",
+		"t13d1516h2_8daaf6152771_e5627efa2ab1": "",
+	}, known)
+
+	_, err = LoadKnownBad(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
+
+func TestKnownBad_Check(t *testing.T) {
+	known := KnownBad{
+		"72a589da586844d7f0818ce684948eea":     "default beacon",
+		"t13d1516h2_8daaf6152771_e5627efa2ab1": "",
+	}
+	info := &Info{
+		Time:       t0,
+		ClientIP:   clientIP,
+		ServerIP:   serverIP,
+		ServerPort: "443(https)",
+		SNI:        "example.com",
+		JA3:        "72a589da586844d7f0818ce684948eea",
+		JA3S:       "ada70206e40642a3e4461f35503241d5",
+		JA4:        "t13d1516h2_8daaf6152771_e5627efa2ab1",
+	}
+
+	alerts := known.Check(info)
+	require.Len(t, alerts, 2)
+	assert.Equal(t, "ja3", alerts[0].Kind)
+	assert.Equal(t, "default beacon", alerts[0].Description)
+	assert.Equal(
+		t,
+		"10.0.0.5 connecting to example.com (93.184.216.34:443(https)) has known bad "+
+			"JA3 72a589da586844d7f0818ce684948eea: default beacon",
+		alerts[0].Message,
+	)
+	assert.Equal(t, "ja4", alerts[1].Kind)
+	assert.Equal(
+		t,
+		"2024-01-01T00:00:00Z ja4: 10.0.0.5 connecting to example.com (93.184.216.34:443(https)) "+
+			"has known bad JA4 t13d1516h2_8daaf6152771_e5627efa2ab1",
+		alerts[1].String(),
+	)
+
+	assert.Empty(t, KnownBad(nil).Check(info))
+	assert.Empty(t, known.Check(&Info{}))
+}
@@ -0,0 +1,332 @@
+package tls
+
+import (
+	"crypto/x509"
+	"errors"
+	"slices"
+)
+
+// Record content types
+const (
+	recordChangeCipherSpec = 20
+	recordAlert            = 21
+	recordHandshake        = 22
+	recordApplicationData  = 23
+)
+
+// Handshake message types
+const (
+	typeClientHello     = 1
+	typeServerHello     = 2
+	typeCertificate     = 11
+	typeServerHelloDone = 14
+)
+
+// Extension types
+const (
+	extServerName          = 0
+	extSupportedGroups     = 10
+	extECPointFormats      = 11
+	extSignatureAlgorithms = 13
+	extALPN                = 16
+	extSupportedVersions   = 43
+)
+
+var errTruncated = errors.New("tls: truncated handshake message")
+
+// ClientHello holds the fields of a ClientHello that describe the client,
+// in the order it sent them. GREASE values are kept
+type ClientHello struct {
+	Version             uint16 // legacy_version, 0x0303 even for TLS 1.3
+	CipherSuites        []uint16
+	Extensions          []uint16 // extension types
+	ServerName          string
+	ALPN                []string
+	SupportedVersions   []uint16
+	SupportedGroups     []uint16
+	ECPointFormats      []uint8
+	SignatureAlgorithms []uint16
+}
+
+// ServerHello holds the choices the server made
+type ServerHello struct {
+	Version          uint16 // legacy_version
+	CipherSuite      uint16
+	Extensions       []uint16
+	ALPN             string
+	SupportedVersion uint16 // the version chosen through supported_versions, TLS 1.3 only
+}
+
+// NegotiatedVersion returns the version of the connection
+func (h *ServerHello) NegotiatedVersion() uint16 {
+	if h.SupportedVersion != 0 {
+		return h.SupportedVersion
+	}
+	return h.Version
+}
+
+// reader reads the big endian integers and length prefixed vectors of TLS
+// messages. Reading past the end empties it and sets short
+type reader struct {
+	b     []byte
+	short bool
+}
+
+func (r *reader) bytes(n int) []byte {
+	if n > len(r.b) {
+		r.b, r.short = nil, true
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) u8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) u16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+func (r *reader) u24() int {
+	b := r.bytes(3)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
+
+// vec8, vec16 and vec24 read a vector with a 1, 2 or 3 byte length
+func (r *reader) vec8() *reader  { return &reader{b: r.bytes(int(r.u8()))} }
+func (r *reader) vec16() *reader { return &reader{b: r.bytes(int(r.u16()))} }
+func (r *reader) vec24() *reader { return &reader{b: r.bytes(r.u24())} }
+
+func (r *reader) empty() bool {
+	return len(r.b) == 0
+}
+
+func (r *reader) u16s() []uint16 {
+	var v []uint16
+	for len(r.b) >= 2 {
+		v = append(v, r.u16())
+	}
+	return v
+}
+
+// ParseClientHello parses the body of a ClientHello handshake message
+func ParseClientHello(body []byte) (*ClientHello, error) {
+	r := &reader{b: body}
+	h := &ClientHello{Version: r.u16()}
+	r.bytes(32) // random
+	r.vec8()    // session id
+	h.CipherSuites = r.vec16().u16s()
+	r.vec8() // compression methods
+	if r.short {
+		return nil, errTruncated
+	}
+
+	// Extensions are optional before TLS 1.2
+	exts := r.vec16()
+	for !exts.empty() && !exts.short {
+		typ := exts.u16()
+		data := exts.vec16()
+		h.Extensions = append(h.Extensions, typ)
+
+		switch typ {
+		case extServerName:
+			names := data.vec16()
+			for !names.empty() && !names.short {
+				nameType := names.u8()
+				name := names.vec16()
+				if nameType == 0 { // host_name
+					h.ServerName = string(name.b)
+				}
+			}
+		case extALPN:
+			h.ALPN = protocols(data.vec16())
+		case extSupportedVersions:
+			h.SupportedVersions = data.vec8().u16s()
+		case extSupportedGroups:
+			h.SupportedGroups = data.vec16().u16s()
+		case extECPointFormats:
+			h.ECPointFormats = slices.Clone(data.vec8().b)
+		case extSignatureAlgorithms:
+			h.SignatureAlgorithms = data.vec16().u16s()
+		}
+	}
+	if exts.short {
+		return nil, errTruncated
+	}
+
+	return h, nil
+}
+
+// ParseServerHello parses the body of a ServerHello handshake message
+func ParseServerHello(body []byte) (*ServerHello, error) {
+	r := &reader{b: body}
+	h := &ServerHello{Version: r.u16()}
+	r.bytes(32) // random
+	r.vec8()    // session id
+	h.CipherSuite = r.u16()
+	r.u8() // compression method
+	if r.short {
+		return nil, errTruncated
+	}
+
+	exts := r.vec16()
+	for !exts.empty() && !exts.short {
+		typ := exts.u16()
+		data := exts.vec16()
+		h.Extensions = append(h.Extensions, typ)
+
+		switch typ {
+		case extALPN:
+			if p := protocols(data.vec16()); len(p) > 0 {
+				h.ALPN = p[0]
+			}
+		case extSupportedVersions:
+			h.SupportedVersion = data.u16()
+		}
+	}
+	if exts.short {
+		return nil, errTruncated
+	}
+
+	return h, nil
+}
+
+// protocols reads an ALPN protocol name list
+func protocols(r *reader) []string {
+	var v []string
+	for !r.empty() && !r.short {
+		if p := r.vec8(); !p.short {
+			v = append(v, string(p.b))
+		}
+	}
+	return v
+}
+
+// ParseCertificates parses the body of a TLS 1.2 Certificate message, the
+// server's chain starting with its own certificate. Certificates that do not
+// parse are skipped
+func ParseCertificates(body []byte) ([]Certificate, error) {
+	r := &reader{b: body}
+	list := r.vec24()
+	if r.short {
+		return nil, errTruncated
+	}
+
+	var certs []Certificate
+	for !list.empty() {
+		der := list.vec24()
+		if list.short {
+			return certs, errTruncated
+		}
+		c, err := x509.ParseCertificate(der.b)
+		if err != nil {
+			continue
+		}
+		certs = append(certs, Certificate{
+			Subject:   c.Subject.String(),
+			Issuer:    c.Issuer.String(),
+			SANs:      subjectAltNames(c),
+			NotBefore: c.NotBefore.UTC(),
+			NotAfter:  c.NotAfter.UTC(),
+		})
+	}
+
+	return certs, nil
+}
+
+// subjectAltNames returns the DNS names and IP addresses of a certificate
+func subjectAltNames(c *x509.Certificate) []string {
+	sans := slices.Clone(c.DNSNames)
+	for _, ip := range c.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
+
+// maxBuffered caps the bytes a stream holds while waiting for the rest of a
+// record, a certificate chain rarely comes close
+const maxBuffered = 64 * 1024
+
+// stream reads the handshake messages sent in one direction of a connection
+// from its ordered bytes
+type stream struct {
+	records []byte // bytes of a record that is not complete yet
+	msgs    []byte // bytes of a handshake message that is not complete yet
+	done    bool   // encrypted, given up on, or nothing more of interest
+}
+
+// read adds the next bytes, calling handle with every handshake message they
+// complete
+func (s *stream) read(payload []byte, handle func(typ uint8, body []byte)) {
+	if s.done {
+		return
+	}
+
+	s.records = append(s.records, payload...)
+	for len(s.records) >= 5 && !s.done {
+		typ := s.records[0]
+		length := int(s.records[3])<<8 | int(s.records[4])
+		if s.records[1] != 3 {
+			s.stop() // not TLS after all
+			return
+		}
+		if len(s.records) < 5+length {
+			break
+		}
+		fragment := s.records[5 : 5+length]
+		s.records = s.records[5+length:]
+
+		switch typ {
+		case recordHandshake:
+			s.msgs = append(s.msgs, fragment...)
+			for len(s.msgs) >= 4 && !s.done {
+				length := int(s.msgs[1])<<16 | int(s.msgs[2])<<8 | int(s.msgs[3])
+				if len(s.msgs) < 4+length {
+					break
+				}
+				handle(s.msgs[0], s.msgs[4:4+length])
+				s.msgs = s.msgs[4+length:]
+			}
+		case recordAlert:
+		default:
+			// ChangeCipherSpec or application data, the rest is encrypted
+			s.done = true
+		}
+	}
+
+	if len(s.records)+len(s.msgs) > maxBuffered {
+		s.done = true
+	}
+	if s.done {
+		s.stop()
+	}
+}
+
+// stop reads nothing more
+func (s *stream) stop() {
+	s.done = true
+	s.records, s.msgs = nil, nil
+}
+
+// isClientHello reports whether a client's first payload starts a TLS
+// handshake with a ClientHello
+func isClientHello(payload []byte) bool {
+	return len(payload) >= 6 &&
+		payload[0] == recordHandshake &&
+		payload[1] == 3 &&
+		payload[5] == typeClientHello
+}
@@ -0,0 +1,281 @@
+// Package tls dissects TLS handshakes seen on the wire, reading the server
+// name, ALPN, versions, cipher suites and certificate chain the two sides
+// exchange before encryption starts
+package tls
+
+import (
+	cryptotls "crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"packeteer/internal/reassembly"
+	"packeteer/internal/storage"
+)
+
+// Info is what a handshake tells about a TLS connection. The offered values
+// come from the ClientHello, the others from the server's answer
+type Info struct {
+	Time            time.Time     `json:"time"`
+	ClientIP        string        `json:"client_ip"`
+	ClientPort      string        `json:"client_port"`
+	ServerIP        string        `json:"server_ip"`
+	ServerPort      string        `json:"server_port"`
+	Interface       string        `json:"-"`
+	SNI             string        `json:"sni,omitempty"`
+	ALPN            string        `json:"alpn,omitempty"` // TLS 1.2 and older, like the certificates
+	OfferedALPN     []string      `json:"offered_alpn,omitempty"`
+	Version         string        `json:"version,omitempty"`
+	OfferedVersions []string      `json:"offered_versions,omitempty"`
+	CipherSuite     string        `json:"cipher_suite,omitempty"`
+	OfferedCiphers  []string      `json:"offered_ciphers,omitempty"`
+	Certificates    []Certificate `json:"certificates,omitempty"` // 1.3 encrypts them
+	JA3             string        `json:"ja3,omitempty"`          // MD5 hashes
+	JA3S            string        `json:"ja3s,omitempty"`
+	JA4             string        `json:"ja4,omitempty"`
+
+	ClientHello *ClientHello `json:"-"`
+	ServerHello *ServerHello `json:"-"`
+}
+
+// Certificate is a certificate of the chain the server sent
+type Certificate struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	SANs      []string  `json:"sans,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+}
+
+// Tracker reads the handshakes of the TCP connections an Assembler hands it,
+// from a ClientHello until the server's messages turn encrypted
+type Tracker struct{}
+
+// NewTracker returns a Tracker to register with a reassembly.Assembler
+func NewTracker() *Tracker {
+	return &Tracker{}
+}
+
+// Accept reads the connections that start with a ClientHello
+func (t *Tracker) Accept(c reassembly.Conn, first []byte) reassembly.Stream {
+	if !isClientHello(first) {
+		return nil
+	}
+	return &Session{info: Info{
+		Time:       c.Time,
+		ClientIP:   c.ClientIP,
+		ClientPort: c.ClientPort,
+		ServerIP:   c.ServerIP,
+		ServerPort: c.ServerPort,
+		Interface:  c.Interface,
+	}}
+}
+
+// Session is the handshake of one connection
+type Session struct {
+	mu             sync.Mutex
+	client, server stream
+	info           Info
+	changed        bool
+	closed         bool
+	reported       bool // complete was returned by Take
+}
+
+// Read adds the next bytes of one side
+func (s *Session) Read(fromClient bool, data []byte, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fromClient {
+		s.client.read(data, s.handleClient)
+	} else {
+		s.server.read(data, s.handleServer)
+	}
+}
+
+// Gap gives up on a side, there is no telling what was lost
+func (s *Session) Gap(fromClient bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fromClient {
+		s.client.stop()
+	} else {
+		s.server.stop()
+	}
+}
+
+// End tells that a side closed the connection, which ends the handshake
+func (s *Session) End(fromClient bool, t time.Time) {
+	s.Close()
+}
+
+// Close tells that the connection is gone
+func (s *Session) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+// Take returns a snapshot of the Info when the handshake taught something new
+// since the last call, and whether it is over as far as it can be read. A
+// connection closing mid handshake leaves whatever was read
+func (s *Session) Take() (info *Info, complete bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reported {
+		return nil, false
+	}
+	complete = s.server.done || s.closed
+	if !s.changed && !complete {
+		return nil, false
+	}
+	s.changed, s.reported = false, complete
+
+	snapshot := s.info
+	return &snapshot, complete
+}
+
+// handleClient reads a handshake message from the client. The ClientHello
+// is all there is to read, what follows it is key exchange or encrypted
+func (s *Session) handleClient(typ uint8, body []byte) {
+	if typ != typeClientHello {
+		return
+	}
+	s.client.done = true
+
+	h, err := ParseClientHello(body)
+	if err != nil {
+		return
+	}
+	s.info.SetClientHello(h, 't')
+	s.changed = true
+}
+
+// handleServer reads a handshake message from the server, up to the point it
+// is encrypted. In TLS 1.3 that is right after the ServerHello
+func (s *Session) handleServer(typ uint8, body []byte) {
+	switch typ {
+	case typeServerHello:
+		h, err := ParseServerHello(body)
+		if err != nil {
+			s.server.done = true
+			return
+		}
+		s.info.SetServerHello(h)
+		if h.NegotiatedVersion() >= cryptotls.VersionTLS13 {
+			s.server.done = true
+		}
+		s.changed = true
+	case typeCertificate:
+		certs, _ := ParseCertificates(body)
+		s.info.Certificates = certs
+		s.changed = true
+	case typeServerHelloDone:
+		s.server.done = true
+	}
+}
+
+// SetClientHello fills in what the client offered. protocol is the JA4
+// protocol, t for TLS over TCP and q for QUIC
+func (i *Info) SetClientHello(h *ClientHello, protocol byte) {
+	i.ClientHello = h
+	i.SNI = h.ServerName
+	i.OfferedALPN = h.ALPN
+	_, i.JA3 = JA3(h)
+	i.JA4 = JA4(h, protocol)
+
+	versions := h.SupportedVersions
+	if len(versions) == 0 {
+		versions = []uint16{h.Version}
+	}
+	i.OfferedVersions = nil
+	for _, v := range versions {
+		if !IsGREASE(v) {
+			i.OfferedVersions = append(i.OfferedVersions, VersionName(v))
+		}
+	}
+	i.OfferedCiphers = nil
+	for _, c := range h.CipherSuites {
+		if !IsGREASE(c) {
+			i.OfferedCiphers = append(i.OfferedCiphers, CipherSuiteName(c))
+		}
+	}
+}
+
+// SetServerHello fills in what the server chose
+func (i *Info) SetServerHello(h *ServerHello) {
+	i.ServerHello = h
+	i.Version = VersionName(h.NegotiatedVersion())
+	i.CipherSuite = CipherSuiteName(h.CipherSuite)
+	i.ALPN = h.ALPN
+	_, i.JA3S = JA3S(h)
+}
+
+// IsGREASE reports whether v is one of the reserved values of RFC 8701,
+// which clients sprinkle into their lists to keep servers tolerant
+func IsGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// VersionName names a protocol version, e.g. TLS 1.3
+func VersionName(v uint16) string {
+	if v == 0x0300 {
+		return "SSL 3.0"
+	}
+	return cryptotls.VersionName(v)
+}
+
+// CipherSuiteName names a cipher suite, or returns its hex value when
+// unknown
+func CipherSuiteName(id uint16) string {
+	return cryptotls.CipherSuiteName(id)
+}
+
+// Summary returns the server name and negotiated parameters on one line, e.g.
+// api.github.com TLS 1.3 h2 TLS_AES_128_GCM_SHA256
+func (i *Info) Summary() string {
+	parts := []string{}
+	for _, p := range []string{i.SNI, i.Version, i.ALPN, i.CipherSuite} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return "handshake"
+	}
+	return strings.Join(parts, " ")
+}
+
+// InsertTLSInfo stores a finished handshake in the database
+func InsertTLSInfo(info *Info, sqldb *sql.DB) error {
+	certs, err := json.Marshal(info.Certificates)
+	if err != nil {
+		return fmt.Errorf("encoding certificates: %w", err)
+	}
+	if info.Certificates == nil {
+		certs = nil
+	}
+
+	return storage.InsertTLSSession(sqldb, storage.TLSSession{
+		Timestamp:       info.Time,
+		ClientIP:        info.ClientIP,
+		ClientPort:      info.ClientPort,
+		ServerIP:        info.ServerIP,
+		ServerPort:      info.ServerPort,
+		Interface:       info.Interface,
+		SNI:             info.SNI,
+		ALPN:            info.ALPN,
+		Version:         info.Version,
+		CipherSuite:     info.CipherSuite,
+		OfferedALPN:     strings.Join(info.OfferedALPN, ","),
+		OfferedVersions: strings.Join(info.OfferedVersions, ","),
+		OfferedCiphers:  strings.Join(info.OfferedCiphers, ","),
+		Certificates:    string(certs),
+		JA3:             info.JA3,
+		JA3S:            info.JA3S,
+		JA4:             info.JA4,
+	})
+}
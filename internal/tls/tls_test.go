@@ -0,0 +1,336 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	cryptotls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"packeteer/internal/reassembly"
+	"packeteer/internal/storage"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// recorder keeps every write made to a connection
+type recorder struct {
+	net.Conn
+	mu     sync.Mutex
+	writes [][]byte
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	r.writes = append(r.writes, append([]byte(nil), b...))
+	r.mu.Unlock()
+	return r.Conn.Write(b)
+}
+
+func (r *recorder) bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var b []byte
+	for _, w := range r.writes {
+		b = append(b, w...)
+	}
+	return b
+}
+
+func certificate(t *testing.T) cryptotls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    t0,
+		NotAfter:     t0.Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return cryptotls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake runs a real handshake of at most version maxVersion, returning
+// the bytes the client and the server sent
+func handshake(t *testing.T, maxVersion uint16) (client, server []byte) {
+	t.Helper()
+	c, s := net.Pipe()
+	rc, rs := &recorder{Conn: c}, &recorder{Conn: s}
+	defer c.Close()
+	defer s.Close()
+
+	srv := cryptotls.Server(rs, &cryptotls.Config{
+		Certificates:           []cryptotls.Certificate{certificate(t)},
+		NextProtos:             []string{"h2"},
+		SessionTicketsDisabled: true,
+		MaxVersion:             maxVersion,
+	})
+	cli := cryptotls.Client(rc, &cryptotls.Config{
+		ServerName:         "example.com",
+		NextProtos:         []string{"h2", "http/1.1"},
+		InsecureSkipVerify: true,
+		MaxVersion:         maxVersion,
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Handshake() }()
+	require.NoError(t, cli.Handshake())
+	require.NoError(t, <-done)
+
+	return rc.bytes(), rs.bytes()
+}
+
+// segments splits a direction's bytes into segments of at most size bytes
+// starting at seq
+func segments(from, to string, seq uint32, b []byte, size int) []reassembly.Segment {
+	var segs []reassembly.Segment
+	for len(b) > 0 {
+		n := min(size, len(b))
+		segs = append(segs, reassembly.Segment{
+			Time:    t0,
+			SrcIP:   from,
+			SrcPort: "51000",
+			DstIP:   to,
+			DstPort: "443(https)",
+			Seq:     seq,
+			Payload: b[:n],
+			ACK:     true,
+		})
+		if from == serverIP {
+			segs[len(segs)-1].SrcPort, segs[len(segs)-1].DstPort = "443(https)", "51000"
+		}
+		seq += uint32(n)
+		b = b[n:]
+	}
+	return segs
+}
+
+// newAssembler returns an Assembler handing connections to a Tracker
+func newAssembler(cfg reassembly.Config) *reassembly.Assembler {
+	a := reassembly.NewAssembler(cfg)
+	a.Register(NewTracker())
+	return a
+}
+
+// feed hands segments to an Assembler, returning the last news of the
+// handshakes they carry
+func feed(a *reassembly.Assembler, segs ...reassembly.Segment) (info *Info, complete bool) {
+	for _, seg := range segs {
+		for _, s := range a.Feed(seg) {
+			if i, c := s.(*Session).Take(); i != nil {
+				info, complete = i, c
+			}
+		}
+	}
+	return info, complete
+}
+
+const (
+	clientIP = "10.0.0.5"
+	serverIP = "93.184.216.34"
+)
+
+// ******************************
+// Tracker
+// ******************************
+
+func TestTracker_TLS13(t *testing.T) {
+	client, server := handshake(t, cryptotls.VersionTLS13)
+	a := newAssembler(reassembly.DefaultConfig())
+
+	info, complete := feed(a, segments(clientIP, serverIP, 1000, client, 1460)...)
+	require.NotNil(t, info)
+	assert.False(t, complete)
+	assert.Equal(t, "example.com", info.SNI)
+	assert.Equal(t, []string{"h2", "http/1.1"}, info.OfferedALPN)
+	assert.Contains(t, info.OfferedVersions, "TLS 1.3")
+	assert.Contains(t, info.OfferedCiphers, "TLS_AES_128_GCM_SHA256")
+	assert.Equal(t, clientIP, info.ClientIP)
+	assert.Equal(t, "443(https)", info.ServerPort)
+	assert.Empty(t, info.Version)
+
+	segs := segments(serverIP, clientIP, 9000, server, 1460)
+	info, complete = feed(a, segs...)
+	require.NotNil(t, info)
+	assert.True(t, complete)
+	assert.Equal(t, "example.com", info.SNI)
+	assert.Equal(t, "TLS 1.3", info.Version)
+	assert.Contains(t, info.CipherSuite, "TLS_")
+	assert.Empty(t, info.ALPN, "encrypted in TLS 1.3")
+	assert.Empty(t, info.Certificates, "encrypted in TLS 1.3")
+
+	// A complete handshake is reported once
+	fin := segs[len(segs)-1]
+	fin.Seq += uint32(len(fin.Payload))
+	fin.Payload, fin.FIN = nil, true
+	info, _ = feed(a, fin)
+	assert.Nil(t, info)
+}
+
+func TestTracker_TLS12(t *testing.T) {
+	client, server := handshake(t, cryptotls.VersionTLS12)
+	a := newAssembler(reassembly.DefaultConfig())
+
+	feed(a, segments(clientIP, serverIP, 1000, client, 1460)...)
+
+	// Small segments out of order, with a retransmission and a partial
+	// overlap
+	segs := segments(serverIP, clientIP, 0xfffffff0, server, 40)
+	require.Greater(t, len(segs), 4)
+	overlap := segs[2]
+	overlap.Seq -= 10
+	overlap.Payload = slices.Concat(segs[1].Payload[30:], segs[2].Payload)
+	segs = append(segs[:3], append([]reassembly.Segment{segs[1], overlap}, segs[3:]...)...)
+	segs[5], segs[6] = segs[6], segs[5]
+
+	info, complete := feed(a, segs...)
+	require.NotNil(t, info)
+	assert.True(t, complete)
+	assert.Equal(t, "TLS 1.2", info.Version)
+	assert.Equal(t, "h2", info.ALPN)
+	require.Len(t, info.Certificates, 1)
+
+	cert := info.Certificates[0]
+	assert.Equal(t, "CN=example.com", cert.Subject)
+	assert.Equal(t, "CN=example.com", cert.Issuer)
+	assert.Equal(t, []string{"example.com", "127.0.0.1"}, cert.SANs)
+	assert.Equal(t, t0, cert.NotBefore)
+	assert.Equal(t, t0.Add(24*time.Hour), cert.NotAfter)
+}
+
+func TestTracker_Gap(t *testing.T) {
+	client, server := handshake(t, cryptotls.VersionTLS12)
+	cfg := reassembly.DefaultConfig()
+	cfg.MaxBufferedPerConn = 10
+	a := newAssembler(cfg)
+
+	feed(a, segments(clientIP, serverIP, 1000, client, 1460)...)
+	segs := segments(serverIP, clientIP, 9000, server, 20)
+	_, complete := feed(a, segs[0])
+	assert.False(t, complete)
+
+	// The lost segment is given up on, what was read is kept
+	info, complete := feed(a, segs[2])
+	require.NotNil(t, info)
+	assert.True(t, complete)
+	assert.Equal(t, "example.com", info.SNI)
+	assert.Empty(t, info.Version)
+}
+
+func TestTracker_Ignores(t *testing.T) {
+	a := newAssembler(reassembly.DefaultConfig())
+
+	streams := a.Feed(reassembly.Segment{
+		SrcIP:   clientIP,
+		DstIP:   serverIP,
+		Payload: []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+	})
+	assert.Empty(t, streams)
+}
+
+func TestTracker_ClosedMidHandshake(t *testing.T) {
+	client, _ := handshake(t, cryptotls.VersionTLS13)
+	a := newAssembler(reassembly.DefaultConfig())
+
+	feed(a, segments(clientIP, serverIP, 1000, client, 1460)...)
+	info, complete := feed(a, reassembly.Segment{
+		Time:    t0,
+		SrcIP:   serverIP,
+		SrcPort: "443(https)",
+		DstIP:   clientIP,
+		DstPort: "51000",
+		RST:     true,
+	})
+	require.NotNil(t, info)
+	assert.True(t, complete)
+	assert.Equal(t, "example.com", info.SNI)
+	assert.Empty(t, a.Flush())
+}
+
+// ******************************
+// Parsing
+// ******************************
+
+func TestParseClientHello_Truncated(t *testing.T) {
+	client, _ := handshake(t, cryptotls.VersionTLS13)
+	body := client[9:] // past the record and handshake headers
+
+	h, err := ParseClientHello(body)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", h.ServerName)
+	assert.Contains(t, h.Extensions, uint16(extServerName))
+	assert.NotEmpty(t, h.SupportedGroups)
+	assert.NotEmpty(t, h.SignatureAlgorithms)
+
+	_, err = ParseClientHello(body[:40])
+	assert.ErrorIs(t, err, errTruncated)
+	_, err = ParseServerHello(nil)
+	assert.ErrorIs(t, err, errTruncated)
+}
+
+func TestIsGREASE(t *testing.T) {
+	assert.True(t, IsGREASE(0x0a0a))
+	assert.True(t, IsGREASE(0xfafa))
+	assert.False(t, IsGREASE(0x0a1a))
+	assert.False(t, IsGREASE(0x1301))
+}
+
+func TestNames(t *testing.T) {
+	assert.Equal(t, "TLS 1.3", VersionName(cryptotls.VersionTLS13))
+	assert.Equal(t, "SSL 3.0", VersionName(0x0300))
+	assert.Equal(t, "TLS_AES_128_GCM_SHA256", CipherSuiteName(0x1301))
+	assert.Equal(t, "0xFFFF", CipherSuiteName(0xffff))
+}
+
+func TestInfo_Summary(t *testing.T) {
+	info := &Info{SNI: "api.github.com", Version: "TLS 1.3", ALPN: "h2"}
+	assert.Equal(t, "api.github.com TLS 1.3 h2", info.Summary())
+	assert.Equal(t, "handshake", (&Info{}).Summary())
+}
+
+// ******************************
+// Storage
+// ******************************
+
+func TestInsertTLSInfo(t *testing.T) {
+	db, err := storage.OpenDb(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	info := &Info{
+		Time:            t0,
+		ClientIP:        clientIP,
+		ClientPort:      "51000",
+		ServerIP:        serverIP,
+		ServerPort:      "443(https)",
+		SNI:             "example.com",
+		OfferedVersions: []string{"TLS 1.3", "TLS 1.2"},
+		Certificates:    []Certificate{{Subject: "CN=example.com", NotAfter: t0}},
+	}
+	require.NoError(t, InsertTLSInfo(info, db))
+	require.NoError(t, InsertTLSInfo(&Info{Time: t0, ServerIP: serverIP}, db))
+
+	sessions, err := storage.GetTLSSessions(db)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "example.com", sessions[0].SNI)
+	assert.Equal(t, "TLS 1.3,TLS 1.2", sessions[0].OfferedVersions)
+	assert.Contains(t, sessions[0].Certificates, `"subject":"CN=example.com"`)
+	assert.Empty(t, sessions[1].Certificates)
+}